@@ -0,0 +1,273 @@
+// Package accesslog writes the finished TCP connections and UDP NAT entries
+// as JSON lines, to a file that is rotated by size.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+)
+
+const (
+	defaultMaxSize    = 100 << 20
+	defaultMaxBackups = 5
+	defaultQueueSize  = 4096
+	flushInterval     = time.Second
+)
+
+// Config configures the Logger.
+type Config struct {
+	// Path of the log file.  "-" writes to stdout, without rotation.
+	Path string
+	// MaxSize is the size in bytes at which the file is rotated.  Zero
+	// selects 100 MiB.
+	MaxSize int64
+	// MaxBackups is the number of rotated files to keep, as Path.1 to
+	// Path.<MaxBackups>.  Zero selects 5.
+	MaxBackups int
+	// Sample is the fraction of the entries to write, between 0 and 1.  Zero
+	// writes all of them.
+	Sample float64
+	// IPPrivacy is how the client IPs are written.  Nil writes them in full.
+	IPPrivacy *service.IPPrivacy
+	Logger    *log.Logger
+}
+
+// Record is a line of the access log.
+type Record struct {
+	// Time is when the connection or NAT entry ended.
+	Time           time.Time `json:"time"`
+	Proto          string    `json:"proto"`
+	AccessKey      string    `json:"access_key,omitempty"`
+	Port           int       `json:"port"`
+	ClientIP       string    `json:"client_ip,omitempty"`
+	ClientLocation string    `json:"client_location,omitempty"`
+	ClientASN      int       `json:"client_asn,omitempty"`
+	ClientASOrg    string    `json:"client_as_org,omitempty"`
+	Target         string    `json:"target,omitempty"`
+	Status         string    `json:"status"`
+	// The bytes from the client to the proxy, the proxy to the target, the
+	// target to the proxy and the proxy to the client.
+	ClientProxyBytes int64 `json:"client_proxy_bytes"`
+	ProxyTargetBytes int64 `json:"proxy_target_bytes"`
+	TargetProxyBytes int64 `json:"target_proxy_bytes"`
+	ProxyClientBytes int64 `json:"proxy_client_bytes"`
+	// The UDP packets in each direction.
+	PacketsFromClient int64   `json:"packets_from_client,omitempty"`
+	PacketsFromTarget int64   `json:"packets_from_target,omitempty"`
+	DurationMs        float64 `json:"duration_ms"`
+	TimeToCipherMs    float64 `json:"time_to_cipher_ms,omitempty"`
+}
+
+// Logger is a service.AccessLog that writes the entries from a goroutine, so
+// that Log never blocks.  Entries are dropped when the writer falls behind.
+type Logger struct {
+	// The entries dropped since the last warning.  Accessed atomically, and
+	// first to be 64-bit aligned on 32-bit platforms.
+	dropped int64
+	config  Config
+	entries chan service.AccessLogEntry
+	done    chan struct{}
+	// Only used by the writer goroutine.
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	// Protects closed, so that Log doesn't send on the closed channel.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New opens the log file and starts the writer.
+func New(config Config) (*Logger, error) {
+	if config.Path == "" {
+		return nil, errors.New("no access log path")
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultMaxSize
+	}
+	if config.MaxBackups <= 0 {
+		config.MaxBackups = defaultMaxBackups
+	}
+	if config.Sample < 0 || config.Sample > 1 {
+		return nil, fmt.Errorf("access log sample %v is not between 0 and 1", config.Sample)
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default().Named("accesslog")
+	}
+	l := &Logger{
+		config:  config,
+		entries: make(chan service.AccessLogEntry, defaultQueueSize),
+		done:    make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log queues `entry`, unless it's not sampled or the queue is full.
+func (l *Logger) Log(entry service.AccessLogEntry) {
+	if l.config.Sample > 0 && l.config.Sample < 1 && rand.Float64() >= l.config.Sample {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.entries <- entry:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+// Close writes the queued entries and closes the file.  Later entries are
+// dropped.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	close(l.entries)
+	l.mu.Unlock()
+	<-l.done
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	if l.file == os.Stdout {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// NewRecord converts an entry to a line of the log, with the client IP shown
+// as `privacy` allows.
+func NewRecord(entry service.AccessLogEntry, privacy *service.IPPrivacy) Record {
+	r := Record{
+		Time:              entry.Start.Add(entry.Duration).UTC(),
+		Proto:             entry.Proto,
+		AccessKey:         entry.AccessKey,
+		Port:              entry.Port,
+		ClientLocation:    entry.ClientLocation,
+		ClientASN:         entry.ClientASN,
+		ClientASOrg:       entry.ClientASOrganization,
+		Target:            entry.Target,
+		Status:            entry.Status,
+		ClientProxyBytes:  entry.Data.ClientProxy,
+		ProxyTargetBytes:  entry.Data.ProxyTarget,
+		TargetProxyBytes:  entry.Data.TargetProxy,
+		ProxyClientBytes:  entry.Data.ProxyClient,
+		PacketsFromClient: entry.PacketsFromClient,
+		PacketsFromTarget: entry.PacketsFromTarget,
+		DurationMs:        milliseconds(entry.Duration),
+		TimeToCipherMs:    milliseconds(entry.TimeToCipher),
+	}
+	r.ClientIP = privacy.IP(addrIP(entry.ClientAddr))
+	return r
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-l.entries:
+			if !ok {
+				return
+			}
+			l.write(entry)
+		case <-ticker.C:
+			if err := l.writer.Flush(); err != nil {
+				l.config.Logger.Error("Failed to write access log", log.Err(err))
+			}
+			if dropped := atomic.SwapInt64(&l.dropped, 0); dropped > 0 {
+				l.config.Logger.Warning("Dropped access log entries", log.Int64("dropped", dropped))
+			}
+		}
+	}
+}
+
+func (l *Logger) write(entry service.AccessLogEntry) {
+	line, err := json.Marshal(NewRecord(entry, l.config.IPPrivacy))
+	if err != nil {
+		l.config.Logger.Error("Failed to encode access log entry", log.Err(err))
+		return
+	}
+	line = append(line, '\n')
+	if l.file != os.Stdout && l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			l.config.Logger.Error("Failed to rotate access log", log.Err(err))
+		}
+	}
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.config.Logger.Error("Failed to write access log", log.Err(err))
+	}
+}
+
+func (l *Logger) open() error {
+	if l.config.Path == "-" {
+		l.file = os.Stdout
+		l.writer = bufio.NewWriter(l.file)
+		return nil
+	}
+	file, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = info.Size()
+	return nil
+}
+
+// Renames Path to Path.1, shifting the older backups, and opens a new file.
+func (l *Logger) rotate() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	path := l.config.Path
+	os.Remove(fmt.Sprintf("%s.%d", path, l.config.MaxBackups))
+	for i := l.config.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	renameErr := os.Rename(path, path+".1")
+	if err := l.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
@@ -0,0 +1,141 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func testEntry(key string) service.AccessLogEntry {
+	return service.AccessLogEntry{
+		Proto:                "tcp",
+		AccessKey:            key,
+		Port:                 8388,
+		ClientAddr:           &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 51000},
+		ClientLocation:       "NL",
+		ClientASN:            64496,
+		ClientASOrganization: "Example Carrier",
+		Target:               "example.com:443",
+		Status:               "OK",
+		Data:                 metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4},
+		Start:                time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Duration:             1500 * time.Millisecond,
+		TimeToCipher:         2 * time.Millisecond,
+	}
+}
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Path: path})
+	require.NoError(t, err)
+	l.Log(testEntry("key1"))
+	require.NoError(t, l.Close())
+	// Entries after Close are dropped.
+	l.Log(testEntry("key2"))
+
+	require.Equal(t, []Record{{
+		Time:             time.Date(2026, 10, 1, 12, 0, 1, 500000000, time.UTC),
+		Proto:            "tcp",
+		AccessKey:        "key1",
+		Port:             8388,
+		ClientIP:         "192.0.2.10",
+		ClientLocation:   "NL",
+		ClientASN:        64496,
+		ClientASOrg:      "Example Carrier",
+		Target:           "example.com:443",
+		Status:           "OK",
+		ClientProxyBytes: 1,
+		ProxyTargetBytes: 2,
+		TargetProxyBytes: 3,
+		ProxyClientBytes: 4,
+		DurationMs:       1500,
+		TimeToCipherMs:   2,
+	}}, readRecords(t, path))
+}
+
+func TestLogger_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	line, err := json.Marshal(NewRecord(testEntry("key0"), nil))
+	require.NoError(t, err)
+	// Two lines per file.
+	l, err := New(Config{Path: path, MaxSize: int64(2*len(line) + 2), MaxBackups: 2})
+	require.NoError(t, err)
+	for _, key := range []string{"key0", "key1", "key2", "key3", "key4", "key5", "key6"} {
+		l.Log(testEntry(key))
+	}
+	require.NoError(t, l.Close())
+
+	keys := func(path string) []string {
+		var keys []string
+		for _, r := range readRecords(t, path) {
+			keys = append(keys, r.AccessKey)
+		}
+		return keys
+	}
+	require.Equal(t, []string{"key6"}, keys(path))
+	require.Equal(t, []string{"key4", "key5"}, keys(path+".1"))
+	require.Equal(t, []string{"key2", "key3"}, keys(path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLogger_Sample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Path: path, Sample: 0.5})
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		l.Log(testEntry("key"))
+	}
+	require.NoError(t, l.Close())
+	n := len(readRecords(t, path))
+	require.Greater(t, n, 350)
+	require.Less(t, n, 650)
+
+	_, err = New(Config{Path: path, Sample: 2})
+	require.Error(t, err)
+}
+
+func TestLogger_IPPrivacy(t *testing.T) {
+	for _, mode := range []string{service.IPPrivacyTruncate, service.IPPrivacyHash, service.IPPrivacyNone} {
+		t.Run(mode, func(t *testing.T) {
+			privacy, err := service.NewIPPrivacy(mode, []byte("key"))
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), "access.log")
+			l, err := New(Config{Path: path, IPPrivacy: privacy})
+			require.NoError(t, err)
+			l.Log(testEntry("key"))
+			require.NoError(t, l.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NotContains(t, string(data), "192.0.2.10")
+			records := readRecords(t, path)
+			require.Len(t, records, 1)
+			require.Equal(t, privacy.IP(net.ParseIP("192.0.2.10")), records[0].ClientIP)
+		})
+	}
+	require.Equal(t, "192.0.2.10", NewRecord(testEntry("key"), nil).ClientIP)
+}
@@ -1,7 +1,6 @@
 module github.com/evgeniy-krivenko/outline-ss-server
 
 require (
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/prometheus/client_golang v1.7.1
 	github.com/shadowsocks/go-shadowsocks2 v0.1.4-0.20201002022019-75d43273f5a5
@@ -181,3 +180,7 @@ require (
 )
 
 go 1.18
+
+// The generated code of the ss_service proto is vendored until vpn-api
+// publishes it.  See third_party/vpn-api.
+replace github.com/evgeniy-krivenko/vpn-api/gen/ss_service => ./third_party/vpn-api/gen/ss_service
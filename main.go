@@ -15,33 +15,47 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/evgeniy-krivenko/outline-ss-server/accesslog"
+	"github.com/evgeniy-krivenko/outline-ss-server/manager"
+	"github.com/evgeniy-krivenko/outline-ss-server/otlp"
 	"github.com/evgeniy-krivenko/outline-ss-server/pkg/consul"
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
 	rpchandler "github.com/evgeniy-krivenko/outline-ss-server/rpc"
 	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	"github.com/evgeniy-krivenko/outline-ss-server/webhook"
 	"github.com/evgeniy-krivenko/vpn-api/gen/ss_service"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health"
 	healphpb "google.golang.org/grpc/health/grpc_health_v1"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
-	"github.com/op/go-logging"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
-var logger *logging.Logger
+// rootLogger is configured by the -log_format and -log_level flags, and the
+// subsystems log through loggers named from it.
+var rootLogger = log.Default()
+var logger = rootLogger.Named("main")
 
 // Set by goreleaser default ldflags. See https://goreleaser.com/customization/build/
 var version = "dev"
@@ -49,15 +63,56 @@ var version = "dev"
 // A UDP NAT timeout of at least 5 minutes is recommended in RFC 4787 Section 4.3.
 const defaultNatTimeout = 5 * time.Minute
 
-func init() {
-	var prefix = "%{level:.1s}%{time:2006-01-02T15:04:05.000Z07:00} %{pid} %{shortfile}]"
-	if terminal.IsTerminal(int(os.Stderr.Fd())) {
-		// Add color only if the output is the terminal
-		prefix = strings.Join([]string{"%{color}", prefix, "%{color:reset}"}, "")
+// setupLogging replaces the root logger with one that writes `format`, text
+// or json, at the levels of `levelSpec`, like "info,udp=debug".  `verbose`
+// lowers the level of the other subsystems to debug.
+func setupLogging(format, levelSpec string, verbose bool) error {
+	var handler log.Handler
+	switch format {
+	case "text":
+		handler = log.NewTextHandler(os.Stderr)
+	case "json":
+		handler = log.NewJSONHandler(os.Stderr)
+	default:
+		return fmt.Errorf("unknown -log_format %q, must be text or json", format)
 	}
-	logging.SetFormatter(logging.MustStringFormatter(strings.Join([]string{prefix, " %{message}"}, "")))
-	logging.SetBackend(logging.NewLogBackend(os.Stderr, "", 0))
-	logger = logging.MustGetLogger("")
+	root := log.New(handler, log.LevelInfo)
+	if err := root.SetLevels(levelSpec); err != nil {
+		return fmt.Errorf("invalid -log_level: %w", err)
+	}
+	if verbose {
+		root.SetLevel(log.LevelDebug)
+	}
+	rootLogger = root
+	logger = root.Named("main")
+	return nil
+}
+
+// handleLevelSignals switches to the levels of `usr1Spec`, like
+// "info,udp=debug", on SIGUSR1, and restores the configured levels on
+// SIGUSR2.
+func handleLevelSignals(usr1Spec string) error {
+	// Check the spec now rather than when the signal arrives.
+	if err := log.New(nil, log.LevelInfo).SetLevels(usr1Spec); err != nil {
+		return fmt.Errorf("invalid -log_level_usr1: %w", err)
+	}
+	configured := rootLogger.Levels()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			spec := configured
+			if sig == syscall.SIGUSR1 {
+				spec = usr1Spec
+			}
+			if err := rootLogger.SetLevels(spec); err != nil {
+				logger.Error("Failed to change the log levels", log.Err(err))
+				continue
+			}
+			logger.Info("Changed the log levels", log.String("levels", rootLogger.Levels()))
+		}
+	}()
+	return nil
 }
 
 func getHost() string {
@@ -70,16 +125,18 @@ func getHost() string {
 }
 
 // RunSSServer starts a shadowsocks server running, and returns the server or an error.
-func RunSSServer(filename string, natTimeout time.Duration, sm metrics.ShadowsocksMetrics, replayHistory int) (*server.SSServer, error) {
-	cnf := &server.SSConfig{
-		NatTimeout:    natTimeout,
-		Metrics:       sm,
-		ReplayHistory: replayHistory,
-		Ports:         make(map[int]*server.SsPort),
-		Logger:        logger,
-	}
-	srv := server.NewSSServer(cnf)
-	err := srv.LoadConfig(filename)
+func RunSSServer(filename string, cnf *server.SSConfig) (*server.SSServer, error) {
+	cnf.Ports = make(map[int]*server.SsPort)
+	cnf.Logger = rootLogger.Named("server")
+	srv, err := server.NewSSServer(cnf)
+	if err != nil {
+		return nil, err
+	}
+	// The keys come from elsewhere without a config file.
+	if filename == "" {
+		return srv, nil
+	}
+	err = srv.LoadConfig(filename)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load config file %v: %v", filename, err)
 	}
@@ -89,7 +146,7 @@ func RunSSServer(filename string, natTimeout time.Duration, sm metrics.Shadowsoc
 		for range sigHup {
 			logger.Info("Updating config")
 			if err := srv.LoadConfig(filename); err != nil {
-				logger.Errorf("Could not reload config: %v", err)
+				logger.Error("Could not reload config", log.Err(err))
 			}
 		}
 	}()
@@ -97,6 +154,65 @@ func RunSSServer(filename string, natTimeout time.Duration, sm metrics.Shadowsoc
 	return srv, nil
 }
 
+// newIPPrivacy returns the IPPrivacy of `mode`, with the hash key read from
+// `keyFile`, or a random key if it's empty.
+func newIPPrivacy(mode, keyFile string) (*service.IPPrivacy, error) {
+	var key []byte
+	if mode == service.IPPrivacyHash {
+		if keyFile != "" {
+			data, err := os.ReadFile(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the IP hash key: %w", err)
+			}
+			key = []byte(strings.TrimSpace(string(data)))
+		} else {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, fmt.Errorf("failed to generate an IP hash key: %w", err)
+			}
+			logger.Warning("No -ip_hash_key given, so the IP hashes change on restart")
+		}
+	}
+	return service.NewIPPrivacy(mode, key)
+}
+
+// parseHeaders parses comma-separated key=value headers.
+func parseHeaders(spec string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(spec, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("header %q must be key=value", header)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// exportUsage writes the usage records of the store at `path` to `w`, in
+// `format` csv or json.
+func exportUsage(w io.Writer, path, format, from, to, accessKey string) error {
+	if path == "" {
+		return errors.New("-usage_export requires -usage_store")
+	}
+	records, err := server.ReadUsageFile(path)
+	if err != nil {
+		return err
+	}
+	records = server.FilterUsage(records, from, to, accessKey)
+	switch format {
+	case "csv":
+		return server.WriteUsageCSV(w, records)
+	case "json":
+		return server.WriteUsageJSON(w, records)
+	default:
+		return fmt.Errorf("unknown -usage_export %q, must be csv or json", format)
+	}
+}
+
 func main() {
 	var flags struct {
 		ConfigFile    string
@@ -104,33 +220,189 @@ func main() {
 		IPCountryDB   string
 		natTimeout    time.Duration
 		replayHistory int
+		replayFilter  string
+		replayFPRate  float64
+		replayMemory  int
+		replayFile    string
+		replaySave    time.Duration
+		udpReplay     int
+		usageInterval time.Duration
+		revokeGrace   time.Duration
+		ban           service.BanConfig
 		Verbose       bool
+		logFormat     string
+		logLevel      string
+		logLevelUSR1  string
 		Version       bool
 		IsGRPC        bool
 		IsConsul      bool
 		GrpcPort      int
 		GrpcAddress   string
+		AccessHost    string
 		ServiceId     string
+		grpcTLS       rpchandler.TLSConfig
+		grpcTokens    string
+		controller    struct {
+			addr      string
+			caFile    string
+			insecure  bool
+			tokenFile string
+		}
+		consulSkipTLS bool
+		consul        struct {
+			agent       consul.Config
+			check       string
+			ttl         time.Duration
+			portService string
+			portTags    string
+			kvPrefix    string
+			registry    string
+		}
+		usageStore struct {
+			path      string
+			flush     time.Duration
+			retention int
+			export    string
+			from, to  string
+			key       string
+		}
+		webhook struct {
+			urls       string
+			secretFile string
+			events     string
+			queue      string
+			usage      bool
+		}
+		accessLog struct {
+			path       string
+			maxSize    int64
+			maxBackups int
+			sample     float64
+			anonymize  bool
+		}
+		ipPrivacy struct {
+			mode    string
+			keyFile string
+		}
+		otlp struct {
+			endpoint    string
+			headers     string
+			interval    time.Duration
+			serviceName string
+			traceRatio  float64
+		}
+		ipASNDB        string
+		asnLabelMax    int
+		targetMetrics  bool
+		accessKeyLabel struct {
+			policy string
+			top    int
+		}
+		quotaThresholds string
+		api             struct {
+			port     int
+			prefix   string
+			hostname string
+			keyPort  int
+			state    string
+			certFile string
+			keyFile  string
+		}
 	}
 	flag.StringVar(&flags.ConfigFile, "config", "", "Configuration filename")
 	flag.StringVar(&flags.MetricsAddr, "metrics", "", "Address for the Prometheus metrics")
 	flag.StringVar(&flags.IPCountryDB, "ip_country_db", "", "Path to the ip-to-country mmdb file")
+	flag.StringVar(&flags.ipASNDB, "ip_asn_db", "", "Path to the ip-to-ASN mmdb file")
+	flag.StringVar(&flags.accessKeyLabel.policy, "access_key_label", metrics.AccessKeyLabelFull, "access_key label of the metrics: full (key IDs), hash (hashed key IDs), top (IDs of the -access_key_label_top keys with the most traffic, the rest are \"other\") or none")
+	flag.IntVar(&flags.accessKeyLabel.top, "access_key_label_top", 100, "Number of keys with their own label with -access_key_label top")
+	flag.IntVar(&flags.asnLabelMax, "asn_label_max", 100, "Max distinct ASNs in the asn metrics label, the rest are \"other\" (0 leaves out the label)")
+	flag.BoolVar(&flags.targetMetrics, "target_metrics", false, "Add metrics of the targets: their location with -ip_country_db, their port class and the TCP dial times")
 	flag.DurationVar(&flags.natTimeout, "udptimeout", defaultNatTimeout, "UDP tunnel timeout")
 	flag.IntVar(&flags.replayHistory, "replay_history", 0, "Replay buffer size (# of handshakes)")
+	flag.StringVar(&flags.replayFilter, "replay_filter", "", "Replay buffer design: map or bloom (default map up to 20000 handshakes, bloom above)")
+	flag.Float64Var(&flags.replayFPRate, "replay_fp_rate", service.DefaultReplayFalsePositiveRate, "Target false positive rate of the bloom replay buffer")
+	flag.IntVar(&flags.replayMemory, "replay_memory_mb", 0, "Memory budget of the replay buffer in MiB (0 is unlimited)")
+	flag.StringVar(&flags.replayFile, "replay_snapshot", "", "File to persist the replay buffer across restarts")
+	flag.DurationVar(&flags.replaySave, "replay_snapshot_interval", time.Minute, "How often to save the replay buffer to -replay_snapshot")
+	flag.IntVar(&flags.api.port, "api_port", 0, "Port of the Outline-compatible HTTPS management API (0 disables it)")
+	flag.StringVar(&flags.api.prefix, "api_prefix", "", "Secret path prefix of the management API (random if empty)")
+	flag.StringVar(&flags.api.hostname, "api_hostname", "", "Hostname for access URLs (default is the host the API was called on)")
+	flag.IntVar(&flags.api.keyPort, "api_key_port", 0, "Port for new access keys created through the API (random if 0)")
+	flag.StringVar(&flags.api.state, "api_state", "", "File to persist the management API state, including keys created through it")
+	flag.StringVar(&flags.api.certFile, "api_cert", "", "TLS certificate of the management API, generated if it doesn't exist")
+	flag.StringVar(&flags.api.keyFile, "api_key", "", "TLS key of the management API, generated if it doesn't exist")
+	flag.DurationVar(&flags.usageInterval, "usage_interval", 10*time.Second, "How often per-key usage is published to the gRPC usage stream")
+	flag.StringVar(&flags.usageStore.path, "usage_store", "", "File to keep the daily usage of each access key in across restarts (empty disables it)")
+	flag.DurationVar(&flags.usageStore.flush, "usage_store_interval", time.Minute, "How often the usage is saved to -usage_store")
+	flag.IntVar(&flags.usageStore.retention, "usage_store_retention", 400, "Days of usage kept in -usage_store (0 keeps all). Older days only count towards the data limits")
+	flag.StringVar(&flags.usageStore.export, "usage_export", "", "Print the usage in -usage_store as csv or json, and exit")
+	flag.StringVar(&flags.usageStore.from, "usage_from", "", "First day to export, like 2006-01-02 (default is the first day in the store)")
+	flag.StringVar(&flags.usageStore.to, "usage_to", "", "Last day to export, like 2006-01-02 (default is the last day in the store)")
+	flag.StringVar(&flags.usageStore.key, "usage_key", "", "Access key ID to export (default is all keys)")
+	flag.StringVar(&flags.webhook.urls, "webhook_url", "", "Comma-separated URLs to POST the lifecycle events to (empty disables webhooks)")
+	flag.StringVar(&flags.webhook.secretFile, "webhook_secret", "", "File with the HMAC secret that signs the webhook requests")
+	flag.StringVar(&flags.webhook.events, "webhook_events", "", "Comma-separated event types to send (default is all)")
+	flag.StringVar(&flags.webhook.queue, "webhook_queue", "", "File to keep the undelivered webhook requests in across restarts")
+	flag.BoolVar(&flags.webhook.usage, "webhook_usage", false, "Send every usage batch as a usage.batch event")
+	flag.StringVar(&flags.accessLog.path, "access_log", "", "File to write a JSON line to for every finished TCP connection and UDP NAT entry (\"-\" for stdout)")
+	flag.Int64Var(&flags.accessLog.maxSize, "access_log_max_size", 100, "Size in MiB at which the access log is rotated")
+	flag.IntVar(&flags.accessLog.maxBackups, "access_log_max_backups", 5, "Number of rotated access logs to keep")
+	flag.Float64Var(&flags.accessLog.sample, "access_log_sample", 1, "Fraction of the connections to write to the access log")
+	flag.BoolVar(&flags.accessLog.anonymize, "access_log_anonymize_ip", false, "Deprecated: use -ip_privacy truncate")
+	flag.StringVar(&flags.ipPrivacy.mode, "ip_privacy", service.IPPrivacyFull, "How client IPs appear in logs, the access log, events and API output: full, truncate (to /24 or /48), hash (keyed with -ip_hash_key) or none")
+	flag.StringVar(&flags.ipPrivacy.keyFile, "ip_hash_key", "", "File with the secret key of -ip_privacy hash (default is a random key, so the hashes change on restart)")
+	flag.StringVar(&flags.otlp.endpoint, "otlp_endpoint", "", "Base URL of an OpenTelemetry collector to export the metrics to over OTLP/HTTP, like http://localhost:4318 (empty disables it)")
+	flag.StringVar(&flags.otlp.headers, "otlp_headers", "", "Comma-separated key=value headers of the OTLP requests, like \"Authorization=Bearer token\"")
+	flag.DurationVar(&flags.otlp.interval, "otlp_interval", 10*time.Second, "How often the metrics and spans are exported to -otlp_endpoint")
+	flag.StringVar(&flags.otlp.serviceName, "otlp_service_name", "outline-ss-server", "service.name of the exported metrics and spans")
+	flag.Float64Var(&flags.otlp.traceRatio, "otlp_trace_ratio", 0, "Fraction of the TCP connections and UDP NAT entries to export spans for (0 disables tracing)")
+	flag.StringVar(&flags.quotaThresholds, "quota_thresholds", "80,100", "Comma-separated percentages of the data limits that emit key.quota_threshold events")
+	flag.DurationVar(&flags.revokeGrace, "revoke_grace_period", 0, "How long the sessions of a removed access key may continue before they are closed")
+	flag.IntVar(&flags.udpReplay, "udp_replay_history", 0, "UDP replay buffer size per access key (# of packets, 0 disables)")
+	flag.IntVar(&flags.ban.MaxFailures, "ban_failures", 0, "Ban a client IP after this many authentication failures within -ban_window (0 disables bans)")
+	flag.IntVar(&flags.ban.MaxSubnetFailures, "ban_subnet_failures", 0, "Ban a client subnet after this many authentication failures within -ban_window (0 disables subnet bans)")
+	flag.IntVar(&flags.ban.SubnetBitsIPv4, "ban_subnet_ipv4", 24, "Prefix length of the IPv4 subnets for -ban_subnet_failures")
+	flag.IntVar(&flags.ban.SubnetBitsIPv6, "ban_subnet_ipv6", 64, "Prefix length of the IPv6 subnets for -ban_subnet_failures")
+	flag.DurationVar(&flags.ban.Window, "ban_window", time.Minute, "Window for counting authentication failures")
+	flag.DurationVar(&flags.ban.BanDuration, "ban_duration", 10*time.Minute, "Duration of the first ban, doubled for each repeated ban")
+	flag.DurationVar(&flags.ban.MaxBanDuration, "ban_max_duration", 24*time.Hour, "Maximum ban duration")
 	flag.BoolVar(&flags.Verbose, "verbose", false, "Enables verbose logging output")
+	flag.StringVar(&flags.logFormat, "log_format", "text", "Format of the logs on stderr: text or json")
+	flag.StringVar(&flags.logLevel, "log_level", "info", "Comma-separated log levels, optionally per subsystem, like \"info,udp=debug\".  SIGUSR2 restores these levels")
+	flag.StringVar(&flags.logLevelUSR1, "log_level_usr1", "debug", "Log levels set on SIGUSR1, in the format of -log_level, e.g. \"info,udp=debug\" to debug only UDP")
 	flag.BoolVar(&flags.Version, "version", false, "The version of the server")
+	flag.StringVar(&flags.AccessHost, "access_host", "", "Hostname or IP address for the access URLs returned over gRPC (default is the host name)")
 	flag.BoolVar(&flags.IsGRPC, "grpc", false, "Should to start gRPC server")
 	flag.BoolVar(&flags.IsConsul, "consul", false, "Should to start service discovery")
 	flag.IntVar(&flags.GrpcPort, "grpc-port", 50051, "Port for gRPC service")
 	flag.StringVar(&flags.GrpcAddress, "grpc-address", getHost(), "Address for gRPC service")
 	flag.StringVar(&flags.ServiceId, "service-id", "", "Service id discovery")
+	flag.StringVar(&flags.grpcTLS.CertFile, "grpc_cert", "", "TLS certificate of the gRPC server, reloaded when it changes (empty disables TLS)")
+	flag.StringVar(&flags.grpcTLS.KeyFile, "grpc_key", "", "TLS key of the gRPC server")
+	flag.StringVar(&flags.grpcTLS.ClientCAFile, "grpc_client_ca", "", "CA certificates to verify gRPC client certificates (empty disables client certificates)")
+	flag.StringVar(&flags.grpcTokens, "grpc_tokens", "", "File of gRPC bearer tokens, one \"<read|mutate> <token>\" per line (empty disables tokens)")
+	flag.StringVar(&flags.controller.addr, "controller", "", "Address of a controller to connect out to, for nodes that the controller can't reach")
+	flag.StringVar(&flags.controller.caFile, "controller_ca", "", "CA certificates to verify the controller (default is the system roots)")
+	flag.BoolVar(&flags.controller.insecure, "controller_insecure", false, "Connect to the controller without TLS")
+	flag.StringVar(&flags.controller.tokenFile, "controller_token", "", "File with the bearer token for the controller")
+	flag.StringVar(&flags.consul.agent.Address, "consul_addr", "localhost:8500", "Address of the Consul agent")
+	flag.StringVar(&flags.consul.agent.Token, "consul_token", "", "ACL token for Consul")
+	flag.StringVar(&flags.consul.agent.Datacenter, "consul_dc", "", "Consul datacenter (default is the agent's)")
+	flag.StringVar(&flags.consul.check, "consul_check", "grpc", "Health check of the gRPC service: grpc, or ttl to report the server's own health")
+	flag.DurationVar(&flags.consul.ttl, "consul_ttl", 30*time.Second, "TTL of the Consul TTL checks, which are updated every third of it")
+	flag.StringVar(&flags.consul.portService, "consul_port_service", "shadowsocks", "Consul service name of the Shadowsocks ports (empty doesn't register them)")
+	flag.StringVar(&flags.consul.portTags, "consul_port_tags", "ss", "Comma-separated tags of the Shadowsocks port services")
+	flag.StringVar(&flags.consul.kvPrefix, "consul_kv_prefix", "", "Consul KV prefix to load the access keys from, instead of -config")
+	flag.StringVar(&flags.consul.registry, "consul_registry_prefix", "", "Consul KV prefix of the node registry, where the node keeps its address and capacity (empty disables it)")
+	flag.BoolVar(&flags.consulSkipTLS, "consul_tls_skip_verify", false, "Don't verify the gRPC certificate in the Consul health check")
 
 	flag.Parse()
 
-	if flags.Verbose {
-		logging.SetLevel(logging.DEBUG, "")
-	} else {
-		logging.SetLevel(logging.INFO, "")
+	if err := setupLogging(flags.logFormat, flags.logLevel, flags.Verbose); err != nil {
+		logger.Fatal("Invalid logging flags", log.Err(err))
+	}
+	if err := handleLevelSignals(flags.logLevelUSR1); err != nil {
+		logger.Fatal("Invalid logging flags", log.Err(err))
 	}
 
 	if flags.Version {
@@ -138,7 +410,14 @@ func main() {
 		return
 	}
 
-	if flags.ConfigFile == "" {
+	if flags.usageStore.export != "" {
+		if err := exportUsage(os.Stdout, flags.usageStore.path, flags.usageStore.export, flags.usageStore.from, flags.usageStore.to, flags.usageStore.key); err != nil {
+			logger.Fatal("Failed to export the usage", log.Err(err))
+		}
+		return
+	}
+
+	if (flags.ConfigFile == "") == (flags.consul.kvPrefix == "") {
 		flag.Usage()
 		return
 	}
@@ -146,29 +425,268 @@ func main() {
 	if flags.MetricsAddr != "" {
 		http.Handle("/metrics", promhttp.Handler())
 		go func() {
-			logger.Fatal(http.ListenAndServe(flags.MetricsAddr, nil))
+			logger.Fatal("Metrics server failed", log.Err(http.ListenAndServe(flags.MetricsAddr, nil)))
 		}()
-		logger.Infof("Metrics on http://%v/metrics", flags.MetricsAddr)
+		logger.Info("Serving metrics", log.String("url", fmt.Sprintf("http://%v/metrics", flags.MetricsAddr)))
 	}
 
 	var ipCountryDB *geoip2.Reader
 	var err error
 	if flags.IPCountryDB != "" {
-		logger.Infof("Using IP-Country database at %v", flags.IPCountryDB)
+		logger.Info("Using IP-Country database", log.String("path", flags.IPCountryDB))
 		ipCountryDB, err = geoip2.Open(flags.IPCountryDB)
 		if err != nil {
-			log.Fatalf("Could not open geoip database at %v: %v", flags.IPCountryDB, err)
+			logger.Fatal("Could not open geoip database", log.String("path", flags.IPCountryDB), log.Err(err))
 		}
 		defer ipCountryDB.Close()
 	}
-	m := metrics.NewPrometheusShadowsocksMetrics(ipCountryDB, prometheus.DefaultRegisterer)
+	var ipASNDB *geoip2.Reader
+	if flags.ipASNDB != "" {
+		logger.Info("Using IP-ASN database", log.String("path", flags.ipASNDB))
+		ipASNDB, err = geoip2.Open(flags.ipASNDB)
+		if err != nil {
+			logger.Fatal("Could not open geoip database", log.String("path", flags.ipASNDB), log.Err(err))
+		}
+		defer ipASNDB.Close()
+	}
+	var asnLabels *metrics.ASNLabeler
+	if ipASNDB != nil && flags.asnLabelMax > 0 {
+		asnLabels = metrics.NewASNLabeler(flags.asnLabelMax)
+	}
+	var events server.EventSink
+	if flags.webhook.urls != "" {
+		var secret []byte
+		if flags.webhook.secretFile != "" {
+			data, err := os.ReadFile(flags.webhook.secretFile)
+			if err != nil {
+				logger.Fatal("Failed to read the webhook secret", log.Err(err))
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		} else {
+			logger.Warning("No -webhook_secret given, so the webhook requests are not signed")
+		}
+		var eventTypes []string
+		if flags.webhook.events != "" {
+			eventTypes = strings.Split(flags.webhook.events, ",")
+		}
+		dispatcher, err := webhook.NewDispatcher(webhook.Config{
+			URLs:      strings.Split(flags.webhook.urls, ","),
+			Secret:    secret,
+			Events:    eventTypes,
+			QueuePath: flags.webhook.queue,
+			Logger:    rootLogger.Named("webhook"),
+		})
+		if err != nil {
+			logger.Fatal("Failed to start the webhooks", log.Err(err))
+		}
+		defer dispatcher.Close()
+		events = dispatcher
+	}
+	var quotaThresholds []int
+	for _, t := range strings.Split(flags.quotaThresholds, ",") {
+		if t == "" {
+			continue
+		}
+		percent, err := strconv.Atoi(strings.TrimSpace(t))
+		if err != nil || percent <= 0 {
+			logger.Fatal("Invalid -quota_thresholds", log.String("value", flags.quotaThresholds))
+		}
+		quotaThresholds = append(quotaThresholds, percent)
+	}
+
+	if flags.accessLog.anonymize && flags.ipPrivacy.mode == service.IPPrivacyFull {
+		logger.Warning("-access_log_anonymize_ip is deprecated, use -ip_privacy truncate")
+		flags.ipPrivacy.mode = service.IPPrivacyTruncate
+	}
+	ipPrivacy, err := newIPPrivacy(flags.ipPrivacy.mode, flags.ipPrivacy.keyFile)
+	if err != nil {
+		logger.Fatal("Invalid IP privacy flags", log.Err(err))
+	}
+
+	var accessLog service.AccessLog
+	if flags.accessLog.path != "" {
+		l, err := accesslog.New(accesslog.Config{
+			Path:       flags.accessLog.path,
+			MaxSize:    flags.accessLog.maxSize << 20,
+			MaxBackups: flags.accessLog.maxBackups,
+			Sample:     flags.accessLog.sample,
+			IPPrivacy:  ipPrivacy,
+			Logger:     rootLogger.Named("accesslog"),
+		})
+		if err != nil {
+			logger.Fatal("Failed to open the access log", log.Err(err))
+		}
+		defer l.Close()
+		accessLog = l
+	}
+
+	keyLabels, err := metrics.NewAccessKeyLabeler(flags.accessKeyLabel.policy, flags.accessKeyLabel.top)
+	if err != nil {
+		logger.Fatal("Invalid -access_key_label", log.Err(err))
+	}
+	m := metrics.NewPrometheusShadowsocksMetricsFromConfig(metrics.Config{
+		IPCountryDB:     ipCountryDB,
+		IPASNDB:         ipASNDB,
+		ASNLabels:       asnLabels,
+		AccessKeyLabels: keyLabels,
+		TargetMetrics:   flags.targetMetrics,
+	}, prometheus.DefaultRegisterer)
+	var tracer service.Tracer
+	if flags.otlp.endpoint != "" {
+		headers, err := parseHeaders(flags.otlp.headers)
+		if err != nil {
+			logger.Fatal("Invalid -otlp_headers", log.Err(err))
+		}
+		exporter, err := otlp.New(otlp.Config{
+			Endpoint:        flags.otlp.endpoint,
+			Headers:         headers,
+			Interval:        flags.otlp.interval,
+			ServiceName:     flags.otlp.serviceName,
+			Version:         version,
+			TraceRatio:      flags.otlp.traceRatio,
+			ASNLabels:       asnLabels,
+			AccessKeyLabels: keyLabels,
+			TargetMetrics:   flags.targetMetrics,
+			Logger:          rootLogger.Named("otlp"),
+		})
+		if err != nil {
+			logger.Fatal("Failed to start the OTLP exporter", log.Err(err))
+		}
+		defer exporter.Close()
+		m = metrics.Tee(m, exporter)
+		tracer = exporter.Tracer()
+		logger.Info("Exporting OTLP metrics", log.String("endpoint", flags.otlp.endpoint), log.Any("trace_ratio", flags.otlp.traceRatio))
+	}
 	m.SetBuildInfo(version)
-	srv, err := RunSSServer(flags.ConfigFile, flags.natTimeout, m, flags.replayHistory)
+	srv, err := RunSSServer(flags.ConfigFile, &server.SSConfig{
+		NatTimeout:              flags.natTimeout,
+		Metrics:                 m,
+		ReplayHistory:           flags.replayHistory,
+		ReplayFilter:            flags.replayFilter,
+		ReplayFalsePositiveRate: flags.replayFPRate,
+		ReplayMaxMemory:         flags.replayMemory << 20,
+		ReplaySnapshotPath:      flags.replayFile,
+		ReplaySnapshotInterval:  flags.replaySave,
+		UDPReplayHistory:        flags.udpReplay,
+		UsageInterval:           flags.usageInterval,
+		RevokeGracePeriod:       flags.revokeGrace,
+		UsageStorePath:          flags.usageStore.path,
+		UsageFlushInterval:      flags.usageStore.flush,
+		UsageRetentionDays:      flags.usageStore.retention,
+		Events:                  events,
+		QuotaThresholds:         quotaThresholds,
+		UsageEvents:             flags.webhook.usage,
+		AccessLog:               accessLog,
+		IPPrivacy:               ipPrivacy,
+		Tracer:                  tracer,
+		Ban:                     flags.ban,
+	})
 	if err != nil {
-		logger.Fatal(err)
+		logger.Fatal("Failed to start the server", log.Err(err))
+	}
+
+	var consulClient *consul.Client
+	if flags.IsConsul || flags.consul.kvPrefix != "" || flags.consul.registry != "" {
+		if consulClient, err = consul.NewClientWithConfig(flags.consul.agent); err != nil {
+			logger.Fatal("Failed to create the Consul client", log.Err(err))
+		}
+	}
+
+	if flags.consul.kvPrefix != "" {
+		watcher := consul.NewKV(consulClient).WatchKeys(flags.consul.kvPrefix)
+		applyKeys := func(keys []consul.AccessKey) error {
+			config := &server.Config{}
+			for _, k := range keys {
+				config.Keys = append(config.Keys, server.KeyConfig{ID: k.ID, Port: k.Port, Cipher: k.Cipher, Secret: k.Secret})
+			}
+			return srv.ApplyConfig(config)
+		}
+		keys, err := watcher.Next(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to load the keys from Consul", log.Err(err))
+		}
+		if err := applyKeys(keys); err != nil {
+			logger.Fatal("Failed to apply the keys from Consul", log.Err(err))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watcher.Run(ctx, applyKeys, func(err error) {
+			logger.Error("Could not update the keys from Consul", log.Err(err))
+		})
+		logger.Info("Watching the access keys in Consul", log.String("prefix", flags.consul.kvPrefix))
+	}
+
+	if flags.api.port != 0 {
+		prefix := flags.api.prefix
+		if prefix == "" {
+			if prefix, err = server.GenerateSecret(); err != nil {
+				logger.Fatal("Failed to generate the API prefix", log.Err(err))
+			}
+			logger.Warning("No -api_prefix given, so the management API URL will change on restart")
+		}
+		mgr, err := manager.NewManager(srv, manager.Config{
+			Hostname:             flags.api.hostname,
+			PortForNewAccessKeys: flags.api.keyPort,
+			StatePath:            flags.api.state,
+			Version:              version,
+			Logger:               rootLogger.Named("manager"),
+		})
+		if err != nil {
+			logger.Fatal("Failed to start the management API", log.Err(err))
+		}
+		host := flags.api.hostname
+		if host == "" {
+			host = getHost()
+		}
+		cert, err := manager.LoadOrCreateCertificate(flags.api.certFile, flags.api.keyFile, host)
+		if err != nil {
+			logger.Fatal("Failed to load the management API certificate", log.Err(err))
+		}
+		apiSrv := &http.Server{
+			Addr:      fmt.Sprintf(":%d", flags.api.port),
+			Handler:   mgr.Handler(prefix),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		}
+		// The same format as the Outline Server installer prints, to paste into the Outline Manager.
+		logger.Info("Management API", log.String("config", fmt.Sprintf(`{"apiUrl":"https://%s/%s","certSha256":"%s"}`,
+			net.JoinHostPort(host, fmt.Sprint(flags.api.port)), prefix, manager.CertificateSHA256(cert))))
+		go func() {
+			if err := apiSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Management API failed", log.Err(err))
+			}
+		}()
+		defer apiSrv.Close()
+	}
+
+	// inject SSServer to handler
+	rpcSrv := rpchandler.NewGrpcHandler(srv)
+	accessHost := flags.AccessHost
+	if accessHost == "" {
+		accessHost = getHost()
 	}
+	rpcSrv.SetAccessHost(accessHost)
+	rpcSrv.SetLogger(rootLogger)
 
 	if flags.IsGRPC {
+		var opts []grpc.ServerOption
+		if flags.grpcTLS.CertFile != "" || flags.grpcTLS.KeyFile != "" {
+			flags.grpcTLS.Logger = rootLogger.Named("grpc")
+			tlsConfig, err := rpchandler.NewServerTLSConfig(flags.grpcTLS)
+			if err != nil {
+				logger.Fatal("Failed to load the gRPC certificate", log.Err(err))
+			}
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		} else if flags.grpcTLS.ClientCAFile != "" {
+			logger.Fatal("-grpc_client_ca requires -grpc_cert and -grpc_key")
+		}
+		if flags.grpcTokens != "" {
+			tokens, err := rpchandler.LoadTokenFile(flags.grpcTokens)
+			if err != nil {
+				logger.Fatal("Failed to load the gRPC tokens", log.Err(err))
+			}
+			auth := rpchandler.NewTokenAuth(tokens)
+			opts = append(opts, grpc.ChainUnaryInterceptor(auth.UnaryInterceptor), grpc.ChainStreamInterceptor(auth.StreamInterceptor))
+		}
 		go func() {
 			lis, err := net.Listen("tcp", fmt.Sprintf(":%d", flags.GrpcPort))
 			if err != nil {
@@ -177,46 +695,168 @@ func main() {
 			defer func(lis net.Listener) {
 				err := lis.Close()
 				if err != nil {
-					logger.Errorf("error close tcp conn")
+					logger.Error("Failed to close the gRPC listener", log.Err(err))
 				}
 			}(lis)
 
-			// inject SSServer to handler
-			rpcSrv := rpchandler.NewGrpcHandler(srv)
-
-			s := grpc.NewServer()
+			s := grpc.NewServer(opts...)
 			ss_service.RegisterSsServiceServer(s, rpcSrv)
 
 			hth := health.NewServer()
 			healphpb.RegisterHealthServer(s, hth)
 
-			logger.Infof("starting grpc server on port %d", flags.GrpcPort)
+			logger.Info("Starting gRPC server", log.Int("port", flags.GrpcPort))
 			if err := s.Serve(lis); err != nil {
 				panic(err)
 			}
 		}()
 	}
 
-	if flags.IsConsul {
-		c, err := consul.NewClient(fmt.Sprintf("%s:%d", "localhost", 8500))
+	if flags.controller.addr != "" {
+		var creds credentials.TransportCredentials
+		if flags.controller.insecure {
+			creds = insecure.NewCredentials()
+		} else if flags.controller.caFile != "" {
+			if creds, err = credentials.NewClientTLSFromFile(flags.controller.caFile, ""); err != nil {
+				logger.Fatal("Failed to load the controller CA", log.Err(err))
+			}
+		} else {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		var token string
+		if flags.controller.tokenFile != "" {
+			tokenData, err := os.ReadFile(flags.controller.tokenFile)
+			if err != nil {
+				logger.Fatal("Failed to read the controller token", log.Err(err))
+			}
+			token = strings.TrimSpace(string(tokenData))
+		}
+		conn, err := grpc.Dial(flags.controller.addr, grpc.WithTransportCredentials(creds))
 		if err != nil {
-			logger.Fatal(err)
-		}
-		err = c.GrpcRegistration(&consul.GrpcRegConf{
-			Id:       flags.ServiceId,
-			Name:     fmt.Sprintf("%s-ss", flags.ServiceId),
-			Addr:     flags.GrpcAddress,
-			Port:     flags.GrpcPort,
-			Tags:     []string{"ss"},
-			Interval: 30,
-			TLS:      false,
+			logger.Fatal("Invalid controller address", log.Err(err))
+		}
+		defer conn.Close()
+		nodeID := flags.ServiceId
+		if nodeID == "" {
+			nodeID = getHost()
+		}
+		outbound := rpchandler.NewOutbound(rpcSrv, rpchandler.GrpcDialer(conn, token), rpchandler.OutboundConfig{
+			NodeID:  nodeID,
+			Version: version,
+			Logger:  rootLogger.Named("controller"),
 		})
-		if err != nil {
-			logger.Fatal(err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go outbound.Run(ctx)
+		logger.Info("Connecting to the controller", log.String("address", flags.controller.addr))
+	}
+
+	var registrar *consul.Registrar
+	if flags.IsConsul {
+		grpcReg := consul.GrpcRegConf{
+			Id:            flags.ServiceId,
+			Name:          fmt.Sprintf("%s-ss", flags.ServiceId),
+			Addr:          flags.GrpcAddress,
+			Port:          flags.GrpcPort,
+			Tags:          []string{"ss"},
+			Interval:      30,
+			TLS:           flags.grpcTLS.CertFile != "",
+			TLSSkipVerify: flags.consulSkipTLS,
+		}
+		switch flags.consul.check {
+		case "grpc":
+			if flags.grpcTLS.ClientCAFile != "" {
+				// Consul's gRPC check can't present a client certificate.
+				logger.Fatal("-grpc_client_ca requires -consul_check ttl")
+			}
+		case "ttl":
+			grpcReg.TTL = flags.consul.ttl
+		default:
+			logger.Fatal("Unknown -consul_check, must be grpc or ttl", log.String("value", flags.consul.check))
+		}
+		if flags.consul.ttl <= 0 {
+			logger.Fatal("-consul_ttl must be positive")
 		}
+		var portTags []string
+		if flags.consul.portTags != "" {
+			portTags = strings.Split(flags.consul.portTags, ",")
+		}
+		registrar = consul.NewRegistrar(consulClient, consul.RegistrarConfig{
+			Grpc:     grpcReg,
+			PortName: flags.consul.portService,
+			PortTags: portTags,
+			TTL:      flags.consul.ttl,
+		}, func() consul.NodeState {
+			health := srv.Health()
+			state := consul.NodeState{Healthy: health.Healthy(), Output: health.String()}
+			for _, p := range srv.PortStatuses() {
+				state.Ports = append(state.Ports, p.Port)
+			}
+			return state
+		})
+		if err := registrar.Register(); err != nil {
+			logger.Fatal("Failed to register in Consul", log.Err(err))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go registrar.Run(ctx, func(err error) {
+			logger.Warning("Failed to update Consul", log.Err(err))
+		})
+	}
+
+	var registry *consul.Registry
+	if flags.consul.registry != "" {
+		if flags.consul.ttl <= 0 {
+			logger.Fatal("-consul_ttl must be positive")
+		}
+		nodeID := flags.ServiceId
+		if nodeID == "" {
+			nodeID = getHost()
+		}
+		registry = consul.NewRegistry(consulClient, consul.RegistryConfig{
+			Prefix: flags.consul.registry,
+			Node: consul.NodeInfo{
+				ID:      nodeID,
+				Address: flags.GrpcAddress,
+				Port:    flags.GrpcPort,
+				Version: version,
+			},
+			TTL: flags.consul.ttl,
+		}, func() consul.NodeCapacity {
+			load := srv.Load()
+			return consul.NodeCapacity{
+				Ports:            load.Ports,
+				Keys:             load.Keys,
+				TCPConnections:   load.TCPConnections,
+				UDPSessions:      load.UDPSessions,
+				BytesTransferred: load.BytesTransferred,
+			}
+		})
+		if err := registry.Register(); err != nil {
+			logger.Fatal("Failed to register in the node registry", log.Err(err))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go registry.Run(ctx, func(err error) {
+			logger.Warning("Failed to update the node registry", log.Err(err))
+		})
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
+	logger.Info("Shutting down")
+	if registrar != nil {
+		if err := registrar.Deregister(); err != nil {
+			logger.Error("Failed to deregister from Consul", log.Err(err))
+		}
+	}
+	if registry != nil {
+		if err := registry.Deregister(); err != nil {
+			logger.Error("Failed to remove the node from the registry", log.Err(err))
+		}
+	}
+	if err := srv.Stop(); err != nil {
+		logger.Error("Failed to stop server", log.Err(err))
+	}
 }
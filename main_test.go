@@ -18,13 +18,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestRunSSServer(t *testing.T) {
 	m := metrics.NewPrometheusShadowsocksMetrics(nil, prometheus.DefaultRegisterer)
-	server, err := RunSSServer("config_example.yml", 30*time.Second, m, 10000)
+	server, err := RunSSServer("config_example.yml", &server.SSConfig{
+		NatTimeout:    30 * time.Second,
+		Metrics:       m,
+		ReplayHistory: 10000,
+	})
 	if err != nil {
 		t.Fatalf("RunSSServer() error = %v", err)
 	}
@@ -0,0 +1,321 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+)
+
+// The JSON representation of an access key, as in the Outline Server API.
+type accessKeyJSON struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Password  string     `json:"password"`
+	Port      int        `json:"port"`
+	Method    string     `json:"method"`
+	AccessURL string     `json:"accessUrl"`
+	DataLimit *DataLimit `json:"dataLimit,omitempty"`
+}
+
+type errorJSON struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Handler returns the HTTP handler of the API.  All requests must start with
+// `prefix`, which acts as a shared secret, e.g. "/Zm9vYmFy".  Requests without
+// it get a 404, so that the API can't be discovered.
+func (m *Manager) Handler(prefix string) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if path == r.URL.Path || !strings.HasPrefix(path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		m.route(w, r, strings.Split(strings.Trim(path, "/"), "/"))
+	})
+}
+
+func (m *Manager) route(w http.ResponseWriter, r *http.Request, segments []string) {
+	method := r.Method
+	switch {
+	case match(segments, "server") && method == http.MethodGet:
+		m.handleServerInfo(w, r)
+	case match(segments, "name") && method == http.MethodPut:
+		m.handleRenameServer(w, r)
+	case match(segments, "server", "hostname-for-access-keys") && method == http.MethodPut:
+		m.handleSetHostname(w, r)
+	case match(segments, "server", "port-for-new-access-keys") && method == http.MethodPut:
+		m.handleSetPort(w, r)
+	case match(segments, "server", "access-key-data-limit") && method == http.MethodPut:
+		m.handleSetDefaultLimit(w, r)
+	case match(segments, "server", "access-key-data-limit") && method == http.MethodDelete:
+		m.writeResult(w, m.SetDefaultDataLimit(nil))
+	case match(segments, "metrics", "enabled") && method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]bool{"metricsEnabled": m.ServerInfo().MetricsEnabled})
+	case match(segments, "metrics", "enabled") && method == http.MethodPut:
+		m.handleSetMetricsEnabled(w, r)
+	case match(segments, "metrics", "transfer") && method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]map[string]int64{"bytesTransferredByUserId": m.TransferredBytes()})
+	case match(segments, "access-keys") && method == http.MethodGet:
+		m.handleListKeys(w, r)
+	case match(segments, "access-keys") && method == http.MethodPost:
+		m.handleCreateKey(w, r, "")
+	case match(segments, "access-keys", "*") && method == http.MethodPut:
+		m.handleCreateKey(w, r, segments[1])
+	case match(segments, "access-keys", "*") && method == http.MethodGet:
+		m.handleGetKey(w, r, segments[1])
+	case match(segments, "access-keys", "*") && method == http.MethodDelete:
+		m.writeResult(w, m.DeleteKey(segments[1]))
+	case match(segments, "access-keys", "*", "name") && method == http.MethodPut:
+		m.handleRenameKey(w, r, segments[1])
+	case match(segments, "access-keys", "*", "data-limit") && method == http.MethodPut:
+		m.handleSetKeyLimit(w, r, segments[1])
+	case match(segments, "access-keys", "*", "data-limit") && method == http.MethodDelete:
+		m.writeResult(w, m.SetKeyDataLimit(segments[1], nil))
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", "Unknown API call")
+	}
+}
+
+// Reports whether the path segments match the pattern, where "*" matches any
+// non-empty segment.
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if segments[i] == "" || (p != "*" && p != segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorJSON{Code: code, Message: message})
+}
+
+// Writes the response to a call without a body: 204 on success, or the error.
+func (m *Manager) writeResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "NotFound", err.Error())
+	case errors.Is(err, ErrKeyExists):
+		writeError(w, http.StatusConflict, "Conflict", err.Error())
+	case errors.Is(err, ErrInvalidInput):
+		writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+	default:
+		m.logger.Error("Management API error", log.Err(err))
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// Decodes the JSON body into v.  An empty body leaves v unchanged.
+func (m *Manager) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Returns the host for access URLs: the configured hostname, or else the host
+// that the request was sent to.
+func (m *Manager) accessHost(r *http.Request) string {
+	if hostname := m.ServerInfo().HostnameForAccessKeys; hostname != "" {
+		return hostname
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return r.Host
+	}
+	return host
+}
+
+func (m *Manager) toJSON(r *http.Request, key AccessKey) accessKeyJSON {
+	return accessKeyJSON{
+		ID:        key.ID,
+		Name:      key.Name,
+		Password:  key.Secret,
+		Port:      key.Port,
+		Method:    key.Cipher,
+		AccessURL: server.AccessURL(m.accessHost(r), key.Port, key.Cipher, key.Secret) + "/?outline=1",
+		DataLimit: key.DataLimit,
+	}
+}
+
+func (m *Manager) handleServerInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.ServerInfo())
+}
+
+func (m *Manager) handleRenameServer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name *string `json:"name"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == nil || *req.Name == "" || len(*req.Name) > 100 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `name` must be a non-empty string of at most 100 bytes")
+		return
+	}
+	m.writeResult(w, m.UpdateServer(func(info *ServerInfo) error {
+		info.Name = *req.Name
+		return nil
+	}))
+}
+
+func (m *Manager) handleSetHostname(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Hostname string `json:"hostname"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	if req.Hostname == "" || strings.ContainsAny(req.Hostname, "/@ ") {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `hostname` must be a hostname or IP address")
+		return
+	}
+	m.writeResult(w, m.UpdateServer(func(info *ServerInfo) error {
+		info.HostnameForAccessKeys = req.Hostname
+		return nil
+	}))
+}
+
+func (m *Manager) handleSetPort(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Port int `json:"port"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `port` must be between 1 and 65535")
+		return
+	}
+	m.writeResult(w, m.UpdateServer(func(info *ServerInfo) error {
+		info.PortForNewAccessKeys = req.Port
+		return nil
+	}))
+}
+
+func (m *Manager) handleSetMetricsEnabled(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MetricsEnabled *bool `json:"metricsEnabled"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	if req.MetricsEnabled == nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `metricsEnabled` must be a boolean")
+		return
+	}
+	// Only recorded for the Outline Manager: this server doesn't report metrics to Outline.
+	m.writeResult(w, m.UpdateServer(func(info *ServerInfo) error {
+		info.MetricsEnabled = *req.MetricsEnabled
+		return nil
+	}))
+}
+
+// The body of the data limit calls: {"limit": {"bytes": 1000}}
+type limitRequest struct {
+	Limit *DataLimit `json:"limit"`
+}
+
+func (m *Manager) readLimit(w http.ResponseWriter, r *http.Request) (*DataLimit, bool) {
+	var req limitRequest
+	if !m.readJSON(w, r, &req) {
+		return nil, false
+	}
+	if req.Limit == nil || req.Limit.Bytes < 0 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `limit.bytes` must be a non-negative number")
+		return nil, false
+	}
+	return req.Limit, true
+}
+
+func (m *Manager) handleSetDefaultLimit(w http.ResponseWriter, r *http.Request) {
+	if limit, ok := m.readLimit(w, r); ok {
+		m.writeResult(w, m.SetDefaultDataLimit(limit))
+	}
+}
+
+func (m *Manager) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys := []accessKeyJSON{}
+	for _, key := range m.Keys() {
+		keys = append(keys, m.toJSON(r, key))
+	}
+	writeJSON(w, http.StatusOK, map[string][]accessKeyJSON{"accessKeys": keys})
+}
+
+func (m *Manager) handleCreateKey(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Name     string     `json:"name"`
+		Method   string     `json:"method"`
+		Password string     `json:"password"`
+		Port     int        `json:"port"`
+		Limit    *DataLimit `json:"limit"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	key, err := m.CreateKey(NewKeyParams{
+		ID:        id,
+		Name:      req.Name,
+		Cipher:    req.Method,
+		Secret:    req.Password,
+		Port:      req.Port,
+		DataLimit: req.Limit,
+	})
+	if err != nil {
+		m.writeResult(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, m.toJSON(r, key))
+}
+
+func (m *Manager) handleGetKey(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := m.Key(id)
+	if err != nil {
+		m.writeResult(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m.toJSON(r, key))
+}
+
+func (m *Manager) handleRenameKey(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Name *string `json:"name"`
+	}
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Parameter `name` must be a string")
+		return
+	}
+	m.writeResult(w, m.RenameKey(id, *req.Name))
+}
+
+func (m *Manager) handleSetKeyLimit(w http.ResponseWriter, r *http.Request, id string) {
+	if limit, ok := m.readLimit(w, r); ok {
+		m.writeResult(w, m.SetKeyDataLimit(id, limit))
+	}
+}
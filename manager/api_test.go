@@ -0,0 +1,285 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrefix = "/TestSecretPrefix"
+
+// In-memory KeyManager that enforces the data limits like server.SSServer.
+type fakeKeyManager struct {
+	mu     sync.Mutex
+	keys   map[string]server.CipherStruct
+	usage  map[string]int64
+	limits server.DataLimits
+}
+
+func newFakeKeyManager(keys ...server.CipherStruct) *fakeKeyManager {
+	km := &fakeKeyManager{keys: make(map[string]server.CipherStruct), usage: make(map[string]int64)}
+	for _, key := range keys {
+		km.keys[key.ID] = key
+	}
+	return km
+}
+
+// Must be called with km.mu held.
+func (km *fakeKeyManager) limited(id string) bool {
+	limit, ok := km.limits.Keys[id]
+	if !ok && km.limits.Default != nil {
+		limit, ok = *km.limits.Default, true
+	}
+	return ok && km.usage[id] >= limit
+}
+
+func (km *fakeKeyManager) list(limited bool) []server.CipherStruct {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	var keys []server.CipherStruct
+	for _, key := range km.keys {
+		if km.limited(key.ID) == limited {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+func (km *fakeKeyManager) Keys() []server.CipherStruct {
+	return km.list(false)
+}
+
+func (km *fakeKeyManager) LimitedKeys() []server.CipherStruct {
+	return km.list(true)
+}
+
+func (km *fakeKeyManager) AddManagedKey(cs server.CipherStruct) (int, error) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.keys[cs.ID] = cs
+	return cs.Port, nil
+}
+
+func (km *fakeKeyManager) RemoveManagedKey(id string) error {
+	return km.RemoveCipher(server.CipherStruct{ID: id})
+}
+
+func (km *fakeKeyManager) RemoveCipher(cs server.CipherStruct) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if _, ok := km.keys[cs.ID]; !ok {
+		return fmt.Errorf("no key %v", cs.ID)
+	}
+	delete(km.keys, cs.ID)
+	return nil
+}
+
+func (km *fakeKeyManager) SetDataLimits(limits server.DataLimits) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.limits = limits
+}
+
+func (km *fakeKeyManager) TransferredBytes() map[string]int64 {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	usage := make(map[string]int64)
+	for k, v := range km.usage {
+		usage[k] = v
+	}
+	return usage
+}
+
+func (km *fakeKeyManager) isServed(id string) bool {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	_, ok := km.keys[id]
+	return ok && !km.limited(id)
+}
+
+func makeTestManager(t *testing.T, km KeyManager, statePath string) *Manager {
+	m, err := NewManager(km, Config{
+		Hostname:             "example.com",
+		PortForNewAccessKeys: 9000,
+		StatePath:            statePath,
+		Version:              "test",
+		Logger:               log.Default(),
+	})
+	require.NoError(t, err)
+	return m
+}
+
+// Sends a request to the API and decodes the JSON response into `out`, if not nil.
+func call(t *testing.T, h http.Handler, method, path string, body interface{}, out interface{}) int {
+	var reqBody bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &reqBody)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAPI_SecretPrefix(t *testing.T) {
+	h := makeTestManager(t, newFakeKeyManager(), "").Handler(testPrefix)
+	require.Equal(t, http.StatusNotFound, call(t, h, "GET", "/server", nil, nil))
+	require.Equal(t, http.StatusNotFound, call(t, h, "GET", "/TestSecretPrefixAndMore/server", nil, nil))
+	require.Equal(t, http.StatusOK, call(t, h, "GET", testPrefix+"/server", nil, nil))
+}
+
+func TestAPI_KeyLifecycle(t *testing.T) {
+	km := newFakeKeyManager(server.CipherStruct{ID: "1", Port: 8000, Cipher: "aes-256-gcm", Secret: "configured"})
+	h := makeTestManager(t, km, "").Handler(testPrefix)
+
+	var created accessKeyJSON
+	require.Equal(t, http.StatusCreated, call(t, h, "POST", testPrefix+"/access-keys", nil, &created))
+	require.Equal(t, "2", created.ID, "IDs of configured keys should be skipped")
+	require.Equal(t, 9000, created.Port)
+	require.Equal(t, server.DefaultCipher, created.Method)
+	require.NotEmpty(t, created.Password)
+	require.True(t, strings.HasPrefix(created.AccessURL, "ss://"))
+	require.True(t, strings.HasSuffix(created.AccessURL, "@example.com:9000/?outline=1"))
+	require.True(t, km.isServed("2"))
+
+	require.Equal(t, http.StatusCreated, call(t, h, "PUT", testPrefix+"/access-keys/custom",
+		map[string]interface{}{"name": "Custom", "method": "aes-128-gcm", "password": "pw", "port": 8001}, &created))
+	require.Equal(t, "custom", created.ID)
+	require.Equal(t, "Custom", created.Name)
+	require.Equal(t, http.StatusConflict, call(t, h, "PUT", testPrefix+"/access-keys/custom", nil, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "POST", testPrefix+"/access-keys",
+		map[string]string{"method": "rc4-md5"}, nil))
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/access-keys/1/name", map[string]string{"name": "Alice"}, nil))
+	require.Equal(t, http.StatusNotFound, call(t, h, "PUT", testPrefix+"/access-keys/404/name", map[string]string{"name": "Bob"}, nil))
+
+	var list struct {
+		AccessKeys []accessKeyJSON `json:"accessKeys"`
+	}
+	require.Equal(t, http.StatusOK, call(t, h, "GET", testPrefix+"/access-keys", nil, &list))
+	require.Len(t, list.AccessKeys, 3)
+	require.Equal(t, "1", list.AccessKeys[0].ID)
+	require.Equal(t, "Alice", list.AccessKeys[0].Name)
+	require.Equal(t, "configured", list.AccessKeys[0].Password)
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "DELETE", testPrefix+"/access-keys/2", nil, nil))
+	require.False(t, km.isServed("2"))
+	require.Equal(t, http.StatusNotFound, call(t, h, "GET", testPrefix+"/access-keys/2", nil, nil))
+}
+
+func TestAPI_DataLimits(t *testing.T) {
+	km := newFakeKeyManager(
+		server.CipherStruct{ID: "1", Port: 8000, Cipher: server.DefaultCipher, Secret: "a"},
+		server.CipherStruct{ID: "2", Port: 8000, Cipher: server.DefaultCipher, Secret: "b"},
+	)
+	km.usage["1"] = 1000
+	km.usage["2"] = 10
+	m := makeTestManager(t, km, "")
+	h := m.Handler(testPrefix)
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/server/access-key-data-limit",
+		map[string]interface{}{"limit": map[string]int64{"bytes": 100}}, nil))
+	require.False(t, km.isServed("1"), "Key over the server-wide limit should be disabled")
+	require.True(t, km.isServed("2"))
+
+	// The disabled key is still listed.
+	var key accessKeyJSON
+	require.Equal(t, http.StatusOK, call(t, h, "GET", testPrefix+"/access-keys/1", nil, &key))
+
+	// A per-key limit overrides the server-wide limit.
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/access-keys/1/data-limit",
+		map[string]interface{}{"limit": map[string]int64{"bytes": 5000}}, nil))
+	require.True(t, km.isServed("1"))
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/access-keys/2/data-limit",
+		map[string]interface{}{"limit": map[string]int64{"bytes": 5}}, nil))
+	require.False(t, km.isServed("2"))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "PUT", testPrefix+"/access-keys/2/data-limit",
+		map[string]interface{}{"limit": map[string]int64{"bytes": -1}}, nil))
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "DELETE", testPrefix+"/access-keys/2/data-limit", nil, nil))
+	require.Equal(t, http.StatusNoContent, call(t, h, "DELETE", testPrefix+"/server/access-key-data-limit", nil, nil))
+	require.True(t, km.isServed("2"))
+
+	var transfer struct {
+		Bytes map[string]int64 `json:"bytesTransferredByUserId"`
+	}
+	require.Equal(t, http.StatusOK, call(t, h, "GET", testPrefix+"/metrics/transfer", nil, &transfer))
+	require.Equal(t, map[string]int64{"1": 1000, "2": 10}, transfer.Bytes)
+}
+
+func TestAPI_ServerSettings(t *testing.T) {
+	h := makeTestManager(t, newFakeKeyManager(), "").Handler(testPrefix)
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/name", map[string]string{"name": "My Server"}, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "PUT", testPrefix+"/name", map[string]string{"name": ""}, nil))
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/server/port-for-new-access-keys", map[string]int{"port": 443}, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "PUT", testPrefix+"/server/port-for-new-access-keys", map[string]int{"port": 70000}, nil))
+	require.Equal(t, http.StatusNoContent, call(t, h, "PUT", testPrefix+"/server/hostname-for-access-keys", map[string]string{"hostname": "198.51.100.1"}, nil))
+
+	var info ServerInfo
+	require.Equal(t, http.StatusOK, call(t, h, "GET", testPrefix+"/server", nil, &info))
+	require.Equal(t, "My Server", info.Name)
+	require.Equal(t, 443, info.PortForNewAccessKeys)
+	require.Equal(t, "198.51.100.1", info.HostnameForAccessKeys)
+	require.Equal(t, "test", info.Version)
+	require.NotEmpty(t, info.ServerID)
+
+	var created accessKeyJSON
+	require.Equal(t, http.StatusCreated, call(t, h, "POST", testPrefix+"/access-keys", nil, &created))
+	require.Equal(t, 443, created.Port)
+	require.Contains(t, created.AccessURL, "@198.51.100.1:443/")
+}
+
+func TestManager_State(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	km := newFakeKeyManager(server.CipherStruct{ID: "1", Port: 8000, Cipher: server.DefaultCipher, Secret: "a"})
+	m := makeTestManager(t, km, statePath)
+	created, err := m.CreateKey(NewKeyParams{Name: "API key"})
+	require.NoError(t, err)
+	require.NoError(t, m.RenameKey("1", "Config key"))
+	serverID := m.ServerInfo().ServerID
+
+	// After a restart, the server only serves the configured key.
+	km = newFakeKeyManager(server.CipherStruct{ID: "1", Port: 8000, Cipher: server.DefaultCipher, Secret: "a"})
+	m = makeTestManager(t, km, statePath)
+	require.Equal(t, serverID, m.ServerInfo().ServerID)
+	require.True(t, km.isServed(created.ID), "Key created through the API should be restored")
+	keys := m.Keys()
+	require.Len(t, keys, 2)
+	require.Equal(t, "Config key", keys[0].Name)
+	require.Equal(t, "API key", keys[1].Name)
+	require.Equal(t, created.Secret, keys[1].Secret)
+
+	// IDs are not reused.
+	next, err := m.CreateKey(NewKeyParams{})
+	require.NoError(t, err)
+	require.Equal(t, "3", next.ID)
+}
+
+func TestLoadOrCreateCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+	cert, err := LoadOrCreateCertificate(certFile, keyFile, "198.51.100.1")
+	require.NoError(t, err)
+	fingerprint := CertificateSHA256(cert)
+	require.Len(t, fingerprint, 64)
+
+	// The saved certificate is reused.
+	cert, err = LoadOrCreateCertificate(certFile, keyFile, "198.51.100.1")
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, CertificateSHA256(cert))
+}
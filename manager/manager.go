@@ -0,0 +1,489 @@
+// Package manager implements a management API that is compatible with the
+// access key API of Outline Server, so that servers can be managed with the
+// Outline Manager and the tools built for it.
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+)
+
+const defaultServerName = "Outline Server"
+
+// Errors returned by the Manager.  The API maps them to HTTP status codes.
+var (
+	ErrNotFound     = errors.New("access key not found")
+	ErrKeyExists    = errors.New("access key already exists")
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// KeyManager is the part of server.SSServer that the Manager uses.  The keys
+// created through the API are managed keys, which the server keeps across
+// config reloads, and the server enforces the data limits.
+type KeyManager interface {
+	Keys() []server.CipherStruct
+	LimitedKeys() []server.CipherStruct
+	AddManagedKey(cs server.CipherStruct) (int, error)
+	RemoveManagedKey(id string) error
+	RemoveCipher(cs server.CipherStruct) error
+	SetDataLimits(limits server.DataLimits)
+	TransferredBytes() map[string]int64
+}
+
+// Config configures the Manager.
+type Config struct {
+	// Hostname is the host used in access URLs.  If empty, the host that the
+	// API request was sent to is used.
+	Hostname string
+	// PortForNewAccessKeys is the default port of new access keys.  If zero,
+	// a free port is picked.
+	PortForNewAccessKeys int
+	// StatePath is a JSON file that holds the server settings, the key names
+	// and limits, and the keys created through the API.  Empty keeps the state
+	// in memory only.
+	StatePath string
+	Version   string
+	Logger    *log.Logger
+}
+
+// DataLimit is a limit on the bytes transferred by an access key.
+type DataLimit struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// AccessKey is an access key as reported by the API.
+type AccessKey struct {
+	server.CipherStruct
+	Name      string
+	DataLimit *DataLimit
+	// Disabled is true while the key is over its data limit, and not served.
+	Disabled bool
+}
+
+// ServerInfo holds the server-wide settings.
+type ServerInfo struct {
+	Name                  string     `json:"name"`
+	ServerID              string     `json:"serverId"`
+	MetricsEnabled        bool       `json:"metricsEnabled"`
+	CreatedTimestampMs    int64      `json:"createdTimestampMs"`
+	Version               string     `json:"version"`
+	AccessKeyDataLimit    *DataLimit `json:"accessKeyDataLimit,omitempty"`
+	PortForNewAccessKeys  int        `json:"portForNewAccessKeys"`
+	HostnameForAccessKeys string     `json:"hostnameForAccessKeys,omitempty"`
+}
+
+// Per-key metadata that the server doesn't know about.
+type keyRecord struct {
+	Name      string     `json:"name,omitempty"`
+	DataLimit *DataLimit `json:"dataLimit,omitempty"`
+	// Key is set for keys created through the API, so that they can be
+	// served again on start.  Keys from the config file are left to it.
+	Key *server.CipherStruct `json:"key,omitempty"`
+}
+
+// The persisted state.
+type state struct {
+	Server ServerInfo            `json:"server"`
+	NextID int                   `json:"nextId"`
+	Keys   map[string]*keyRecord `json:"keys"`
+}
+
+// Manager implements the management operations on top of a KeyManager.
+// It is safe for concurrent use.
+type Manager struct {
+	km     KeyManager
+	config Config
+	logger *log.Logger
+	mu     sync.Mutex // Protects state.
+	state  state
+}
+
+// NewManager loads the state, serves the keys created through the API again,
+// and passes the data limits to the KeyManager.
+func NewManager(km KeyManager, config Config) (*Manager, error) {
+	m := &Manager{
+		km:     km,
+		config: config,
+		logger: config.Logger,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if config.Hostname != "" {
+		m.state.Server.HostnameForAccessKeys = config.Hostname
+	}
+	if config.PortForNewAccessKeys != 0 {
+		m.state.Server.PortForNewAccessKeys = config.PortForNewAccessKeys
+	}
+	if m.state.Server.PortForNewAccessKeys == 0 {
+		port, err := pickFreePort()
+		if err != nil {
+			return nil, err
+		}
+		m.state.Server.PortForNewAccessKeys = port
+	}
+	m.state.Server.Version = config.Version
+	m.restoreKeys()
+	m.km.SetDataLimits(m.dataLimits())
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newServerID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func pickFreePort() (int, error) {
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to pick a port for new access keys: %w", err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (m *Manager) load() error {
+	m.state = state{NextID: 1, Keys: make(map[string]*keyRecord)}
+	if m.config.StatePath != "" {
+		data, err := ioutil.ReadFile(m.config.StatePath)
+		if err == nil {
+			if err := json.Unmarshal(data, &m.state); err != nil {
+				return fmt.Errorf("failed to parse manager state %v: %w", m.config.StatePath, err)
+			}
+			if m.state.Keys == nil {
+				m.state.Keys = make(map[string]*keyRecord)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read manager state: %w", err)
+		}
+	}
+	if m.state.Server.ServerID == "" {
+		id, err := newServerID()
+		if err != nil {
+			return err
+		}
+		m.state.Server.ServerID = id
+		m.state.Server.Name = defaultServerName
+		m.state.Server.CreatedTimestampMs = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return nil
+}
+
+// Writes the state atomically, so that a crash can't leave a partial file.
+// Must be called with m.mu held, except during construction.
+func (m *Manager) save() error {
+	if m.config.StatePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(&m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(m.config.StatePath), filepath.Base(m.config.StatePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save manager state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save manager state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save manager state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.config.StatePath); err != nil {
+		return fmt.Errorf("failed to save manager state: %w", err)
+	}
+	return nil
+}
+
+// Adds the keys created through the API to the server after a restart.  Only
+// called during construction.
+func (m *Manager) restoreKeys() {
+	for id, record := range m.state.Keys {
+		if record.Key == nil {
+			continue
+		}
+		port, err := m.km.AddManagedKey(*record.Key)
+		if err != nil {
+			m.logger.Error("Failed to restore access key", log.String("access_key", id), log.Err(err))
+			continue
+		}
+		record.Key.Port = port
+	}
+}
+
+// Returns the data limits of the state.  Must be called with m.mu held, except
+// during construction.
+func (m *Manager) dataLimits() server.DataLimits {
+	limits := server.DataLimits{Keys: make(map[string]int64)}
+	if limit := m.state.Server.AccessKeyDataLimit; limit != nil {
+		bytes := limit.Bytes
+		limits.Default = &bytes
+	}
+	for id, record := range m.state.Keys {
+		if record.DataLimit != nil {
+			limits.Keys[id] = record.DataLimit.Bytes
+		}
+	}
+	return limits
+}
+
+func (m *Manager) record(id string) *keyRecord {
+	record, ok := m.state.Keys[id]
+	if !ok {
+		record = &keyRecord{}
+		m.state.Keys[id] = record
+	}
+	return record
+}
+
+// Returns the served keys and the keys over their data limit.  Must be called
+// with m.mu held.
+func (m *Manager) keysLocked() []AccessKey {
+	var keys []AccessKey
+	for _, cs := range m.km.Keys() {
+		keys = append(keys, m.accessKeyLocked(cs, false))
+	}
+	for _, cs := range m.km.LimitedKeys() {
+		keys = append(keys, m.accessKeyLocked(cs, true))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessID(keys[i].ID, keys[j].ID)
+	})
+	return keys
+}
+
+// Orders numeric IDs numerically, as they are in Outline.
+func lessID(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	if (errA == nil) != (errB == nil) {
+		return errA == nil
+	}
+	return a < b
+}
+
+func (m *Manager) accessKeyLocked(cs server.CipherStruct, disabled bool) AccessKey {
+	key := AccessKey{CipherStruct: cs, Disabled: disabled}
+	if record, ok := m.state.Keys[cs.ID]; ok {
+		key.Name = record.Name
+		key.DataLimit = record.DataLimit
+	}
+	return key
+}
+
+func (m *Manager) findLocked(id string) (AccessKey, bool) {
+	for _, key := range m.keysLocked() {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return AccessKey{}, false
+}
+
+// Keys returns all the access keys, including those over their data limit.
+func (m *Manager) Keys() []AccessKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keysLocked()
+}
+
+// Key returns the access key with this ID.
+func (m *Manager) Key(id string) (AccessKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.findLocked(id)
+	if !ok {
+		return AccessKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+// NewKeyParams are the optional parameters of a new access key.
+type NewKeyParams struct {
+	// ID is the key ID.  If empty, the next free numeric ID is used.
+	ID        string
+	Name      string
+	Cipher    string
+	Secret    string
+	Port      int
+	DataLimit *DataLimit
+}
+
+// CreateKey creates an access key and starts serving it.
+func (m *Manager) CreateKey(params NewKeyParams) (AccessKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if params.ID != "" {
+		if _, exists := m.findLocked(params.ID); exists {
+			return AccessKey{}, ErrKeyExists
+		}
+	} else {
+		params.ID = m.nextIDLocked()
+	}
+	if params.Cipher == "" {
+		params.Cipher = server.DefaultCipher
+	}
+	if err := server.ValidateCipher(params.Cipher); err != nil {
+		return AccessKey{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if params.Secret == "" {
+		secret, err := server.GenerateSecret()
+		if err != nil {
+			return AccessKey{}, err
+		}
+		params.Secret = secret
+	}
+	if params.Port == 0 {
+		params.Port = m.state.Server.PortForNewAccessKeys
+	}
+	if params.Port < 1 || params.Port > 65535 {
+		return AccessKey{}, fmt.Errorf("%w: port %v is out of range", ErrInvalidInput, params.Port)
+	}
+	if err := validateLimit(params.DataLimit); err != nil {
+		return AccessKey{}, err
+	}
+	cs := server.CipherStruct{ID: params.ID, Port: params.Port, Cipher: params.Cipher, Secret: params.Secret}
+	m.state.Keys[cs.ID] = &keyRecord{Name: params.Name, DataLimit: params.DataLimit, Key: &cs}
+	if params.DataLimit != nil {
+		m.km.SetDataLimits(m.dataLimits())
+	}
+	port, err := m.km.AddManagedKey(cs)
+	if err != nil {
+		delete(m.state.Keys, cs.ID)
+		m.km.SetDataLimits(m.dataLimits())
+		return AccessKey{}, err
+	}
+	cs.Port = port
+	m.logger.Info("Created access key", log.String("access_key", cs.ID), log.Int("port", cs.Port))
+	key, _ := m.findLocked(cs.ID)
+	return key, m.save()
+}
+
+func (m *Manager) nextIDLocked() string {
+	for {
+		id := strconv.Itoa(m.state.NextID)
+		m.state.NextID++
+		if _, exists := m.findLocked(id); !exists {
+			return id
+		}
+	}
+}
+
+// DeleteKey stops serving an access key and forgets it.  Keys from the config
+// file come back when it is reloaded.
+func (m *Manager) DeleteKey(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.findLocked(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if record, ok := m.state.Keys[id]; ok && record.Key != nil {
+		if err := m.km.RemoveManagedKey(id); err != nil {
+			return err
+		}
+	} else if err := m.km.RemoveCipher(key.CipherStruct); err != nil {
+		return err
+	}
+	delete(m.state.Keys, id)
+	m.km.SetDataLimits(m.dataLimits())
+	m.logger.Info("Deleted access key", log.String("access_key", id))
+	return m.save()
+}
+
+// RenameKey sets the name of an access key.
+func (m *Manager) RenameKey(id, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.findLocked(id); !ok {
+		return ErrNotFound
+	}
+	m.record(id).Name = name
+	return m.save()
+}
+
+func validateLimit(limit *DataLimit) error {
+	if limit != nil && limit.Bytes < 0 {
+		return fmt.Errorf("%w: data limit must not be negative", ErrInvalidInput)
+	}
+	return nil
+}
+
+// SetKeyDataLimit sets or, if `limit` is nil, removes the data limit of an
+// access key, which overrides the server-wide limit.
+func (m *Manager) SetKeyDataLimit(id string, limit *DataLimit) error {
+	if err := validateLimit(limit); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.findLocked(id); !ok {
+		return ErrNotFound
+	}
+	m.record(id).DataLimit = limit
+	m.km.SetDataLimits(m.dataLimits())
+	return m.save()
+}
+
+// SetDefaultDataLimit sets or, if `limit` is nil, removes the data limit of
+// the access keys that don't have their own.
+func (m *Manager) SetDefaultDataLimit(limit *DataLimit) error {
+	if err := validateLimit(limit); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Server.AccessKeyDataLimit = limit
+	m.km.SetDataLimits(m.dataLimits())
+	return m.save()
+}
+
+// ServerInfo returns the server-wide settings.
+func (m *Manager) ServerInfo() ServerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.Server
+}
+
+// UpdateServer applies `update` to the server-wide settings and saves them.
+func (m *Manager) UpdateServer(update func(info *ServerInfo) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info := m.state.Server
+	if err := update(&info); err != nil {
+		return err
+	}
+	m.state.Server = info
+	return m.save()
+}
+
+// TransferredBytes returns the bytes transferred by each access key, as
+// counted by the KeyManager.
+func (m *Manager) TransferredBytes() map[string]int64 {
+	return m.km.TransferredBytes()
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadOrCreateCertificate loads the API certificate from certFile and keyFile.
+// If they don't exist, it generates a self-signed certificate for `host` and
+// saves it there, like the Outline Server installer does.  If certFile is
+// empty, the generated certificate is not saved.  The Outline Manager pins the
+// certificate by its SHA-256 fingerprint, so it doesn't need to be signed by a CA.
+func LoadOrCreateCertificate(certFile, keyFile, host string) (tls.Certificate, error) {
+	if certFile == "" {
+		certPEM, keyPEM, err := generateCertificate(host)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to generate API certificate: %w", err)
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		return cert, nil
+	}
+	if _, statErr := os.Stat(certFile); !os.IsNotExist(statErr) {
+		return tls.Certificate{}, fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	certPEM, keyPEM, err := generateCertificate(host)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate API certificate: %w", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save API key: %w", err)
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save API certificate: %w", err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func generateCertificate(host string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else if host != "" {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// CertificateSHA256 returns the fingerprint of the leaf certificate in the
+// format that the Outline Manager expects as "certSha256".
+func CertificateSHA256(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,146 @@
+package otlp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The OTLP/JSON messages.  64-bit integers are strings, as in the JSON
+// mapping of protobuf, and the trace and span IDs are hex.
+
+type exportMetricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   scope        `json:"scope"`
+	Metrics []metricJSON `json:"metrics"`
+}
+
+type exportTraceRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    string   `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func stringAttribute(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+// Returns the attribute `key` with `value`, a string, an integer, a bool or
+// a float64.  Other values are formatted as strings.
+func attribute(key string, value interface{}) keyValue {
+	switch v := value.(type) {
+	case string:
+		return stringAttribute(key, v)
+	case int:
+		return keyValue{Key: key, Value: anyValue{IntValue: strconv.Itoa(v)}}
+	case int64:
+		return keyValue{Key: key, Value: anyValue{IntValue: strconv.FormatInt(v, 10)}}
+	case bool:
+		return keyValue{Key: key, Value: anyValue{BoolValue: &v}}
+	case float64:
+		return keyValue{Key: key, Value: anyValue{DoubleValue: &v}}
+	}
+	return stringAttribute(key, fmt.Sprint(value))
+}
+
+// Aggregation temporality and span enums.
+const (
+	temporalityCumulative = 2
+	spanKindInternal      = 1
+	spanKindServer        = 2
+	statusCodeOK          = 1
+	statusCodeError       = 2
+)
+
+type metricJSON struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Sum         *sumJSON       `json:"sum,omitempty"`
+	Gauge       *gaugeJSON     `json:"gauge,omitempty"`
+	Histogram   *histogramJSON `json:"histogram,omitempty"`
+}
+
+type sumJSON struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type gaugeJSON struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type histogramJSON struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            statusJSON `json:"status"`
+}
+
+type statusJSON struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,414 @@
+package otlp
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+type instrumentKind int
+
+const (
+	// A monotonic cumulative sum.
+	kindCounter instrumentKind = iota
+	// A non-monotonic cumulative sum, whose series are removed when they
+	// reach zero so that removed keys don't leave series behind.
+	kindUpDownCounter
+	kindGauge
+	kindHistogram
+)
+
+// An instrument keeps the series of a metric in memory, to export them all
+// every time.
+type instrument struct {
+	name        string
+	description string
+	unit        string
+	kind        instrumentKind
+	labels      []string
+	// Upper bounds of the buckets of a histogram.
+	bounds []float64
+	// Converts the values of the series when they are collected, merging
+	// the series with the same values.  Only for sums.  May be nil.
+	relabel func(values []string) []string
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	// The value, or the sum of a histogram.
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+func newInstrument(kind instrumentKind, name, description, unit string, labels ...string) *instrument {
+	return &instrument{name: name, description: description, unit: unit, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+func newHistogram(name, description, unit string, bounds []float64, labels ...string) *instrument {
+	i := newInstrument(kindHistogram, name, description, unit, labels...)
+	i.bounds = bounds
+	return i
+}
+
+// Returns the series of `values`.  The caller holds mu.
+func (i *instrument) get(values []string) *series {
+	key := strings.Join(values, "\x00")
+	s, ok := i.series[key]
+	if !ok {
+		s = &series{values: values}
+		if i.kind == kindHistogram {
+			s.buckets = make([]uint64, len(i.bounds)+1)
+		}
+		i.series[key] = s
+	}
+	return s
+}
+
+func (i *instrument) add(delta float64, values ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s := i.get(values)
+	s.value += delta
+	if i.kind == kindUpDownCounter && s.value <= 0 {
+		delete(i.series, strings.Join(values, "\x00"))
+	}
+}
+
+// Adds `delta` unless it's zero, to avoid series that are always zero.
+func (i *instrument) addIfNonZero(delta int64, values ...string) {
+	if delta > 0 {
+		i.add(float64(delta), values...)
+	}
+}
+
+func (i *instrument) set(value float64, values ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.get(values).value = value
+}
+
+func (i *instrument) observe(value float64, values ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s := i.get(values)
+	s.value += value
+	s.count++
+	s.buckets[sort.SearchFloat64s(i.bounds, value)]++
+}
+
+// Returns the metric with the current value of every series, or nil if
+// there are none.
+func (i *instrument) collect(start, now time.Time) *metricJSON {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.series) == 0 {
+		return nil
+	}
+	all := i.series
+	if i.relabel != nil {
+		all = make(map[string]*series)
+		for _, s := range i.series {
+			values := i.relabel(s.values)
+			key := strings.Join(values, "\x00")
+			if merged, ok := all[key]; ok {
+				merged.value += s.value
+			} else {
+				all[key] = &series{values: values, value: s.value}
+			}
+		}
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	m := &metricJSON{Name: i.name, Description: i.description, Unit: i.unit}
+	var points []numberDataPoint
+	var histogramPoints []histogramDataPoint
+	for _, k := range keys {
+		s := all[k]
+		attributes := make([]keyValue, len(i.labels))
+		for j, label := range i.labels {
+			attributes[j] = stringAttribute(label, s.values[j])
+		}
+		if i.kind == kindHistogram {
+			buckets := make([]string, len(s.buckets))
+			for j, b := range s.buckets {
+				buckets[j] = strconv.FormatUint(b, 10)
+			}
+			histogramPoints = append(histogramPoints, histogramDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: startNano,
+				TimeUnixNano:      nowNano,
+				Count:             strconv.FormatUint(s.count, 10),
+				Sum:               s.value,
+				BucketCounts:      buckets,
+				ExplicitBounds:    i.bounds,
+			})
+			continue
+		}
+		points = append(points, numberDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: startNano,
+			TimeUnixNano:      nowNano,
+			AsDouble:          s.value,
+		})
+	}
+	switch i.kind {
+	case kindCounter, kindUpDownCounter:
+		m.Sum = &sumJSON{DataPoints: points, AggregationTemporality: temporalityCumulative, IsMonotonic: i.kind == kindCounter}
+	case kindGauge:
+		m.Gauge = &gaugeJSON{DataPoints: points}
+	case kindHistogram:
+		m.Histogram = &histogramJSON{DataPoints: histogramPoints, AggregationTemporality: temporalityCumulative}
+	}
+	return m
+}
+
+// The metrics of the Exporter, with the names, labels and buckets of the
+// Prometheus metrics so that the same dashboards work with both.
+type exporterMetrics struct {
+	buildInfo               *instrument
+	accessKeys              *instrument
+	ports                   *instrument
+	dataBytes               *instrument
+	timeToCipherMs          *instrument
+	timeToFirstByteMs       *instrument
+	tcpProbes               *instrument
+	tcpOpenConnections      *instrument
+	tcpClosedConnections    *instrument
+	tcpConnectionDurationMs *instrument
+	tcpActiveConnections    *instrument
+	// Without the client and access key labels.
+	tcpAggregateClosedConnections *instrument
+	aggregateDataBytes            *instrument
+	udpAddedNatEntries            *instrument
+	udpRemovedNatEntries          *instrument
+	udpActiveNatEntries           *instrument
+	udpNatEntryDurationMs         *instrument
+	bans                          *instrument
+	activeBans                    *instrument
+	targetDials                   *instrument
+	tcpTargetDialMs               *instrument
+	all                           []*instrument
+	// Adds the asn label after the location label.  May be nil.
+	asnLabels *metrics.ASNLabeler
+	keyLabels *metrics.AccessKeyLabeler
+}
+
+func newExporterMetrics(asnLabels *metrics.ASNLabeler, keyLabels *metrics.AccessKeyLabeler) exporterMetrics {
+	// The labels of the client, that follow the location.
+	client := []string{"location"}
+	if asnLabels != nil {
+		client = append(client, "asn")
+	}
+	labels := func(before []string, after ...string) []string {
+		return append(append(before, client...), after...)
+	}
+	m := exporterMetrics{
+		asnLabels:            asnLabels,
+		keyLabels:            keyLabels,
+		buildInfo:            newInstrument(kindGauge, "shadowsocks_build_info", "Information on the outline-ss-server build", "1", "version"),
+		accessKeys:           newInstrument(kindGauge, "shadowsocks_keys", "Count of access keys", "1"),
+		ports:                newInstrument(kindGauge, "shadowsocks_ports", "Count of open Shadowsocks ports", "1"),
+		tcpOpenConnections:   newInstrument(kindCounter, "shadowsocks_tcp_connections_opened", "Count of open TCP connections", "1", labels(nil)...),
+		tcpClosedConnections: newInstrument(kindCounter, "shadowsocks_tcp_connections_closed", "Count of closed TCP connections", "1", labels(nil, "status", "access_key")...),
+		tcpConnectionDurationMs: newHistogram("shadowsocks_tcp_connection_duration_ms", "TCP connection duration distributions.", "ms", []float64{
+			100,
+			float64(time.Second.Milliseconds()),
+			float64(time.Minute.Milliseconds()),
+			float64(time.Hour.Milliseconds()),
+			float64(24 * time.Hour.Milliseconds()),     // Day
+			float64(7 * 24 * time.Hour.Milliseconds()), // Week
+		}, "status"),
+		dataBytes:                     newInstrument(kindCounter, "shadowsocks_data_bytes", "Bytes transferred by the proxy", "By", labels([]string{"dir", "proto"}, "status", "access_key")...),
+		tcpProbes:                     newHistogram("shadowsocks_tcp_probes", "Histogram of number of bytes from client to proxy, for detecting possible probes", "By", []float64{0, 49, 50, 51, 73, 91}, labels(nil, "port", "status", "error")...),
+		timeToCipherMs:                newHistogram("shadowsocks_time_to_cipher_ms", "Time needed to find the cipher", "ms", []float64{0.1, 1, 10, 100, 1000}, "proto", "found_key"),
+		timeToFirstByteMs:             newHistogram("shadowsocks_time_to_first_byte_ms", "Time from connecting to the target, or sending it the first UDP packet, to its first byte", "ms", []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}, "proto"),
+		tcpAggregateClosedConnections: newInstrument(kindCounter, "shadowsocks_tcp_aggregate_connections_closed", "Count of closed TCP connections, by status only", "1", "status"),
+		aggregateDataBytes:            newInstrument(kindCounter, "shadowsocks_aggregate_data_bytes", "Bytes transferred by the proxy, by direction and protocol only", "By", "dir", "proto"),
+		tcpActiveConnections:          newInstrument(kindUpDownCounter, "shadowsocks_tcp_active_connections", "Count of authenticated TCP connections that are open", "1", "access_key", "port"),
+		udpAddedNatEntries:            newInstrument(kindCounter, "shadowsocks_udp_nat_entries_added", "Entries added to the UDP NAT table", "1"),
+		udpRemovedNatEntries:          newInstrument(kindCounter, "shadowsocks_udp_nat_entries_removed", "Entries removed from the UDP NAT table", "1"),
+		udpActiveNatEntries:           newInstrument(kindUpDownCounter, "shadowsocks_udp_active_nat_entries", "Count of entries in the UDP NAT table", "1", "access_key", "port"),
+		udpNatEntryDurationMs: newHistogram("shadowsocks_udp_nat_entry_duration_ms", "UDP NAT entry (session) duration distributions.", "ms", []float64{
+			float64(time.Second.Milliseconds()),
+			float64(10 * time.Second.Milliseconds()),
+			float64(time.Minute.Milliseconds()),
+			float64(5 * time.Minute.Milliseconds()),
+			float64(time.Hour.Milliseconds()),
+			float64(24 * time.Hour.Milliseconds()), // Day
+		}),
+		bans:       newInstrument(kindCounter, "shadowsocks_bans", "Count of bans of client IPs or subnets", "1", "scope"),
+		activeBans: newInstrument(kindGauge, "shadowsocks_active_bans", "Count of currently banned client IPs and subnets", "1"),
+		targetDials: newInstrument(kindCounter, "shadowsocks_target_dials", "Count of TCP dials and UDP NAT entries, by target location and port class", "1",
+			"proto", "location", "port_class", "status"),
+		tcpTargetDialMs: newHistogram("shadowsocks_tcp_target_dial_ms", "Time to resolve and connect to the targets", "ms",
+			[]float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}, "status"),
+	}
+	if keyLabels != nil {
+		// The active series are kept by key ID, and labelled when collected,
+		// so that they follow the changes of the labels.
+		relabel := func(values []string) []string {
+			return []string{keyLabels.Label(values[0]), values[1]}
+		}
+		m.tcpActiveConnections.relabel = relabel
+		m.udpActiveNatEntries.relabel = relabel
+	}
+	m.all = []*instrument{m.buildInfo, m.accessKeys, m.ports, m.tcpOpenConnections, m.tcpProbes, m.tcpClosedConnections,
+		m.tcpConnectionDurationMs, m.dataBytes, m.timeToCipherMs, m.timeToFirstByteMs, m.tcpActiveConnections,
+		m.udpAddedNatEntries, m.udpRemovedNatEntries, m.udpActiveNatEntries, m.udpNatEntryDurationMs, m.bans, m.activeBans,
+		m.tcpAggregateClosedConnections, m.aggregateDataBytes, m.targetDials, m.tcpTargetDialMs}
+	return m
+}
+
+// Returns the values of the location label, and of the asn label if there is
+// one.
+func (m *exporterMetrics) clientValues(clientInfo metrics.ClientInfo) []string {
+	if m.asnLabels == nil {
+		return []string{clientInfo.CountryCode}
+	}
+	return []string{clientInfo.CountryCode, m.asnLabels.Label(clientInfo.ASN)}
+}
+
+// Adds the data of `dir` and `proto`, unless it's zero.  `keyLabel` is the
+// label of the access key.
+func (m *exporterMetrics) addData(value int64, dir, proto string, client []string, status, keyLabel string) {
+	m.dataBytes.addIfNonZero(value, append(append([]string{dir, proto}, client...), status, keyLabel)...)
+	m.aggregateDataBytes.addIfNonZero(value, dir, proto)
+}
+
+func (m *exporterMetrics) collect(start, now time.Time) []metricJSON {
+	var collected []metricJSON
+	for _, i := range m.all {
+		if metric := i.collect(start, now); metric != nil {
+			collected = append(collected, *metric)
+		}
+	}
+	return collected
+}
+
+var _ metrics.ShadowsocksMetrics = (*Exporter)(nil)
+
+func (e *Exporter) SetBuildInfo(version string) {
+	e.metrics.buildInfo.set(1, version)
+}
+
+// GetClientInfo returns no info, since the Exporter has no IP database.  Use
+// metrics.Tee to get the client info from the Prometheus metrics.
+func (e *Exporter) GetClientInfo(net.Addr) (metrics.ClientInfo, error) {
+	return metrics.ClientInfo{}, nil
+}
+
+func (e *Exporter) SetNumAccessKeys(numKeys int, numPorts int) {
+	e.metrics.accessKeys.set(float64(numKeys))
+	e.metrics.ports.set(float64(numPorts))
+}
+
+func (e *Exporter) AddOpenTCPConnection(clientInfo metrics.ClientInfo) {
+	e.metrics.tcpOpenConnections.add(1, e.metrics.clientValues(clientInfo)...)
+}
+
+func (e *Exporter) AddClosedTCPConnection(clientInfo metrics.ClientInfo, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
+	m := &e.metrics
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, data.ProxyTarget+data.ProxyClient)
+	keyLabel := m.keyLabels.Label(accessKey)
+	m.tcpClosedConnections.add(1, append(client, status, keyLabel)...)
+	m.tcpAggregateClosedConnections.add(1, status)
+	m.tcpConnectionDurationMs.observe(duration.Seconds()*1000, status)
+	m.timeToCipherMs.observe(timeToCipher.Seconds()*1000, "tcp", isFound(accessKey))
+	m.addData(data.ClientProxy, "c>p", "tcp", client, status, keyLabel)
+	m.addData(data.ProxyTarget, "p>t", "tcp", client, status, keyLabel)
+	m.addData(data.TargetProxy, "p<t", "tcp", client, status, keyLabel)
+	m.addData(data.ProxyClient, "c<p", "tcp", client, status, keyLabel)
+}
+
+// AddTCPData does nothing, since the data of TCP connections is counted when
+// they close.
+func (e *Exporter) AddTCPData(accessKey string, data metrics.ProxyMetrics) {}
+
+func (e *Exporter) AddTCPProbe(clientInfo metrics.ClientInfo, status, drainResult string, port int, data metrics.ProxyMetrics) {
+	values := append(e.metrics.clientValues(clientInfo), strconv.Itoa(port), status, drainResult)
+	e.metrics.tcpProbes.observe(float64(data.ClientProxy), values...)
+}
+
+func (e *Exporter) AddActiveTCPConnection(accessKey string, port int) {
+	e.metrics.tcpActiveConnections.add(1, accessKey, strconv.Itoa(port))
+}
+
+func (e *Exporter) RemoveActiveTCPConnection(accessKey string, port int) {
+	e.metrics.tcpActiveConnections.add(-1, accessKey, strconv.Itoa(port))
+}
+
+func (e *Exporter) AddTimeToFirstByte(proto string, ttfb time.Duration) {
+	e.metrics.timeToFirstByteMs.observe(ttfb.Seconds()*1000, proto)
+}
+
+// GetTargetInfo returns no info, like GetClientInfo.
+func (e *Exporter) GetTargetInfo(ip net.IP, port int) metrics.TargetInfo {
+	return metrics.TargetInfo{}
+}
+
+func (e *Exporter) AddTargetDial(proto string, targetInfo metrics.TargetInfo, status string, duration time.Duration) {
+	if !e.config.TargetMetrics {
+		return
+	}
+	e.metrics.targetDials.add(1, proto, targetInfo.CountryCode, targetInfo.PortClass, status)
+	if proto == "tcp" {
+		e.metrics.tcpTargetDialMs.observe(duration.Seconds()*1000, status)
+	}
+}
+
+func (e *Exporter) AddUDPPacketFromClient(clientInfo metrics.ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+	m := &e.metrics
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, int64(proxyTargetBytes))
+	keyLabel := m.keyLabels.Label(accessKey)
+	m.timeToCipherMs.observe(timeToCipher.Seconds()*1000, "udp", isFound(accessKey))
+	m.addData(int64(clientProxyBytes), "c>p", "udp", client, status, keyLabel)
+	m.addData(int64(proxyTargetBytes), "p>t", "udp", client, status, keyLabel)
+}
+
+func (e *Exporter) AddUDPPacketFromTarget(clientInfo metrics.ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+	m := &e.metrics
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, int64(proxyClientBytes))
+	keyLabel := m.keyLabels.Label(accessKey)
+	m.addData(int64(targetProxyBytes), "p<t", "udp", client, status, keyLabel)
+	m.addData(int64(proxyClientBytes), "c<p", "udp", client, status, keyLabel)
+}
+
+func (e *Exporter) AddUDPNatEntry(accessKey string, port int) {
+	e.metrics.udpAddedNatEntries.add(1)
+	e.metrics.udpActiveNatEntries.add(1, accessKey, strconv.Itoa(port))
+}
+
+func (e *Exporter) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
+	e.metrics.udpRemovedNatEntries.add(1)
+	e.metrics.udpActiveNatEntries.add(-1, accessKey, strconv.Itoa(port))
+	e.metrics.udpNatEntryDurationMs.observe(duration.Seconds() * 1000)
+}
+
+func (e *Exporter) AddBan(scope string) {
+	e.metrics.bans.add(1, scope)
+}
+
+func (e *Exporter) SetNumActiveBans(numBans int) {
+	e.metrics.activeBans.set(float64(numBans))
+}
+
+// Converts accessKey to "true" or "false"
+func isFound(accessKey string) string {
+	return strconv.FormatBool(accessKey != "")
+}
@@ -0,0 +1,258 @@
+// Package otlp exports the metrics of the server, and optionally traces of its
+// connections, to an OpenTelemetry collector over OTLP/HTTP with JSON
+// encoding.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+const (
+	defaultInterval    = 10 * time.Second
+	defaultTimeout     = 10 * time.Second
+	defaultServiceName = "outline-ss-server"
+	defaultMaxSpans    = 2048
+	// The spans are exported early when this many are waiting.
+	spanBatchSize = 512
+	scopeName     = "github.com/evgeniy-krivenko/outline-ss-server/otlp"
+)
+
+// Config configures the Exporter.
+type Config struct {
+	// Endpoint is the base URL of the collector, like http://localhost:4318.
+	// The metrics are sent to /v1/metrics and the spans to /v1/traces.
+	Endpoint string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Interval is how often the metrics and the finished spans are exported.
+	// Zero selects 10 seconds.
+	Interval time.Duration
+	// Timeout of each request.  Zero selects 10 seconds.
+	Timeout time.Duration
+	// ServiceName is the service.name of the exported resource.  Empty
+	// selects "outline-ss-server".
+	ServiceName string
+	// Version is the service.version of the exported resource.
+	Version string
+	// TraceRatio is the fraction of the TCP connections and UDP NAT entries
+	// that are traced.  Zero disables tracing.
+	TraceRatio float64
+	// MaxQueuedSpans bounds the finished spans waiting to be exported.  New
+	// spans are dropped when it's full.  Zero selects 2048.
+	MaxQueuedSpans int
+	// ASNLabels adds the asn label, like metrics.Config.ASNLabels.  Nil
+	// leaves it out.
+	ASNLabels *metrics.ASNLabeler
+	// AccessKeyLabels converts the key IDs to the values of the access_key
+	// labels, like metrics.Config.AccessKeyLabels.  Nil labels with the key
+	// IDs.
+	AccessKeyLabels *metrics.AccessKeyLabeler
+	// TargetMetrics enables the metrics of the targets, like
+	// metrics.Config.TargetMetrics.
+	TargetMetrics bool
+	Logger        *log.Logger
+}
+
+// Exporter is a metrics.ShadowsocksMetrics that exports the metrics with
+// OTLP.  Its Tracer exports the spans of the connections.  The metrics are
+// cumulative, so a failed export only delays them, but the spans of a failed
+// export are lost.
+type Exporter struct {
+	config     Config
+	client     *http.Client
+	metricsURL string
+	tracesURL  string
+	resource   resource
+	start      time.Time
+	metrics    exporterMetrics
+	mu         sync.Mutex // Protects spans and droppedSpans.
+	spans      []*span
+	// Spans dropped since the last warning.
+	droppedSpans int
+	wake         chan struct{}
+	done         chan struct{}
+	stopped      chan struct{}
+	closeOnce    sync.Once
+}
+
+// New starts an Exporter that exports every Config.Interval until Close.
+func New(config Config) (*Exporter, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the OTLP endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http or https URL", config.Endpoint)
+	}
+	if config.TraceRatio < 0 || config.TraceRatio > 1 {
+		return nil, fmt.Errorf("trace ratio %v must be between 0 and 1", config.TraceRatio)
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultServiceName
+	}
+	if config.MaxQueuedSpans <= 0 {
+		config.MaxQueuedSpans = defaultMaxSpans
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default().Named("otlp")
+	}
+	base := strings.TrimSuffix(config.Endpoint, "/")
+	e := &Exporter{
+		config:     config,
+		client:     &http.Client{Timeout: config.Timeout},
+		metricsURL: base + "/v1/metrics",
+		tracesURL:  base + "/v1/traces",
+		start:      time.Now(),
+		metrics:    newExporterMetrics(config.ASNLabels, config.AccessKeyLabels),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	e.resource.Attributes = []keyValue{
+		stringAttribute("service.name", config.ServiceName),
+	}
+	if config.Version != "" {
+		e.resource.Attributes = append(e.resource.Attributes, stringAttribute("service.version", config.Version))
+	}
+	go e.run()
+	return e, nil
+}
+
+// Close exports the metrics and the finished spans one last time, and stops
+// the Exporter.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.done)
+	})
+	<-e.stopped
+	return nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.export()
+		case <-e.wake:
+			e.exportSpans()
+		case <-e.done:
+			e.export()
+			return
+		}
+	}
+}
+
+func (e *Exporter) export() {
+	e.exportMetrics()
+	e.exportSpans()
+}
+
+func (e *Exporter) exportMetrics() {
+	request := exportMetricsRequest{ResourceMetrics: []resourceMetrics{{
+		Resource: e.resource,
+		ScopeMetrics: []scopeMetrics{{
+			Scope:   scope{Name: scopeName},
+			Metrics: e.metrics.collect(e.start, time.Now()),
+		}},
+	}}}
+	if len(request.ResourceMetrics[0].ScopeMetrics[0].Metrics) == 0 {
+		return
+	}
+	if err := e.post(e.metricsURL, request); err != nil {
+		e.config.Logger.Warning("Failed to export the metrics", log.Err(err))
+	}
+}
+
+func (e *Exporter) exportSpans() {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	dropped := e.droppedSpans
+	e.droppedSpans = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.config.Logger.Warning("Dropped spans because the queue is full", log.Int("spans", dropped))
+	}
+	if len(spans) == 0 {
+		return
+	}
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.encode())
+	}
+	request := exportTraceRequest{ResourceSpans: []resourceSpans{{
+		Resource: e.resource,
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: scopeName},
+			Spans: encoded,
+		}},
+	}}}
+	if err := e.post(e.tracesURL, request); err != nil {
+		e.config.Logger.Warning("Failed to export the spans", log.Err(err), log.Int("spans", len(spans)))
+	}
+}
+
+func (e *Exporter) post(url string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode the request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// Queues a finished span for the next export.
+func (e *Exporter) addSpan(s *span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) >= e.config.MaxQueuedSpans {
+		e.droppedSpans++
+		return
+	}
+	e.spans = append(e.spans, s)
+	if len(e.spans) == spanBatchSize {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An OTLP/HTTP collector that keeps the requests it receives.
+type collector struct {
+	t       *testing.T
+	mu      sync.Mutex
+	metrics []map[string]interface{}
+	traces  []map[string]interface{}
+	headers []http.Header
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	c := &collector{t: t}
+	server := httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func (c *collector) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	assert.Equal(c.t, http.MethodPost, req.Method)
+	assert.Equal(c.t, "application/json", req.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(req.Body)
+	var decoded map[string]interface{}
+	assert.NoError(c.t, json.Unmarshal(body, &decoded))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers = append(c.headers, req.Header)
+	switch req.URL.Path {
+	case "/v1/metrics":
+		c.metrics = append(c.metrics, decoded)
+	case "/v1/traces":
+		c.traces = append(c.traces, decoded)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Returns the metrics of the last export, by name.
+func (c *collector) lastMetrics(t *testing.T) map[string]map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.NotEmpty(t, c.metrics)
+	request := c.metrics[len(c.metrics)-1]
+	resource := request["resourceMetrics"].([]interface{})[0].(map[string]interface{})
+	requireServiceName(t, resource)
+	scope := resource["scopeMetrics"].([]interface{})[0].(map[string]interface{})
+	byName := make(map[string]map[string]interface{})
+	for _, m := range scope["metrics"].([]interface{}) {
+		m := m.(map[string]interface{})
+		byName[m["name"].(string)] = m
+	}
+	return byName
+}
+
+// Returns all the exported spans.
+func (c *collector) spans(t *testing.T) []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []map[string]interface{}
+	for _, request := range c.traces {
+		resource := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		requireServiceName(t, resource)
+		scope := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})
+		for _, s := range scope["spans"].([]interface{}) {
+			spans = append(spans, s.(map[string]interface{}))
+		}
+	}
+	return spans
+}
+
+func requireServiceName(t *testing.T, resource map[string]interface{}) {
+	attributes := resource["resource"].(map[string]interface{})["attributes"].([]interface{})
+	require.Contains(t, attributes, map[string]interface{}{
+		"key": "service.name", "value": map[string]interface{}{"stringValue": "ss-test"},
+	})
+}
+
+func dataPoints(t *testing.T, metric map[string]interface{}, kind string) []interface{} {
+	require.Contains(t, metric, kind)
+	return metric[kind].(map[string]interface{})["dataPoints"].([]interface{})
+}
+
+func attributes(point interface{}) map[string]string {
+	values := make(map[string]string)
+	list, _ := point.(map[string]interface{})["attributes"].([]interface{})
+	for _, kv := range list {
+		kv := kv.(map[string]interface{})
+		value := kv["value"].(map[string]interface{})
+		if s, ok := value["stringValue"].(string); ok {
+			values[kv["key"].(string)] = s
+		} else if i, ok := value["intValue"].(string); ok {
+			values[kv["key"].(string)] = i
+		}
+	}
+	return values
+}
+
+func TestExporter_Metrics(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{
+		Endpoint:    server.URL + "/",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		Interval:    time.Hour,
+		ServiceName: "ss-test",
+	})
+	require.NoError(t, err)
+	require.Nil(t, e.Tracer())
+
+	e.SetBuildInfo("1.2.3")
+	e.SetNumAccessKeys(3, 2)
+	e.AddOpenTCPConnection(metrics.ClientInfo{CountryCode: "US"})
+	e.AddClosedTCPConnection(metrics.ClientInfo{CountryCode: "US"}, "key-1", "OK", metrics.ProxyMetrics{ClientProxy: 100, ProxyClient: 2000}, 5*time.Millisecond, 2*time.Second)
+	e.AddActiveTCPConnection("key-1", 8388)
+	e.AddActiveTCPConnection("key-1", 8388)
+	e.AddActiveTCPConnection("key-2", 8388)
+	e.RemoveActiveTCPConnection("key-2", 8388)
+	e.AddTimeToFirstByte("tcp", 30*time.Millisecond)
+	e.AddUDPNatEntry("key-1", 8388)
+	e.RemoveUDPNatEntry("key-1", 8388, 20*time.Second)
+	e.AddBan("ip")
+	require.NoError(t, e.Close())
+
+	m := c.lastMetrics(t)
+	require.Equal(t, "Bearer token", c.headers[0].Get("Authorization"))
+
+	points := dataPoints(t, m["shadowsocks_keys"], "gauge")
+	require.Len(t, points, 1)
+	require.Equal(t, float64(3), points[0].(map[string]interface{})["asDouble"])
+	require.Equal(t, map[string]string{"version": "1.2.3"}, attributes(dataPoints(t, m["shadowsocks_build_info"], "gauge")[0]))
+
+	closed := m["shadowsocks_tcp_connections_closed"]
+	require.Equal(t, true, closed["sum"].(map[string]interface{})["isMonotonic"])
+	require.Equal(t, float64(2), closed["sum"].(map[string]interface{})["aggregationTemporality"])
+	points = dataPoints(t, closed, "sum")
+	require.Len(t, points, 1)
+	require.Equal(t, map[string]string{"location": "US", "status": "OK", "access_key": "key-1"}, attributes(points[0]))
+	require.NotEmpty(t, points[0].(map[string]interface{})["startTimeUnixNano"])
+
+	// Only the directions with data have series.
+	points = dataPoints(t, m["shadowsocks_data_bytes"], "sum")
+	require.Len(t, points, 2)
+
+	// The series of key-2 is gone, since it reached zero.
+	active := m["shadowsocks_tcp_active_connections"]
+	require.Equal(t, false, active["sum"].(map[string]interface{})["isMonotonic"])
+	points = dataPoints(t, active, "sum")
+	require.Len(t, points, 1)
+	require.Equal(t, map[string]string{"access_key": "key-1", "port": "8388"}, attributes(points[0]))
+	require.Equal(t, float64(2), points[0].(map[string]interface{})["asDouble"])
+	require.NotContains(t, m, "shadowsocks_udp_active_nat_entries")
+
+	ttfb := dataPoints(t, m["shadowsocks_time_to_first_byte_ms"], "histogram")[0].(map[string]interface{})
+	require.Equal(t, "1", ttfb["count"])
+	require.Equal(t, float64(30), ttfb["sum"])
+	require.Equal(t, []interface{}{"0", "0", "1", "0", "0", "0", "0", "0", "0", "0", "0"}, ttfb["bucketCounts"])
+	require.Len(t, ttfb["explicitBounds"], 10)
+
+	natDuration := dataPoints(t, m["shadowsocks_udp_nat_entry_duration_ms"], "histogram")[0].(map[string]interface{})
+	require.Equal(t, []interface{}{"0", "0", "1", "0", "0", "0", "0"}, natDuration["bucketCounts"])
+	require.Equal(t, map[string]string{"scope": "ip"}, attributes(dataPoints(t, m["shadowsocks_bans"], "sum")[0]))
+}
+
+func TestExporter_ASNLabel(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", ASNLabels: metrics.NewASNLabeler(1)})
+	require.NoError(t, err)
+	e.AddOpenTCPConnection(metrics.ClientInfo{CountryCode: "US", ASN: 64496})
+	e.AddOpenTCPConnection(metrics.ClientInfo{CountryCode: "DE", ASN: 64497})
+	e.AddUDPPacketFromClient(metrics.ClientInfo{CountryCode: "US", ASN: 64496}, "key-1", "OK", 10, 0, 0)
+	require.NoError(t, e.Close())
+
+	m := c.lastMetrics(t)
+	points := dataPoints(t, m["shadowsocks_tcp_connections_opened"], "sum")
+	require.Len(t, points, 2)
+	require.Equal(t, map[string]string{"location": "DE", "asn": metrics.OtherLabel}, attributes(points[0]))
+	require.Equal(t, map[string]string{"location": "US", "asn": "64496"}, attributes(points[1]))
+	points = dataPoints(t, m["shadowsocks_data_bytes"], "sum")
+	require.Len(t, points, 1)
+	require.Equal(t, map[string]string{"dir": "c>p", "proto": "udp", "location": "US", "asn": "64496", "status": "OK", "access_key": "key-1"}, attributes(points[0]))
+}
+
+func TestExporter_AccessKeyLabels(t *testing.T) {
+	c, server := newCollector(t)
+	keyLabels, err := metrics.NewAccessKeyLabeler(metrics.AccessKeyLabelTop, 1)
+	require.NoError(t, err)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", AccessKeyLabels: keyLabels})
+	require.NoError(t, err)
+	e.AddActiveTCPConnection("key-1", 8388)
+	e.AddActiveTCPConnection("key-2", 8388)
+	e.AddClosedTCPConnection(metrics.ClientInfo{}, "key-2", "OK", metrics.ProxyMetrics{ProxyClient: 100}, 0, time.Second)
+	e.AddClosedTCPConnection(metrics.ClientInfo{}, "key-1", "OK", metrics.ProxyMetrics{ProxyClient: 10}, 0, time.Second)
+	require.NoError(t, e.Close())
+
+	m := c.lastMetrics(t)
+	points := dataPoints(t, m["shadowsocks_tcp_connections_closed"], "sum")
+	require.Len(t, points, 2)
+	require.Equal(t, "key-2", attributes(points[0])["access_key"])
+	require.Equal(t, metrics.OtherLabel, attributes(points[1])["access_key"])
+	// The active connections have the labels of the export.
+	points = dataPoints(t, m["shadowsocks_tcp_active_connections"], "sum")
+	require.Len(t, points, 2)
+	require.Equal(t, map[string]string{"access_key": "key-2", "port": "8388"}, attributes(points[0]))
+	require.Equal(t, map[string]string{"access_key": metrics.OtherLabel, "port": "8388"}, attributes(points[1]))
+	points = dataPoints(t, m["shadowsocks_aggregate_data_bytes"], "sum")
+	require.Len(t, points, 1)
+	require.Equal(t, float64(110), points[0].(map[string]interface{})["asDouble"])
+}
+
+func TestExporter_TargetMetrics(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", TargetMetrics: true})
+	require.NoError(t, err)
+	e.AddTargetDial("tcp", metrics.TargetInfo{CountryCode: "DE", PortClass: metrics.PortClassWeb}, "ERR_CONNECT_REFUSED", 20*time.Millisecond)
+	e.AddTargetDial("udp", metrics.TargetInfo{PortClass: metrics.PortClassDNS}, "OK", 0)
+	require.NoError(t, e.Close())
+
+	m := c.lastMetrics(t)
+	points := dataPoints(t, m["shadowsocks_target_dials"], "sum")
+	require.Len(t, points, 2)
+	require.Equal(t, map[string]string{"proto": "tcp", "location": "DE", "port_class": "web", "status": "ERR_CONNECT_REFUSED"}, attributes(points[0]))
+	dial := dataPoints(t, m["shadowsocks_tcp_target_dial_ms"], "histogram")
+	require.Len(t, dial, 1)
+	require.Equal(t, "1", dial[0].(map[string]interface{})["count"])
+}
+
+func TestExporter_Interval(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: 10 * time.Millisecond, ServiceName: "ss-test"})
+	require.NoError(t, err)
+	defer e.Close()
+	e.AddBan("subnet")
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.metrics) >= 2
+	}, time.Second, 5*time.Millisecond)
+	require.Contains(t, c.lastMetrics(t), "shadowsocks_bans")
+}
+
+func TestExporter_Traces(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", TraceRatio: 1})
+	require.NoError(t, err)
+	tracer := e.Tracer()
+	require.NotNil(t, tracer)
+
+	root := tracer.StartSpan(service.SpanTCPConnection, nil)
+	root.SetAttribute("port", 8388)
+	dial := tracer.StartSpan(service.SpanTCPDial, root)
+	dial.SetAttribute("target", "example.com:443")
+	dial.End("ERR_CONNECT")
+	root.SetAttribute("client_proxy_bytes", int64(1500))
+	root.End("ERR_CONNECT")
+	other := tracer.StartSpan(service.SpanUDPSession, nil)
+	other.End("OK")
+	require.NoError(t, e.Close())
+
+	spans := c.spans(t)
+	require.Len(t, spans, 3)
+	byName := make(map[string]map[string]interface{})
+	for _, s := range spans {
+		byName[s["name"].(string)] = s
+	}
+	rootJSON, dialJSON, udpJSON := byName[service.SpanTCPConnection], byName[service.SpanTCPDial], byName[service.SpanUDPSession]
+	require.Len(t, rootJSON["traceId"], 32)
+	require.Len(t, rootJSON["spanId"], 16)
+	require.NotContains(t, rootJSON, "parentSpanId")
+	require.Equal(t, rootJSON["traceId"], dialJSON["traceId"])
+	require.Equal(t, rootJSON["spanId"], dialJSON["parentSpanId"])
+	require.NotEqual(t, rootJSON["traceId"], udpJSON["traceId"])
+	require.Equal(t, float64(spanKindServer), rootJSON["kind"])
+	require.Equal(t, float64(spanKindInternal), dialJSON["kind"])
+
+	require.Equal(t, map[string]string{"port": "8388", "client_proxy_bytes": "1500", "status": "ERR_CONNECT"}, attributes(rootJSON))
+	require.Equal(t, map[string]interface{}{"code": float64(statusCodeError), "message": "ERR_CONNECT"}, rootJSON["status"])
+	require.Equal(t, map[string]interface{}{"code": float64(statusCodeOK)}, udpJSON["status"])
+	require.Equal(t, "example.com:443", attributes(dialJSON)["target"])
+	require.NotEqual(t, dialJSON["startTimeUnixNano"], "0")
+}
+
+func TestExporter_TraceSampling(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", TraceRatio: 0.5})
+	require.NoError(t, err)
+	tracer := e.Tracer()
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		root := tracer.StartSpan(service.SpanTCPConnection, nil)
+		child := tracer.StartSpan(service.SpanTCPAccept, root)
+		// The children of dropped spans are dropped too.
+		_, rootSampled := root.(*span)
+		_, childSampled := child.(*span)
+		require.Equal(t, rootSampled, childSampled)
+		if rootSampled {
+			sampled++
+		}
+		child.End("OK")
+		root.End("OK")
+	}
+	require.InDelta(t, 500, sampled, 100)
+	require.NoError(t, e.Close())
+	require.Len(t, c.spans(t), 2*sampled)
+}
+
+func TestExporter_MaxQueuedSpans(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test", TraceRatio: 1, MaxQueuedSpans: 3})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		e.Tracer().StartSpan(service.SpanUDPSession, nil).End("OK")
+	}
+	require.NoError(t, e.Close())
+	require.Len(t, c.spans(t), 3)
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, err := New(Config{Endpoint: "localhost:4318"})
+	require.Error(t, err)
+	_, err = New(Config{Endpoint: "http://localhost:4318", TraceRatio: 2})
+	require.Error(t, err)
+}
+
+func TestTee(t *testing.T) {
+	c, server := newCollector(t)
+	e, err := New(Config{Endpoint: server.URL, Interval: time.Hour, ServiceName: "ss-test"})
+	require.NoError(t, err)
+	m := metrics.Tee(&metrics.NoOpMetrics{}, e)
+	m.AddTCPProbe(metrics.ClientInfo{CountryCode: "XL"}, "ERR_CIPHER", "eof", 8388, metrics.ProxyMetrics{ClientProxy: 50})
+	require.NoError(t, e.Close())
+	probe := dataPoints(t, c.lastMetrics(t)["shadowsocks_tcp_probes"], "histogram")[0]
+	require.Equal(t, map[string]string{"location": "XL", "port": "8388", "status": "ERR_CIPHER", "error": "eof"}, attributes(probe))
+}
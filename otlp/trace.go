@@ -0,0 +1,97 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+)
+
+// Tracer returns the service.Tracer that exports the spans of the sampled
+// connections, or nil if tracing is disabled.
+func (e *Exporter) Tracer() service.Tracer {
+	if e.config.TraceRatio <= 0 {
+		return nil
+	}
+	return &tracer{exporter: e}
+}
+
+type tracer struct {
+	exporter *Exporter
+}
+
+// StartSpan samples the root spans with Config.TraceRatio.  The children of
+// a span that isn't sampled aren't either.
+func (t *tracer) StartSpan(name string, parent service.Span) service.Span {
+	s := &span{exporter: t.exporter, name: name, start: time.Now()}
+	if parent == nil {
+		if rand.Float64() >= t.exporter.config.TraceRatio {
+			return droppedSpan{}
+		}
+		binary.BigEndian.PutUint64(s.traceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(s.traceID[8:], rand.Uint64())
+	} else {
+		p, ok := parent.(*span)
+		if !ok {
+			return droppedSpan{}
+		}
+		s.traceID = p.traceID
+		s.parentID = p.spanID
+		s.hasParent = true
+	}
+	binary.BigEndian.PutUint64(s.spanID[:], rand.Uint64())
+	return s
+}
+
+// A sampled span.  It's used by one goroutine at a time.
+type span struct {
+	exporter   *Exporter
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	hasParent  bool
+	name       string
+	start      time.Time
+	end        time.Time
+	status     string
+	attributes []keyValue
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.attributes = append(s.attributes, attribute(key, value))
+}
+
+func (s *span) End(status string) {
+	s.end = time.Now()
+	s.status = status
+	s.exporter.addSpan(s)
+}
+
+func (s *span) encode() spanJSON {
+	encoded := spanJSON{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        append(s.attributes, stringAttribute("status", s.status)),
+		Status:            statusJSON{Code: statusCodeOK},
+	}
+	if s.hasParent {
+		encoded.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		encoded.Kind = spanKindInternal
+	}
+	if s.status != "OK" {
+		encoded.Status = statusJSON{Code: statusCodeError, Message: s.status}
+	}
+	return encoded
+}
+
+type droppedSpan struct{}
+
+func (droppedSpan) SetAttribute(key string, value interface{}) {}
+func (droppedSpan) End(status string)                          {}
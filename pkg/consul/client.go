@@ -3,6 +3,7 @@ package consul
 import (
 	"fmt"
 	"github.com/hashicorp/consul/api"
+	"time"
 )
 
 const timeout = "30s"
@@ -11,9 +12,25 @@ type Client struct {
 	*api.Client
 }
 
+// Config configures the connection to the Consul agent.
+type Config struct {
+	// Address of the agent, e.g. localhost:8500.
+	Address string
+	// Token is the ACL token.  Empty uses the agent's default token.
+	Token string
+	// Datacenter defaults to the agent's datacenter.
+	Datacenter string
+}
+
 func NewClient(addr string) (*Client, error) {
+	return NewClientWithConfig(Config{Address: addr})
+}
+
+func NewClientWithConfig(cnf Config) (*Client, error) {
 	conf := &api.Config{
-		Address: addr,
+		Address:    cnf.Address,
+		Token:      cnf.Token,
+		Datacenter: cnf.Datacenter,
 	}
 
 	client, err := api.NewClient(conf)
@@ -30,21 +47,32 @@ type GrpcRegConf struct {
 	Tags           []string
 	Interval       int
 	TLS            bool
+	// TLSSkipVerify disables verification of the server certificate by the
+	// health check, for self-signed certificates.
+	TLSSkipVerify bool
+	// TTL replaces the gRPC check with a TTL check, whose status is set with
+	// UpdateTTL.  Zero keeps the gRPC check.
+	TTL time.Duration
 }
 
 func (c *Client) GrpcRegistration(conf *GrpcRegConf) error {
+	check := &api.AgentServiceCheck{
+		GRPC:          fmt.Sprintf("%s:%d", conf.Addr, conf.Port),
+		GRPCUseTLS:    conf.TLS,
+		TLSSkipVerify: conf.TLSSkipVerify,
+		Interval:      fmt.Sprintf("%ds", conf.Interval),
+		Timeout:       timeout,
+	}
+	if conf.TTL > 0 {
+		check = ttlCheck(conf.Id, conf.TTL)
+	}
 	registration := &api.AgentServiceRegistration{
-		ID:   conf.Id,
-		Name: conf.Name,
+		ID:      conf.Id,
+		Name:    conf.Name,
 		Address: conf.Addr,
-		Port: conf.Port,
-		Tags: conf.Tags,
-		Check: &api.AgentServiceCheck{
-			GRPC:       fmt.Sprintf("%s:%d", conf.Addr, conf.Port),
-			GRPCUseTLS: conf.TLS,
-			Interval:   fmt.Sprintf("%ds", conf.Interval),
-			Timeout:    timeout,
-		},
+		Port:    conf.Port,
+		Tags:    conf.Tags,
+		Check:   check,
 	}
 	err := c.Agent().ServiceRegister(registration)
 	if err != nil {
@@ -53,3 +81,65 @@ func (c *Client) GrpcRegistration(conf *GrpcRegConf) error {
 
 	return nil
 }
+
+// TTL checks of crashed nodes stay critical until the service is removed after
+// this many TTLs.
+const deregisterAfterTTLs = 10
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+func ttlCheck(serviceID string, ttl time.Duration) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		CheckID:                        checkID(serviceID),
+		TTL:                            ttl.String(),
+		DeregisterCriticalServiceAfter: (deregisterAfterTTLs * ttl).String(),
+	}
+}
+
+type ServiceRegConf struct {
+	Id, Name, Addr string
+	Port           int
+	Tags           []string
+	Meta           map[string]string
+	// TTL of the check, whose status is set with UpdateTTL.
+	TTL time.Duration
+}
+
+// ServiceRegistration registers a service with a TTL check.
+func (c *Client) ServiceRegistration(conf *ServiceRegConf) error {
+	registration := &api.AgentServiceRegistration{
+		ID:      conf.Id,
+		Name:    conf.Name,
+		Address: conf.Addr,
+		Port:    conf.Port,
+		Tags:    conf.Tags,
+		Meta:    conf.Meta,
+		Check:   ttlCheck(conf.Id, conf.TTL),
+	}
+	if err := c.Agent().ServiceRegister(registration); err != nil {
+		return fmt.Errorf("error to register service %v: %w", conf.Id, err)
+	}
+	return nil
+}
+
+// Deregister removes a service from the agent.
+func (c *Client) Deregister(serviceID string) error {
+	if err := c.Agent().ServiceDeregister(serviceID); err != nil {
+		return fmt.Errorf("error to deregister service %v: %w", serviceID, err)
+	}
+	return nil
+}
+
+// UpdateTTL sets the status of the TTL check of a service.
+func (c *Client) UpdateTTL(serviceID string, passing bool, output string) error {
+	status := api.HealthPassing
+	if !passing {
+		status = api.HealthCritical
+	}
+	if err := c.Agent().UpdateTTL(checkID(serviceID), output, status); err != nil {
+		return fmt.Errorf("error to update check of service %v: %w", serviceID, err)
+	}
+	return nil
+}
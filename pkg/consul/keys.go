@@ -0,0 +1,125 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	defaultKeysWaitTime = 5 * time.Minute
+	keysMinBackoff      = time.Second
+	keysMaxBackoff      = time.Minute
+)
+
+// AccessKey is an access key stored in the KV store, as a JSON object under
+// `<prefix>/<id>`.
+type AccessKey struct {
+	ID     string `json:"id"`
+	Port   int    `json:"port"`
+	Cipher string `json:"cipher"`
+	Secret string `json:"secret"`
+}
+
+// KeyWatcher watches the access keys under a KV prefix with blocking queries.
+type KeyWatcher struct {
+	kv     *api.KV
+	prefix string
+	// WaitTime bounds how long a blocking query waits for a change.  Zero
+	// selects 5 minutes.
+	WaitTime time.Duration
+	// The index of the last keys returned.
+	index uint64
+}
+
+func (kv *KV) WatchKeys(prefix string) *KeyWatcher {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &KeyWatcher{kv: kv.KV, prefix: prefix}
+}
+
+// Next waits until the keys differ from the ones it returned last, and
+// returns them.  The first call returns immediately.
+func (w *KeyWatcher) Next(ctx context.Context) ([]AccessKey, error) {
+	waitTime := w.WaitTime
+	if waitTime <= 0 {
+		waitTime = defaultKeysWaitTime
+	}
+	for {
+		opts := (&api.QueryOptions{WaitIndex: w.index, WaitTime: waitTime}).WithContext(ctx)
+		pairs, meta, err := w.kv.List(w.prefix, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error to list keys under %v: %w", w.prefix, err)
+		}
+		// The wait timed out without changes.
+		if w.index != 0 && meta.LastIndex == w.index {
+			continue
+		}
+		// The index goes back when the Consul state is restored or reset, and
+		// must start over.
+		if meta.LastIndex < w.index {
+			w.index = 0
+			continue
+		}
+		w.index = meta.LastIndex
+		return parseAccessKeys(w.prefix, pairs)
+	}
+}
+
+func parseAccessKeys(prefix string, pairs api.KVPairs) ([]AccessKey, error) {
+	keys := make([]AccessKey, 0, len(pairs))
+	for _, pair := range pairs {
+		// Folders have no value.
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		var key AccessKey
+		if err := json.Unmarshal(pair.Value, &key); err != nil {
+			return nil, fmt.Errorf("error unmarshal access key %v: %w", pair.Key, err)
+		}
+		if key.ID == "" {
+			key.ID = strings.TrimPrefix(pair.Key, prefix)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// Run calls `apply` with the keys every time they change, until `ctx` is done.
+// Errors are passed to `onError`, and the watch is retried with back-off, so
+// the last keys applied stay in use while Consul is unreachable.
+func (w *KeyWatcher) Run(ctx context.Context, apply func([]AccessKey) error, onError func(error)) {
+	backoff := keysMinBackoff
+	for {
+		keys, err := w.Next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff = keysMinBackoff
+			if err := apply(keys); err != nil {
+				onError(err)
+			}
+			continue
+		}
+		onError(err)
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/4+1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > keysMaxBackoff {
+			backoff = keysMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake Consul KV store that answers blocking queries.
+type fakeKV struct {
+	mu      sync.Mutex
+	index   uint64
+	values  map[string]string
+	changed chan struct{}
+	down    bool
+}
+
+func newFakeKV(t *testing.T) (*fakeKV, *KV) {
+	store := &fakeKV{index: 1, values: make(map[string]string), changed: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(store.serveHTTP))
+	t.Cleanup(server.Close)
+	client, err := NewClient(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	return store, NewKV(client)
+}
+
+func (s *fakeKV) set(values map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range values {
+		s.values[k] = v
+	}
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *fakeKV) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *fakeKV) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	s.mu.Lock()
+	if waitIndex >= s.index {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	if s.down {
+		http.Error(w, "No cluster leader", http.StatusInternalServerError)
+		return
+	}
+	var pairs api.KVPairs
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			pairs = append(pairs, &api.KVPair{Key: k, Value: []byte(v), ModifyIndex: s.index})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func TestKeyWatcher_Next(t *testing.T) {
+	store, kv := newFakeKV(t)
+	store.set(map[string]string{
+		"ss/keys/":  "",
+		"ss/keys/a": `{"port": 8000, "cipher": "chacha20-ietf-poly1305", "secret": "a"}`,
+		"ss/keys/b": `{"id": "user-b", "port": 8001, "cipher": "aes-256-gcm", "secret": "b"}`,
+		"ss/other":  `{}`,
+	})
+	w := kv.WatchKeys("ss/keys")
+	keys, err := w.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []AccessKey{
+		{ID: "a", Port: 8000, Cipher: "chacha20-ietf-poly1305", Secret: "a"},
+		{ID: "user-b", Port: 8001, Cipher: "aes-256-gcm", Secret: "b"},
+	}, keys)
+
+	// Next blocks until the keys change.
+	done := make(chan []AccessKey)
+	go func() {
+		keys, err := w.Next(context.Background())
+		require.NoError(t, err)
+		done <- keys
+	}()
+	select {
+	case <-done:
+		t.Fatal("Next returned without a change")
+	case <-time.After(300 * time.Millisecond):
+	}
+	store.set(map[string]string{"ss/keys/c": `{"port": 8000, "cipher": "chacha20-ietf-poly1305", "secret": "c"}`})
+	select {
+	case keys := <-done:
+		require.Len(t, keys, 3)
+		require.Equal(t, "c", keys[1].ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next didn't return after a change")
+	}
+
+	store.set(map[string]string{"ss/keys/d": `not json`})
+	_, err = w.Next(context.Background())
+	require.Error(t, err)
+}
+
+func TestKeyWatcher_Run(t *testing.T) {
+	store, kv := newFakeKV(t)
+	store.set(map[string]string{"ss/keys/a": `{"port": 8000, "cipher": "chacha20-ietf-poly1305", "secret": "a"}`})
+	applied := make(chan []AccessKey, 10)
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		kv.WatchKeys("ss/keys").Run(ctx, func(keys []AccessKey) error {
+			applied <- keys
+			return nil
+		}, func(err error) { errs <- err })
+		close(done)
+	}()
+	require.Len(t, <-applied, 1)
+
+	// While Consul is unreachable, the errors are reported and nothing is
+	// applied.
+	store.setDown(true)
+	store.set(map[string]string{"ss/keys/b": `{"port": 8000, "cipher": "chacha20-ietf-poly1305", "secret": "b"}`})
+	select {
+	case err := <-errs:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No error while Consul is down")
+	}
+	require.Empty(t, applied)
+
+	// The keys are applied once it's back.
+	store.setDown(false)
+	select {
+	case keys := <-applied:
+		require.Len(t, keys, 2)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Keys not applied after Consul came back")
+	}
+
+	cancel()
+	<-done
+}
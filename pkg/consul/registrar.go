@@ -0,0 +1,153 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NodeState is what the Registrar publishes about the node.
+type NodeState struct {
+	// Healthy sets the status of the TTL checks.
+	Healthy bool
+	// Output is shown with the checks.
+	Output string
+	// Ports are the Shadowsocks ports that are serving.
+	Ports []int
+}
+
+type RegistrarConfig struct {
+	// Grpc is the registration of the gRPC service.  Its ID prefixes the IDs
+	// of the port services.
+	Grpc GrpcRegConf
+	// PortName and PortTags are the name and tags of the services registered
+	// for each Shadowsocks port.  Empty PortName disables them.
+	PortName string
+	PortTags []string
+	// TTL of the checks of the port services, and of the gRPC service if it
+	// uses a TTL check.  The state is published every third of it.
+	TTL time.Duration
+}
+
+// Registrar registers the gRPC service and the Shadowsocks ports of the node,
+// and keeps them in sync with the node's state.
+type Registrar struct {
+	client *Client
+	conf   RegistrarConfig
+	state  func() NodeState
+	mu     sync.Mutex // Protects ports and deregistered.
+	// The registered ports.
+	ports        map[int]bool
+	deregistered bool
+}
+
+func NewRegistrar(client *Client, conf RegistrarConfig, state func() NodeState) *Registrar {
+	return &Registrar{client: client, conf: conf, state: state, ports: make(map[int]bool)}
+}
+
+func (r *Registrar) portServiceID(port int) string {
+	return fmt.Sprintf("%s-port-%d", r.conf.Grpc.Id, port)
+}
+
+// Register registers the services and publishes the current state.
+func (r *Registrar) Register() error {
+	if err := r.client.GrpcRegistration(&r.conf.Grpc); err != nil {
+		return err
+	}
+	return r.sync()
+}
+
+// Publishes the state: registers new ports, deregisters the ones that are
+// gone, and updates the TTL checks.
+func (r *Registrar) sync() error {
+	state := r.state()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deregistered {
+		return nil
+	}
+	var firstErr error
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if r.conf.Grpc.TTL > 0 {
+		setErr(r.client.UpdateTTL(r.conf.Grpc.Id, state.Healthy, state.Output))
+	}
+	if r.conf.PortName == "" {
+		return firstErr
+	}
+	current := make(map[int]bool, len(state.Ports))
+	for _, port := range state.Ports {
+		current[port] = true
+		if !r.ports[port] {
+			err := r.client.ServiceRegistration(&ServiceRegConf{
+				Id:   r.portServiceID(port),
+				Name: r.conf.PortName,
+				Addr: r.conf.Grpc.Addr,
+				Port: port,
+				Tags: r.conf.PortTags,
+				Meta: map[string]string{"node": r.conf.Grpc.Id},
+				TTL:  r.conf.TTL,
+			})
+			if err != nil {
+				setErr(err)
+				continue
+			}
+			r.ports[port] = true
+		}
+		setErr(r.client.UpdateTTL(r.portServiceID(port), state.Healthy, state.Output))
+	}
+	for port := range r.ports {
+		if !current[port] {
+			if err := r.client.Deregister(r.portServiceID(port)); err != nil {
+				setErr(err)
+				continue
+			}
+			delete(r.ports, port)
+		}
+	}
+	return firstErr
+}
+
+// Run publishes the state every third of the TTL until `ctx` is done.
+func (r *Registrar) Run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(r.conf.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.sync(); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Deregister removes all the services of the node.
+func (r *Registrar) Deregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deregistered = true
+	ports := make([]int, 0, len(r.ports))
+	for port := range r.ports {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	var firstErr error
+	for _, port := range ports {
+		if err := r.client.Deregister(r.portServiceID(port)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.ports, port)
+	}
+	if err := r.client.Deregister(r.conf.Grpc.Id); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -0,0 +1,121 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake Consul agent that keeps the registered services and check statuses.
+type fakeAgent struct {
+	mu       sync.Mutex
+	services map[string]api.AgentServiceRegistration
+	checks   map[string]string
+	tokens   []string
+}
+
+func newFakeAgent(t *testing.T) (*fakeAgent, *Client) {
+	agent := &fakeAgent{services: make(map[string]api.AgentServiceRegistration), checks: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(agent.serveHTTP))
+	t.Cleanup(server.Close)
+	client, err := NewClientWithConfig(Config{Address: strings.TrimPrefix(server.URL, "http://"), Token: "secret-token"})
+	require.NoError(t, err)
+	return agent, client
+}
+
+func (a *fakeAgent) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens = append(a.tokens, r.Header.Get("X-Consul-Token"))
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var reg api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.services[reg.ID] = reg
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(a.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+		var update struct{ Status string }
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.checks[strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")] = update.Status
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *fakeAgent) serviceIDs() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ids []string
+	for id := range a.services {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (a *fakeAgent) check(serviceID string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.checks[checkID(serviceID)]
+}
+
+func TestRegistrar(t *testing.T) {
+	agent, client := newFakeAgent(t)
+	state := NodeState{Healthy: true, Ports: []int{8000, 8001}}
+	r := NewRegistrar(client, RegistrarConfig{
+		Grpc:     GrpcRegConf{Id: "node1", Name: "node1-ss", Addr: "192.0.2.1", Port: 50051, TTL: time.Minute},
+		PortName: "shadowsocks",
+		PortTags: []string{"ss"},
+		TTL:      time.Minute,
+	}, func() NodeState { return state })
+
+	require.NoError(t, r.Register())
+	require.ElementsMatch(t, []string{"node1", "node1-port-8000", "node1-port-8001"}, agent.serviceIDs())
+	port := agent.services["node1-port-8000"]
+	require.Equal(t, "shadowsocks", port.Name)
+	require.Equal(t, 8000, port.Port)
+	require.Equal(t, []string{"ss"}, port.Tags)
+	require.Equal(t, "node1", port.Meta["node"])
+	require.Equal(t, "1m0s", agent.services["node1"].Check.TTL)
+	require.Equal(t, api.HealthPassing, agent.check("node1"))
+	require.Equal(t, api.HealthPassing, agent.check("node1-port-8001"))
+
+	// A port goes away and the config reload fails.
+	state = NodeState{Healthy: false, Output: "reload failed", Ports: []int{8000}}
+	require.NoError(t, r.sync())
+	require.ElementsMatch(t, []string{"node1", "node1-port-8000"}, agent.serviceIDs())
+	require.Equal(t, api.HealthCritical, agent.check("node1"))
+	require.Equal(t, api.HealthCritical, agent.check("node1-port-8000"))
+
+	require.NoError(t, r.Deregister())
+	require.Empty(t, agent.serviceIDs())
+	// No more updates after deregistration.
+	require.NoError(t, r.sync())
+	require.Empty(t, agent.serviceIDs())
+
+	for _, token := range agent.tokens {
+		require.Equal(t, "secret-token", token)
+	}
+}
+
+func TestGrpcRegistration_GrpcCheck(t *testing.T) {
+	agent, client := newFakeAgent(t)
+	require.NoError(t, client.GrpcRegistration(&GrpcRegConf{Id: "node1", Addr: "192.0.2.1", Port: 50051, Interval: 30, TLS: true}))
+	check := agent.services["node1"].Check
+	require.Equal(t, "192.0.2.1:50051", check.GRPC)
+	require.True(t, check.GRPCUseTLS)
+	require.Empty(t, check.TTL)
+}
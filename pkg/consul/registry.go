@@ -0,0 +1,247 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// How many times an update is retried when the key changed since it was read.
+const casRetries = 5
+
+// ErrNodeIDInUse is returned when another live node is registered with the
+// same ID.
+var ErrNodeIDInUse = errors.New("node ID is registered by another node")
+
+// NodeCapacity is the load of a node, updated with every heartbeat.
+type NodeCapacity struct {
+	Ports          int `json:"ports"`
+	Keys           int `json:"keys"`
+	TCPConnections int `json:"tcp_connections"`
+	UDPSessions    int `json:"udp_sessions"`
+	// BytesTransferred since the node started, and the rate since the last
+	// heartbeat.
+	BytesTransferred int64 `json:"bytes_transferred"`
+	BytesPerSecond   int64 `json:"bytes_per_second"`
+}
+
+// NodeInfo is the entry of a node in the registry.
+type NodeInfo struct {
+	ID        string       `json:"id"`
+	Address   string       `json:"address"`
+	Port      int          `json:"port"`
+	Version   string       `json:"version"`
+	Capacity  NodeCapacity `json:"capacity"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type RegistryConfig struct {
+	// Prefix of the node keys.  Each node is stored under `<prefix>/<id>`.
+	Prefix string
+	// Node is the entry of this node.  Its capacity and update time are set on
+	// every heartbeat.
+	Node NodeInfo
+	// TTL of the session that holds the node's key.  The key is deleted when
+	// the node misses its heartbeats, which are sent every third of it.
+	TTL time.Duration
+}
+
+// Registry publishes the node in the KV store, under a key of its own that is
+// held by a session, so that nodes that register at the same time don't
+// overwrite each other, and the keys of dead nodes are deleted by Consul.
+type Registry struct {
+	client   *Client
+	conf     RegistryConfig
+	capacity func() NodeCapacity
+	mu       sync.Mutex // Protects the fields below.
+	session  string
+	// The ModifyIndex of the node's key after the last update.
+	index        uint64
+	lastBytes    int64
+	lastTime     time.Time
+	deregistered bool
+}
+
+func NewRegistry(client *Client, conf RegistryConfig, capacity func() NodeCapacity) *Registry {
+	conf.Prefix = strings.TrimSuffix(conf.Prefix, "/")
+	return &Registry{client: client, conf: conf, capacity: capacity}
+}
+
+func (r *Registry) key() string {
+	return r.conf.Prefix + "/" + r.conf.Node.ID
+}
+
+// Register creates the session and the node's key.
+func (r *Registry) Register() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.register()
+}
+
+func (r *Registry) register() error {
+	session, _, err := r.client.Session().Create(&api.SessionEntry{
+		Name:     "ss-node-" + r.conf.Node.ID,
+		TTL:      r.conf.TTL.String(),
+		Behavior: api.SessionBehaviorDelete,
+		// The key is only ever taken by the same node, so there is no need
+		// to wait before it registers again.
+		LockDelay: time.Millisecond,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error to create session: %w", err)
+	}
+	value, err := r.value()
+	if err != nil {
+		return err
+	}
+	acquired, _, err := r.client.KV().Acquire(&api.KVPair{Key: r.key(), Value: value, Session: session}, nil)
+	if err == nil && !acquired {
+		err = fmt.Errorf("%v: %w", r.key(), ErrNodeIDInUse)
+	}
+	if err != nil {
+		r.client.Session().Destroy(session, nil)
+		return fmt.Errorf("error to register node: %w", err)
+	}
+	r.session = session
+	return r.readIndex()
+}
+
+// Returns the node's entry with the current capacity.
+func (r *Registry) value() ([]byte, error) {
+	node := r.conf.Node
+	node.Capacity = r.capacity()
+	now := time.Now()
+	if !r.lastTime.IsZero() {
+		if elapsed := now.Sub(r.lastTime).Seconds(); elapsed > 0 {
+			node.Capacity.BytesPerSecond = int64(float64(node.Capacity.BytesTransferred-r.lastBytes) / elapsed)
+		}
+	}
+	r.lastBytes, r.lastTime = node.Capacity.BytesTransferred, now
+	node.UpdatedAt = now.UTC()
+	v, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("error to marshal node: %w", err)
+	}
+	return v, nil
+}
+
+func (r *Registry) readIndex() error {
+	pair, _, err := r.client.KV().Get(r.key(), nil)
+	if err != nil {
+		return fmt.Errorf("error to read node: %w", err)
+	}
+	if pair == nil {
+		r.index = 0
+		return nil
+	}
+	r.index = pair.ModifyIndex
+	return nil
+}
+
+// Heartbeat renews the session and updates the capacity.  The node registers
+// again if its session expired.
+func (r *Registry) Heartbeat() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deregistered {
+		return nil
+	}
+	if r.session == "" {
+		return r.register()
+	}
+	entry, _, err := r.client.Session().Renew(r.session, nil)
+	if err != nil {
+		return fmt.Errorf("error to renew session: %w", err)
+	}
+	if entry == nil {
+		// The session expired, and took the key with it.
+		r.session = ""
+		return r.register()
+	}
+	return r.update()
+}
+
+// Writes the node's entry if the key hasn't changed since it was last read,
+// and retries with the new index if it has.
+func (r *Registry) update() error {
+	for i := 0; i < casRetries; i++ {
+		value, err := r.value()
+		if err != nil {
+			return err
+		}
+		ok, _, err := r.client.KV().CAS(&api.KVPair{Key: r.key(), Value: value, ModifyIndex: r.index}, nil)
+		if err != nil {
+			return fmt.Errorf("error to update node: %w", err)
+		}
+		pair, _, err := r.client.KV().Get(r.key(), nil)
+		if err != nil {
+			return fmt.Errorf("error to read node: %w", err)
+		}
+		if pair == nil || pair.Session != r.session {
+			// The key was deleted or taken over.  Start over with a new session.
+			r.client.Session().Destroy(r.session, nil)
+			r.session = ""
+			return r.register()
+		}
+		r.index = pair.ModifyIndex
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("error to update node %v: the key keeps changing", r.key())
+}
+
+// Run sends a heartbeat every third of the TTL until `ctx` is done.
+func (r *Registry) Run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(r.conf.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Heartbeat(); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Deregister destroys the session, which deletes the node's key.
+func (r *Registry) Deregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deregistered = true
+	if r.session == "" {
+		return nil
+	}
+	if _, err := r.client.Session().Destroy(r.session, nil); err != nil {
+		return fmt.Errorf("error to destroy session: %w", err)
+	}
+	r.session = ""
+	return nil
+}
+
+// Nodes returns the nodes registered under `prefix`.
+func (kv *KV) Nodes(prefix string) ([]NodeInfo, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	pairs, _, err := kv.List(prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error to list nodes: %w", err)
+	}
+	nodes := make([]NodeInfo, 0, len(pairs))
+	for _, pair := range pairs {
+		var node NodeInfo
+		if err := json.Unmarshal(pair.Value, &node); err != nil {
+			return nil, fmt.Errorf("error unmarshal node %v: %w", pair.Key, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
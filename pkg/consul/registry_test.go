@@ -0,0 +1,214 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake Consul with sessions and the KV operations of the registry.
+type fakeConsul struct {
+	mu          sync.Mutex
+	index       uint64
+	nextSession int
+	sessions    map[string]bool
+	pairs       map[string]*api.KVPair
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *Client) {
+	c := &fakeConsul{sessions: make(map[string]bool), pairs: make(map[string]*api.KVPair)}
+	server := httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	t.Cleanup(server.Close)
+	client, err := NewClient(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	return c, client
+}
+
+// Invalidates a session, which deletes the keys it holds.
+func (c *fakeConsul) expire(session string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, session)
+	for key, pair := range c.pairs {
+		if pair.Session == session {
+			delete(c.pairs, key)
+		}
+	}
+}
+
+// Overwrites a key, as an operator would.
+func (c *fakeConsul) put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index++
+	pair := c.pairs[key]
+	pair.Value = value
+	pair.ModifyIndex = c.index
+}
+
+func (c *fakeConsul) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	query := r.URL.Query()
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		c.nextSession++
+		id := fmt.Sprintf("session-%d", c.nextSession)
+		c.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		if !c.sessions[id] {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]*api.SessionEntry{{ID: id}})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+		delete(c.sessions, id)
+		for key, pair := range c.pairs {
+			if pair.Session == id {
+				delete(c.pairs, key)
+			}
+		}
+		w.Write([]byte("true"))
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodGet:
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		var pairs api.KVPairs
+		for k, pair := range c.pairs {
+			if k == key || (query.Has("recurse") && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, pair)
+			}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodPut:
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		value, _ := io.ReadAll(r.Body)
+		w.Write([]byte(strconv.FormatBool(c.kvPut(key, value, query))))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *fakeConsul) kvPut(key string, value []byte, query map[string][]string) bool {
+	existing := c.pairs[key]
+	session := ""
+	if existing != nil {
+		session = existing.Session
+	}
+	if acquire := strings.Join(query["acquire"], ""); acquire != "" {
+		if !c.sessions[acquire] || (session != "" && session != acquire) {
+			return false
+		}
+		session = acquire
+	}
+	if cas := strings.Join(query["cas"], ""); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if (index == 0 && existing != nil) || (index != 0 && (existing == nil || existing.ModifyIndex != index)) {
+			return false
+		}
+	}
+	c.index++
+	c.pairs[key] = &api.KVPair{Key: key, Value: value, Session: session, ModifyIndex: c.index}
+	return true
+}
+
+func (c *fakeConsul) node(t *testing.T, key string) NodeInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pair := c.pairs[key]
+	require.NotNil(t, pair, "no node at %v", key)
+	var node NodeInfo
+	require.NoError(t, json.Unmarshal(pair.Value, &node))
+	return node
+}
+
+func (c *fakeConsul) hasKey(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pairs[key] != nil
+}
+
+func testRegistry(client *Client, id string, capacity *NodeCapacity) *Registry {
+	return NewRegistry(client, RegistryConfig{
+		Prefix: "ss/nodes/",
+		Node:   NodeInfo{ID: id, Address: "192.0.2.1", Port: 50051, Version: "test"},
+		TTL:    30 * time.Second,
+	}, func() NodeCapacity { return *capacity })
+}
+
+func TestRegistry(t *testing.T) {
+	fake, client := newFakeConsul(t)
+	capacity := &NodeCapacity{Ports: 1, Keys: 2}
+	r := testRegistry(client, "node1", capacity)
+	require.NoError(t, r.Register())
+	node := fake.node(t, "ss/nodes/node1")
+	require.Equal(t, "192.0.2.1", node.Address)
+	require.Equal(t, 2, node.Capacity.Keys)
+
+	// A heartbeat updates the capacity.
+	capacity.TCPConnections = 5
+	require.NoError(t, r.Heartbeat())
+	require.Equal(t, 5, fake.node(t, "ss/nodes/node1").Capacity.TCPConnections)
+
+	// The update is retried when the key changed in between.
+	fake.put("ss/nodes/node1", []byte(`{}`))
+	capacity.TCPConnections = 6
+	require.NoError(t, r.Heartbeat())
+	require.Equal(t, 6, fake.node(t, "ss/nodes/node1").Capacity.TCPConnections)
+
+	// The node registers again when its session expired.
+	fake.expire(r.session)
+	require.False(t, fake.hasKey("ss/nodes/node1"))
+	require.NoError(t, r.Heartbeat())
+	require.Equal(t, "node1", fake.node(t, "ss/nodes/node1").ID)
+
+	nodes, err := NewKV(client).Nodes("ss/nodes")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+
+	require.NoError(t, r.Deregister())
+	require.False(t, fake.hasKey("ss/nodes/node1"))
+	// No more heartbeats after deregistration.
+	require.NoError(t, r.Heartbeat())
+	require.False(t, fake.hasKey("ss/nodes/node1"))
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	fake, client := newFakeConsul(t)
+	capacity := &NodeCapacity{}
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = testRegistry(client, fmt.Sprintf("node%d", i), capacity).Register()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err)
+		require.True(t, fake.hasKey(fmt.Sprintf("ss/nodes/node%d", i)))
+	}
+
+	// A second node with the same ID is rejected while the first is alive.
+	err := testRegistry(client, "node0", capacity).Register()
+	require.True(t, errors.Is(err, ErrNodeIDInUse), err)
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Returns the value of a field as it's written: errors and fmt.Stringers
+// become strings.
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+type textHandler struct {
+	mu  sync.Mutex
+	w   io.Writer
+	pid int
+}
+
+// NewTextHandler returns a Handler that writes lines like
+//
+//	I2022-10-01T12:00:00.000Z 1234 udp.go:56] udp: message key=value
+//
+// with the first letter of the level, the time, the process ID, the caller,
+// the subsystem, the message and the fields.
+func NewTextHandler(w io.Writer) Handler {
+	return &textHandler{w: w, pid: os.Getpid()}
+}
+
+func (h *textHandler) Handle(r Record) error {
+	var buf bytes.Buffer
+	buf.WriteString(strings.ToUpper(r.Level.String()[:1]))
+	buf.WriteString(r.Time.Format(timeLayout))
+	fmt.Fprintf(&buf, " %d %s] ", h.pid, r.Caller)
+	if r.Subsystem != "" {
+		buf.WriteString(r.Subsystem)
+		buf.WriteString(": ")
+	}
+	buf.WriteString(r.Message)
+	for _, f := range r.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		value := fmt.Sprint(fieldValue(f.Value))
+		if value == "" || strings.ContainsAny(value, " =\"\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+type jsonHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONHandler returns a Handler that writes a JSON object per line, with
+// the "time", "level", "subsystem", "caller" and "msg" keys followed by the
+// fields.
+func NewJSONHandler(w io.Writer) Handler {
+	return &jsonHandler{w: w}
+}
+
+func (h *jsonHandler) Handle(r Record) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeJSONField(&buf, "time", r.Time.UTC().Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeJSONField(&buf, "level", r.Level.String())
+	if r.Subsystem != "" {
+		buf.WriteByte(',')
+		writeJSONField(&buf, "subsystem", r.Subsystem)
+	}
+	if r.Caller != "" {
+		buf.WriteByte(',')
+		writeJSONField(&buf, "caller", r.Caller)
+	}
+	buf.WriteByte(',')
+	writeJSONField(&buf, "msg", r.Message)
+	for _, f := range r.Fields {
+		buf.WriteByte(',')
+		writeJSONField(&buf, f.Key, fieldValue(f.Value))
+	}
+	buf.WriteString("}\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
@@ -0,0 +1,395 @@
+// Package log is a leveled, structured logger.  Loggers are named after the
+// subsystem that uses them, and the level of every subsystem can be changed at
+// runtime.  Records are written as text or JSON lines by a Handler.
+//
+// A nil *Logger discards everything, so that it can be left out in tests.
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a record.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// ParseLevel parses "debug", "info", "warning" (or "warn") and "error".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key and value of a record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any returns a field with any value.  Errors and fmt.Stringers are
+// formatted as strings.
+func Any(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+func String(key, value string) Field {
+	return Field{key, value}
+}
+
+func Int(key string, value int) Field {
+	return Field{key, value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{key, value}
+}
+
+func Duration(key string, value time.Duration) Field {
+	return Field{key, value}
+}
+
+// Err returns an "error" field.
+func Err(err error) Field {
+	return Field{"error", err}
+}
+
+// Record is a log entry.
+type Record struct {
+	Time      time.Time
+	Level     Level
+	Subsystem string
+	// Caller is the file name and line of the call.
+	Caller  string
+	Message string
+	Fields  []Field
+}
+
+// Handler writes the records.  It must be safe for concurrent use.
+type Handler interface {
+	Handle(r Record) error
+}
+
+// The handler and levels shared by a root Logger and the loggers derived from
+// it.
+type registry struct {
+	handler Handler
+	mu      sync.Mutex // Protects defaultLevel, overrides and levels.
+	// The level of the subsystems without an override.
+	defaultLevel Level
+	overrides    map[string]Level
+	// The effective level of every subsystem with a Logger.
+	levels map[string]*int32
+}
+
+func (r *registry) level(subsystem string) *int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	level, ok := r.levels[subsystem]
+	if !ok {
+		level = new(int32)
+		*level = int32(r.effectiveLocked(subsystem))
+		r.levels[subsystem] = level
+	}
+	return level
+}
+
+func (r *registry) effectiveLocked(subsystem string) Level {
+	if level, ok := r.overrides[subsystem]; ok {
+		return level
+	}
+	return r.defaultLevel
+}
+
+func (r *registry) updateLocked() {
+	for subsystem, level := range r.levels {
+		atomic.StoreInt32(level, int32(r.effectiveLocked(subsystem)))
+	}
+}
+
+// Logger writes records of one subsystem, with a set of fields.
+type Logger struct {
+	registry  *registry
+	subsystem string
+	// The level of the subsystem, which may change at any time.
+	level  *int32
+	fields []Field
+}
+
+// New returns a root Logger, with no subsystem, that writes the records at
+// `level` or above to `handler`.
+func New(handler Handler, level Level) *Logger {
+	r := &registry{
+		handler:      handler,
+		defaultLevel: level,
+		overrides:    make(map[string]Level),
+		levels:       make(map[string]*int32),
+	}
+	return &Logger{registry: r, level: r.level("")}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultLogger *Logger
+)
+
+// Default returns a root Logger that writes text to stderr at LevelInfo.
+func Default() *Logger {
+	defaultOnce.Do(func() {
+		defaultLogger = New(NewTextHandler(os.Stderr), LevelInfo)
+	})
+	return defaultLogger
+}
+
+// Named returns a Logger for `subsystem`, with the handler and levels of `l`
+// but without its fields.
+func (l *Logger) Named(subsystem string) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{registry: l.registry, subsystem: subsystem, level: l.registry.level(subsystem)}
+}
+
+// With returns a Logger that adds `fields` to every record.
+func (l *Logger) With(fields ...Field) *Logger {
+	if l == nil {
+		return nil
+	}
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &Logger{registry: l.registry, subsystem: l.subsystem, level: l.level, fields: merged}
+}
+
+// Subsystem returns the subsystem of the Logger.
+func (l *Logger) Subsystem() string {
+	if l == nil {
+		return ""
+	}
+	return l.subsystem
+}
+
+// Enabled returns whether records at `level` are written.  Callers can use
+// it to avoid preparing expensive fields.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= Level(atomic.LoadInt32(l.level))
+}
+
+// SetLevel sets the level of the subsystems without their own level.
+func (l *Logger) SetLevel(level Level) {
+	if l == nil {
+		return
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultLevel = level
+	r.updateLocked()
+}
+
+// SetSubsystemLevel sets the level of `subsystem`, which then doesn't follow
+// SetLevel.
+func (l *Logger) SetSubsystemLevel(subsystem string, level Level) {
+	if l == nil {
+		return
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides[subsystem] = level
+	r.updateLocked()
+}
+
+// ResetLevels sets the level of all subsystems to `level`, removing their
+// own levels.
+func (l *Logger) ResetLevels(level Level) {
+	if l == nil {
+		return
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultLevel = level
+	r.overrides = make(map[string]Level)
+	r.updateLocked()
+}
+
+// Levels returns the current levels as a spec for SetLevels.
+func (l *Logger) Levels() string {
+	if l == nil {
+		return ""
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	specs := []string{r.defaultLevel.String()}
+	var subsystems []string
+	for subsystem := range r.overrides {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	for _, subsystem := range subsystems {
+		specs = append(specs, subsystem+"="+r.overrides[subsystem].String())
+	}
+	return strings.Join(specs, ",")
+}
+
+// SetLevels parses a comma-separated list of levels, like
+// "info,udp=debug,tcp=warning", and replaces the current levels with it.  The
+// level without a subsystem applies to the other subsystems, and is `info` if
+// missing.
+func (l *Logger) SetLevels(spec string) error {
+	defaultLevel := LevelInfo
+	overrides := make(map[string]Level)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, levelName := "", part
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			subsystem, levelName = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+		level, err := ParseLevel(levelName)
+		if err != nil {
+			return err
+		}
+		if subsystem == "" {
+			defaultLevel = level
+		} else {
+			overrides[subsystem] = level
+		}
+	}
+	if l == nil {
+		return nil
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultLevel = defaultLevel
+	r.overrides = overrides
+	r.updateLocked()
+	return nil
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	record := Record{
+		Time:      time.Now(),
+		Level:     level,
+		Subsystem: l.subsystem,
+		Message:   msg,
+	}
+	// Skip log and the exported method.
+	if _, file, line, ok := runtime.Caller(2); ok {
+		record.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	if len(l.fields) > 0 {
+		record.Fields = make([]Field, 0, len(l.fields)+len(fields))
+		record.Fields = append(record.Fields, l.fields...)
+		record.Fields = append(record.Fields, fields...)
+	} else {
+		record.Fields = fields
+	}
+	if err := l.registry.handler.Handle(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write log: %v\n", err)
+	}
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) {
+	if l.Enabled(LevelDebug) {
+		l.log(LevelDebug, msg, fields)
+	}
+}
+
+func (l *Logger) Info(msg string, fields ...Field) {
+	if l.Enabled(LevelInfo) {
+		l.log(LevelInfo, msg, fields)
+	}
+}
+
+func (l *Logger) Warning(msg string, fields ...Field) {
+	if l.Enabled(LevelWarning) {
+		l.log(LevelWarning, msg, fields)
+	}
+}
+
+func (l *Logger) Error(msg string, fields ...Field) {
+	if l.Enabled(LevelError) {
+		l.log(LevelError, msg, fields)
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.log(LevelDebug, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.log(LevelInfo, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	if l.Enabled(LevelWarning) {
+		l.log(LevelWarning, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.log(LevelError, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// Fatal logs at LevelError and exits.
+func (l *Logger) Fatal(msg string, fields ...Field) {
+	if l != nil {
+		l.log(LevelError, msg, fields)
+	}
+	os.Exit(1)
+}
+
+// Fatalf logs at LevelError and exits.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	if l != nil {
+		l.log(LevelError, fmt.Sprintf(format, args...), nil)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHandler struct {
+	records []Record
+}
+
+func (h *recordingHandler) Handle(r Record) error {
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestLevels(t *testing.T) {
+	handler := &recordingHandler{}
+	root := New(handler, LevelInfo)
+	tcp := root.Named("tcp")
+	udp := root.Named("udp")
+
+	tcp.Debug("hidden")
+	tcp.Info("shown")
+	require.Len(t, handler.records, 1)
+	require.Equal(t, "tcp", handler.records[0].Subsystem)
+	require.Equal(t, "shown", handler.records[0].Message)
+
+	root.SetSubsystemLevel("udp", LevelDebug)
+	require.True(t, udp.Enabled(LevelDebug))
+	require.False(t, tcp.Enabled(LevelDebug))
+	// Loggers created later get the level of their subsystem.
+	require.True(t, root.Named("udp").Enabled(LevelDebug))
+
+	root.SetLevel(LevelError)
+	require.False(t, tcp.Enabled(LevelWarning))
+	require.True(t, udp.Enabled(LevelDebug))
+
+	root.ResetLevels(LevelDebug)
+	require.True(t, tcp.Enabled(LevelDebug))
+	require.Equal(t, "debug", root.Levels())
+}
+
+func TestSetLevels(t *testing.T) {
+	root := New(&recordingHandler{}, LevelInfo)
+	tcp := root.Named("tcp")
+	udp := root.Named("udp")
+
+	require.NoError(t, root.SetLevels("warning, udp=debug"))
+	require.False(t, tcp.Enabled(LevelInfo))
+	require.True(t, tcp.Enabled(LevelWarning))
+	require.True(t, udp.Enabled(LevelDebug))
+	require.Equal(t, "warning,udp=debug", root.Levels())
+
+	// The default is info when missing.
+	require.NoError(t, root.SetLevels("tcp=error"))
+	require.True(t, udp.Enabled(LevelInfo))
+	require.False(t, udp.Enabled(LevelDebug))
+	require.False(t, tcp.Enabled(LevelWarning))
+
+	// Invalid specs leave the levels alone.
+	require.Error(t, root.SetLevels("info,udp=loud"))
+	require.Equal(t, "info,tcp=error", root.Levels())
+}
+
+func TestWith(t *testing.T) {
+	handler := &recordingHandler{}
+	logger := New(handler, LevelDebug).Named("udp").With(String("client", "1.2.3.4"))
+	logger.Debug("packet", Int("size", 10))
+	require.Len(t, handler.records, 1)
+	require.Equal(t, []Field{{"client", "1.2.3.4"}, {"size", 10}}, handler.records[0].Fields)
+	require.True(t, strings.HasPrefix(handler.records[0].Caller, "log_test.go:"), handler.records[0].Caller)
+}
+
+func TestNilLogger(t *testing.T) {
+	var logger *Logger
+	require.Nil(t, logger.Named("tcp"))
+	require.False(t, logger.Enabled(LevelError))
+	logger.Error("dropped", Err(errors.New("failed")))
+	logger.Infof("dropped %v", 1)
+}
+
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(NewTextHandler(&buf), LevelInfo).Named("tcp")
+	logger.Warning("Failed to dial", String("target", "example.com:443"), Err(errors.New("no route")), Duration("after", time.Second))
+	line := buf.String()
+	require.True(t, strings.HasPrefix(line, "W"), line)
+	require.Contains(t, line, "] tcp: Failed to dial target=example.com:443 error=\"no route\" after=1s\n")
+}
+
+func TestJSONHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(NewJSONHandler(&buf), LevelInfo).Named("udp")
+	logger.Info("NAT entry", String("target", "1.1.1.1:53"), Int64("bytes", 1500), Err(errors.New("timeout")))
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, "info", record["level"])
+	require.Equal(t, "udp", record["subsystem"])
+	require.Equal(t, "NAT entry", record["msg"])
+	require.Equal(t, "1.1.1.1:53", record["target"])
+	require.Equal(t, float64(1500), record["bytes"])
+	require.Equal(t, "timeout", record["error"])
+	require.Contains(t, record["caller"], "log_test.go:")
+}
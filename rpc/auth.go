@@ -0,0 +1,142 @@
+package rpc_handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Scope is what a token allows.
+type Scope string
+
+const (
+	// ScopeRead allows the methods that don't change the server.
+	ScopeRead Scope = "read"
+	// ScopeMutate allows all methods.
+	ScopeMutate Scope = "mutate"
+)
+
+// The methods that ScopeRead allows.  Other methods, including ones added
+// later, require ScopeMutate.
+var readMethods = map[string]bool{
+	"SsConnectionStatus":   true,
+	"CheckSsPortAvailable": true,
+	"ListBans":             true,
+	"ListKeys":             true,
+	"ListPorts":            true,
+	"StreamUsage":          true,
+	"GetUsage":             true,
+}
+
+// Services that don't need a token, so that load balancers and Consul can check health.
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// TokenAuth checks the bearer token in the "authorization" metadata of each
+// call against a set of tokens and their scopes.
+type TokenAuth struct {
+	tokens map[string]Scope
+}
+
+// NewTokenAuth returns a TokenAuth for `tokens`, which maps each token to its scope.
+func NewTokenAuth(tokens map[string]Scope) *TokenAuth {
+	return &TokenAuth{tokens: tokens}
+}
+
+// LoadTokenFile reads a token file, where each line has a scope and a token,
+// separated by whitespace.  Empty lines and lines starting with # are ignored.
+func LoadTokenFile(path string) (map[string]Scope, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer file.Close()
+	tokens := make(map[string]Scope)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%d: expected a scope and a token", path, lineNum)
+		}
+		scope := Scope(fields[0])
+		if scope != ScopeRead && scope != ScopeMutate {
+			return nil, fmt.Errorf("%v:%d: unknown scope %q", path, lineNum, scope)
+		}
+		tokens[fields[1]] = scope
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %v", path)
+	}
+	return tokens, nil
+}
+
+// Returns the scope of the token in the call's metadata.
+func (a *TokenAuth) scope(ctx context.Context) (Scope, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if token == value {
+			continue
+		}
+		// Compare against every token in constant time, so that the timing
+		// doesn't reveal a matching prefix.
+		var found Scope
+		for candidate, scope := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+				found = scope
+			}
+		}
+		if found != "" {
+			return found, true
+		}
+	}
+	return "", false
+}
+
+func (a *TokenAuth) authorize(ctx context.Context, fullMethod string) error {
+	if strings.HasPrefix(fullMethod, healthServicePrefix) {
+		return nil
+	}
+	scope, ok := a.scope(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if scope != ScopeMutate && !readMethods[method] {
+		return status.Errorf(codes.PermissionDenied, "token scope %q does not allow %v", scope, method)
+	}
+	return nil
+}
+
+// UnaryInterceptor authorizes unary calls.
+func (a *TokenAuth) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authorizes streaming calls.
+func (a *TokenAuth) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,79 @@
+package rpc_handler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func callUnary(auth *TokenAuth, ctx context.Context, method string) codes.Code {
+	_, err := auth.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	return status.Code(err)
+}
+
+func TestTokenAuth_Scopes(t *testing.T) {
+	auth := NewTokenAuth(map[string]Scope{"reader": ScopeRead, "admin": ScopeMutate})
+
+	require.Equal(t, codes.Unauthenticated, callUnary(auth, context.Background(), "/ss_service.SsService/ListKeys"))
+	require.Equal(t, codes.Unauthenticated, callUnary(auth, withToken("wrong"), "/ss_service.SsService/ListKeys"))
+	require.Equal(t, codes.Unauthenticated, callUnary(auth,
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "reader")), "/ss_service.SsService/ListKeys"))
+
+	require.Equal(t, codes.OK, callUnary(auth, withToken("reader"), "/ss_service.SsService/ListKeys"))
+	require.Equal(t, codes.PermissionDenied, callUnary(auth, withToken("reader"), "/ss_service.SsService/ActivateSsConnection"))
+	require.Equal(t, codes.PermissionDenied, callUnary(auth, withToken("reader"), "/ss_service.SsService/SomeNewMethod"))
+	require.Equal(t, codes.OK, callUnary(auth, withToken("admin"), "/ss_service.SsService/ActivateSsConnection"))
+
+	// Health checks don't need a token.
+	require.Equal(t, codes.OK, callUnary(auth, context.Background(), "/grpc.health.v1.Health/Check"))
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestTokenAuth_Stream(t *testing.T) {
+	auth := NewTokenAuth(map[string]Scope{"reader": ScopeRead})
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	info := &grpc.StreamServerInfo{FullMethod: "/ss_service.SsService/StreamUsage"}
+	require.NoError(t, auth.StreamInterceptor(nil, &fakeServerStream{ctx: withToken("reader")}, info, handler))
+	err := auth.StreamInterceptor(nil, &fakeServerStream{ctx: context.Background()}, info, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("# Monitoring\nread abc\n\nmutate  def\n"), 0600))
+	tokens, err := LoadTokenFile(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]Scope{"abc": ScopeRead, "def": ScopeMutate}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte("write abc\n"), 0600))
+	_, err = LoadTokenFile(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("abc\n"), 0600))
+	_, err = LoadTokenFile(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("# Nothing\n"), 0600))
+	_, err = LoadTokenFile(path)
+	require.Error(t, err)
+}
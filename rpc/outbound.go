@@ -0,0 +1,274 @@
+package rpc_handler
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/vpn-api/gen/ss_service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultMinBackoff     = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// ControlStream is the node's end of the stream to the controller.
+type ControlStream interface {
+	Send(*ss_service.NodeMessage) error
+	Recv() (*ss_service.ControllerMessage, error)
+}
+
+// Dialer opens a stream to the controller.  The stream ends when `ctx` is done.
+type Dialer func(ctx context.Context) (ControlStream, error)
+
+// GrpcDialer returns a Dialer that opens the stream on `conn`, with `token`
+// as the bearer token if it's not empty.
+func GrpcDialer(conn *grpc.ClientConn, token string) Dialer {
+	client := ss_service.NewSsControllerClient(conn)
+	return func(ctx context.Context) (ControlStream, error) {
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return client.Connect(ctx)
+	}
+}
+
+// OutboundConfig configures the connection to the controller.
+type OutboundConfig struct {
+	// NodeID and Version are sent to the controller when connecting.
+	NodeID  string
+	Version string
+	// HealthInterval is how often the health is sent.  Zero selects 30 seconds.
+	HealthInterval time.Duration
+	// MinBackoff and MaxBackoff bound the delay before reconnecting.  The delay
+	// doubles after every failed attempt.  Zero selects 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Logger     *log.Logger
+}
+
+// Outbound connects out to a controller, for nodes that the controller can't
+// reach.  It runs the commands that the controller sends with the same
+// Handler as the inbound gRPC server, and sends back the results, the usage
+// batches and the health of the node.
+type Outbound struct {
+	h      *Handler
+	dial   Dialer
+	config OutboundConfig
+	// The last usage batch acknowledged by the controller, to resume the
+	// usage after a reconnect.
+	ackMu      sync.Mutex
+	usageEpoch string
+	usageSeq   uint64
+}
+
+// NewOutbound returns an Outbound that runs the commands with `h`.
+func NewOutbound(h *Handler, dial Dialer, config OutboundConfig) *Outbound {
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = defaultHealthInterval
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = defaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	return &Outbound{h: h, dial: dial, config: config}
+}
+
+// Run keeps a stream to the controller until `ctx` is done, reconnecting
+// with back-off when it fails.
+func (o *Outbound) Run(ctx context.Context) {
+	backoff := o.config.MinBackoff
+	for {
+		start := time.Now()
+		err := o.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// A connection that lasted longer than the maximum delay was healthy.
+		if time.Since(start) > o.config.MaxBackoff {
+			backoff = o.config.MinBackoff
+		}
+		// Up to 25% of jitter, so that nodes don't reconnect all at once.
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/4+1))
+		o.config.Logger.Warning("Controller connection failed", log.Err(err), log.Duration("retry_in", delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > o.config.MaxBackoff {
+			backoff = o.config.MaxBackoff
+		}
+	}
+}
+
+// Runs one stream until it fails or `ctx` is done.
+func (o *Outbound) connect(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := o.dial(ctx)
+	if err != nil {
+		return err
+	}
+	// gRPC streams don't support concurrent sends.
+	var sendMu sync.Mutex
+	send := func(msg *ss_service.NodeMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(msg)
+	}
+	if err := send(&ss_service.NodeMessage{Hello: &ss_service.NodeHello{NodeId: o.config.NodeID, Version: o.config.Version}}); err != nil {
+		return err
+	}
+	o.config.Logger.Info("Connected to the controller")
+
+	errCh := make(chan error, 2)
+	var updates sync.WaitGroup
+	updates.Add(1)
+	go func() {
+		defer updates.Done()
+		errCh <- o.sendUpdates(ctx, send)
+	}()
+	go func() {
+		for {
+			cmd, err := stream.Recv()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if ack := cmd.GetUsageAck(); ack != nil {
+				o.ack(ack)
+				continue
+			}
+			if err := send(o.handle(ctx, cmd)); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	err = <-errCh
+	// Canceling the context ends the stream, and with it the other goroutine.
+	// The next stream resends the batches that the controller didn't
+	// acknowledge.
+	cancel()
+	updates.Wait()
+	return err
+}
+
+// Sends the usage batches as they are published, and the health every
+// HealthInterval.
+func (o *Outbound) sendUpdates(ctx context.Context, send func(*ss_service.NodeMessage) error) error {
+	epoch, seq := o.acked()
+	backlog, batches, cancel := o.h.ss.SubscribeUsage(epoch, seq)
+	defer cancel()
+	sendBatch := func(b *ss_service.UsageBatch) error {
+		return send(&ss_service.NodeMessage{UsageBatch: b})
+	}
+	for _, b := range backlog {
+		if err := sendBatch(usageBatchToProto(b)); err != nil {
+			return err
+		}
+	}
+	if err := send(&ss_service.NodeMessage{Health: o.health()}); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(o.config.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case b, ok := <-batches:
+			if !ok {
+				return errors.New("usage stream interrupted")
+			}
+			if err := sendBatch(usageBatchToProto(b)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := send(&ss_service.NodeMessage{Health: o.health()}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Records the last usage batch acknowledged by the controller.  Acks of
+// earlier batches of the same epoch are ignored.
+func (o *Outbound) ack(ack *ss_service.UsageAck) {
+	o.ackMu.Lock()
+	defer o.ackMu.Unlock()
+	if ack.GetEpoch() == o.usageEpoch && ack.GetSeq() <= o.usageSeq {
+		return
+	}
+	o.usageEpoch, o.usageSeq = ack.GetEpoch(), ack.GetSeq()
+}
+
+// Returns the last usage batch acknowledged by the controller.
+func (o *Outbound) acked() (epoch string, seq uint64) {
+	o.ackMu.Lock()
+	defer o.ackMu.Unlock()
+	return o.usageEpoch, o.usageSeq
+}
+
+func (o *Outbound) health() *ss_service.NodeHealth {
+	health := &ss_service.NodeHealth{Timestamp: time.Now().Unix()}
+	for _, p := range o.h.ss.PortStatuses() {
+		health.NumPorts++
+		health.NumKeys += int32(p.NumKeys)
+	}
+	return health
+}
+
+// Runs a command and returns the response, with the request ID of the command.
+func (o *Outbound) handle(ctx context.Context, cmd *ss_service.ControllerMessage) *ss_service.NodeMessage {
+	resp := &ss_service.NodeMessage{RequestId: cmd.GetRequestId()}
+	var err error
+	switch {
+	case cmd.Activate != nil:
+		resp.Activate, err = o.h.ActivateSsConnection(ctx, cmd.Activate)
+	case cmd.Deactivate != nil:
+		resp.Deactivate, err = o.h.DeactivateSsConnection(ctx, cmd.Deactivate)
+	case cmd.Status != nil:
+		resp.Status, err = o.h.SsConnectionStatus(ctx, cmd.Status)
+	case cmd.SetKeys != nil:
+		resp.SetKeys, err = o.h.SetKeys(ctx, cmd.SetKeys)
+	case cmd.ListKeys != nil:
+		resp.ListKeys, err = o.h.ListKeys(ctx, cmd.ListKeys)
+	case cmd.ListPorts != nil:
+		resp.ListPorts, err = o.h.ListPorts(ctx, cmd.ListPorts)
+	case cmd.ListBans != nil:
+		resp.ListBans, err = o.h.ListBans(ctx, cmd.ListBans)
+	case cmd.Ban != nil:
+		resp.Ban, err = o.h.Ban(ctx, cmd.Ban)
+	case cmd.Unban != nil:
+		resp.Unban, err = o.h.Unban(ctx, cmd.Unban)
+	case cmd.GetUsage != nil:
+		resp.GetUsage, err = o.h.GetUsage(ctx, cmd.GetUsage)
+	case cmd.SetLogLevels != nil:
+		resp.SetLogLevels, err = o.h.SetLogLevels(ctx, cmd.SetLogLevels)
+	default:
+		err = status.Error(codes.Unimplemented, "unknown command")
+	}
+	if err != nil {
+		s := status.Convert(err)
+		resp.Code = int32(s.Code())
+		resp.Error = s.Message()
+	}
+	return resp
+}
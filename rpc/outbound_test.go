@@ -0,0 +1,212 @@
+package rpc_handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/evgeniy-krivenko/vpn-api/gen/ss_service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+// The controller's end of a stream.
+type fakeStream struct {
+	ctx      context.Context
+	toNode   chan *ss_service.ControllerMessage
+	fromNode chan *ss_service.NodeMessage
+}
+
+func (s *fakeStream) Send(msg *ss_service.NodeMessage) error {
+	select {
+	case s.fromNode <- msg:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *fakeStream) Recv() (*ss_service.ControllerMessage, error) {
+	select {
+	case msg, ok := <-s.toNode:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// Returns the next message that isn't usage or health.
+func (s *fakeStream) response(t *testing.T) *ss_service.NodeMessage {
+	for {
+		select {
+		case msg := <-s.fromNode:
+			if msg.UsageBatch == nil && msg.Health == nil {
+				return msg
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the node")
+		}
+	}
+}
+
+// A controller that accepts every stream.
+func fakeController() (Dialer, <-chan *fakeStream) {
+	streams := make(chan *fakeStream, 1)
+	dial := func(ctx context.Context) (ControlStream, error) {
+		s := &fakeStream{
+			ctx:      ctx,
+			toNode:   make(chan *ss_service.ControllerMessage),
+			fromNode: make(chan *ss_service.NodeMessage, 10),
+		}
+		streams <- s
+		return s, nil
+	}
+	return dial, streams
+}
+
+func makeTestServer(t *testing.T) *server.SSServer {
+	s, err := server.NewSSServer(&server.SSConfig{
+		Metrics: &metrics.NoOpMetrics{},
+		Ports:   make(map[int]*server.SsPort),
+		Logger:  log.Default(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestOutbound(t *testing.T) {
+	dial, streams := fakeController()
+	h := NewGrpcHandler(makeTestServer(t))
+	root := log.New(log.NewTextHandler(io.Discard), log.LevelInfo)
+	h.SetLogger(root)
+	o := NewOutbound(h, dial, OutboundConfig{
+		NodeID:         "node",
+		HealthInterval: time.Hour,
+		MinBackoff:     time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+
+	stream := <-streams
+	require.Equal(t, "node", stream.response(t).Hello.NodeId)
+	health := <-stream.fromNode
+	require.NotNil(t, health.Health)
+	require.Equal(t, int32(0), health.Health.NumKeys)
+
+	port := freePort(t)
+	stream.toNode <- &ss_service.ControllerMessage{
+		RequestId: "1",
+		Activate:  &ss_service.SsConnectionReq{UserId: "user", Port: int32(port), Secret: "secret"},
+	}
+	resp := stream.response(t)
+	require.Equal(t, "1", resp.RequestId)
+	require.Empty(t, resp.Error)
+	require.True(t, resp.Activate.IsActive)
+
+	stream.toNode <- &ss_service.ControllerMessage{RequestId: "2", ListKeys: &ss_service.ListKeysReq{}}
+	resp = stream.response(t)
+	require.Equal(t, "2", resp.RequestId)
+	require.Len(t, resp.ListKeys.Keys, 1)
+	require.Equal(t, "user", resp.ListKeys.Keys[0].UserId)
+
+	stream.toNode <- &ss_service.ControllerMessage{RequestId: "3", Ban: &ss_service.BanReq{Subnet: "invalid"}}
+	resp = stream.response(t)
+	require.Equal(t, int32(codes.InvalidArgument), resp.Code)
+	require.NotEmpty(t, resp.Error)
+
+	stream.toNode <- &ss_service.ControllerMessage{RequestId: "4"}
+	require.Equal(t, int32(codes.Unimplemented), stream.response(t).Code)
+
+	// Only the UDP logs are raised to debug.
+	stream.toNode <- &ss_service.ControllerMessage{RequestId: "5", SetLogLevels: &ss_service.SetLogLevelsReq{Levels: "info,udp=debug"}}
+	resp = stream.response(t)
+	require.Empty(t, resp.Error)
+	require.Equal(t, "info,udp=debug", resp.SetLogLevels.Levels)
+	require.True(t, root.Named("udp").Enabled(log.LevelDebug))
+	require.False(t, root.Named("tcp").Enabled(log.LevelDebug))
+	stream.toNode <- &ss_service.ControllerMessage{RequestId: "6", SetLogLevels: &ss_service.SetLogLevelsReq{Levels: "udp=loud"}}
+	require.Equal(t, int32(codes.InvalidArgument), stream.response(t).Code)
+	require.Equal(t, "info,udp=debug", root.Levels())
+
+	// The node reconnects when the controller ends the stream.
+	close(stream.toNode)
+	stream = <-streams
+	require.Equal(t, "node", stream.response(t).Hello.NodeId)
+
+	cancel()
+	<-done
+}
+
+func TestOutbound_UsageAck(t *testing.T) {
+	s, err := server.NewSSServer(&server.SSConfig{
+		Metrics:       &metrics.NoOpMetrics{},
+		Ports:         make(map[int]*server.SsPort),
+		Logger:        log.Default(),
+		UsageInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	dial, streams := fakeController()
+	o := NewOutbound(NewGrpcHandler(s), dial, OutboundConfig{
+		HealthInterval: time.Hour,
+		MinBackoff:     time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+
+	// Returns the next usage batch.
+	nextBatch := func(stream *fakeStream) *ss_service.UsageBatch {
+		for {
+			select {
+			case msg := <-stream.fromNode:
+				if msg.UsageBatch != nil {
+					return msg.UsageBatch
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for a usage batch")
+			}
+		}
+	}
+	stream := <-streams
+	first := nextBatch(stream)
+	for nextBatch(stream).Seq < first.Seq+2 {
+	}
+	// Only the first batch is acknowledged before the stream ends.
+	stream.toNode <- &ss_service.ControllerMessage{UsageAck: &ss_service.UsageAck{Epoch: first.Epoch, Seq: first.Seq}}
+	close(stream.toNode)
+
+	// The batches that weren't acknowledged are sent again.
+	stream = <-streams
+	resumed := nextBatch(stream)
+	require.Equal(t, first.Epoch, resumed.Epoch)
+	require.Equal(t, first.Seq+1, resumed.Seq)
+
+	cancel()
+	<-done
+}
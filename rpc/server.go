@@ -3,15 +3,22 @@ package rpc_handler
 import (
 	"context"
 	"fmt"
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
 	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
 	"github.com/evgeniy-krivenko/vpn-api/gen/ss_service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net"
+	"time"
 )
 
-var cipherType = "chacha20-ietf-poly1305"
-
 type Handler struct {
 	ss *server.SSServer
+	// Host used in the access URLs of activated keys.
+	accessHost string
+	// The root logger whose levels SetLogLevels changes.  May be nil.
+	logger *log.Logger
 	ss_service.UnimplementedSsServiceServer
 }
 
@@ -19,18 +26,53 @@ func NewGrpcHandler(ss *server.SSServer) *Handler {
 	return &Handler{ss: ss}
 }
 
+// SetAccessHost sets the hostname or IP address that clients connect to, for
+// the access URLs returned by ActivateSsConnection.
+func (h *Handler) SetAccessHost(host string) {
+	h.accessHost = host
+}
+
+// SetLogger sets the root logger whose levels SetLogLevels changes.  Without
+// it, SetLogLevels fails.
+func (h *Handler) SetLogger(root *log.Logger) {
+	h.logger = root
+}
+
+// ActivateSsConnection adds a key.  The cipher defaults to server.DefaultCipher,
+// and a random secret is generated if none is given.  The response has the
+// final port, which differs from the requested one if it wasn't available.
 func (h *Handler) ActivateSsConnection(ctx context.Context, acr *ss_service.SsConnectionReq) (*ss_service.SsConnectionRes, error) {
-	_, err := h.ss.AddCipher(server.CipherStruct{
+	cipher := acr.GetCipher()
+	if cipher == "" {
+		cipher = server.DefaultCipher
+	}
+	if err := server.ValidateCipher(cipher); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	secret := acr.GetSecret()
+	if secret == "" {
+		var err error
+		if secret, err = server.GenerateSecret(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	port, err := h.ss.AddCipher(server.CipherStruct{
 		Port:   int(acr.GetPort()),
 		ID:     acr.GetUserId(),
-		Secret: acr.GetSecret(),
-		Cipher: cipherType,
+		Secret: secret,
+		Cipher: cipher,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &ss_service.SsConnectionRes{IsActive: true}, nil
+	return &ss_service.SsConnectionRes{
+		IsActive:  true,
+		Cipher:    cipher,
+		Secret:    secret,
+		Port:      int32(port),
+		AccessUrl: server.AccessURL(h.accessHost, port, cipher, secret),
+	}, nil
 }
 func (h *Handler) DeactivateSsConnection(ctx context.Context, req *ss_service.SsConnectionReq) (*ss_service.SsConnectionRes, error) {
 	err := h.ss.RemoveCipher(server.CipherStruct{
@@ -64,3 +106,222 @@ func (h *Handler) CheckSsPortAvailable(ctx context.Context, req *ss_service.Chec
 	resp.Status = true
 	return &resp, nil
 }
+
+// banToProto shows the subnet as `privacy` allows, like the logs and the
+// webhook events.
+func banToProto(b service.BanEntry, privacy *service.IPPrivacy) *ss_service.Ban {
+	return &ss_service.Ban{
+		Subnet:   privacy.Subnet(b.Subnet),
+		Scope:    b.Scope,
+		Reason:   b.Reason,
+		Since:    b.Since.Unix(),
+		Until:    b.Until.Unix(),
+		Offenses: int32(b.Offenses),
+	}
+}
+
+func (h *Handler) ListBans(ctx context.Context, req *ss_service.ListBansReq) (*ss_service.ListBansRes, error) {
+	var resp ss_service.ListBansRes
+	for _, b := range h.ss.Bans() {
+		resp.Bans = append(resp.Bans, banToProto(b, h.ss.IPPrivacy()))
+	}
+	return &resp, nil
+}
+
+func (h *Handler) Ban(ctx context.Context, req *ss_service.BanReq) (*ss_service.BanRes, error) {
+	b, err := h.ss.Ban(req.GetSubnet(), time.Duration(req.GetDurationSeconds())*time.Second, req.GetReason())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ss_service.BanRes{Ban: banToProto(b, h.ss.IPPrivacy())}, nil
+}
+
+func (h *Handler) Unban(ctx context.Context, req *ss_service.UnbanReq) (*ss_service.UnbanRes, error) {
+	removed, err := h.ss.Unban(req.GetSubnet())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ss_service.UnbanRes{Removed: removed > 0, Count: uint32(removed)}, nil
+}
+
+func (h *Handler) ListKeys(ctx context.Context, req *ss_service.ListKeysReq) (*ss_service.ListKeysRes, error) {
+	var resp ss_service.ListKeysRes
+	for _, k := range h.ss.KeyStatuses(int(req.GetPort())) {
+		key := &ss_service.Key{
+			UserId:           k.ID,
+			Port:             int32(k.Port),
+			Cipher:           k.Cipher,
+			CreatedAt:        k.CreatedAt.Unix(),
+			LastLocation:     k.LastLocation,
+			BytesTransferred: k.BytesTransferred,
+		}
+		if !k.LastSeen.IsZero() {
+			key.LastSeen = k.LastSeen.Unix()
+		}
+		resp.Keys = append(resp.Keys, key)
+	}
+	return &resp, nil
+}
+
+func (h *Handler) ListPorts(ctx context.Context, req *ss_service.ListPortsReq) (*ss_service.ListPortsRes, error) {
+	var resp ss_service.ListPortsRes
+	for _, p := range h.ss.PortStatuses() {
+		resp.Ports = append(resp.Ports, &ss_service.Port{
+			Port:             int32(p.Port),
+			NumKeys:          int32(p.NumKeys),
+			BytesTransferred: p.BytesTransferred,
+		})
+	}
+	return &resp, nil
+}
+
+func usageBatchToProto(b server.UsageBatch) *ss_service.UsageBatch {
+	batch := &ss_service.UsageBatch{
+		Epoch:     b.Epoch,
+		Seq:       b.Seq,
+		Timestamp: b.Time.Unix(),
+	}
+	for _, d := range b.Deltas {
+		batch.Deltas = append(batch.Deltas, &ss_service.UsageDelta{
+			UserId:           d.AccessKey,
+			Protocol:         d.Proto,
+			ClientProxyBytes: d.ClientProxy,
+			ProxyTargetBytes: d.ProxyTarget,
+			TargetProxyBytes: d.TargetProxy,
+			ProxyClientBytes: d.ProxyClient,
+			Connections:      d.Connections,
+			Packets:          d.Packets,
+		})
+	}
+	return batch
+}
+
+// StreamUsage sends the retained batches after the requested sequence number,
+// and then every new batch.  The stream ends with codes.Unavailable if the
+// consumer falls behind or the server stops; the consumer should then resume
+// from the last batch it received.
+func (h *Handler) StreamUsage(req *ss_service.StreamUsageReq, stream ss_service.SsService_StreamUsageServer) error {
+	backlog, batches, cancel := h.ss.SubscribeUsage(req.GetEpoch(), req.GetAfterSeq())
+	defer cancel()
+	for _, b := range backlog {
+		if err := stream.Send(usageBatchToProto(b)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case b, ok := <-batches:
+			if !ok {
+				return status.Error(codes.Unavailable, "usage stream interrupted, resume from the last batch")
+			}
+			if err := stream.Send(usageBatchToProto(b)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Returns an InvalidArgument error if `day` is not empty or in
+// server.UsageDayLayout.
+func validateDay(name, day string) error {
+	if day == "" {
+		return nil
+	}
+	if _, err := time.Parse(server.UsageDayLayout, day); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v must be a day like 2006-01-02: %v", name, err)
+	}
+	return nil
+}
+
+// GetUsage returns the daily usage records from the `from` day to the `to`
+// day, inclusive, of one key or of all keys if the user ID is empty.  Empty
+// bounds select all the days.
+func (h *Handler) GetUsage(ctx context.Context, req *ss_service.GetUsageReq) (*ss_service.GetUsageRes, error) {
+	if err := validateDay("from", req.GetFrom()); err != nil {
+		return nil, err
+	}
+	if err := validateDay("to", req.GetTo()); err != nil {
+		return nil, err
+	}
+	records, err := h.ss.Usage(req.GetFrom(), req.GetTo(), req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	resp := &ss_service.GetUsageRes{}
+	for _, r := range records {
+		resp.Records = append(resp.Records, &ss_service.UsageRecord{
+			Day:         r.Day,
+			UserId:      r.AccessKey,
+			Proto:       r.Proto,
+			UpBytes:     r.Up,
+			DownBytes:   r.Down,
+			Connections: r.Connections,
+			Packets:     r.Packets,
+		})
+	}
+	return resp, nil
+}
+
+// SetLogLevels replaces the log levels with the ones in the request, unless
+// it's empty, and returns the levels in effect.
+func (h *Handler) SetLogLevels(ctx context.Context, req *ss_service.SetLogLevelsReq) (*ss_service.SetLogLevelsRes, error) {
+	if h.logger == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the log levels can't be changed")
+	}
+	if req.GetLevels() != "" {
+		if err := h.logger.SetLevels(req.GetLevels()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.logger.Named("grpc").Info("Changed the log levels", log.String("levels", h.logger.Levels()))
+	}
+	return &ss_service.SetLogLevelsRes{Levels: h.logger.Levels()}, nil
+}
+
+func keyRefs(keys []server.CipherStruct) []*ss_service.KeyRef {
+	var refs []*ss_service.KeyRef
+	for _, k := range keys {
+		refs = append(refs, &ss_service.KeyRef{UserId: k.ID, Port: int32(k.Port)})
+	}
+	return refs
+}
+
+// SetKeys replaces the keys of the server with the desired set in the
+// request, and reports the changes.  The cipher defaults to
+// server.DefaultCipher, and a zero expiry time never expires.  Invalid keys
+// are reported in the errors, and don't prevent the others from being applied.
+func (h *Handler) SetKeys(ctx context.Context, req *ss_service.SetKeysReq) (*ss_service.SetKeysRes, error) {
+	specs := make([]server.KeySpec, 0, len(req.GetKeys()))
+	for _, k := range req.GetKeys() {
+		cipher := k.GetCipher()
+		if cipher == "" {
+			cipher = server.DefaultCipher
+		}
+		var expiresAt time.Time
+		if k.GetExpiresAt() > 0 {
+			expiresAt = time.Unix(k.GetExpiresAt(), 0)
+		}
+		specs = append(specs, server.KeySpec{
+			CipherStruct: server.CipherStruct{
+				ID:     k.GetUserId(),
+				Port:   int(k.GetPort()),
+				Cipher: cipher,
+				Secret: k.GetSecret(),
+			},
+			DataLimit: k.GetDataLimitBytes(),
+			ExpiresAt: expiresAt,
+		})
+	}
+	diff := h.ss.SetKeys(specs)
+	resp := &ss_service.SetKeysRes{
+		Added:   keyRefs(diff.Added),
+		Removed: keyRefs(diff.Removed),
+		Changed: keyRefs(diff.Changed),
+		Limited: keyRefs(diff.Limited),
+	}
+	for _, e := range diff.Errors {
+		resp.Errors = append(resp.Errors, &ss_service.KeyError{UserId: e.ID, Port: int32(e.Port), Message: e.Err.Error()})
+	}
+	return resp, nil
+}
@@ -0,0 +1,82 @@
+package rpc_handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/evgeniy-krivenko/vpn-api/gen/ss_service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListBans_IPPrivacy(t *testing.T) {
+	privacy, err := service.NewIPPrivacy(service.IPPrivacyTruncate, nil)
+	require.NoError(t, err)
+	s, err := server.NewSSServer(&server.SSConfig{
+		Metrics:   &metrics.NoOpMetrics{},
+		Ports:     make(map[int]*server.SsPort),
+		Logger:    log.Default(),
+		IPPrivacy: privacy,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	h := NewGrpcHandler(s)
+
+	ban, err := h.Ban(context.Background(), &ss_service.BanReq{Subnet: "203.0.113.7", DurationSeconds: 60})
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.0/24", ban.Ban.Subnet)
+
+	bans, err := h.ListBans(context.Background(), &ss_service.ListBansReq{})
+	require.NoError(t, err)
+	require.Len(t, bans.Bans, 1)
+	require.Equal(t, "203.0.113.0/24", bans.Bans[0].Subnet)
+	require.False(t, strings.Contains(bans.Bans[0].Subnet, "203.0.113.7"), "The raw IP should not be shown")
+
+	// The ban can be lifted with the subnet as it's shown.
+	unban, err := h.Unban(context.Background(), &ss_service.UnbanReq{Subnet: bans.Bans[0].Subnet})
+	require.NoError(t, err)
+	require.True(t, unban.Removed)
+	require.Equal(t, uint32(1), unban.Count)
+	require.Empty(t, s.Bans())
+}
+
+func TestUnban_CollidingBans(t *testing.T) {
+	privacy, err := service.NewIPPrivacy(service.IPPrivacyTruncate, nil)
+	require.NoError(t, err)
+	s, err := server.NewSSServer(&server.SSConfig{
+		Metrics:   &metrics.NoOpMetrics{},
+		Ports:     make(map[int]*server.SsPort),
+		Logger:    log.Default(),
+		IPPrivacy: privacy,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	h := NewGrpcHandler(s)
+
+	for _, subnet := range []string{"203.0.113.7", "203.0.113.8", "198.51.100.7"} {
+		_, err := h.Ban(context.Background(), &ss_service.BanReq{Subnet: subnet, DurationSeconds: 60})
+		require.NoError(t, err)
+	}
+
+	// Both bans are shown as the same subnet, so both are lifted and counted.
+	unban, err := h.Unban(context.Background(), &ss_service.UnbanReq{Subnet: "203.0.113.0/24"})
+	require.NoError(t, err)
+	require.True(t, unban.Removed)
+	require.Equal(t, uint32(2), unban.Count)
+	require.Len(t, s.Bans(), 1)
+
+	// A stored subnet lifts only its own ban.
+	unban, err = h.Unban(context.Background(), &ss_service.UnbanReq{Subnet: "198.51.100.7"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), unban.Count)
+	require.Empty(t, s.Bans())
+
+	unban, err = h.Unban(context.Background(), &ss_service.UnbanReq{Subnet: "198.51.100.7"})
+	require.NoError(t, err)
+	require.False(t, unban.Removed)
+	require.Equal(t, uint32(0), unban.Count)
+}
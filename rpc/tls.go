@@ -0,0 +1,144 @@
+package rpc_handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+)
+
+// How often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// TLSConfig configures TLS for the gRPC server.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS: clients must present a certificate
+	// signed by one of these CAs.  Empty disables client certificates.
+	ClientCAFile string
+	// Logger receives the certificate reloads.
+	Logger *log.Logger
+}
+
+// certReloader serves the certificate and client CAs from files, and reloads
+// them when the files change, so that certificates can be rotated without a
+// restart.  A failed reload keeps the previous certificate.
+type certReloader struct {
+	config    TLSConfig
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+	checked   time.Time
+	now       func() time.Time
+}
+
+// NewServerTLSConfig returns a tls.Config for the gRPC server that reloads the
+// files in `config` when they change.
+func NewServerTLSConfig(config TLSConfig) (*tls.Config, error) {
+	r := &certReloader{config: config, now: time.Now}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	// The config returned by GetConfigForClient replaces this one, so it's
+	// cloned from it to keep the HTTP/2 ALPN that gRPC clients require.
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2"},
+	}
+	serverConfig := base.Clone()
+	serverConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return r.configForClient(base), nil
+	}
+	return serverConfig, nil
+}
+
+// The latest modification time of the files.
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return fmt.Errorf("failed to read gRPC TLS files: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load gRPC certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read gRPC client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %v", r.config.ClientCAFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTime = modTime
+	return nil
+}
+
+// Reloads the files if they changed since the last load, at most once per
+// certCheckInterval.
+func (r *certReloader) maybeReload() {
+	r.mu.Lock()
+	now := r.now()
+	if now.Sub(r.checked) < certCheckInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.checked = now
+	loaded := r.modTime
+	r.mu.Unlock()
+
+	modTime, err := r.filesModTime()
+	if err != nil || !modTime.After(loaded) {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.config.Logger.Error("Keeping the previous gRPC certificate", log.Err(err))
+		return
+	}
+	r.config.Logger.Info("Reloaded the gRPC certificate")
+}
+
+// Returns a copy of `base` with the current certificate and client CAs.
+func (r *certReloader) configForClient(base *tls.Config) *tls.Config {
+	r.maybeReload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config := base.Clone()
+	config.Certificates = []tls.Certificate{*r.cert}
+	if r.clientCAs != nil {
+		config.ClientCAs = r.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
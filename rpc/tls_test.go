@@ -0,0 +1,119 @@
+package rpc_handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a self-signed certificate for `name` and its key to `dir`.
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func leafName(t *testing.T, config *tls.Config) string {
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	return cert.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+	caFile, _ := writeTestCert(t, dir, "ca")
+	now := time.Now()
+	r := &certReloader{
+		config: TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+		now:    func() time.Time { return now },
+	}
+	require.NoError(t, r.load())
+	config := r.configForClient(&tls.Config{})
+	require.Equal(t, "first", leafName(t, config))
+	require.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	// Replace the certificate with a newer one.
+	second, secondKey := writeTestCert(t, dir, "second")
+	require.NoError(t, os.Rename(second, certFile))
+	require.NoError(t, os.Rename(secondKey, keyFile))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	// Not checked again until certCheckInterval passes.
+	require.Equal(t, "first", leafName(t, r.configForClient(&tls.Config{})))
+	now = now.Add(certCheckInterval)
+	require.Equal(t, "second", leafName(t, r.configForClient(&tls.Config{})))
+
+	// A broken file keeps the previous certificate.
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	now = now.Add(certCheckInterval)
+	require.Equal(t, "second", leafName(t, r.configForClient(&tls.Config{})))
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server")
+	config, err := NewServerTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	clientConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, clientConfig.ClientAuth)
+
+	_, err = NewServerTLSConfig(TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")})
+	require.Error(t, err)
+}
+
+func TestNewServerTLSConfig_ALPN(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server")
+	// The client certificate is self-signed, so it's its own CA.
+	clientCertFile, clientKeyFile := writeTestCert(t, dir, "client")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+	config, err := NewServerTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCertFile})
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	server := tls.Server(serverConn, config)
+	go server.Handshake()
+	client := tls.Client(clientConn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+		Certificates:       []tls.Certificate{clientCert},
+	})
+	require.NoError(t, client.Handshake())
+	require.Equal(t, "h2", client.ConnectionState().NegotiatedProtocol)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+)
+
+// The types of the lifecycle events.
+const (
+	// EventKeyFirstUsed is emitted the first time a client authenticates with
+	// an access key.  With a usage store, keys that have usage from a previous
+	// run don't count as new.
+	EventKeyFirstUsed = "key.first_used"
+	// EventQuotaThreshold is emitted when the usage of a key crosses one of
+	// the QuotaThresholds of its data limit.
+	EventQuotaThreshold = "key.quota_threshold"
+	// EventKeyExpired is emitted when a key is stopped at its expiry time.
+	EventKeyExpired = "key.expired"
+	// EventClientBanned is emitted for every new ban of a client IP or subnet.
+	EventClientBanned = "client.banned"
+	// EventConfigReloadFailed is emitted when LoadConfig or ApplyConfig fails.
+	EventConfigReloadFailed = "config.reload_failed"
+	// EventUsageBatch is emitted for every usage batch with deltas, if
+	// enabled with SSConfig.UsageEvents.
+	EventUsageBatch = "usage.batch"
+)
+
+// Event is a lifecycle event of the server.  The fields that don't apply to
+// the type are empty.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	AccessKey string    `json:"access_key,omitempty"`
+	Port      int       `json:"port,omitempty"`
+	// ThresholdPercent, DataLimit and BytesTransferred describe a quota
+	// threshold.
+	ThresholdPercent int   `json:"threshold_percent,omitempty"`
+	DataLimit        int64 `json:"data_limit,omitempty"`
+	BytesTransferred int64 `json:"bytes_transferred,omitempty"`
+	// ExpiresAt is the expiry time of an expired key.
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	Ban       *BanEvent   `json:"ban,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Usage     *UsageBatch `json:"usage,omitempty"`
+}
+
+// BanEvent describes a new ban.
+type BanEvent struct {
+	Subnet string `json:"subnet"`
+	// Scope is "ip", "subnet" or "manual".
+	Scope    string    `json:"scope"`
+	Reason   string    `json:"reason"`
+	Until    time.Time `json:"until"`
+	Offenses int       `json:"offenses"`
+}
+
+// EventSink receives the lifecycle events of the server.  Emit is called from
+// the relay paths and with locks held, so it must not block.
+type EventSink interface {
+	Emit(Event)
+}
+
+func (s *SSServer) emit(e Event) {
+	if s.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	s.events.Emit(e)
+}
+
+// Returns the event of a ban, with the subnet shown as `privacy` allows.
+func banEvent(b service.BanEntry, privacy *service.IPPrivacy) *BanEvent {
+	return &BanEvent{
+		Subnet:   privacy.Subnet(b.Subnet),
+		Scope:    b.Scope,
+		Reason:   b.Reason,
+		Until:    b.Until.UTC(),
+		Offenses: b.Offenses,
+	}
+}
+
+// Called by the services for every authenticated connection and NAT entry.
+func (s *SSServer) keyAuthenticated(port int, keyID string) {
+	if _, used := s.usedKeys.LoadOrStore(keyID, true); !used {
+		s.emit(Event{Type: EventKeyFirstUsed, AccessKey: keyID, Port: port})
+	}
+}
+
+// Emits EventQuotaThreshold for the keys whose usage crossed a threshold
+// since the last call.  s.mu must be held.
+func (s *SSServer) checkQuotas(usage map[string]int64) {
+	ids := make(map[string]bool)
+	for k := range s.allKeysLocked() {
+		ids[k.id] = true
+	}
+	for id := range ids {
+		limit, ok := s.dataLimitLocked(id)
+		if !ok || limit <= 0 {
+			continue
+		}
+		percent := usage[id] * 100 / limit
+		crossed := 0
+		for _, t := range s.quotaThresholds {
+			if int64(t) <= percent && t > crossed {
+				crossed = t
+			}
+		}
+		if crossed > s.quotaNotified[id] {
+			s.quotaNotified[id] = crossed
+			s.emit(Event{
+				Type:             EventQuotaThreshold,
+				AccessKey:        id,
+				ThresholdPercent: crossed,
+				DataLimit:        limit,
+				BytesTransferred: usage[id],
+			})
+		}
+	}
+}
+
+// Emits EventUsageBatch for every usage batch with deltas, until the server
+// stops.
+func (s *SSServer) runUsageEvents() {
+	var epoch string
+	var seq uint64
+	for {
+		backlog, batches, cancel := s.usageFeed.subscribe(epoch, seq)
+		emitBatch := func(b UsageBatch) {
+			epoch, seq = b.Epoch, b.Seq
+			if len(b.Deltas) > 0 {
+				s.emit(Event{Type: EventUsageBatch, Usage: &b})
+			}
+		}
+		for _, b := range backlog {
+			emitBatch(b)
+		}
+	receive:
+		for {
+			select {
+			case b, ok := <-batches:
+				if !ok {
+					// Fell behind.  Resume from the history.
+					break receive
+				}
+				emitBatch(b)
+			case <-s.done:
+				cancel()
+				return
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+)
+
+// DefaultCipher is the cipher of new keys that don't specify one.
+const DefaultCipher = "chacha20-ietf-poly1305"
+
+// Keys returns the access keys served on all ports, sorted by port and ID.
+func (s *SSServer) Keys() []CipherStruct {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []CipherStruct
+	for portNum, port := range s.ports {
+		for _, entry := range port.cipherList.Entries() {
+			keys = append(keys, CipherStruct{
+				ID:     entry.ID,
+				Port:   portNum,
+				Cipher: entry.Cipher,
+				Secret: entry.Secret,
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Port != keys[j].Port {
+			return keys[i].Port < keys[j].Port
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// LimitedKeys returns the access keys that are not served because they reached
+// their data limit or expired, sorted by port and ID.
+func (s *SSServer) LimitedKeys() []CipherStruct {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []CipherStruct
+	for _, key := range sortedKeys(s.allKeysLocked()) {
+		if s.limited[portKey{key.Port, key.ID}] {
+			keys = append(keys, key.CipherStruct)
+		}
+	}
+	return keys
+}
+
+// AddManagedKey serves an access key of the management API.  Unlike the keys
+// of AddCipher, managed keys are kept across config reloads and SetKeys, but
+// a key of the config with the same port and ID takes precedence.  If the port
+// can't be opened, the next free port is used.  Returns the port.
+func (s *SSServer) AddManagedKey(cs CipherStruct) (int, error) {
+	cipher, err := ss.NewCipher(cs.Cipher, cs.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cipher for key %v: %v", cs.ID, err)
+	}
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs.Port = s.openPortLocked(cs.Port)
+	s.managedKeys[cs.ID] = serverKey{CipherStruct: cs, cipher: cipher}
+	if err := s.serveKeysLocked(usage); err != nil {
+		return 0, err
+	}
+	s.logger.Info("Added managed access key", log.String("access_key", cs.ID), log.Int("port", cs.Port))
+	return cs.Port, nil
+}
+
+// RemoveManagedKey stops serving a key added by AddManagedKey.
+func (s *SSServer) RemoveManagedKey(id string) error {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.managedKeys[id]; !ok {
+		return fmt.Errorf("managed access key %v does not exist", id)
+	}
+	delete(s.managedKeys, id)
+	return s.serveKeysLocked(usage)
+}
+
+// Returns the keys and the managed keys.  s.mu must be held.
+func (s *SSServer) allKeysLocked() map[portKey]serverKey {
+	if len(s.managedKeys) == 0 {
+		return s.keys
+	}
+	all := make(map[portKey]serverKey, len(s.keys)+len(s.managedKeys))
+	for k, key := range s.keys {
+		all[k] = key
+	}
+	for _, key := range s.managedKeys {
+		k := portKey{key.Port, key.ID}
+		if _, ok := all[k]; !ok {
+			all[k] = key
+		}
+	}
+	return all
+}
+
+// DataLimits are the data limits of the management API.  They apply to all
+// the access keys, in addition to the limits of SetKeys, and a key over either
+// limit is not served.
+type DataLimits struct {
+	// Default is the limit of the keys without their own.  Nil is unlimited.
+	Default *int64
+	// Keys are the limits of individual keys by ID, which override Default.
+	Keys map[string]int64
+}
+
+func (l DataLimits) limit(id string) (int64, bool) {
+	if limit, ok := l.Keys[id]; ok {
+		return limit, true
+	}
+	if l.Default != nil {
+		return *l.Default, true
+	}
+	return 0, false
+}
+
+// SetDataLimits replaces the data limits of the management API.  They are
+// applied immediately, and keys under their new limit are served again.
+func (s *SSServer) SetDataLimits(limits DataLimits) {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.dataLimits
+	s.dataLimits = limits
+	// Quota thresholds are notified again when the data limit changes.
+	for id := range s.quotaNotified {
+		oldLimit, _ := old.limit(id)
+		newLimit, _ := limits.limit(id)
+		if oldLimit != newLimit {
+			delete(s.quotaNotified, id)
+		}
+	}
+	if err := s.serveKeysLocked(usage); err != nil {
+		s.logger.Error("Failed to apply the data limits", log.Err(err))
+	}
+}
+
+// Returns the data limit of the key `id`, which is the lower of the limits of
+// SetKeys and of the management API, and whether it has one.  s.mu must be
+// held.
+func (s *SSServer) dataLimitLocked(id string) (int64, bool) {
+	limit, ok := s.dataLimits.limit(id)
+	if l := s.limits[id].data; l > 0 && (!ok || l < limit) {
+		limit, ok = l, true
+	}
+	return limit, ok
+}
+
+// KeyStatus describes an access key and its activity.
+type KeyStatus struct {
+	CipherStruct
+	CreatedAt time.Time
+	// LastSeen is zero if the key hasn't been used since the server started.
+	LastSeen time.Time
+	// LastLocation is the location of the last client IP, if known.
+	LastLocation string
+	// BytesTransferred is counted per key ID, as in TransferredBytes.
+	BytesTransferred int64
+}
+
+// KeyStatuses returns the status of the access keys on `port`, or on all ports
+// if `port` is zero, sorted by port and ID.
+func (s *SSServer) KeyStatuses(port int) []KeyStatus {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	var statuses []KeyStatus
+	var lastIPs []net.IP
+	for portNum, p := range s.ports {
+		if port != 0 && portNum != port {
+			continue
+		}
+		for _, entry := range p.cipherList.Entries() {
+			statuses = append(statuses, KeyStatus{
+				CipherStruct: CipherStruct{
+					ID:     entry.ID,
+					Port:   portNum,
+					Cipher: entry.Cipher,
+					Secret: entry.Secret,
+				},
+				CreatedAt:        entry.CreatedAt,
+				LastSeen:         entry.LastSeen,
+				BytesTransferred: usage[entry.ID],
+			})
+			lastIPs = append(lastIPs, entry.LastClientIP)
+		}
+	}
+	s.mu.Unlock()
+	// Look up the locations without holding the lock.
+	for i, ip := range lastIPs {
+		if ip == nil {
+			continue
+		}
+		clientInfo, err := s.m.GetClientInfo(&net.UDPAddr{IP: ip})
+		if err != nil {
+			s.logger.Debug("Failed location lookup", log.Err(err))
+		}
+		statuses[i].LastLocation = clientInfo.CountryCode
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Port != statuses[j].Port {
+			return statuses[i].Port < statuses[j].Port
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// PortStatus describes a port that the server listens on.
+type PortStatus struct {
+	Port             int
+	NumKeys          int
+	BytesTransferred int64
+}
+
+// PortStatuses returns the status of the ports, sorted by port.
+func (s *SSServer) PortStatuses() []PortStatus {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]PortStatus, 0, len(s.ports))
+	for portNum, p := range s.ports {
+		status := PortStatus{Port: portNum}
+		for _, entry := range p.cipherList.Entries() {
+			status.NumKeys++
+			status.BytesTransferred += usage[entry.ID]
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Port < statuses[j].Port
+	})
+	return statuses
+}
+
+// Load is the load of the server, for capacity planning.
+type Load struct {
+	Ports int
+	Keys  int
+	// Open TCP connections and UDP NAT entries.
+	TCPConnections int
+	UDPSessions    int
+	// BytesTransferred is the total of TransferredBytes.
+	BytesTransferred int64
+}
+
+// Load returns the current load of the server.
+func (s *SSServer) Load() Load {
+	load := Load{
+		TCPConnections: int(atomic.LoadInt64(&s.usage.tcpConns)),
+		UDPSessions:    int(atomic.LoadInt64(&s.usage.udpSessions)),
+	}
+	for _, bytes := range s.usage.transferred() {
+		load.BytesTransferred += bytes
+	}
+	for _, p := range s.PortStatuses() {
+		load.Ports++
+		load.Keys += p.NumKeys
+	}
+	return load
+}
+
+// SubscribeUsage returns the retained usage batches after `afterSeq` of
+// `epoch`, and a channel of the following batches.  If `epoch` is not the
+// current epoch, e.g. after a restart, all the retained batches are returned.
+// The channel is closed if the consumer falls behind or the server stops, and
+// the consumer can then resume from the last batch it received.  `cancel` must
+// be called when done.
+func (s *SSServer) SubscribeUsage(epoch string, afterSeq uint64) (backlog []UsageBatch, batches <-chan UsageBatch, cancel func()) {
+	return s.usageFeed.subscribe(epoch, afterSeq)
+}
+
+// TransferredBytes returns the bytes transferred by each access key since the
+// server started.  If the usage store is enabled, the bytes of previous runs
+// are included.
+func (s *SSServer) TransferredBytes() map[string]int64 {
+	return s.usage.transferred()
+}
+
+// Usage returns the daily usage records from `from` to `to`, inclusive, in
+// UsageDayLayout, of `accessKey` or of all keys if it's empty.
+func (s *SSServer) Usage(from, to, accessKey string) ([]UsageRecord, error) {
+	if s.usageStore == nil {
+		return nil, errors.New("the usage store is disabled")
+	}
+	return s.usageStore.query(from, to, accessKey), nil
+}
+
+// ValidateCipher returns an error if `name` is not one of ss.SupportedCipherNames().
+func ValidateCipher(name string) error {
+	for _, supported := range ss.SupportedCipherNames() {
+		if name == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported cipher %q, must be one of %v", name, ss.SupportedCipherNames())
+}
+
+// GenerateSecret returns a random secret with 128 bits of entropy.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// AccessURL returns the SIP002 URL of a key, e.g. ss://Y2hhY2hh...@example.com:443
+func AccessURL(host string, port int, cipher, secret string) string {
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(cipher + ":" + secret))
+	return "ss://" + userInfo + "@" + net.JoinHostPort(host, strconv.Itoa(port))
+}
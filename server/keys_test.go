@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCipher(t *testing.T) {
+	require.NoError(t, ValidateCipher(DefaultCipher))
+	require.NoError(t, ValidateCipher("aes-128-gcm"))
+	require.Error(t, ValidateCipher("rc4-md5"))
+	require.Error(t, ValidateCipher(""))
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	require.NoError(t, err)
+	b, err := GenerateSecret()
+	require.NoError(t, err)
+	require.Len(t, a, 22)
+	require.NotEqual(t, a, b)
+}
+
+func TestAccessURL(t *testing.T) {
+	url := AccessURL("2001:db8::1", 443, "aes-256-gcm", "s3cr3t")
+	require.True(t, strings.HasSuffix(url, "@[2001:db8::1]:443"), url)
+	userInfo := strings.TrimSuffix(strings.TrimPrefix(url, "ss://"), "@[2001:db8::1]:443")
+	decoded, err := base64.RawURLEncoding.DecodeString(userInfo)
+	require.NoError(t, err)
+	require.Equal(t, "aes-256-gcm:s3cr3t", string(decoded))
+}
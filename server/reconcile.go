@@ -0,0 +1,327 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+)
+
+// KeySpec is an access key in the desired set of SetKeys.
+type KeySpec struct {
+	CipherStruct
+	// DataLimit is the number of bytes the key may transfer, as counted by
+	// TransferredBytes.  Zero is unlimited.  Limits apply per key ID, like
+	// the usage.
+	DataLimit int64
+	// ExpiresAt is when the key stops being served.  Zero never expires.
+	ExpiresAt time.Time
+}
+
+// The limits of a key that SetKeys enforces.
+type keyLimit struct {
+	data      int64
+	expiresAt time.Time
+}
+
+func (l keyLimit) isZero() bool {
+	return l.data == 0 && l.expiresAt.IsZero()
+}
+
+func (l keyLimit) equal(other keyLimit) bool {
+	return l.data == other.data && l.expiresAt.Equal(other.expiresAt)
+}
+
+func (l keyLimit) expired(now time.Time) bool {
+	return !l.expiresAt.IsZero() && !now.Before(l.expiresAt)
+}
+
+// KeyError is a key that SetKeys rejected.
+type KeyError struct {
+	ID   string
+	Port int
+	Err  error
+}
+
+// KeyDiff is the outcome of SetKeys.
+type KeyDiff struct {
+	Added   []CipherStruct
+	Removed []CipherStruct
+	// Changed keys have a new cipher, secret or data limit.
+	Changed []CipherStruct
+	// Limited keys are in the desired set, but are not served because they
+	// reached their data limit or expired.
+	Limited []CipherStruct
+	// Errors are the rejected keys.  Their current version, if any, is kept.
+	Errors []KeyError
+}
+
+// Keys are identified by port and ID.
+type portKey struct {
+	port int
+	id   string
+}
+
+// An access key that is served unless it is limited.
+type serverKey struct {
+	CipherStruct
+	cipher *ss.Cipher
+}
+
+func validateKeySpec(spec KeySpec) error {
+	if spec.ID == "" {
+		return errors.New("missing key ID")
+	}
+	if spec.Port <= 0 || spec.Port > 65535 {
+		return fmt.Errorf("invalid port %v", spec.Port)
+	}
+	if err := ValidateCipher(spec.Cipher); err != nil {
+		return err
+	}
+	if spec.Secret == "" {
+		return errors.New("missing secret")
+	}
+	if spec.DataLimit < 0 {
+		return fmt.Errorf("invalid data limit %v", spec.DataLimit)
+	}
+	return nil
+}
+
+func sortCipherStructs(keys []CipherStruct) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Port != keys[j].Port {
+			return keys[i].Port < keys[j].Port
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+// SetKeys replaces the access keys of all ports with `keys`, like a config
+// reload: ports are started and stopped as needed, and the sessions of removed
+// keys are closed.  Invalid keys, and keys whose port can't be started, are
+// rejected without affecting the others.  All the keys are checked before any
+// is applied.  Keys that reached their data limit are not served until the
+// limit is raised, and expired keys until their expiry time is extended.
+func (s *SSServer) SetKeys(keys []KeySpec) KeyDiff {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var diff KeyDiff
+	seen := make(map[portKey]bool)
+	desired := make(map[portKey]serverKey)
+	limits := make(map[string]keyLimit)
+	// A rejected key keeps its current version, if any.
+	reject := func(k portKey, err error) {
+		diff.Errors = append(diff.Errors, KeyError{k.id, k.port, err})
+		if cur, ok := s.keys[k]; ok {
+			desired[k] = cur
+			if limit, ok := s.limits[k.id]; ok {
+				limits[k.id] = limit
+			}
+		}
+	}
+	for _, spec := range keys {
+		k := portKey{spec.Port, spec.ID}
+		if seen[k] {
+			diff.Errors = append(diff.Errors, KeyError{spec.ID, spec.Port, errors.New("duplicate key")})
+			continue
+		}
+		seen[k] = true
+		err := validateKeySpec(spec)
+		var cipher *ss.Cipher
+		if err == nil {
+			cipher, err = ss.NewCipher(spec.Cipher, spec.Secret)
+		}
+		if err != nil {
+			reject(k, err)
+			continue
+		}
+		desired[k] = serverKey{CipherStruct: spec.CipherStruct, cipher: cipher}
+		if limit := (keyLimit{data: spec.DataLimit, expiresAt: spec.ExpiresAt}); !limit.isZero() {
+			limits[spec.ID] = limit
+		}
+	}
+	// Start the new ports before anything is applied, so that the keys of a
+	// port that can't be started can be rejected.
+	startErrs := make(map[int]error)
+	for _, key := range sortedKeys(desired) {
+		if _, ok := s.ports[key.Port]; ok {
+			continue
+		}
+		err, tried := startErrs[key.Port]
+		if !tried {
+			err = s.startPort(key.Port)
+			startErrs[key.Port] = err
+		}
+		if err != nil {
+			k := portKey{key.Port, key.ID}
+			delete(desired, k)
+			reject(k, err)
+		}
+	}
+
+	before := s.servedLocked()
+	oldLimits := s.limits
+	// Quota thresholds are notified again when the data limit changes.
+	for id := range s.quotaNotified {
+		if limits[id].data != oldLimits[id].data {
+			delete(s.quotaNotified, id)
+		}
+	}
+	s.keys = desired
+	s.limits = limits
+	// All the ports were started above, so this can only fail to stop a port.
+	if err := s.serveKeysLocked(usage); err != nil {
+		s.logger.Error("Failed to apply keys", log.Err(err))
+	}
+	after := s.servedLocked()
+	// The keys are reported in the order of `keys`.
+	reported := make(map[portKey]bool)
+	for _, spec := range keys {
+		k := portKey{spec.Port, spec.ID}
+		key, ok := desired[k]
+		if !ok || reported[k] {
+			continue
+		}
+		reported[k] = true
+		cur, wasServed := before[k]
+		switch {
+		case s.limited[k]:
+			diff.Limited = append(diff.Limited, key.CipherStruct)
+		case !wasServed:
+			diff.Added = append(diff.Added, key.CipherStruct)
+		case cur.Cipher != key.Cipher || cur.Secret != key.Secret || !oldLimits[k.id].equal(limits[k.id]):
+			diff.Changed = append(diff.Changed, key.CipherStruct)
+		}
+	}
+	for k, cur := range before {
+		if _, ok := after[k]; !ok && !s.limited[k] {
+			diff.Removed = append(diff.Removed, cur)
+		}
+	}
+	sortCipherStructs(diff.Removed)
+	s.logger.Info("Set access keys", log.Int("keys", len(after)), log.Int("added", len(diff.Added)),
+		log.Int("removed", len(diff.Removed)), log.Int("changed", len(diff.Changed)),
+		log.Int("limited", len(diff.Limited)), log.Int("rejected", len(diff.Errors)))
+	return diff
+}
+
+// Returns the served keys.  s.mu must be held.
+func (s *SSServer) servedLocked() map[portKey]CipherStruct {
+	served := make(map[portKey]CipherStruct)
+	for portNum, port := range s.ports {
+		for _, entry := range port.cipherList.Entries() {
+			served[portKey{portNum, entry.ID}] = CipherStruct{ID: entry.ID, Port: portNum, Cipher: entry.Cipher, Secret: entry.Secret}
+		}
+	}
+	return served
+}
+
+// Returns the keys that reached their data limit or expired, with the reason.
+// s.mu must be held.
+func (s *SSServer) limitedLocked(usage map[string]int64, now time.Time) map[portKey]string {
+	limited := make(map[portKey]string)
+	for k := range s.allKeysLocked() {
+		if s.limits[k.id].expired(now) {
+			limited[k] = "Access key expired"
+		} else if limit, ok := s.dataLimitLocked(k.id); ok && usage[k.id] >= limit {
+			limited[k] = "Access key reached its data limit"
+		}
+	}
+	return limited
+}
+
+func sortedKeys(keys map[portKey]serverKey) []serverKey {
+	sorted := make([]serverKey, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Port != sorted[j].Port {
+			return sorted[i].Port < sorted[j].Port
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// Serves the keys that are within their limits, and stops serving the others.
+// Ports are started and stopped as needed, and the sessions of the keys that
+// are no longer served are closed.  s.mu must be held.
+func (s *SSServer) serveKeysLocked(usage map[string]int64) error {
+	now := time.Now()
+	limited := s.limitedLocked(usage, now)
+	before := s.servedLocked()
+	portCiphers := make(map[int]*list.List) // Values are *List of *CipherEntry.
+	numKeys := 0
+	for _, key := range sortedKeys(s.allKeysLocked()) {
+		k := portKey{key.Port, key.ID}
+		if reason, ok := limited[k]; ok {
+			if _, wasServed := before[k]; wasServed {
+				s.logger.Info(reason, log.String("access_key", key.ID), log.Int("port", key.Port))
+				if limit := s.limits[key.ID]; limit.expired(now) {
+					expiresAt := limit.expiresAt.UTC()
+					s.emit(Event{Type: EventKeyExpired, AccessKey: key.ID, Port: key.Port, ExpiresAt: &expiresAt})
+				}
+			}
+			continue
+		}
+		if s.limited[k] {
+			s.logger.Info("Access key is within its limits again", log.String("access_key", key.ID), log.Int("port", key.Port))
+		}
+		cipherList, ok := portCiphers[key.Port]
+		if !ok {
+			cipherList = list.New()
+			portCiphers[key.Port] = cipherList
+		}
+		entry := service.MakeCipherEntry(key.ID, key.cipher, key.Secret)
+		cipherList.PushBack(&entry)
+		numKeys++
+	}
+	s.limited = make(map[portKey]bool, len(limited))
+	for k := range limited {
+		s.limited[k] = true
+	}
+	err := s.applyPortCiphers(portCiphers)
+	s.m.SetNumAccessKeys(numKeys, len(portCiphers))
+	return err
+}
+
+// Stops serving the keys that reached their data limit or expired, and serves
+// the ones whose limit was raised or removed again.
+func (s *SSServer) enforceLimits() {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkQuotas(usage)
+	limited := s.limitedLocked(usage, time.Now())
+	changed := len(limited) != len(s.limited)
+	for k := range limited {
+		changed = changed || !s.limited[k]
+	}
+	if !changed {
+		return
+	}
+	if err := s.serveKeysLocked(usage); err != nil {
+		s.logger.Error("Failed to apply the limits", log.Err(err))
+	}
+}
+
+func (s *SSServer) runLimits(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.enforceLimits()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+)
+
+// replaySnapshotter periodically saves the replay cache to disk, so that
+// replay protection survives restarts.
+type replaySnapshotter struct {
+	cache    *service.ReplayCache
+	path     string
+	interval time.Duration
+	logger   *log.Logger
+	done     chan struct{}
+}
+
+func newReplaySnapshotter(cache *service.ReplayCache, path string, interval time.Duration, logger *log.Logger) *replaySnapshotter {
+	return &replaySnapshotter{
+		cache:    cache,
+		path:     path,
+		interval: interval,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+}
+
+// load restores the cache from the snapshot file, if there is one.
+func (r *replaySnapshotter) load() {
+	err := r.cache.LoadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		r.logger.Info("No replay cache snapshot", log.String("path", r.path))
+	} else if err != nil {
+		// A bad snapshot must not prevent the server from starting.
+		r.logger.Warning("Failed to load replay cache snapshot", log.String("path", r.path), log.Err(err))
+	} else {
+		r.logger.Info("Loaded replay cache snapshot", log.String("path", r.path))
+	}
+}
+
+func (r *replaySnapshotter) save() error {
+	if err := r.cache.SaveFile(r.path); err != nil {
+		r.logger.Error("Failed to save replay cache snapshot", log.String("path", r.path), log.Err(err))
+		return err
+	}
+	return nil
+}
+
+func (r *replaySnapshotter) run() {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.save()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// stop stops the periodic snapshots and saves a final one.
+func (r *replaySnapshotter) stop() error {
+	close(r.done)
+	return r.save()
+}
@@ -2,22 +2,25 @@ package server
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
 	"github.com/evgeniy-krivenko/outline-ss-server/service"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
-	"github.com/op/go-logging"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
-//var logger *logging.Logger
-
 // 59 seconds is most common timeout for servers that do not respond to invalid requests
 const tcpReadTimeout = 59 * time.Second
 
+const defaultUsageInterval = 10 * time.Second
+
 type SsPort struct {
 	tcpService service.TCPService
 	udpService service.UDPService
@@ -25,29 +28,181 @@ type SsPort struct {
 }
 
 type SSServer struct {
-	natTimeout  time.Duration
-	m           metrics.ShadowsocksMetrics
-	replayCache service.ReplayCache
-	ports       map[int]*SsPort
-	logger      *logging.Logger
+	natTimeout time.Duration
+	m          metrics.ShadowsocksMetrics
+	usage      *usageMetrics
+	usageFeed  *usageFeed
+	usageStore *usageStore
+	// How often open TCP connections report their data.
+	usageInterval  time.Duration
+	replayCache    *service.ReplayCache
+	replaySnapshot *replaySnapshotter
+	udpReplay      int
+	revokeGrace    time.Duration
+	bans           service.BanList
+	accessLog      service.AccessLog
+	privacy        *service.IPPrivacy
+	tracer         service.Tracer
+	mu             sync.Mutex // Protects ports, keys, managedKeys, limits, dataLimits, limited, quotaNotified and loadErr.
+	ports          map[int]*SsPort
+	// The error of the last LoadConfig.
+	loadErr error
+	// The access keys to serve unless they are limited.  Config reloads and
+	// SetKeys replace them, and AddCipher and RemoveCipher edit them.
+	keys map[portKey]serverKey
+	// The keys of the management API by ID, which are served in addition to
+	// `keys` and kept across config reloads and SetKeys.
+	managedKeys map[string]serverKey
+	// Data limits and expiry times of the access keys, set by SetKeys.
+	limits map[string]keyLimit
+	// Data limits of the management API.
+	dataLimits DataLimits
+	// The keys that are not served because they reached their limit.
+	limited  map[portKey]bool
+	done     chan struct{}
+	stopOnce sync.Once
+	stopErr  error
+	logger   *log.Logger
+	// May be nil.
+	events          EventSink
+	quotaThresholds []int
+	// The highest quota threshold notified for each key.
+	quotaNotified map[string]int
+	// The keys that have been used, for EventKeyFirstUsed.
+	usedKeys sync.Map
 }
 
-func NewSSServer(cnf *SSConfig) *SSServer {
-	return &SSServer{
-		cnf.NatTimeout,
-		cnf.Metrics,
-		service.NewReplayCache(cnf.ReplayHistory),
-		cnf.Ports,
-		cnf.Logger,
+func NewSSServer(cnf *SSConfig) (*SSServer, error) {
+	replayCache, err := service.NewReplayCacheFromConfig(service.ReplayConfig{
+		Capacity:          cnf.ReplayHistory,
+		Filter:            cnf.ReplayFilter,
+		FalsePositiveRate: cnf.ReplayFalsePositiveRate,
+		MaxMemory:         cnf.ReplayMaxMemory,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replay cache: %w", err)
+	}
+	if cnf.UDPReplayHistory > service.MaxCapacity {
+		return nil, fmt.Errorf("UDP replay history %v exceeds the maximum of %v", cnf.UDPReplayHistory, service.MaxCapacity)
 	}
+	usageInterval := cnf.UsageInterval
+	if usageInterval <= 0 {
+		usageInterval = defaultUsageInterval
+	}
+	feed := newUsageFeed(usageInterval)
+	usage := newUsageMetrics(cnf.Metrics, feed)
+	s := &SSServer{
+		natTimeout:    cnf.NatTimeout,
+		m:             usage,
+		usage:         usage,
+		usageFeed:     feed,
+		usageInterval: usageInterval,
+		replayCache:   replayCache,
+		udpReplay:     cnf.UDPReplayHistory,
+		revokeGrace:   cnf.RevokeGracePeriod,
+		bans:          service.NewBanList(cnf.Ban, cnf.Metrics, cnf.Logger.Named("ban")),
+		ports:         cnf.Ports,
+		keys:          make(map[portKey]serverKey),
+		managedKeys:   make(map[string]serverKey),
+		limits:        make(map[string]keyLimit),
+		limited:       make(map[portKey]bool),
+		logger:        cnf.Logger,
+		done:          make(chan struct{}),
+		events:        cnf.Events,
+		accessLog:     cnf.AccessLog,
+		privacy:       cnf.IPPrivacy,
+		tracer:        cnf.Tracer,
+		// Checked with the data limits by enforceLimits.
+		quotaThresholds: cnf.QuotaThresholds,
+		quotaNotified:   make(map[string]int),
+	}
+	s.bans.SetIPPrivacy(s.privacy)
+	if s.events != nil {
+		s.bans.SetBanHook(func(b service.BanEntry) {
+			s.emit(Event{Type: EventClientBanned, Ban: banEvent(b, s.privacy)})
+		})
+	}
+	if cnf.UsageStorePath != "" {
+		s.usageStore = newUsageStore(cnf.UsageStorePath, cnf.UsageFlushInterval, cnf.UsageRetentionDays, s.logger.Named("usage"))
+		if err := s.usageStore.load(); err != nil {
+			return nil, fmt.Errorf("failed to load usage store: %w", err)
+		}
+		usage.store = s.usageStore
+		// Data limits count the usage of previous runs too.
+		usage.seed(s.usageStore.totals())
+		for _, r := range s.usageStore.query("", "", "") {
+			s.usedKeys.Store(r.AccessKey, true)
+		}
+		go s.usageStore.run()
+	}
+	if cnf.ReplaySnapshotPath != "" && cnf.ReplayHistory > 0 {
+		s.replaySnapshot = newReplaySnapshotter(s.replayCache, cnf.ReplaySnapshotPath, cnf.ReplaySnapshotInterval, s.logger.Named("replay"))
+		s.replaySnapshot.load()
+		go s.replaySnapshot.run()
+	}
+	go feed.run()
+	go s.runLimits(usageInterval)
+	if cnf.UsageEvents && s.events != nil {
+		go s.runUsageEvents()
+	}
+	return s, nil
 }
 
 type SSConfig struct {
 	NatTimeout    time.Duration
 	Metrics       metrics.ShadowsocksMetrics
 	ReplayHistory int
-	Ports         map[int]*SsPort
-	Logger        *logging.Logger
+	// ReplayFilter, ReplayFalsePositiveRate and ReplayMaxMemory select the
+	// replay cache design.  See service.ReplayConfig.
+	ReplayFilter            string
+	ReplayFalsePositiveRate float64
+	ReplayMaxMemory         int
+	// ReplaySnapshotPath is the file where the replay cache is saved every
+	// ReplaySnapshotInterval and on Stop, and loaded from on start.
+	// Empty disables persistence.
+	ReplaySnapshotPath     string
+	ReplaySnapshotInterval time.Duration
+	// UDPReplayHistory is the number of packets remembered per access key to
+	// detect replayed UDP packets.  Zero disables the check.
+	UDPReplayHistory int
+	// UsageInterval is how often usage deltas are published to the usage
+	// stream.  Zero selects the default of 10 seconds.
+	UsageInterval time.Duration
+	// RevokeGracePeriod is how long the established sessions of a removed
+	// access key may continue before they are closed.  Zero closes them
+	// right away.
+	RevokeGracePeriod time.Duration
+	// UsageStorePath is the file where the daily usage of the access keys is
+	// kept.  It's saved every UsageFlushInterval, one minute by default, and
+	// on Stop.  Empty disables the usage store.
+	UsageStorePath     string
+	UsageFlushInterval time.Duration
+	// UsageRetentionDays is how many days of usage the store keeps.  Only
+	// the bytes of older days are kept, for the data limits.  Zero keeps all
+	// the days.
+	UsageRetentionDays int
+	// Events receives the lifecycle events.  May be nil.
+	Events EventSink
+	// QuotaThresholds are the percentages of the data limits whose crossing
+	// emits EventQuotaThreshold, e.g. 80 and 100.
+	QuotaThresholds []int
+	// UsageEvents enables EventUsageBatch.
+	UsageEvents bool
+	// AccessLog receives the finished TCP connections and UDP NAT entries of
+	// all ports.  May be nil.
+	AccessLog service.AccessLog
+	// IPPrivacy is how the client IPs appear in the logs, the access log and
+	// the events, and which part of them is kept for the access key search.
+	// Nil shows full IPs.  The ban list API shows the banned subnets the same
+	// way, and lifts the bans by the subnets as shown.
+	IPPrivacy *service.IPPrivacy
+	// Tracer records a span for every TCP connection and UDP NAT entry.  May
+	// be nil.
+	Tracer service.Tracer
+	// Ban configures automatic banning of clients with repeated authentication failures.
+	Ban    service.BanConfig
+	Ports  map[int]*SsPort
+	Logger *log.Logger
 }
 
 func (s *SSServer) startPort(portNum int) error {
@@ -57,13 +212,33 @@ func (s *SSServer) startPort(portNum int) error {
 	}
 	packetConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: portNum})
 	if err != nil {
+		listener.Close()
 		return fmt.Errorf("Failed to start UDP on port %v: %v", portNum, err)
 	}
-	s.logger.Infof("Listening TCP and UDP on port %v", portNum)
+	s.logger.Info("Listening TCP and UDP", log.Int("port", portNum))
 	port := &SsPort{cipherList: service.NewCipherList()}
 	// TODO: Register initial data metrics at zero.
-	port.tcpService = service.NewTCPService(port.cipherList, &s.replayCache, s.m, tcpReadTimeout)
-	port.udpService = service.NewUDPService(s.natTimeout, port.cipherList, s.m)
+	port.tcpService = service.NewTCPService(port.cipherList, s.replayCache, s.m, tcpReadTimeout, s.logger.Named("tcp"))
+	port.udpService = service.NewUDPService(s.natTimeout, port.cipherList, s.m, s.logger.Named("udp"))
+	if s.events != nil {
+		authHook := func(keyID string) { s.keyAuthenticated(portNum, keyID) }
+		port.tcpService.SetAuthHook(authHook)
+		port.udpService.SetAuthHook(authHook)
+	}
+	port.tcpService.SetBanList(s.bans)
+	port.udpService.SetBanList(s.bans)
+	port.tcpService.SetAccessLog(s.accessLog)
+	port.udpService.SetAccessLog(s.accessLog)
+	port.tcpService.SetIPPrivacy(s.privacy)
+	port.udpService.SetIPPrivacy(s.privacy)
+	port.tcpService.SetTracer(s.tracer)
+	port.tcpService.SetDataReportInterval(s.usageInterval)
+	port.udpService.SetTracer(s.tracer)
+	if err := port.udpService.SetReplayHistory(s.udpReplay); err != nil {
+		listener.Close()
+		packetConn.Close()
+		return err
+	}
 	s.ports[portNum] = port
 	go port.tcpService.Serve(listener)
 	go port.udpService.Serve(packetConn)
@@ -84,54 +259,153 @@ func (s *SSServer) removePort(portNum int) error {
 	if udpErr != nil {
 		return fmt.Errorf("Failed to close packetConn on %v: %v", portNum, udpErr)
 	}
-	s.logger.Infof("Stopped TCP and UDP on port %v", portNum)
+	s.logger.Info("Stopped TCP and UDP", log.Int("port", portNum))
 	return nil
 }
 
+// Returns the entries of `before` that are not in `after` with the same
+// cipher and secret.
+func removedKeys(before, after []service.CipherEntryInfo) []service.CipherEntryInfo {
+	current := make(map[string]service.CipherEntryInfo, len(after))
+	for _, entry := range after {
+		current[entry.ID] = entry
+	}
+	var removed []service.CipherEntryInfo
+	for _, entry := range before {
+		if e, ok := current[entry.ID]; !ok || e.Cipher != entry.Cipher || e.Secret != entry.Secret {
+			removed = append(removed, entry)
+		}
+	}
+	return removed
+}
+
+// Closes the sessions of `keys` on `port` after the grace period, unless they
+// are back in the cipher list by then.  The port may already be removed.
+func (s *SSServer) revokeKeys(portNum int, port *SsPort, keys []service.CipherEntryInfo) {
+	if len(keys) == 0 {
+		return
+	}
+	revoke := func() {
+		for _, key := range removedKeys(keys, port.cipherList.Entries()) {
+			closed := port.tcpService.RevokeKey(key.ID) + port.udpService.RevokeKey(key.ID)
+			if closed > 0 {
+				s.logger.Info("Closed sessions of revoked key", log.Int("sessions", closed), log.String("access_key", key.ID), log.Int("port", portNum))
+			}
+		}
+	}
+	if s.revokeGrace > 0 {
+		time.AfterFunc(s.revokeGrace, revoke)
+	} else {
+		revoke()
+	}
+}
+
 func (s *SSServer) LoadConfig(filename string) error {
 	config, err := readConfig(filename)
 	if err != nil {
-		return fmt.Errorf("Failed to read config file %v: %v", filename, err)
+		err = fmt.Errorf("Failed to read config file %v: %v", filename, err)
+		s.setLoadErr(err)
+		return err
 	}
+	return s.ApplyConfig(config)
+}
 
-	portChanges := make(map[int]int)
-	portCiphers := make(map[int]*list.List) // Values are *List of *CipherEntry.
+// ApplyConfig replaces the keys with the ones in `config`, as LoadConfig does
+// with the keys of a file.  The keys are kept if `config` is invalid.  The
+// managed keys stay, and only the sessions of the keys that are no longer
+// served are closed.
+func (s *SSServer) ApplyConfig(config *Config) error {
+	err := s.applyConfig(config)
+	s.setLoadErr(err)
+	return err
+}
+
+func (s *SSServer) setLoadErr(err error) {
+	s.mu.Lock()
+	s.loadErr = err
+	s.mu.Unlock()
+	if err != nil {
+		s.emit(Event{Type: EventConfigReloadFailed, Error: err.Error()})
+	}
+}
+
+// The limits of the keys are checked again, since they may have changed.
+func (s *SSServer) applyConfig(config *Config) error {
+	keys := make(map[portKey]serverKey)
 	for _, keyConfig := range config.Keys {
-		portChanges[keyConfig.Port] = 1
-		cipherList, ok := portCiphers[keyConfig.Port]
-		if !ok {
-			cipherList = list.New()
-			portCiphers[keyConfig.Port] = cipherList
-		}
 		cipher, err := ss.NewCipher(keyConfig.Cipher, keyConfig.Secret)
 		if err != nil {
 			return fmt.Errorf("Failed to create cipher for key %v: %v", keyConfig.ID, err)
 		}
-		entry := service.MakeCipherEntry(keyConfig.ID, cipher, keyConfig.Secret)
-		cipherList.PushBack(&entry)
+		cs := CipherStruct{ID: keyConfig.ID, Port: keyConfig.Port, Cipher: keyConfig.Cipher, Secret: keyConfig.Secret}
+		keys[portKey{cs.Port, cs.ID}] = serverKey{CipherStruct: cs, cipher: cipher}
 	}
-	for port := range s.ports {
-		portChanges[port] = portChanges[port] - 1
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	if err := s.serveKeysLocked(usage); err != nil {
+		return err
 	}
-	for portNum, count := range portChanges {
-		if count == -1 {
-			if err := s.removePort(portNum); err != nil {
-				return fmt.Errorf("Failed to remove port %v: %v", portNum, err)
-			}
-		} else if count == +1 {
-			if err := s.startPort(portNum); err != nil {
-				return fmt.Errorf("Failed to start port %v: %v", portNum, err)
-			}
+	s.logger.Info("Loaded access keys", log.Int("keys", len(config.Keys)))
+	return nil
+}
+
+// Replaces the keys of all ports with `portCiphers`, whose values are *List of
+// *CipherEntry.  Ports are started and stopped as needed, and the sessions of
+// removed keys are closed.  s.mu must be held.
+func (s *SSServer) applyPortCiphers(portCiphers map[int]*list.List) error {
+	for portNum, port := range s.ports {
+		if _, ok := portCiphers[portNum]; ok {
+			continue
+		}
+		if err := s.removePort(portNum); err != nil {
+			return fmt.Errorf("Failed to remove port %v: %v", portNum, err)
+		}
+		before := port.cipherList.Entries()
+		port.cipherList.Update(list.New())
+		s.revokeKeys(portNum, port, before)
+	}
+	for portNum := range portCiphers {
+		if _, ok := s.ports[portNum]; ok {
+			continue
+		}
+		if err := s.startPort(portNum); err != nil {
+			return fmt.Errorf("Failed to start port %v: %v", portNum, err)
 		}
 	}
 	for portNum, cipherList := range portCiphers {
-		s.ports[portNum].cipherList.Update(cipherList)
+		port := s.ports[portNum]
+		before := port.cipherList.Entries()
+		// Keep the order of the ports that didn't change, which is the most
+		// recently used first.
+		if sameKeys(before, cipherList) {
+			continue
+		}
+		port.cipherList.Update(cipherList)
+		s.revokeKeys(portNum, port, removedKeys(before, port.cipherList.Entries()))
 	}
-	s.logger.Infof("Loaded %v access keys", len(config.Keys))
-	s.m.SetNumAccessKeys(len(config.Keys), len(portCiphers))
 	return nil
 }
 
+// Whether `cipherList`, a List of *CipherEntry, has the same keys as `entries`.
+func sameKeys(entries []service.CipherEntryInfo, cipherList *list.List) bool {
+	if len(entries) != cipherList.Len() {
+		return false
+	}
+	current := make(map[string]service.CipherEntryInfo, len(entries))
+	for _, entry := range entries {
+		current[entry.ID] = entry
+	}
+	for e := cipherList.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*service.CipherEntry)
+		if cur, ok := current[c.ID]; !ok || cur.Secret != c.Secret || cur.Cipher != c.Cipher.Name() {
+			return false
+		}
+	}
+	return true
+}
+
 type CipherStruct struct {
 	ID     string
 	Port   int
@@ -140,54 +414,53 @@ type CipherStruct struct {
 }
 
 func (s *SSServer) AddCipher(cs CipherStruct) (int, error) {
-	var isPortInit bool
-	for port := range s.ports {
-		if port == cs.Port {
-			isPortInit = true
-		}
-	}
-
-	if !isPortInit {
-		var port = cs.Port
-		// iter while starting port
-		// TODO Remove iter and create method to check free port
-		for err := s.startPort(port); err != nil; {
-			s.logger.Errorf("error for starting port: %d", port)
-			port++
-		}
-		cs.Port = port
-	}
-
 	cipher, err := ss.NewCipher(cs.Cipher, cs.Secret)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create cipher for key %v: %v", cs.ID, err)
 	}
-	entry := service.MakeCipherEntry(cs.ID, cipher, cs.Secret)
-
-	s.ports[cs.Port].cipherList.AddCipher(&entry)
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs.Port = s.openPortLocked(cs.Port)
+	s.keys[portKey{cs.Port, cs.ID}] = serverKey{CipherStruct: cs, cipher: cipher}
+	if err := s.serveKeysLocked(usage); err != nil {
+		return 0, err
+	}
 
-	s.logger.Infof("add cipher with client id %s and port %d", cs.ID, cs.Port)
+	s.logger.Info("Added access key", log.String("access_key", cs.ID), log.Int("port", cs.Port))
 
 	return cs.Port, nil
 }
 
-func (s *SSServer) RemoveCipher(cs CipherStruct) error {
-	ssP, ok := s.ports[cs.Port]
-	if !ok {
-		return fmt.Errorf("port for remove does not exists in server: %d", cs.Port)
+// Starts `port` if it isn't open yet.  If it can't be started, the next ports
+// are tried.  Returns the open port.  s.mu must be held.
+func (s *SSServer) openPortLocked(port int) int {
+	if _, ok := s.ports[port]; ok {
+		return port
 	}
-	ssP.cipherList.RemoveCipher(cs.ID)
-	l := ssP.cipherList.GetList()
-	if l.Len() <= 0 {
-		err := s.removePort(cs.Port)
-		if err != nil {
-			return err
-		}
+	// TODO Remove iter and create method to check free port
+	for err := s.startPort(port); err != nil; err = s.startPort(port) {
+		s.logger.Error("Failed to start port", log.Int("port", port), log.Err(err))
+		port++
 	}
-	return nil
+	return port
+}
+
+func (s *SSServer) RemoveCipher(cs CipherStruct) error {
+	usage := s.usage.transferred()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := portKey{cs.Port, cs.ID}
+	if _, ok := s.keys[k]; !ok {
+		return fmt.Errorf("access key %v does not exist on port %d", cs.ID, cs.Port)
+	}
+	delete(s.keys, k)
+	return s.serveKeysLocked(usage)
 }
 
 func (s *SSServer) IsCipherExists(cs CipherStruct) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	ssP, ok := s.ports[cs.Port]
 	if !ok {
 		return false
@@ -196,23 +469,145 @@ func (s *SSServer) IsCipherExists(cs CipherStruct) bool {
 	return ssP.cipherList.IsCipherExists(cs.ID)
 }
 
-// Stop serving on all ports.
+// IPPrivacy returns how the client IPs may be shown.  May be nil, which
+// shows them in full.
+func (s *SSServer) IPPrivacy() *service.IPPrivacy {
+	return s.privacy
+}
+
+// Bans returns the active bans.
+func (s *SSServer) Bans() []service.BanEntry {
+	return s.bans.List()
+}
+
+// Ban bans an IP address or CIDR for `duration`.
+func (s *SSServer) Ban(subnet string, duration time.Duration, reason string) (service.BanEntry, error) {
+	ipNet, err := service.ParseBanSubnet(subnet)
+	if err != nil {
+		return service.BanEntry{}, fmt.Errorf("invalid subnet to ban: %w", err)
+	}
+	if duration <= 0 {
+		return service.BanEntry{}, fmt.Errorf("invalid ban duration: %v", duration)
+	}
+	return s.bans.Ban(ipNet, duration, reason), nil
+}
+
+// Unban lifts the ban on an IP address or CIDR, and returns the number of
+// bans lifted.  `subnet` may also be a subnet as IPPrivacy shows it, which
+// lifts all the bans shown that way, since they can't be told apart.
+func (s *SSServer) Unban(subnet string) (int, error) {
+	if s.privacy.Mode() != service.IPPrivacyFull && subnet != "" {
+		removed := 0
+		for _, b := range s.bans.List() {
+			if s.privacy.Subnet(b.Subnet) == subnet && s.bans.Unban(b.Subnet) {
+				removed++
+			}
+		}
+		if removed > 0 {
+			return removed, nil
+		}
+	}
+	ipNet, err := service.ParseBanSubnet(subnet)
+	if err != nil {
+		return 0, fmt.Errorf("invalid subnet to unban: %w", err)
+	}
+	if !s.bans.Unban(ipNet) {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// Health is the state of the server, for health checks.
+type Health struct {
+	Ports int
+	Keys  int
+	// LoadError is the error of the last config reload, or nil.
+	LoadError error
+}
+
+// Healthy returns whether the last config reload succeeded.
+func (h Health) Healthy() bool {
+	return h.LoadError == nil
+}
+
+func (h Health) String() string {
+	if h.LoadError != nil {
+		return fmt.Sprintf("%v keys on %v ports, config reload failed: %v", h.Keys, h.Ports, h.LoadError)
+	}
+	return fmt.Sprintf("%v keys on %v ports", h.Keys, h.Ports)
+}
+
+// Health returns the state of the server.
+func (s *SSServer) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := Health{Ports: len(s.ports), LoadError: s.loadErr}
+	for _, port := range s.ports {
+		health.Keys += port.cipherList.Len()
+	}
+	return health
+}
+
+// Stop serving on all ports.  Later calls return the error of the first.
 func (s *SSServer) Stop() error {
+	s.stopOnce.Do(func() { s.stopErr = s.stop() })
+	return s.stopErr
+}
+
+// stop stops everything even if some of it fails, so that the usage and the
+// replay cache are always saved, and returns all the errors.
+func (s *SSServer) stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
 	for portNum := range s.ports {
 		if err := s.removePort(portNum); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	s.usageFeed.stop()
+	close(s.done)
+	if s.usageStore != nil {
+		if err := s.usageStore.stop(); err != nil {
+			errs = append(errs, fmt.Errorf("Failed to save usage: %w", err))
+		}
+	}
+	if s.replaySnapshot != nil {
+		if err := s.replaySnapshot.stop(); err != nil {
+			errs = append(errs, fmt.Errorf("Failed to save replay cache: %w", err))
+		}
+	}
+	if err := s.bans.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return joinErrors(errs)
 }
 
-type Config struct {
-	Keys []struct {
-		ID     string
-		Port   int
-		Cipher string
-		Secret string
+// joinErrors returns nil if `errs` is empty, its only error, or an error with
+// the messages of all of them.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
 	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+type Config struct {
+	Keys []KeyConfig
+}
+
+type KeyConfig struct {
+	ID     string
+	Port   int
+	Cipher string
+	Secret string
 }
 
 func readConfig(filename string) (*Config, error) {
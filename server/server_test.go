@@ -0,0 +1,436 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestServer(t *testing.T) *SSServer {
+	s, err := NewSSServer(&SSConfig{
+		Metrics: &metrics.NoOpMetrics{},
+		Ports:   make(map[int]*SsPort),
+		Logger:  log.Default(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// Returns a port that is free for TCP and UDP.
+func freePort(t *testing.T) int {
+	for {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{})
+		require.NoError(t, err)
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		if conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port}); err == nil {
+			conn.Close()
+			return port
+		}
+	}
+}
+
+func TestRemovedKeys(t *testing.T) {
+	before := []service.CipherEntryInfo{
+		{ID: "kept", Cipher: DefaultCipher, Secret: "a"},
+		{ID: "removed", Cipher: DefaultCipher, Secret: "b"},
+		{ID: "rekeyed", Cipher: DefaultCipher, Secret: "c"},
+	}
+	after := []service.CipherEntryInfo{
+		{ID: "kept", Cipher: DefaultCipher, Secret: "a"},
+		{ID: "rekeyed", Cipher: DefaultCipher, Secret: "new"},
+		{ID: "added", Cipher: DefaultCipher, Secret: "d"},
+	}
+	var ids []string
+	for _, key := range removedKeys(before, after) {
+		ids = append(ids, key.ID)
+	}
+	require.Equal(t, []string{"removed", "rekeyed"}, ids)
+	require.Empty(t, removedKeys(before, before))
+}
+
+func TestStopTwice(t *testing.T) {
+	s := makeTestServer(t)
+	_, err := s.AddCipher(CipherStruct{ID: "a", Port: freePort(t), Cipher: DefaultCipher, Secret: "a"})
+	require.NoError(t, err)
+	require.NoError(t, s.Stop())
+	require.NoError(t, s.Stop())
+}
+
+func ids(keys []CipherStruct) []string {
+	var ids []string
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+// Records the keys revoked on a port.
+type revokeRecorder struct {
+	service.TCPService
+	mu      sync.Mutex
+	revoked []string
+}
+
+func (r *revokeRecorder) RevokeKey(keyID string) int {
+	r.mu.Lock()
+	r.revoked = append(r.revoked, keyID)
+	r.mu.Unlock()
+	return r.TCPService.RevokeKey(keyID)
+}
+
+func TestApplyConfig_RevokesOnlyRemovedKeys(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	require.NoError(t, s.ApplyConfig(&Config{Keys: []KeyConfig{
+		{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"},
+		{ID: "b", Port: port, Cipher: DefaultCipher, Secret: "b"},
+	}}))
+	_, err := s.AddManagedKey(CipherStruct{ID: "m", Port: port, Cipher: DefaultCipher, Secret: "m"})
+	require.NoError(t, err)
+	recorder := &revokeRecorder{}
+	s.mu.Lock()
+	recorder.TCPService = s.ports[port].tcpService
+	s.ports[port].tcpService = recorder
+	s.mu.Unlock()
+
+	// Reloading the same config revokes nothing, and removing a config key
+	// doesn't revoke the managed key.
+	config := &Config{Keys: []KeyConfig{{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"}}}
+	require.NoError(t, s.ApplyConfig(config))
+	require.NoError(t, s.ApplyConfig(config))
+	recorder.mu.Lock()
+	require.Equal(t, []string{"b"}, recorder.revoked)
+	recorder.mu.Unlock()
+	require.Equal(t, []string{"a", "m"}, ids(s.Keys()))
+}
+
+func TestSetKeys(t *testing.T) {
+	s := makeTestServer(t)
+	port1, port2 := freePort(t), freePort(t)
+	key := func(id string, port int, secret string) KeySpec {
+		return KeySpec{CipherStruct: CipherStruct{ID: id, Port: port, Cipher: DefaultCipher, Secret: secret}}
+	}
+
+	diff := s.SetKeys([]KeySpec{key("a", port1, "a"), key("b", port1, "b"), key("c", port2, "c")})
+	require.Equal(t, []string{"a", "b", "c"}, ids(diff.Added))
+	require.Empty(t, diff.Removed)
+	require.Empty(t, diff.Errors)
+	require.Len(t, s.PortStatuses(), 2)
+
+	invalid := key("b", port1, "")
+	diff = s.SetKeys([]KeySpec{key("a", port1, "a2"), invalid, key("d", port1, "d"), key("d", port1, "d")})
+	require.Equal(t, []string{"d"}, ids(diff.Added))
+	require.Equal(t, []string{"a"}, ids(diff.Changed))
+	require.Equal(t, []string{"c"}, ids(diff.Removed))
+	require.Len(t, diff.Errors, 2)
+	require.Equal(t, "b", diff.Errors[0].ID)
+	require.Equal(t, "d", diff.Errors[1].ID)
+	// The rejected key keeps its current version, and the empty port is stopped.
+	require.Equal(t, []CipherStruct{
+		{ID: "a", Port: port1, Cipher: DefaultCipher, Secret: "a2"},
+		{ID: "b", Port: port1, Cipher: DefaultCipher, Secret: "b"},
+		{ID: "d", Port: port1, Cipher: DefaultCipher, Secret: "d"},
+	}, s.Keys())
+
+	// Applying the same set again changes nothing.
+	diff = s.SetKeys([]KeySpec{key("a", port1, "a2"), key("b", port1, "b"), key("d", port1, "d")})
+	require.Equal(t, KeyDiff{}, diff)
+}
+
+func TestSetKeys_DataLimit(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	s.usage.add("a", 100)
+	limited := KeySpec{CipherStruct: CipherStruct{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"}, DataLimit: 100}
+	other := KeySpec{CipherStruct: CipherStruct{ID: "b", Port: port, Cipher: DefaultCipher, Secret: "b"}, DataLimit: 100}
+
+	diff := s.SetKeys([]KeySpec{limited, other})
+	require.Equal(t, []string{"a"}, ids(diff.Limited))
+	require.Equal(t, []string{"b"}, ids(diff.Added))
+	require.Equal(t, []string{"b"}, ids(s.Keys()))
+
+	// Keys that reach the limit later are removed by enforceLimits.
+	s.usage.add("b", 150)
+	s.enforceLimits()
+	require.Empty(t, s.Keys())
+	require.Empty(t, s.PortStatuses())
+
+	// Raising the limit serves the keys again.  The stopped port may not be
+	// released yet, so they move to another port.
+	limited.DataLimit = 1000
+	other.DataLimit = 0
+	limited.Port = freePort(t)
+	other.Port = limited.Port
+	diff = s.SetKeys([]KeySpec{limited, other})
+	require.Empty(t, diff.Errors)
+	require.Equal(t, []string{"a", "b"}, ids(diff.Added))
+	require.Empty(t, diff.Limited)
+}
+
+func TestSetKeys_PortInUse(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	require.NoError(t, err)
+	defer listener.Close()
+	busyPort := listener.Addr().(*net.TCPAddr).Port
+	key := func(id string, port int) KeySpec {
+		return KeySpec{CipherStruct: CipherStruct{ID: id, Port: port, Cipher: DefaultCipher, Secret: id}}
+	}
+	s.SetKeys([]KeySpec{key("a", port)})
+
+	// The key on the busy port is rejected before anything is applied, and the
+	// others are applied.
+	diff := s.SetKeys([]KeySpec{key("a", port), key("b", busyPort), key("c", port)})
+	require.Len(t, diff.Errors, 1)
+	require.Equal(t, "b", diff.Errors[0].ID)
+	require.Equal(t, []string{"c"}, ids(diff.Added))
+	require.Equal(t, []string{"a", "c"}, ids(s.Keys()))
+	require.Len(t, s.PortStatuses(), 1)
+}
+
+func TestLimits_ReloadAndRaise(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	config := &Config{Keys: []KeyConfig{
+		{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"},
+		{ID: "b", Port: port, Cipher: DefaultCipher, Secret: "b"},
+	}}
+	require.NoError(t, s.ApplyConfig(config))
+	s.mu.Lock()
+	s.limits["a"] = keyLimit{data: 100}
+	s.mu.Unlock()
+	s.usage.add("a", 150)
+	s.enforceLimits()
+	require.Equal(t, []string{"b"}, ids(s.Keys()))
+
+	// A reload doesn't bring back the key over its limit.
+	require.NoError(t, s.ApplyConfig(config))
+	require.Equal(t, []string{"b"}, ids(s.Keys()))
+
+	// Raising the limit serves it again.
+	s.mu.Lock()
+	s.limits["a"] = keyLimit{data: 1000}
+	s.mu.Unlock()
+	s.enforceLimits()
+	require.Equal(t, []string{"a", "b"}, ids(s.Keys()))
+}
+
+func TestManagedKeys(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	config := &Config{Keys: []KeyConfig{{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"}}}
+	require.NoError(t, s.ApplyConfig(config))
+	managedPort, err := s.AddManagedKey(CipherStruct{ID: "m", Port: port, Cipher: DefaultCipher, Secret: "m"})
+	require.NoError(t, err)
+	require.Equal(t, port, managedPort)
+
+	// Managed keys are kept across reloads and SetKeys.
+	require.NoError(t, s.ApplyConfig(config))
+	require.Equal(t, []string{"a", "m"}, ids(s.Keys()))
+	s.SetKeys(nil)
+	require.Equal(t, []string{"m"}, ids(s.Keys()))
+
+	require.NoError(t, s.RemoveManagedKey("m"))
+	require.Empty(t, s.Keys())
+	require.Error(t, s.RemoveManagedKey("m"))
+}
+
+func TestDataLimits(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	_, err := s.AddManagedKey(CipherStruct{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"})
+	require.NoError(t, err)
+	_, err = s.AddManagedKey(CipherStruct{ID: "b", Port: port, Cipher: DefaultCipher, Secret: "b"})
+	require.NoError(t, err)
+	s.usage.add("a", 150)
+
+	limit := int64(100)
+	s.SetDataLimits(DataLimits{Default: &limit})
+	require.Equal(t, []string{"b"}, ids(s.Keys()))
+	require.Equal(t, []string{"a"}, ids(s.LimitedKeys()))
+
+	// A per-key limit overrides the default, and the lower of it and the
+	// limit of SetKeys applies.
+	s.SetDataLimits(DataLimits{Default: &limit, Keys: map[string]int64{"a": 1000, "b": 0}})
+	require.Equal(t, []string{"a"}, ids(s.Keys()))
+	require.Equal(t, []string{"b"}, ids(s.LimitedKeys()))
+
+	s.SetDataLimits(DataLimits{})
+	require.Equal(t, []string{"a", "b"}, ids(s.Keys()))
+	require.Empty(t, s.LimitedKeys())
+}
+
+func TestTransferredBytes_UsageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	require.NoError(t, writeUsageFile(path, usageFile{Records: []UsageRecord{
+		{Day: "2026-10-01", AccessKey: "a", Proto: "tcp", Up: 10, Down: 20},
+		{Day: "2026-10-02", AccessKey: "a", Proto: "udp", Up: 1, Down: 2},
+	}, PrunedBytes: map[string]int64{"a": 100}}))
+	s, err := NewSSServer(&SSConfig{
+		Metrics:        &metrics.NoOpMetrics{},
+		Ports:          make(map[int]*SsPort),
+		Logger:         log.Default(),
+		UsageStorePath: path,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	// Data limits count the usage of previous runs.
+	require.Equal(t, map[string]int64{"a": 133}, s.TransferredBytes())
+}
+
+// A TCPService whose Stop fails.
+type failingStop struct {
+	service.TCPService
+}
+
+func (f failingStop) Stop() error {
+	f.TCPService.Stop()
+	return errors.New("stop failed")
+}
+
+func TestStop_SavesUsageOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	s, err := NewSSServer(&SSConfig{
+		Metrics:        &metrics.NoOpMetrics{},
+		Ports:          make(map[int]*SsPort),
+		Logger:         log.Default(),
+		UsageStorePath: path,
+	})
+	require.NoError(t, err)
+	port := freePort(t)
+	_, err = s.AddManagedKey(CipherStruct{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"})
+	require.NoError(t, err)
+	s.mu.Lock()
+	s.ports[port].tcpService = failingStop{s.ports[port].tcpService}
+	s.mu.Unlock()
+	s.m.AddTCPData("a", metrics.ProxyMetrics{ProxyTarget: 10})
+
+	require.Error(t, s.Stop())
+	records, err := ReadUsageFile(path)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+}
+
+func TestApplyConfig_KeepsLastGood(t *testing.T) {
+	s := makeTestServer(t)
+	port := freePort(t)
+	good := &Config{Keys: []KeyConfig{{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"}}}
+	require.NoError(t, s.ApplyConfig(good))
+	require.True(t, s.Health().Healthy())
+
+	bad := &Config{Keys: []KeyConfig{
+		{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"},
+		{ID: "b", Port: port, Cipher: "invalid", Secret: "b"},
+	}}
+	require.Error(t, s.ApplyConfig(bad))
+	require.False(t, s.Health().Healthy())
+	require.Equal(t, []string{"a"}, ids(s.Keys()))
+
+	require.NoError(t, s.ApplyConfig(good))
+	require.True(t, s.Health().Healthy())
+}
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recordingSink) Emit(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// Returns the events since the last call.
+func (r *recordingSink) take() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	for i := range events {
+		events[i].Time = time.Time{}
+	}
+	return events
+}
+
+func TestEvents(t *testing.T) {
+	sink := &recordingSink{}
+	s, err := NewSSServer(&SSConfig{
+		Metrics:         &metrics.NoOpMetrics{},
+		Ports:           make(map[int]*SsPort),
+		Logger:          log.Default(),
+		Events:          sink,
+		QuotaThresholds: []int{50, 80, 100},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	port := freePort(t)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	limited := KeySpec{CipherStruct: CipherStruct{ID: "a", Port: port, Cipher: DefaultCipher, Secret: "a"}, DataLimit: 100}
+	expiring := KeySpec{CipherStruct: CipherStruct{ID: "b", Port: port, Cipher: DefaultCipher, Secret: "b"}, ExpiresAt: expiresAt}
+	s.SetKeys([]KeySpec{limited, expiring})
+
+	// Only the first authentication of a key is an event.
+	s.keyAuthenticated(port, "a")
+	s.keyAuthenticated(port, "a")
+	require.Equal(t, []Event{{Type: EventKeyFirstUsed, AccessKey: "a", Port: port}}, sink.take())
+
+	// Only the highest threshold crossed since the last check is notified.
+	s.usage.add("a", 85)
+	s.enforceLimits()
+	s.enforceLimits()
+	require.Equal(t, []Event{{Type: EventQuotaThreshold, AccessKey: "a", ThresholdPercent: 80, DataLimit: 100, BytesTransferred: 85}}, sink.take())
+	// A new limit notifies the thresholds again.
+	limited.DataLimit = 170
+	s.SetKeys([]KeySpec{limited, expiring})
+	s.enforceLimits()
+	require.Equal(t, []Event{{Type: EventQuotaThreshold, AccessKey: "a", ThresholdPercent: 50, DataLimit: 170, BytesTransferred: 85}}, sink.take())
+
+	expiring.ExpiresAt = time.Now().Add(-time.Second).UTC().Truncate(time.Second)
+	s.mu.Lock()
+	s.limits["b"] = keyLimit{expiresAt: expiring.ExpiresAt}
+	s.mu.Unlock()
+	s.enforceLimits()
+	require.Equal(t, []Event{{Type: EventKeyExpired, AccessKey: "b", Port: port, ExpiresAt: &expiring.ExpiresAt}}, sink.take())
+	require.Equal(t, []string{"a"}, ids(s.Keys()))
+
+	require.Error(t, s.ApplyConfig(&Config{Keys: []KeyConfig{{ID: "c", Port: port, Cipher: "invalid", Secret: "c"}}}))
+	events := sink.take()
+	require.Len(t, events, 1)
+	require.Equal(t, EventConfigReloadFailed, events[0].Type)
+	require.NotEmpty(t, events[0].Error)
+}
+
+func TestBanEvent_IPPrivacy(t *testing.T) {
+	sink := &recordingSink{}
+	privacy, err := service.NewIPPrivacy(service.IPPrivacyTruncate, nil)
+	require.NoError(t, err)
+	s, err := NewSSServer(&SSConfig{
+		Metrics:   &metrics.NoOpMetrics{},
+		Ports:     make(map[int]*SsPort),
+		Logger:    log.Default(),
+		Events:    sink,
+		IPPrivacy: privacy,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+
+	_, err = s.Ban("192.0.2.10", time.Minute, "abuse")
+	require.NoError(t, err)
+	events := sink.take()
+	require.Len(t, events, 1)
+	require.Equal(t, "192.0.2.0/24", events[0].Ban.Subnet)
+	// The ban list still has the banned IP, so that it can be lifted.
+	require.Equal(t, "192.0.2.10/32", s.Bans()[0].Subnet.String())
+}
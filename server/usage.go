@@ -0,0 +1,127 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+// usageMetrics passes everything through to the wrapped metrics, and also
+// tallies the bytes of each access key, since the server started or over the
+// whole usage store if it's enabled.  It feeds the usage deltas to the usage
+// stream and the usage store, and counts the open TCP connections and UDP NAT
+// entries.  Like Outline, it counts the bytes that the proxy sends: to the
+// target (p>t) and back to the client (c<p).  The TCP bytes are counted as
+// the connections report them with AddTCPData.
+type usageMetrics struct {
+	// Accessed atomically.  They come first to be 64-bit aligned on 32-bit
+	// platforms.
+	tcpConns    int64
+	udpSessions int64
+	metrics.ShadowsocksMetrics
+	mu    sync.Mutex
+	bytes map[string]int64
+	feed  *usageFeed
+	// store is nil if the usage store is disabled.
+	store *usageStore
+}
+
+func newUsageMetrics(m metrics.ShadowsocksMetrics, feed *usageFeed) *usageMetrics {
+	return &usageMetrics{ShadowsocksMetrics: m, bytes: make(map[string]int64), feed: feed}
+}
+
+func (m *usageMetrics) add(accessKey string, n int64) {
+	if accessKey == "" || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.bytes[accessKey] += n
+	m.mu.Unlock()
+}
+
+// seed adds `totals` to the tally.
+func (m *usageMetrics) seed(totals map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range totals {
+		m.bytes[k] += v
+	}
+}
+
+// transferred returns a copy of the tally.
+func (m *usageMetrics) transferred() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bytes := make(map[string]int64, len(m.bytes))
+	for k, v := range m.bytes {
+		bytes[k] = v
+	}
+	return bytes
+}
+
+func (m *usageMetrics) record(delta UsageDelta) {
+	m.feed.add(delta)
+	if m.store != nil {
+		m.store.add(delta)
+	}
+}
+
+func (m *usageMetrics) AddOpenTCPConnection(clientInfo metrics.ClientInfo) {
+	m.ShadowsocksMetrics.AddOpenTCPConnection(clientInfo)
+	atomic.AddInt64(&m.tcpConns, 1)
+}
+
+func (m *usageMetrics) AddClosedTCPConnection(clientInfo metrics.ClientInfo, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
+	m.ShadowsocksMetrics.AddClosedTCPConnection(clientInfo, accessKey, status, data, timeToCipher, duration)
+	atomic.AddInt64(&m.tcpConns, -1)
+	// The data was already counted by AddTCPData.
+	m.record(UsageDelta{AccessKey: accessKey, Proto: "tcp", Connections: 1})
+}
+
+func (m *usageMetrics) AddTCPData(accessKey string, data metrics.ProxyMetrics) {
+	m.ShadowsocksMetrics.AddTCPData(accessKey, data)
+	m.add(accessKey, data.ProxyTarget+data.ProxyClient)
+	m.record(UsageDelta{
+		AccessKey:   accessKey,
+		Proto:       "tcp",
+		ClientProxy: data.ClientProxy,
+		ProxyTarget: data.ProxyTarget,
+		TargetProxy: data.TargetProxy,
+		ProxyClient: data.ProxyClient,
+	})
+}
+
+func (m *usageMetrics) AddUDPPacketFromClient(clientInfo metrics.ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+	m.ShadowsocksMetrics.AddUDPPacketFromClient(clientInfo, accessKey, status, clientProxyBytes, proxyTargetBytes, timeToCipher)
+	m.add(accessKey, int64(proxyTargetBytes))
+	m.record(UsageDelta{
+		AccessKey:   accessKey,
+		Proto:       "udp",
+		ClientProxy: int64(clientProxyBytes),
+		ProxyTarget: int64(proxyTargetBytes),
+		Packets:     1,
+	})
+}
+
+func (m *usageMetrics) AddUDPPacketFromTarget(clientInfo metrics.ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+	m.ShadowsocksMetrics.AddUDPPacketFromTarget(clientInfo, accessKey, status, targetProxyBytes, proxyClientBytes)
+	m.add(accessKey, int64(proxyClientBytes))
+	m.record(UsageDelta{
+		AccessKey:   accessKey,
+		Proto:       "udp",
+		TargetProxy: int64(targetProxyBytes),
+		ProxyClient: int64(proxyClientBytes),
+	})
+}
+
+func (m *usageMetrics) AddUDPNatEntry(accessKey string, port int) {
+	m.ShadowsocksMetrics.AddUDPNatEntry(accessKey, port)
+	atomic.AddInt64(&m.udpSessions, 1)
+}
+
+func (m *usageMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
+	m.ShadowsocksMetrics.RemoveUDPNatEntry(accessKey, port, duration)
+	atomic.AddInt64(&m.udpSessions, -1)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many batches are kept for consumers that resume after a disconnect.
+const usageFeedHistory = 360
+
+// Buffer of each subscription.  A consumer that falls further behind is
+// disconnected, and can resume from the history.
+const usageFeedBuffer = 16
+
+// UsageDelta is the usage of an access key over one protocol during a batch.
+type UsageDelta struct {
+	AccessKey string `json:"access_key"`
+	// Proto is "tcp" or "udp".
+	Proto string `json:"proto"`
+	// Bytes in each direction: client to proxy, proxy to target, target to
+	// proxy and proxy to client.
+	ClientProxy int64 `json:"client_proxy"`
+	ProxyTarget int64 `json:"proxy_target"`
+	TargetProxy int64 `json:"target_proxy"`
+	ProxyClient int64 `json:"proxy_client"`
+	// Connections is the number of TCP connections that closed.
+	Connections int64 `json:"connections"`
+	// Packets is the number of UDP packets from the client.
+	Packets int64 `json:"packets"`
+}
+
+// UsageBatch holds the usage deltas of one interval.
+type UsageBatch struct {
+	// Epoch identifies the server process.  Sequence numbers restart at 1 in
+	// every epoch.
+	Epoch string `json:"epoch"`
+	// Seq increases by one for every batch, including empty ones, so that
+	// consumers can detect gaps.
+	Seq    uint64       `json:"seq"`
+	Time   time.Time    `json:"time"`
+	Deltas []UsageDelta `json:"deltas"`
+}
+
+type usageDeltaKey struct {
+	accessKey string
+	proto     string
+}
+
+// usageFeed collects usage deltas and publishes them in batches.
+type usageFeed struct {
+	epoch    string
+	interval time.Duration
+	mu       sync.Mutex
+	pending  map[usageDeltaKey]*UsageDelta
+	seq      uint64
+	// The most recent batches, oldest first.
+	history []UsageBatch
+	subs    map[chan UsageBatch]struct{}
+	done    chan struct{}
+}
+
+func newUsageFeed(interval time.Duration) *usageFeed {
+	epoch := make([]byte, 8)
+	rand.Read(epoch)
+	return &usageFeed{
+		epoch:    hex.EncodeToString(epoch),
+		interval: interval,
+		pending:  make(map[usageDeltaKey]*UsageDelta),
+		subs:     make(map[chan UsageBatch]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Adds `delta` to the pending delta of the same key and protocol.
+func (f *usageFeed) add(delta UsageDelta) {
+	if delta.AccessKey == "" {
+		return
+	}
+	key := usageDeltaKey{delta.AccessKey, delta.Proto}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.pending[key]
+	if !ok {
+		f.pending[key] = &delta
+		return
+	}
+	d.ClientProxy += delta.ClientProxy
+	d.ProxyTarget += delta.ProxyTarget
+	d.TargetProxy += delta.TargetProxy
+	d.ProxyClient += delta.ProxyClient
+	d.Connections += delta.Connections
+	d.Packets += delta.Packets
+}
+
+// Publishes the pending deltas as the next batch.
+func (f *usageFeed) flush(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	batch := UsageBatch{Epoch: f.epoch, Seq: f.seq, Time: now}
+	for _, d := range f.pending {
+		batch.Deltas = append(batch.Deltas, *d)
+	}
+	sort.Slice(batch.Deltas, func(i, j int) bool {
+		if batch.Deltas[i].AccessKey != batch.Deltas[j].AccessKey {
+			return batch.Deltas[i].AccessKey < batch.Deltas[j].AccessKey
+		}
+		return batch.Deltas[i].Proto < batch.Deltas[j].Proto
+	})
+	f.pending = make(map[usageDeltaKey]*UsageDelta)
+	if len(f.history) == usageFeedHistory {
+		copy(f.history, f.history[1:])
+		f.history = f.history[:len(f.history)-1]
+	}
+	f.history = append(f.history, batch)
+	for ch := range f.subs {
+		select {
+		case ch <- batch:
+		default:
+			// Too slow.  Closing the channel ends the stream.
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the retained batches after `afterSeq` of `epoch`, and a
+// channel of the following batches.  If `epoch` is not the current epoch, all
+// the retained batches are returned.  The channel is closed if the consumer
+// falls behind or the feed stops.  `cancel` must be called when done.
+func (f *usageFeed) subscribe(epoch string, afterSeq uint64) (backlog []UsageBatch, ch <-chan UsageBatch, cancel func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if epoch != f.epoch {
+		afterSeq = 0
+	}
+	for _, batch := range f.history {
+		if batch.Seq > afterSeq {
+			backlog = append(backlog, batch)
+		}
+	}
+	c := make(chan UsageBatch, usageFeedBuffer)
+	f.subs[c] = struct{}{}
+	cancel = func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[c]; ok {
+			delete(f.subs, c)
+			close(c)
+		}
+	}
+	return backlog, c, cancel
+}
+
+func (f *usageFeed) run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			f.flush(now)
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// stop publishes the pending deltas, if any, and ends the batches and all
+// the subscriptions.
+func (f *usageFeed) stop() {
+	close(f.done)
+	f.mu.Lock()
+	pending := len(f.pending) > 0
+	f.mu.Unlock()
+	if pending {
+		f.flush(time.Now())
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageMetrics_Feed(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	m := newUsageMetrics(&metrics.NoOpMetrics{}, feed)
+	m.AddTCPData("key1", metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4})
+	m.AddClosedTCPConnection(metrics.ClientInfo{}, "key1", "OK", metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4}, 0, 0)
+	m.AddTCPData("key1", metrics.ProxyMetrics{ClientProxy: 10})
+	m.AddClosedTCPConnection(metrics.ClientInfo{}, "key1", "OK", metrics.ProxyMetrics{ClientProxy: 10}, 0, 0)
+	m.AddUDPPacketFromClient(metrics.ClientInfo{}, "key1", "OK", 5, 4, 0)
+	m.AddUDPPacketFromTarget(metrics.ClientInfo{}, "key1", "OK", 7, 8)
+	// Packets that didn't authenticate have no key.
+	m.AddUDPPacketFromClient(metrics.ClientInfo{}, "", "ERR_CIPHER", 5, 0, 0)
+
+	_, batches, cancel := feed.subscribe("", 0)
+	defer cancel()
+	feed.flush(time.Unix(1000, 0))
+	batch := <-batches
+	require.Equal(t, uint64(1), batch.Seq)
+	require.Equal(t, []UsageDelta{
+		{AccessKey: "key1", Proto: "tcp", ClientProxy: 11, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4, Connections: 2},
+		{AccessKey: "key1", Proto: "udp", ClientProxy: 5, ProxyTarget: 4, TargetProxy: 7, ProxyClient: 8, Packets: 1},
+	}, batch.Deltas)
+	require.Equal(t, map[string]int64{"key1": 2 + 4 + 4 + 8}, m.transferred())
+
+	// Empty batches keep the sequence contiguous.
+	feed.flush(time.Unix(1010, 0))
+	batch = <-batches
+	require.Equal(t, uint64(2), batch.Seq)
+	require.Empty(t, batch.Deltas)
+}
+
+func TestUsageMetrics_OpenConnection(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	m := newUsageMetrics(&metrics.NoOpMetrics{}, feed)
+	m.AddOpenTCPConnection(metrics.ClientInfo{})
+	// The data of a connection that is still open.
+	m.AddTCPData("key1", metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2})
+
+	_, batches, cancel := feed.subscribe("", 0)
+	defer cancel()
+	feed.flush(time.Unix(1000, 0))
+	batch := <-batches
+	require.Equal(t, []UsageDelta{
+		{AccessKey: "key1", Proto: "tcp", ClientProxy: 1, ProxyTarget: 2},
+	}, batch.Deltas)
+	require.Equal(t, map[string]int64{"key1": 2}, m.transferred())
+	require.Equal(t, int64(1), atomic.LoadInt64(&m.tcpConns))
+}
+
+func TestUsageFeed_StopFlushes(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	_, batches, cancel := feed.subscribe(feed.epoch, 0)
+	defer cancel()
+	feed.add(UsageDelta{AccessKey: "key1", Proto: "tcp", Connections: 1})
+	feed.stop()
+
+	var got []UsageBatch
+	for batch := range batches {
+		got = append(got, batch)
+	}
+	require.Len(t, got, 1)
+	require.Equal(t, []UsageDelta{{AccessKey: "key1", Proto: "tcp", Connections: 1}}, got[0].Deltas)
+}
+
+func TestUsageFeed_Resume(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	for i := 0; i < 3; i++ {
+		feed.add(UsageDelta{AccessKey: "key1", Proto: "tcp", Connections: 1})
+		feed.flush(time.Now())
+	}
+
+	backlog, _, cancel := feed.subscribe(feed.epoch, 1)
+	cancel()
+	require.Len(t, backlog, 2)
+	require.Equal(t, uint64(2), backlog[0].Seq)
+	require.Equal(t, uint64(3), backlog[1].Seq)
+
+	// A consumer of a previous epoch gets everything that was retained.
+	backlog, _, cancel = feed.subscribe("previous", 3)
+	cancel()
+	require.Len(t, backlog, 3)
+	require.Equal(t, feed.epoch, backlog[0].Epoch)
+}
+
+func TestUsageFeed_History(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	for i := 0; i < usageFeedHistory+10; i++ {
+		feed.flush(time.Now())
+	}
+	backlog, _, cancel := feed.subscribe(feed.epoch, 0)
+	cancel()
+	require.Len(t, backlog, usageFeedHistory)
+	// The consumer can see the gap from the sequence numbers.
+	require.Equal(t, uint64(11), backlog[0].Seq)
+}
+
+func TestUsageFeed_SlowConsumer(t *testing.T) {
+	feed := newUsageFeed(time.Hour)
+	_, batches, cancel := feed.subscribe(feed.epoch, 0)
+	defer cancel()
+	for i := 0; i < usageFeedBuffer+1; i++ {
+		feed.flush(time.Now())
+	}
+	n := 0
+	for range batches {
+		n++
+	}
+	require.Equal(t, usageFeedBuffer, n, "Channel should be closed after the buffered batches")
+
+	_, batches, cancel = feed.subscribe(feed.epoch, 0)
+	defer cancel()
+	feed.stop()
+	_, ok := <-batches
+	require.False(t, ok, "Stop should end the subscriptions")
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+)
+
+// UsageDayLayout is the format of the days of the usage records, in UTC.
+const UsageDayLayout = "2006-01-02"
+
+const defaultUsageFlushInterval = time.Minute
+
+// UsageRecord is the usage of an access key over one protocol during a day.
+// Like TransferredBytes, TCP bytes are counted while the connections are open.
+type UsageRecord struct {
+	Day       string `json:"day"`
+	AccessKey string `json:"access_key"`
+	// Proto is "tcp" or "udp".
+	Proto string `json:"proto"`
+	// Up is the bytes sent to the targets, and Down the bytes sent back to
+	// the client.
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+	// Connections is the number of TCP connections that closed, and Packets
+	// the number of UDP packets from the client.
+	Connections int64 `json:"connections"`
+	Packets     int64 `json:"packets"`
+}
+
+type usageRecordKey struct {
+	day       string
+	accessKey string
+	proto     string
+}
+
+// usageFile is the content of a usage store file.
+type usageFile struct {
+	Records []UsageRecord `json:"records"`
+	// PrunedBytes is the bytes transferred by each access key in the days
+	// that are no longer kept, counted like TransferredBytes.
+	PrunedBytes map[string]int64 `json:"pruned_bytes,omitempty"`
+}
+
+// usageStore keeps the daily usage of the access keys in a file, so that it
+// survives restarts and can be queried by billing period.  Days older than
+// the retention are removed, and only their bytes are kept, so that the
+// data limits still count them.
+type usageStore struct {
+	path     string
+	interval time.Duration
+	// The days kept.  Zero keeps all of them.
+	retentionDays int
+	logger        *log.Logger
+	// Serializes the saves, so that the last one writes the latest records.
+	saveMu  sync.Mutex
+	mu      sync.Mutex
+	records map[usageRecordKey]*UsageRecord
+	// The bytes of each access key in the removed days.
+	pruned map[string]int64
+	// Whether there are changes since the last save.
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+	now     func() time.Time
+}
+
+// newUsageStore returns a store that keeps `retentionDays` days of usage, or
+// all of them if it's zero.
+func newUsageStore(path string, interval time.Duration, retentionDays int, logger *log.Logger) *usageStore {
+	if interval <= 0 {
+		interval = defaultUsageFlushInterval
+	}
+	return &usageStore{
+		path:          path,
+		interval:      interval,
+		retentionDays: retentionDays,
+		logger:        logger,
+		records:       make(map[usageRecordKey]*UsageRecord),
+		pruned:        make(map[string]int64),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		now:           time.Now,
+	}
+}
+
+// load reads the records saved by a previous run.  A missing file is not an
+// error.
+func (s *usageStore) load() error {
+	file, err := readUsageFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range file.Records {
+		r := file.Records[i]
+		s.records[usageRecordKey{r.Day, r.AccessKey, r.Proto}] = &r
+	}
+	for k, v := range file.PrunedBytes {
+		s.pruned[k] += v
+	}
+	s.pruneLocked()
+	return nil
+}
+
+// Removes the records older than the retention, and adds their bytes to
+// s.pruned.  s.mu must be held.
+func (s *usageStore) pruneLocked() {
+	if s.retentionDays <= 0 {
+		return
+	}
+	oldest := s.now().UTC().AddDate(0, 0, 1-s.retentionDays).Format(UsageDayLayout)
+	for key, r := range s.records {
+		if r.Day < oldest {
+			s.pruned[r.AccessKey] += r.Up + r.Down
+			delete(s.records, key)
+			s.dirty = true
+		}
+	}
+}
+
+// Adds `delta` to the record of the current day.
+func (s *usageStore) add(delta UsageDelta) {
+	if delta.AccessKey == "" {
+		return
+	}
+	key := usageRecordKey{s.now().UTC().Format(UsageDayLayout), delta.AccessKey, delta.Proto}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key]
+	if !ok {
+		r = &UsageRecord{Day: key.day, AccessKey: key.accessKey, Proto: key.proto}
+		s.records[key] = r
+	}
+	r.Up += delta.ProxyTarget
+	r.Down += delta.ProxyClient
+	r.Connections += delta.Connections
+	r.Packets += delta.Packets
+	s.dirty = true
+}
+
+// query returns the records of the days from `from` to `to`, inclusive, in
+// UsageDayLayout.  Empty bounds and an empty `accessKey` match everything.
+func (s *usageStore) query(from, to, accessKey string) []UsageRecord {
+	s.mu.Lock()
+	records := make([]UsageRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, *r)
+	}
+	s.mu.Unlock()
+	return FilterUsage(records, from, to, accessKey)
+}
+
+// totals returns the bytes transferred by each access key over all the
+// days, including the removed ones, counted like TransferredBytes.
+func (s *usageStore) totals() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[string]int64, len(s.pruned))
+	for k, v := range s.pruned {
+		totals[k] = v
+	}
+	for _, r := range s.records {
+		totals[r.AccessKey] += r.Up + r.Down
+	}
+	return totals
+}
+
+// FilterUsage returns the records of the days from `from` to `to`, inclusive,
+// sorted by day, access key and protocol.  Empty bounds and an empty
+// `accessKey` match everything.
+func FilterUsage(records []UsageRecord, from, to, accessKey string) []UsageRecord {
+	filtered := make([]UsageRecord, 0, len(records))
+	for _, r := range records {
+		if (from != "" && r.Day < from) || (to != "" && r.Day > to) || (accessKey != "" && r.AccessKey != accessKey) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.AccessKey != b.AccessKey {
+			return a.AccessKey < b.AccessKey
+		}
+		return a.Proto < b.Proto
+	})
+	return filtered
+}
+
+// save removes the records older than the retention, and atomically replaces
+// the file with the rest, if they changed.
+func (s *usageStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	s.pruneLocked()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	records := make([]UsageRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, *r)
+	}
+	pruned := make(map[string]int64, len(s.pruned))
+	for k, v := range s.pruned {
+		pruned[k] = v
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	err := writeUsageFile(s.path, usageFile{Records: FilterUsage(records, "", "", ""), PrunedBytes: pruned})
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		s.logger.Error("Failed to save usage", log.String("path", s.path), log.Err(err))
+	}
+	return err
+}
+
+func writeUsageFile(path string, file usageFile) error {
+	if file.Records == nil {
+		file.Records = []UsageRecord{}
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *usageStore) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stop stops the periodic saves, waits for run to return, and saves the last
+// changes.
+func (s *usageStore) stop() error {
+	close(s.done)
+	<-s.stopped
+	return s.save()
+}
+
+// ReadUsageFile reads the records of a usage store file.
+func ReadUsageFile(path string) ([]UsageRecord, error) {
+	file, err := readUsageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.Records, nil
+}
+
+// readUsageFile reads a usage store file.
+func readUsageFile(path string) (usageFile, error) {
+	var file usageFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("failed to parse usage file %v: %w", path, err)
+	}
+	return file, nil
+}
+
+// WriteUsageJSON writes the records as a JSON array.
+func WriteUsageJSON(w io.Writer, records []UsageRecord) error {
+	if records == nil {
+		records = []UsageRecord{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// WriteUsageCSV writes the records as CSV, with a header row.
+func WriteUsageCSV(w io.Writer, records []UsageRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"day", "access_key", "proto", "up", "down", "connections", "packets"})
+	for _, r := range records {
+		cw.Write([]string{
+			r.Day,
+			r.AccessKey,
+			r.Proto,
+			strconv.FormatInt(r.Up, 10),
+			strconv.FormatInt(r.Down, 10),
+			strconv.FormatInt(r.Connections, 10),
+			strconv.FormatInt(r.Packets, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	logger := log.Default()
+	store := newUsageStore(path, time.Hour, 0, logger)
+	require.NoError(t, store.load())
+	go store.run()
+	m := newUsageMetrics(&metrics.NoOpMetrics{}, newUsageFeed(time.Hour))
+	m.store = store
+
+	store.now = func() time.Time { return time.Date(2026, 10, 1, 23, 0, 0, 0, time.UTC) }
+	m.AddTCPData("key1", metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4})
+	m.AddClosedTCPConnection(metrics.ClientInfo{}, "key1", "OK", metrics.ProxyMetrics{ClientProxy: 1, ProxyTarget: 2, TargetProxy: 3, ProxyClient: 4}, 0, 0)
+	m.AddUDPPacketFromClient(metrics.ClientInfo{}, "key1", "OK", 5, 4, 0)
+	m.AddUDPPacketFromTarget(metrics.ClientInfo{}, "key1", "OK", 7, 8)
+	m.AddUDPPacketFromClient(metrics.ClientInfo{}, "", "ERR_CIPHER", 5, 0, 0)
+	store.now = func() time.Time { return time.Date(2026, 10, 2, 1, 0, 0, 0, time.UTC) }
+	m.AddTCPData("key1", metrics.ProxyMetrics{ProxyTarget: 10, ProxyClient: 20})
+	m.AddClosedTCPConnection(metrics.ClientInfo{}, "key1", "OK", metrics.ProxyMetrics{ProxyTarget: 10, ProxyClient: 20}, 0, 0)
+	m.AddTCPData("key2", metrics.ProxyMetrics{ProxyTarget: 1})
+	m.AddClosedTCPConnection(metrics.ClientInfo{}, "key2", "OK", metrics.ProxyMetrics{ProxyTarget: 1}, 0, 0)
+
+	day1 := []UsageRecord{
+		{Day: "2026-10-01", AccessKey: "key1", Proto: "tcp", Up: 2, Down: 4, Connections: 1},
+		{Day: "2026-10-01", AccessKey: "key1", Proto: "udp", Up: 4, Down: 8, Packets: 1},
+	}
+	require.Equal(t, day1, store.query("", "2026-10-01", ""))
+	require.Equal(t, []UsageRecord{
+		{Day: "2026-10-02", AccessKey: "key1", Proto: "tcp", Up: 10, Down: 20, Connections: 1},
+	}, store.query("2026-10-02", "2026-10-31", "key1"))
+	require.Len(t, store.query("", "", ""), 4)
+
+	// The records survive a restart.
+	require.NoError(t, store.stop())
+	store = newUsageStore(path, time.Hour, 0, logger)
+	require.NoError(t, store.load())
+	require.Equal(t, day1, store.query("2026-10-01", "2026-10-01", ""))
+	records, err := ReadUsageFile(path)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+}
+
+func TestUsageStore_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	require.NoError(t, writeUsageFile(path, usageFile{Records: []UsageRecord{
+		{Day: "2026-09-01", AccessKey: "key1", Proto: "tcp", Up: 1, Down: 2},
+		{Day: "2026-10-01", AccessKey: "key1", Proto: "tcp", Up: 10, Down: 20},
+	}}))
+	store := newUsageStore(path, time.Hour, 30, log.Default())
+	store.now = func() time.Time { return time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC) }
+	require.NoError(t, store.load())
+	go store.run()
+	require.Equal(t, []UsageRecord{
+		{Day: "2026-10-01", AccessKey: "key1", Proto: "tcp", Up: 10, Down: 20},
+	}, store.query("", "", ""))
+	// The removed days still count.
+	require.Equal(t, map[string]int64{"key1": 33}, store.totals())
+
+	// Days are removed as they get older than the retention.
+	store.now = func() time.Time { return time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC) }
+	require.NoError(t, store.stop())
+	require.Empty(t, store.query("", "", ""))
+	store = newUsageStore(path, time.Hour, 30, log.Default())
+	require.NoError(t, store.load())
+	require.Equal(t, map[string]int64{"key1": 33}, store.totals())
+	records, err := ReadUsageFile(path)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+func TestWriteUsage(t *testing.T) {
+	records := []UsageRecord{{Day: "2026-10-01", AccessKey: "key1", Proto: "tcp", Up: 2, Down: 4, Connections: 1}}
+	var buf bytes.Buffer
+	require.NoError(t, WriteUsageCSV(&buf, records))
+	require.Equal(t, "day,access_key,proto,up,down,connections,packets\n2026-10-01,key1,tcp,2,4,1,0\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteUsageJSON(&buf, nil))
+	require.JSONEq(t, `[]`, buf.String())
+	buf.Reset()
+	require.NoError(t, WriteUsageJSON(&buf, records))
+	require.JSONEq(t, `[{"day": "2026-10-01", "access_key": "key1", "proto": "tcp", "up": 2, "down": 4, "connections": 1, "packets": 0}]`, buf.String())
+}
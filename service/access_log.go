@@ -0,0 +1,105 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+// AccessLogEntry describes a finished TCP connection or UDP NAT entry.
+type AccessLogEntry struct {
+	// Proto is "tcp" or "udp".
+	Proto string
+	// AccessKey is empty if the client didn't authenticate.
+	AccessKey      string
+	Port           int
+	ClientAddr     net.Addr
+	ClientLocation string
+	// ClientASN and ClientASOrganization are zero without an IP-ASN database.
+	ClientASN            int
+	ClientASOrganization string
+	// Target is the first target address sent by the client, which may be a
+	// domain name.  Empty if the client didn't send one.
+	Target string
+	// Status is "OK" or the status of the ConnectionError that ended the
+	// connection, like in the metrics.
+	Status string
+	// The bytes in each direction.
+	Data metrics.ProxyMetrics
+	// The UDP packets in each direction.  Zero for TCP.
+	PacketsFromClient int64
+	PacketsFromTarget int64
+	Start             time.Time
+	Duration          time.Duration
+	// TimeToCipher is the time spent finding the access key.  Zero for UDP.
+	TimeToCipher time.Duration
+}
+
+// AccessLog receives an entry for every finished TCP connection and UDP NAT
+// entry.  Log is called from the relay paths, so it must not block.
+type AccessLog interface {
+	Log(entry AccessLogEntry)
+}
+
+// The stats of a NAT entry for the access log.  The upstream side is updated
+// by Serve and the downstream side by timedCopy, so they are protected by mu.
+type natStats struct {
+	mu                sync.Mutex
+	target            string
+	clientProxy       int64
+	proxyTarget       int64
+	packetsFromClient int64
+	targetProxy       int64
+	proxyClient       int64
+	packetsFromTarget int64
+}
+
+func (s *natStats) addFromClient(target net.Addr, clientProxyBytes, proxyTargetBytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target == "" {
+		s.target = target.String()
+	}
+	s.clientProxy += int64(clientProxyBytes)
+	s.proxyTarget += int64(proxyTargetBytes)
+	s.packetsFromClient++
+}
+
+func (s *natStats) addFromTarget(targetProxyBytes, proxyClientBytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targetProxy += int64(targetProxyBytes)
+	s.proxyClient += int64(proxyClientBytes)
+	s.packetsFromTarget++
+}
+
+// Fills the target, data and packets of `entry`.
+func (s *natStats) fill(entry *AccessLogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.Target = s.target
+	entry.Data = metrics.ProxyMetrics{
+		ClientProxy: s.clientProxy,
+		ProxyTarget: s.proxyTarget,
+		TargetProxy: s.targetProxy,
+		ProxyClient: s.proxyClient,
+	}
+	entry.PacketsFromClient = s.packetsFromClient
+	entry.PacketsFromTarget = s.packetsFromTarget
+}
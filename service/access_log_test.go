@@ -0,0 +1,135 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+)
+
+type testAccessLog struct {
+	mu      sync.Mutex
+	entries []AccessLogEntry
+}
+
+func (l *testAccessLog) Log(entry AccessLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+func TestTCPAccessLog(t *testing.T) {
+	targetListener, targetRunning := startDiscardServer(t)
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
+	s := NewTCPService(cipherList, nil, &probeTestMetrics{}, 200*time.Millisecond, nil)
+	s.SetTargetIPValidator(allowAll)
+	accessLog := &testAccessLog{}
+	s.SetAccessLog(accessLog)
+	go s.Serve(listener)
+
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	clientBytes := makeClientBytesBasic(t, entry.Cipher, targetListener.Addr().String())
+	_, err = conn.Write(clientBytes)
+	require.Nil(t, err)
+	conn.CloseWrite()
+	// The proxy closes the connection once the target closes it.
+	io.Copy(ioutil.Discard, conn)
+	conn.Close()
+	s.GracefulStop()
+	targetListener.Close()
+	targetRunning.Wait()
+
+	require.Len(t, accessLog.entries, 1)
+	logged := accessLog.entries[0]
+	require.Equal(t, "tcp", logged.Proto)
+	require.Equal(t, entry.ID, logged.AccessKey)
+	require.Equal(t, listener.Addr().(*net.TCPAddr).Port, logged.Port)
+	require.Equal(t, conn.LocalAddr().String(), logged.ClientAddr.String())
+	require.Equal(t, targetListener.Addr().String(), logged.Target)
+	require.Equal(t, "OK", logged.Status)
+	require.Equal(t, int64(len(clientBytes)), logged.Data.ClientProxy)
+	require.Equal(t, int64(100), logged.Data.ProxyTarget)
+	require.Greater(t, logged.Duration, time.Duration(0))
+}
+
+func TestUDPAccessLog(t *testing.T) {
+	// A target that echoes the packets.
+	targetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer targetConn.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := targetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			targetConn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := NewUDPService(time.Minute, cipherList, &metrics.NoOpMetrics{}, nil)
+	s.SetTargetIPValidator(allowAll)
+	accessLog := &testAccessLog{}
+	s.SetAccessLog(accessLog)
+	go s.Serve(serverConn)
+
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer clientConn.Close()
+	plaintext := append(socks.ParseAddr(targetConn.LocalAddr().String()), make([]byte, 10)...)
+	packet, err := ss.Pack(make([]byte, serverUDPBufferSize), plaintext, entry.Cipher)
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = clientConn.WriteTo(packet, serverConn.LocalAddr())
+		require.Nil(t, err)
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = clientConn.ReadFrom(make([]byte, serverUDPBufferSize))
+		require.Nil(t, err)
+	}
+	s.GracefulStop()
+
+	require.Len(t, accessLog.entries, 1)
+	logged := accessLog.entries[0]
+	require.Equal(t, "udp", logged.Proto)
+	require.Equal(t, entry.ID, logged.AccessKey)
+	require.Equal(t, serverConn.LocalAddr().(*net.UDPAddr).Port, logged.Port)
+	require.Equal(t, clientConn.LocalAddr().String(), logged.ClientAddr.String())
+	require.Equal(t, targetConn.LocalAddr().String(), logged.Target)
+	require.Equal(t, "OK", logged.Status)
+	require.Equal(t, int64(2), logged.PacketsFromClient)
+	require.Equal(t, int64(2), logged.PacketsFromTarget)
+	require.Equal(t, int64(2*len(packet)), logged.Data.ClientProxy)
+	require.Equal(t, int64(20), logged.Data.ProxyTarget)
+	require.Equal(t, int64(20), logged.Data.TargetProxy)
+}
@@ -0,0 +1,410 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+// How often expired bans and stale failure records are discarded.
+const banSweepInterval = 10 * time.Second
+
+// Ban scopes, reported to metrics and in BanEntry.Scope.
+const (
+	BanScopeIP     = "ip"
+	BanScopeSubnet = "subnet"
+	BanScopeManual = "manual"
+)
+
+// BanConfig controls when a BanList bans a source automatically.
+// The zero value disables automatic bans, but manual bans still work.
+type BanConfig struct {
+	// Window is the sliding window over which authentication failures are counted.
+	Window time.Duration
+	// MaxFailures is the number of failures from a single IP within Window that
+	// triggers a ban of that IP.  Zero disables automatic bans.
+	MaxFailures int
+	// MaxSubnetFailures is the number of failures from a single subnet within
+	// Window that triggers a ban of the whole subnet.  Zero disables subnet bans.
+	MaxSubnetFailures int
+	// SubnetBitsIPv4 and SubnetBitsIPv6 are the prefix lengths used to group
+	// addresses into subnets, e.g. 24 and 64.
+	SubnetBitsIPv4 int
+	SubnetBitsIPv6 int
+	// BanDuration is the length of the first ban.  Every repeated ban of the
+	// same source doubles the duration, up to MaxBanDuration.
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+}
+
+// BanEntry describes an active ban.
+type BanEntry struct {
+	Subnet *net.IPNet
+	Scope  string
+	// Reason is the status of the failure that triggered the ban, or the
+	// operator-provided reason for manual bans.
+	Reason string
+	Since  time.Time
+	Until  time.Time
+	// Offenses is the number of times this source has been banned recently.
+	Offenses int
+}
+
+// BanList tracks authentication failures per source and bans sources that
+// fail too often.  It is safe for concurrent use.
+//
+// Failures are only reported for TCP, where the source address has completed a
+// handshake and can't be spoofed, but bans apply to both TCP and UDP.
+type BanList interface {
+	// IsBanned reports whether `ip` is covered by an active ban.
+	IsBanned(ip net.IP) bool
+	// AddFailure records an authentication failure with `status` from `ip`, and
+	// bans the IP or its subnet if a threshold is crossed.
+	AddFailure(ip net.IP, status string)
+	// Ban bans `subnet` for `duration`, replacing any existing ban of the same subnet.
+	Ban(subnet *net.IPNet, duration time.Duration, reason string) BanEntry
+	// Unban lifts the ban on exactly `subnet` and forgets its history.
+	// Returns false if there was no such ban.
+	Unban(subnet *net.IPNet) bool
+	// List returns the active bans, soonest expiry first.
+	List() []BanEntry
+	// SetBanHook sets a function that is called with every new ban, automatic
+	// or manual.  The function must not block.
+	SetBanHook(hook func(BanEntry))
+	// SetIPPrivacy sets how the banned subnets appear in the logs.  The bans
+	// themselves and List are not affected.
+	SetIPPrivacy(privacy *IPPrivacy)
+	// Close stops the background sweeper.  It may be called more than once.
+	Close() error
+}
+
+// Per-source state.  A record exists while the source has recent failures,
+// an active ban, or a ban history that still affects the back-off.
+type banRecord struct {
+	subnet   *net.IPNet
+	scope    string
+	failures []time.Time
+	offenses int
+	reason   string
+	since    time.Time
+	until    time.Time
+	// Whether this record is included in banList.active.
+	counted bool
+}
+
+func (r *banRecord) isBanned(now time.Time) bool {
+	return now.Before(r.until)
+}
+
+func (r *banRecord) entry() BanEntry {
+	return BanEntry{
+		Subnet:   r.subnet,
+		Scope:    r.scope,
+		Reason:   r.reason,
+		Since:    r.since,
+		Until:    r.until,
+		Offenses: r.offenses,
+	}
+}
+
+type banList struct {
+	config  BanConfig
+	m       metrics.ShadowsocksMetrics
+	mu      sync.RWMutex // Protects records, masks, hook and privacy.
+	records map[string]*banRecord
+	// Masks of all the bans that have been added, used to find the candidate
+	// records for an IP.
+	masks map[string]net.IPMask
+	// Number of active bans, so that IsBanned can skip the lock in the common case.
+	active    int32
+	done      chan struct{}
+	closeOnce sync.Once
+	now       func() time.Time
+	// Called with every new ban.  May be nil.
+	hook func(BanEntry)
+	// How the banned subnets appear in the logs.
+	privacy *IPPrivacy
+	logger  *log.Logger
+}
+
+// NewBanList creates a BanList with the given policy.  `m` receives the ban
+// metrics, and `logger` the bans.
+func NewBanList(config BanConfig, m metrics.ShadowsocksMetrics, logger *log.Logger) BanList {
+	bl := newBanList(config, m, time.Now)
+	bl.logger = logger
+	go bl.sweepLoop()
+	return bl
+}
+
+func newBanList(config BanConfig, m metrics.ShadowsocksMetrics, now func() time.Time) *banList {
+	if config.SubnetBitsIPv4 == 0 {
+		config.SubnetBitsIPv4 = 24
+	}
+	if config.SubnetBitsIPv6 == 0 {
+		config.SubnetBitsIPv6 = 64
+	}
+	if config.MaxBanDuration < config.BanDuration {
+		config.MaxBanDuration = config.BanDuration
+	}
+	return &banList{
+		config:  config,
+		m:       m,
+		records: make(map[string]*banRecord),
+		masks:   make(map[string]net.IPMask),
+		done:    make(chan struct{}),
+		now:     now,
+	}
+}
+
+// ParseBanSubnet parses an IP address or a CIDR.  A bare IP address is treated
+// as a single-address subnet.
+func ParseBanSubnet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return subnet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address %q", s)
+	}
+	return singleIPNet(ip), nil
+}
+
+func singleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+func (bl *banList) subnetFor(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(bl.config.SubnetBitsIPv4, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(bl.config.SubnetBitsIPv6, 128)
+	return &net.IPNet{IP: ip.To16().Mask(mask), Mask: mask}
+}
+
+func (bl *banList) IsBanned(ip net.IP) bool {
+	if ip == nil || atomic.LoadInt32(&bl.active) == 0 {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	now := bl.now()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	for _, mask := range bl.masks {
+		if len(mask) != len(ip) {
+			continue
+		}
+		subnet := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		if r, ok := bl.records[subnet.String()]; ok && r.isBanned(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bl *banList) AddFailure(ip net.IP, status string) {
+	if ip == nil || bl.config.MaxFailures <= 0 {
+		return
+	}
+	now := bl.now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.addFailureLocked(singleIPNet(ip), BanScopeIP, bl.config.MaxFailures, status, now)
+	if bl.config.MaxSubnetFailures > 0 {
+		bl.addFailureLocked(bl.subnetFor(ip), BanScopeSubnet, bl.config.MaxSubnetFailures, status, now)
+	}
+}
+
+func (bl *banList) addFailureLocked(subnet *net.IPNet, scope string, maxFailures int, status string, now time.Time) {
+	key := subnet.String()
+	r, ok := bl.records[key]
+	if !ok {
+		r = &banRecord{subnet: subnet, scope: scope}
+		bl.records[key] = r
+	}
+	if r.isBanned(now) {
+		return
+	}
+	r.failures = append(trimFailures(r.failures, now.Add(-bl.config.Window)), now)
+	if len(r.failures) < maxFailures {
+		return
+	}
+	// Forget old offenses so that the back-off eventually resets.
+	if !r.until.IsZero() && now.Sub(r.until) > bl.config.MaxBanDuration {
+		r.offenses = 0
+	}
+	r.offenses++
+	bl.banLocked(r, scope, bl.backoff(r.offenses), status, now)
+	bl.logger.Info("Banned", log.String("subnet", bl.privacy.Subnet(subnet)), log.Duration("duration", r.until.Sub(now)), log.Int("failures", len(r.failures)), log.String("status", status))
+	r.failures = nil
+}
+
+// Drops the failures that happened before `start`.
+func trimFailures(failures []time.Time, start time.Time) []time.Time {
+	i := 0
+	for i < len(failures) && failures[i].Before(start) {
+		i++
+	}
+	return failures[i:]
+}
+
+func (bl *banList) backoff(offenses int) time.Duration {
+	d := bl.config.BanDuration
+	for i := 1; i < offenses && d < bl.config.MaxBanDuration; i++ {
+		d *= 2
+	}
+	if d > bl.config.MaxBanDuration {
+		d = bl.config.MaxBanDuration
+	}
+	return d
+}
+
+func (bl *banList) banLocked(r *banRecord, scope string, duration time.Duration, reason string, now time.Time) {
+	if !r.counted {
+		r.counted = true
+		bl.m.SetNumActiveBans(int(atomic.AddInt32(&bl.active, 1)))
+	}
+	r.scope = scope
+	r.reason = reason
+	r.since = now
+	r.until = now.Add(duration)
+	bl.masks[r.subnet.Mask.String()] = r.subnet.Mask
+	bl.m.AddBan(scope)
+	if bl.hook != nil {
+		bl.hook(r.entry())
+	}
+}
+
+func (bl *banList) SetBanHook(hook func(BanEntry)) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.hook = hook
+}
+
+func (bl *banList) SetIPPrivacy(privacy *IPPrivacy) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.privacy = privacy
+}
+
+func (bl *banList) Ban(subnet *net.IPNet, duration time.Duration, reason string) BanEntry {
+	now := bl.now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	key := subnet.String()
+	r, ok := bl.records[key]
+	if !ok {
+		r = &banRecord{subnet: subnet}
+		bl.records[key] = r
+	}
+	r.offenses++
+	bl.banLocked(r, BanScopeManual, duration, reason, now)
+	bl.logger.Info("Banned", log.String("subnet", bl.privacy.Subnet(subnet)), log.Duration("duration", duration), log.String("reason", reason))
+	return r.entry()
+}
+
+func (bl *banList) Unban(subnet *net.IPNet) bool {
+	now := bl.now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	key := subnet.String()
+	r, ok := bl.records[key]
+	if !ok {
+		return false
+	}
+	delete(bl.records, key)
+	if r.counted {
+		bl.m.SetNumActiveBans(int(atomic.AddInt32(&bl.active, -1)))
+	}
+	if !r.isBanned(now) {
+		return false
+	}
+	bl.logger.Info("Unbanned", log.String("subnet", bl.privacy.Subnet(subnet)))
+	return true
+}
+
+func (bl *banList) List() []BanEntry {
+	now := bl.now()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	var bans []BanEntry
+	for _, r := range bl.records {
+		if r.isBanned(now) {
+			bans = append(bans, r.entry())
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+	return bans
+}
+
+// Discards expired bans and records that no longer affect any decision.
+func (bl *banList) sweep() {
+	now := bl.now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	active := 0
+	for key, r := range bl.records {
+		if r.isBanned(now) {
+			active++
+			continue
+		}
+		r.counted = false
+		r.failures = trimFailures(r.failures, now.Add(-bl.config.Window))
+		historyExpired := r.until.IsZero() || now.Sub(r.until) > bl.config.MaxBanDuration
+		if len(r.failures) == 0 && historyExpired {
+			delete(bl.records, key)
+		}
+	}
+	atomic.StoreInt32(&bl.active, int32(active))
+	bl.m.SetNumActiveBans(active)
+}
+
+func (bl *banList) sweepLoop() {
+	ticker := time.NewTicker(banSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bl.sweep()
+		case <-bl.done:
+			return
+		}
+	}
+}
+
+func (bl *banList) Close() error {
+	bl.closeOnce.Do(func() { close(bl.done) })
+	return nil
+}
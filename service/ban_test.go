@@ -0,0 +1,182 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+// Fake clock for testing time-dependent behavior.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+type banTestMetrics struct {
+	metrics.NoOpMetrics
+	bans       map[string]int
+	activeBans int
+}
+
+func (m *banTestMetrics) AddBan(scope string) {
+	m.bans[scope]++
+}
+
+func (m *banTestMetrics) SetNumActiveBans(numBans int) {
+	m.activeBans = numBans
+}
+
+func makeTestBanList(config BanConfig) (*banList, *fakeClock, *banTestMetrics) {
+	clock := &fakeClock{t: time.Unix(1_000_000, 0)}
+	m := &banTestMetrics{bans: make(map[string]int)}
+	return newBanList(config, m, clock.now), clock, m
+}
+
+var testBanConfig = BanConfig{
+	Window:         time.Minute,
+	MaxFailures:    3,
+	BanDuration:    time.Minute,
+	MaxBanDuration: 4 * time.Minute,
+}
+
+func TestBanList_Threshold(t *testing.T) {
+	bl, _, m := makeTestBanList(testBanConfig)
+	ip := net.ParseIP("192.0.2.1")
+	bl.AddFailure(ip, "ERR_CIPHER")
+	bl.AddFailure(ip, "ERR_CIPHER")
+	require.False(t, bl.IsBanned(ip))
+	bl.AddFailure(ip, "ERR_REPLAY_CLIENT")
+	require.True(t, bl.IsBanned(ip))
+	require.False(t, bl.IsBanned(net.ParseIP("192.0.2.2")))
+	require.Equal(t, 1, m.bans[BanScopeIP])
+	require.Equal(t, 1, m.activeBans)
+
+	bans := bl.List()
+	require.Len(t, bans, 1)
+	require.Equal(t, "192.0.2.1/32", bans[0].Subnet.String())
+	require.Equal(t, "ERR_REPLAY_CLIENT", bans[0].Reason)
+	require.Equal(t, time.Minute, bans[0].Until.Sub(bans[0].Since))
+}
+
+func TestBanList_SlidingWindow(t *testing.T) {
+	bl, clock, _ := makeTestBanList(testBanConfig)
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 10; i++ {
+		bl.AddFailure(ip, "ERR_CIPHER")
+		clock.advance(40 * time.Second)
+	}
+	require.False(t, bl.IsBanned(ip), "Failures spread beyond the window should not trigger a ban")
+}
+
+func TestBanList_Backoff(t *testing.T) {
+	bl, clock, _ := makeTestBanList(testBanConfig)
+	ip := net.ParseIP("2001:db8::1")
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute}
+	for _, duration := range expected {
+		for i := 0; i < testBanConfig.MaxFailures; i++ {
+			bl.AddFailure(ip, "ERR_CIPHER")
+		}
+		require.True(t, bl.IsBanned(ip))
+		clock.advance(duration - time.Second)
+		require.True(t, bl.IsBanned(ip))
+		clock.advance(time.Second)
+		require.False(t, bl.IsBanned(ip))
+	}
+
+	// A long period of good behavior resets the back-off.
+	clock.advance(testBanConfig.MaxBanDuration + time.Second)
+	for i := 0; i < testBanConfig.MaxFailures; i++ {
+		bl.AddFailure(ip, "ERR_CIPHER")
+	}
+	bans := bl.List()
+	require.Len(t, bans, 1)
+	require.Equal(t, time.Minute, bans[0].Until.Sub(bans[0].Since))
+}
+
+func TestBanList_Subnet(t *testing.T) {
+	config := testBanConfig
+	config.MaxFailures = 100
+	config.MaxSubnetFailures = 3
+	bl, _, m := makeTestBanList(config)
+	for i := 1; i <= 3; i++ {
+		bl.AddFailure(net.IPv4(192, 0, 2, byte(i)), "ERR_CIPHER")
+	}
+	require.True(t, bl.IsBanned(net.ParseIP("192.0.2.200")))
+	require.False(t, bl.IsBanned(net.ParseIP("192.0.3.1")))
+	require.Equal(t, 1, m.bans[BanScopeSubnet])
+}
+
+func TestBanList_Manual(t *testing.T) {
+	bl, clock, m := makeTestBanList(BanConfig{})
+	subnet, err := ParseBanSubnet("2001:db8::/32")
+	require.NoError(t, err)
+	bl.Ban(subnet, time.Hour, "abuse report")
+	require.True(t, bl.IsBanned(net.ParseIP("2001:db8:1::1")))
+	require.False(t, bl.IsBanned(net.ParseIP("2001:db9::1")))
+	require.Equal(t, 1, m.activeBans)
+
+	require.True(t, bl.Unban(subnet))
+	require.False(t, bl.IsBanned(net.ParseIP("2001:db8:1::1")))
+	require.False(t, bl.Unban(subnet))
+	require.Equal(t, 0, m.activeBans)
+
+	// Automatic bans are disabled in the zero config.
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 100; i++ {
+		bl.AddFailure(ip, "ERR_CIPHER")
+	}
+	require.False(t, bl.IsBanned(ip))
+
+	single, err := ParseBanSubnet("192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.1/32", single.String())
+	bl.Ban(single, time.Minute, "")
+	clock.advance(2 * time.Minute)
+	bl.sweep()
+	require.Equal(t, 0, m.activeBans)
+	require.Empty(t, bl.List())
+
+	_, err = ParseBanSubnet("not an ip")
+	require.Error(t, err)
+}
+
+func TestBanList_CloseTwice(t *testing.T) {
+	bl := NewBanList(testBanConfig, &metrics.NoOpMetrics{}, nil)
+	require.NoError(t, bl.Close())
+	require.NoError(t, bl.Close())
+}
+
+func BenchmarkBanList_IsBanned(b *testing.B) {
+	bl, _, _ := makeTestBanList(testBanConfig)
+	subnet, _ := ParseBanSubnet("198.51.100.0/24")
+	bl.Ban(subnet, time.Hour, "")
+	ip := net.ParseIP("192.0.2.1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bl.IsBanned(ip)
+	}
+}
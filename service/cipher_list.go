@@ -18,6 +18,7 @@ import (
 	"container/list"
 	"net"
 	"sync"
+	"time"
 
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
 )
@@ -26,12 +27,29 @@ import (
 const minSaltEntropy = 16
 
 // CipherEntry holds a Cipher with an identifier.
-// The public fields are constant, but lastClientIP is mutable under cipherList.mu.
+// The public fields are constant, but lastClientIP and lastSeen are mutable
+// under cipherList.mu.
 type CipherEntry struct {
 	ID            string
 	Cipher        *ss.Cipher
+	Secret        string
 	SaltGenerator ServerSaltGenerator
+	createdAt     time.Time
 	lastClientIP  net.IP
+	lastSeen      time.Time
+}
+
+// CipherEntryInfo describes a CipherEntry at the time of a snapshot.
+type CipherEntryInfo struct {
+	ID     string
+	Cipher string
+	Secret string
+	// CreatedAt is when the entry was first added.
+	CreatedAt time.Time
+	// LastSeen is when the entry was last used by a client, or zero if it
+	// hasn't been used yet.
+	LastSeen     time.Time
+	LastClientIP net.IP
 }
 
 // MakeCipherEntry constructs a CipherEntry.
@@ -48,7 +66,9 @@ func MakeCipherEntry(id string, cipher *ss.Cipher, secret string) CipherEntry {
 	return CipherEntry{
 		ID:            id,
 		Cipher:        cipher,
+		Secret:        secret,
 		SaltGenerator: saltGenerator,
+		createdAt:     time.Now(),
 	}
 }
 
@@ -59,10 +79,17 @@ type CipherList interface {
 	SnapshotForClientIP(clientIP net.IP) []*list.Element
 	MarkUsedByClientIP(e *list.Element, clientIP net.IP)
 	// Update replaces the current contents of the CipherList with `contents`,
-	// which is a List of *CipherEntry.  Update takes ownership of `contents`,
-	// which must not be read or written after this call.
+	// which is a List of *CipherEntry.  Entries with the same ID and secret as
+	// a current entry keep its creation time and usage.  Update takes ownership
+	// of `contents`, which must not be read or written after this call.
 	Update(contents *list.List)
+	// Deprecated: GetList returns the internal list without synchronization.
+	// Use Entries or Len instead.
 	GetList() *list.List
+	// Entries returns a snapshot of the entries, most recently used first.
+	Entries() []CipherEntryInfo
+	// Len returns the number of entries.
+	Len() int
 	AddCipher(e *CipherEntry)
 	RemoveCipher(ID string)
 	IsCipherExists(ID string) bool
@@ -113,12 +140,26 @@ func (cl *cipherList) MarkUsedByClientIP(e *list.Element, clientIP net.IP) {
 
 	c := e.Value.(*CipherEntry)
 	c.lastClientIP = clientIP
+	c.lastSeen = time.Now()
 }
 
 func (cl *cipherList) Update(src *list.List) {
 	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	current := make(map[string]*CipherEntry, cl.list.Len())
+	for e := cl.list.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*CipherEntry)
+		current[c.ID] = c
+	}
+	for e := src.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*CipherEntry)
+		if old, ok := current[c.ID]; ok && old.Secret == c.Secret && old.Cipher.Name() == c.Cipher.Name() {
+			c.createdAt = old.createdAt
+			c.lastClientIP = old.lastClientIP
+			c.lastSeen = old.lastSeen
+		}
+	}
 	cl.list = src
-	cl.mu.Unlock()
 }
 
 func (cl *cipherList) GetList() *list.List {
@@ -127,6 +168,30 @@ func (cl *cipherList) GetList() *list.List {
 	return cl.list
 }
 
+func (cl *cipherList) Entries() []CipherEntryInfo {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	entries := make([]CipherEntryInfo, 0, cl.list.Len())
+	for e := cl.list.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*CipherEntry)
+		entries = append(entries, CipherEntryInfo{
+			ID:           c.ID,
+			Cipher:       c.Cipher.Name(),
+			Secret:       c.Secret,
+			CreatedAt:    c.createdAt,
+			LastSeen:     c.lastSeen,
+			LastClientIP: c.lastClientIP,
+		})
+	}
+	return entries
+}
+
+func (cl *cipherList) Len() int {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.list.Len()
+}
+
 func (cl *cipherList) AddCipher(e *CipherEntry) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
@@ -15,13 +15,69 @@
 package service
 
 import (
+	"container/list"
 	"math/rand"
 	"net"
 	"testing"
 
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+	"github.com/stretchr/testify/require"
 )
 
+func TestCipherList_Entries(t *testing.T) {
+	ciphers, err := MakeTestCiphers([]string{"secret0", "secret1"})
+	require.NoError(t, err)
+	require.Equal(t, 2, ciphers.Len())
+	entries := ciphers.Entries()
+	require.Len(t, entries, 2)
+	require.Equal(t, "id-0", entries[0].ID)
+	require.Equal(t, "chacha20-ietf-poly1305", entries[0].Cipher)
+	require.Equal(t, "secret0", entries[0].Secret)
+	require.False(t, entries[0].CreatedAt.IsZero())
+	require.True(t, entries[1].LastSeen.IsZero())
+	require.Nil(t, entries[1].LastClientIP)
+
+	clientIP := net.ParseIP("192.0.2.1")
+	ciphers.MarkUsedByClientIP(ciphers.SnapshotForClientIP(nil)[1], clientIP)
+	entries = ciphers.Entries()
+	require.Equal(t, "id-1", entries[0].ID, "Most recently used entry should be first")
+	require.False(t, entries[0].LastSeen.IsZero())
+	require.True(t, clientIP.Equal(entries[0].LastClientIP))
+}
+
+func TestCipherList_UpdateKeepsUsage(t *testing.T) {
+	ciphers, err := MakeTestCiphers([]string{"secret0", "secret1"})
+	require.NoError(t, err)
+	clientIP := net.ParseIP("192.0.2.1")
+	for _, e := range ciphers.SnapshotForClientIP(nil) {
+		ciphers.MarkUsedByClientIP(e, clientIP)
+	}
+	before := make(map[string]CipherEntryInfo)
+	for _, info := range ciphers.Entries() {
+		before[info.ID] = info
+	}
+
+	// id-0 is unchanged, and id-1 has a new secret.
+	contents := list.New()
+	for id, secret := range map[string]string{"id-0": "secret0", "id-1": "changed"} {
+		cipher, err := ss.NewCipher(ss.TestCipher, secret)
+		require.NoError(t, err)
+		entry := MakeCipherEntry(id, cipher, secret)
+		contents.PushBack(&entry)
+	}
+	ciphers.Update(contents)
+
+	after := make(map[string]CipherEntryInfo)
+	for _, info := range ciphers.Entries() {
+		after[info.ID] = info
+	}
+	require.Equal(t, before["id-0"].CreatedAt, after["id-0"].CreatedAt)
+	require.Equal(t, before["id-0"].LastSeen, after["id-0"].LastSeen)
+	require.True(t, clientIP.Equal(after["id-0"].LastClientIP))
+	require.True(t, after["id-1"].LastSeen.IsZero(), "Usage of a changed key should be reset")
+	require.Nil(t, after["id-1"].LastClientIP)
+}
+
 func BenchmarkLocking(b *testing.B) {
 	var ip net.IP
 
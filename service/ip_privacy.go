@@ -0,0 +1,197 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+)
+
+// IP privacy modes.
+const (
+	// IPPrivacyFull shows the client IPs as they are.
+	IPPrivacyFull = "full"
+	// IPPrivacyTruncate shows the /24 of IPv4 and the /48 of IPv6 addresses.
+	IPPrivacyTruncate = "truncate"
+	// IPPrivacyHash shows a keyed hash of the IPs, so that the same client can
+	// be followed without revealing its address.
+	IPPrivacyHash = "hash"
+	// IPPrivacyNone leaves the IPs out.
+	IPPrivacyNone = "none"
+)
+
+const (
+	truncateBitsIPv4 = 24
+	truncateBitsIPv6 = 48
+	// Length of the hashes, in bytes.
+	ipHashSize = 8
+)
+
+// IPPrivacy is how client IPs appear in logs, access logs, events and API
+// output.  It also limits the client IPs that are kept in memory for longer
+// than a connection, like CipherEntry.LastClientIP.  It is safe for
+// concurrent use.
+//
+// A nil *IPPrivacy shows full IPs.
+type IPPrivacy struct {
+	mode string
+	key  []byte
+}
+
+// NewIPPrivacy returns the IPPrivacy of `mode`, one of IPPrivacyFull,
+// IPPrivacyTruncate, IPPrivacyHash and IPPrivacyNone.  `hashKey` is required
+// by IPPrivacyHash.
+func NewIPPrivacy(mode string, hashKey []byte) (*IPPrivacy, error) {
+	switch mode {
+	case IPPrivacyFull, IPPrivacyTruncate, IPPrivacyNone:
+	case IPPrivacyHash:
+		if len(hashKey) == 0 {
+			return nil, errors.New("IP hashing requires a key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown IP privacy mode %q, must be full, truncate, hash or none", mode)
+	}
+	return &IPPrivacy{mode: mode, key: hashKey}, nil
+}
+
+// Mode returns the mode of the policy.
+func (p *IPPrivacy) Mode() string {
+	if p == nil {
+		return IPPrivacyFull
+	}
+	return p.mode
+}
+
+// IP returns `ip` as it may be shown, or "" if it may not be shown at all.
+func (p *IPPrivacy) IP(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	switch p.Mode() {
+	case IPPrivacyTruncate:
+		return truncateIP(ip).String()
+	case IPPrivacyHash:
+		return p.hash(ip)
+	case IPPrivacyNone:
+		return ""
+	}
+	return ip.String()
+}
+
+// Addr returns the client address `addr` as it may be shown.  Only the full
+// mode keeps the port, since the port and a truncated IP can identify a
+// client.
+func (p *IPPrivacy) Addr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if p.Mode() == IPPrivacyFull {
+		return addr.String()
+	}
+	return p.IP(addrIP(addr))
+}
+
+// Subnet returns a banned subnet as it may be shown.  Subnets narrower than
+// the truncation prefix are truncated, and hashed subnets keep their prefix
+// length, like "1a2b3c4d5e6f7a8b/32".
+func (p *IPPrivacy) Subnet(subnet *net.IPNet) string {
+	if subnet == nil {
+		return ""
+	}
+	ones, bits := subnet.Mask.Size()
+	switch p.Mode() {
+	case IPPrivacyTruncate:
+		limit := truncateBitsIPv4
+		if bits == 8*net.IPv6len {
+			limit = truncateBitsIPv6
+		}
+		if ones <= limit {
+			return subnet.String()
+		}
+		return (&net.IPNet{IP: truncateIP(subnet.IP), Mask: net.CIDRMask(limit, bits)}).String()
+	case IPPrivacyHash:
+		return p.hash(subnet.IP) + "/" + strconv.Itoa(ones)
+	case IPPrivacyNone:
+		return ""
+	}
+	return subnet.String()
+}
+
+// Retain returns the part of `ip` that may be kept in memory after the
+// connection ends: the IP in the full mode, its truncated prefix in the
+// truncate and hash modes, and nothing in the none mode.
+func (p *IPPrivacy) Retain(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	switch p.Mode() {
+	case IPPrivacyTruncate, IPPrivacyHash:
+		return truncateIP(ip)
+	case IPPrivacyNone:
+		return nil
+	}
+	return ip
+}
+
+// AddrField returns a log field with the client address `addr` as it may be
+// shown.  The address is only formatted when the record is written.
+func (p *IPPrivacy) AddrField(key string, addr net.Addr) log.Field {
+	return log.Any(key, privateAddr{p, addr})
+}
+
+type privateAddr struct {
+	privacy *IPPrivacy
+	addr    net.Addr
+}
+
+func (a privateAddr) String() string {
+	return a.privacy.Addr(a.addr)
+}
+
+func (p *IPPrivacy) hash(ip net.IP) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(ip.To16())
+	return hex.EncodeToString(mac.Sum(nil)[:ipHashSize])
+}
+
+func truncateIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(truncateBitsIPv4, 8*net.IPv4len))
+	}
+	return ip.Mask(net.CIDRMask(truncateBitsIPv6, 8*net.IPv6len))
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
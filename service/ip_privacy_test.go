@@ -0,0 +1,203 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+)
+
+// The address of the clients in the leak tests, which differs from the
+// 127.0.0.1 of the servers and targets.
+var privateClientIP = net.ParseIP("127.0.0.2")
+
+func TestIPPrivacy(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.10")
+	ip6 := net.ParseIP("2001:db8:1:2::5")
+	addr := &net.TCPAddr{IP: ip4, Port: 51000}
+	_, host, _ := net.ParseCIDR("192.0.2.10/32")
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+
+	var full *IPPrivacy
+	require.Equal(t, IPPrivacyFull, full.Mode())
+	require.Equal(t, "192.0.2.10", full.IP(ip4))
+	require.Equal(t, "192.0.2.10:51000", full.Addr(addr))
+	require.Equal(t, "192.0.2.10/32", full.Subnet(host))
+	require.Equal(t, ip4, full.Retain(ip4))
+
+	truncate, err := NewIPPrivacy(IPPrivacyTruncate, nil)
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.0", truncate.IP(ip4))
+	require.Equal(t, "2001:db8:1::", truncate.IP(ip6))
+	require.Equal(t, "192.0.2.0", truncate.Addr(addr))
+	require.Equal(t, "192.0.2.0/24", truncate.Subnet(host))
+	require.Equal(t, "192.0.2.0/24", truncate.Subnet(subnet))
+	require.Equal(t, "192.0.2.0", truncate.Retain(ip4).String())
+
+	hash, err := NewIPPrivacy(IPPrivacyHash, []byte("key"))
+	require.NoError(t, err)
+	require.Len(t, hash.IP(ip4), 2*ipHashSize)
+	require.Equal(t, hash.IP(ip4), hash.Addr(addr))
+	require.Equal(t, hash.IP(ip4)+"/32", hash.Subnet(host))
+	require.NotEqual(t, hash.IP(ip4), hash.IP(net.ParseIP("192.0.2.11")))
+	otherKey, err := NewIPPrivacy(IPPrivacyHash, []byte("other"))
+	require.NoError(t, err)
+	require.NotEqual(t, hash.IP(ip4), otherKey.IP(ip4))
+	require.Equal(t, "192.0.2.0", hash.Retain(ip4).String())
+
+	none, err := NewIPPrivacy(IPPrivacyNone, nil)
+	require.NoError(t, err)
+	require.Equal(t, "", none.IP(ip4))
+	require.Equal(t, "", none.Addr(addr))
+	require.Equal(t, "", none.Subnet(host))
+	require.Nil(t, none.Retain(ip4))
+
+	_, err = NewIPPrivacy(IPPrivacyHash, nil)
+	require.Error(t, err)
+	_, err = NewIPPrivacy("mask", nil)
+	require.Error(t, err)
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTCPIPPrivacy(t *testing.T) {
+	for _, mode := range []string{IPPrivacyTruncate, IPPrivacyHash, IPPrivacyNone} {
+		t.Run(mode, func(t *testing.T) {
+			privacy, err := NewIPPrivacy(mode, []byte("key"))
+			require.NoError(t, err)
+			var logs syncBuffer
+			logger := log.New(log.NewJSONHandler(&logs), log.LevelDebug)
+
+			targetListener, targetRunning := startDiscardServer(t)
+			listener := makeLocalhostListener(t)
+			cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+			require.NoError(t, err)
+			s := NewTCPService(cipherList, nil, &probeTestMetrics{}, 200*time.Millisecond, logger.Named("tcp"))
+			s.SetTargetIPValidator(allowAll)
+			s.SetIPPrivacy(privacy)
+			bans := NewBanList(BanConfig{Window: time.Minute, MaxFailures: 1, BanDuration: time.Minute}, &metrics.NoOpMetrics{}, logger.Named("ban"))
+			defer bans.Close()
+			bans.SetIPPrivacy(privacy)
+			s.SetBanList(bans)
+			go s.Serve(listener)
+
+			entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+			serverAddr := listener.Addr().(*net.TCPAddr)
+			// A proxied connection.
+			conn, err := net.DialTCP("tcp", &net.TCPAddr{IP: privateClientIP}, serverAddr)
+			require.NoError(t, err)
+			_, err = conn.Write(makeClientBytesBasic(t, entry.Cipher, targetListener.Addr().String()))
+			require.NoError(t, err)
+			conn.CloseWrite()
+			io.Copy(ioutil.Discard, conn)
+			conn.Close()
+			// A probe, which gets the client banned.
+			conn, err = net.DialTCP("tcp", &net.TCPAddr{IP: privateClientIP}, serverAddr)
+			require.NoError(t, err)
+			conn.Write(make([]byte, 50))
+			conn.CloseWrite()
+			io.Copy(ioutil.Discard, conn)
+			conn.Close()
+			s.GracefulStop()
+			targetListener.Close()
+			targetRunning.Wait()
+
+			require.Len(t, bans.List(), 1)
+			require.Contains(t, logs.String(), `"msg":"Proxying"`)
+			require.Contains(t, logs.String(), `"msg":"Banned"`)
+			require.NotContains(t, logs.String(), privateClientIP.String())
+			require.Equal(t, privacy.Retain(privateClientIP), cipherList.Entries()[0].LastClientIP)
+		})
+	}
+}
+
+func TestUDPIPPrivacy(t *testing.T) {
+	for _, mode := range []string{IPPrivacyTruncate, IPPrivacyHash, IPPrivacyNone} {
+		t.Run(mode, func(t *testing.T) {
+			privacy, err := NewIPPrivacy(mode, []byte("key"))
+			require.NoError(t, err)
+			var logs syncBuffer
+			logger := log.New(log.NewJSONHandler(&logs), log.LevelDebug)
+
+			// A target that echoes the packets.
+			targetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer targetConn.Close()
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, addr, err := targetConn.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					targetConn.WriteTo(buf[:n], addr)
+				}
+			}()
+
+			cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+			require.NoError(t, err)
+			entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+			serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			s := NewUDPService(time.Minute, cipherList, &metrics.NoOpMetrics{}, logger.Named("udp"))
+			s.SetTargetIPValidator(allowAll)
+			s.SetIPPrivacy(privacy)
+			go s.Serve(serverConn)
+
+			clientConn, err := net.ListenPacket("udp", net.JoinHostPort(privateClientIP.String(), "0"))
+			require.NoError(t, err)
+			defer clientConn.Close()
+			plaintext := append(socks.ParseAddr(targetConn.LocalAddr().String()), make([]byte, 10)...)
+			packet, err := ss.Pack(make([]byte, serverUDPBufferSize), plaintext, entry.Cipher)
+			require.NoError(t, err)
+			_, err = clientConn.WriteTo(packet, serverConn.LocalAddr())
+			require.NoError(t, err)
+			clientConn.SetReadDeadline(time.Now().Add(time.Second))
+			_, _, err = clientConn.ReadFrom(make([]byte, serverUDPBufferSize))
+			require.NoError(t, err)
+			s.GracefulStop()
+
+			require.Contains(t, logs.String(), `"msg":"Got response"`)
+			require.NotContains(t, logs.String(), privateClientIP.String())
+			require.Equal(t, privacy.Retain(privateClientIP), cipherList.Entries()[0].LastClientIP)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// OtherLabel is the value of the labels that are over their cardinality limit.
+const OtherLabel = "other"
+
+// ASNLabeler converts ASNs to the values of the asn label.  It bounds the
+// cardinality of the label: the first ASNs seen keep their number, and the
+// rest are reported as OtherLabel.
+type ASNLabeler struct {
+	max  int
+	mu   sync.RWMutex
+	asns map[int]string
+}
+
+// NewASNLabeler returns an ASNLabeler for up to `max` distinct ASNs.  If
+// `max` is not positive, the ASNs are unbounded.
+func NewASNLabeler(max int) *ASNLabeler {
+	return &ASNLabeler{max: max, asns: make(map[int]string)}
+}
+
+// Label returns the label value of `asn`, which is empty if it's unknown.
+func (l *ASNLabeler) Label(asn int) string {
+	if asn == 0 {
+		return ""
+	}
+	l.mu.RLock()
+	label, ok := l.asns[asn]
+	l.mu.RUnlock()
+	if ok {
+		return label
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if label, ok := l.asns[asn]; ok {
+		return label
+	}
+	if l.max > 0 && len(l.asns) >= l.max {
+		return OtherLabel
+	}
+	label = strconv.Itoa(asn)
+	l.asns[asn] = label
+	return label
+}
+
+// Access key label policies.
+const (
+	// AccessKeyLabelFull labels the metrics with the key IDs.
+	AccessKeyLabelFull = "full"
+	// AccessKeyLabelHash labels the metrics with a hash of the key IDs, to
+	// keep them out of dashboards.  It doesn't reduce the cardinality, and
+	// short IDs are easy to guess from their hashes.
+	AccessKeyLabelHash = "hash"
+	// AccessKeyLabelTop labels the metrics with the IDs of the keys that
+	// transferred the most bytes since the start, and the rest as OtherLabel.
+	AccessKeyLabelTop = "top"
+	// AccessKeyLabelNone leaves the access_key labels empty.
+	AccessKeyLabelNone = "none"
+)
+
+// Length of the hashes of AccessKeyLabelHash, in bytes.
+const accessKeyHashSize = 6
+
+// AccessKeyLabeler converts access key IDs to the values of the access_key
+// label, with one of the AccessKeyLabel policies.  It is safe for concurrent
+// use, and may be shared by several metrics, since sharing only scales the
+// transferred bytes.
+//
+// A nil *AccessKeyLabeler labels with the key IDs.
+type AccessKeyLabeler struct {
+	policy string
+	top    int
+	mu     sync.Mutex
+	// The bytes transferred by each key, with AccessKeyLabelTop.
+	usage   map[string]int64
+	topKeys map[string]bool
+	// Not above the usage of any of topKeys.
+	minTopUsage int64
+}
+
+// NewAccessKeyLabeler returns the AccessKeyLabeler of `policy`.  `top` is
+// the number of keys with their own label, and is required by
+// AccessKeyLabelTop.
+func NewAccessKeyLabeler(policy string, top int) (*AccessKeyLabeler, error) {
+	switch policy {
+	case AccessKeyLabelFull, AccessKeyLabelHash, AccessKeyLabelNone:
+	case AccessKeyLabelTop:
+		if top <= 0 {
+			return nil, fmt.Errorf("access key label policy top requires a positive number of keys, got %v", top)
+		}
+	default:
+		return nil, fmt.Errorf("unknown access key label policy %q, must be full, hash, top or none", policy)
+	}
+	return &AccessKeyLabeler{
+		policy:  policy,
+		top:     top,
+		usage:   make(map[string]int64),
+		topKeys: make(map[string]bool),
+	}, nil
+}
+
+// Policy returns the policy of the labeler.
+func (l *AccessKeyLabeler) Policy() string {
+	if l == nil {
+		return AccessKeyLabelFull
+	}
+	return l.policy
+}
+
+// Label returns the label value of `accessKey`.  The empty key of the
+// clients that didn't authenticate stays empty.
+func (l *AccessKeyLabeler) Label(accessKey string) string {
+	if accessKey == "" {
+		return ""
+	}
+	switch l.Policy() {
+	case AccessKeyLabelHash:
+		hash := sha256.Sum256([]byte(accessKey))
+		return hex.EncodeToString(hash[:accessKeyHashSize])
+	case AccessKeyLabelTop:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.topKeys[accessKey] {
+			return accessKey
+		}
+		return OtherLabel
+	case AccessKeyLabelNone:
+		return ""
+	}
+	return accessKey
+}
+
+// Observe adds `bytes` to the bytes transferred by `accessKey`, that rank
+// the keys of AccessKeyLabelTop.
+func (l *AccessKeyLabeler) Observe(accessKey string, bytes int64) {
+	if accessKey == "" || bytes <= 0 || l.Policy() != AccessKeyLabelTop {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := l.usage[accessKey] + bytes
+	l.usage[accessKey] = usage
+	if l.topKeys[accessKey] {
+		return
+	}
+	if len(l.topKeys) < l.top {
+		l.topKeys[accessKey] = true
+		return
+	}
+	if usage <= l.minTopUsage {
+		return
+	}
+	// Replace the top key with the least usage, if it's below `usage`.
+	var minKey string
+	minUsage := usage
+	for key := range l.topKeys {
+		if l.usage[key] < minUsage {
+			minKey, minUsage = key, l.usage[key]
+		}
+	}
+	if minKey != "" {
+		delete(l.topKeys, minKey)
+		l.topKeys[accessKey] = true
+	}
+	l.minTopUsage = minUsage
+}
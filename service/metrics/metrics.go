@@ -20,6 +20,8 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
@@ -27,48 +29,152 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// ClientInfo is what is known about a client IP.
+type ClientInfo struct {
+	// CountryCode is the ISO code of the country, or one of the codes of
+	// local (XL), unknown (ZZ) and failed (XA, XD) lookups.  It's empty
+	// without an IP-country database.
+	CountryCode string
+	// ASN is the autonomous system number, or zero if it's unknown or there
+	// is no IP-ASN database.
+	ASN int
+	// ASOrganization is the organization of the autonomous system.
+	ASOrganization string
+}
+
+// Port classes of the targets.
+const (
+	PortClassWeb   = "web"
+	PortClassDNS   = "dns"
+	PortClassMail  = "mail"
+	PortClassOther = "other"
+)
+
+// TargetInfo is what is known about a target of the proxy.
+type TargetInfo struct {
+	// CountryCode is the ISO code of the country of the target IP, like
+	// ClientInfo.CountryCode.  It's empty without an IP-country database or
+	// the IP of the target.
+	CountryCode string
+	// PortClass is one of the PortClass constants.
+	PortClass string
+}
+
 // ShadowsocksMetrics registers metrics for the Shadowsocks service.
 type ShadowsocksMetrics interface {
 	SetBuildInfo(version string)
 
-	GetLocation(net.Addr) (string, error)
+	GetClientInfo(net.Addr) (ClientInfo, error)
 
 	SetNumAccessKeys(numKeys int, numPorts int)
 
 	// TCP metrics
-	AddOpenTCPConnection(clientLocation string)
-	AddClosedTCPConnection(clientLocation, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration)
-	AddTCPProbe(clientLocation, status, drainResult string, port int, data ProxyMetrics)
+	AddOpenTCPConnection(clientInfo ClientInfo)
+	AddClosedTCPConnection(clientInfo ClientInfo, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration)
+	// AddTCPData reports the bytes of an authenticated TCP connection of
+	// `accessKey` since its last report.  Open connections are reported
+	// periodically, and the rest of their bytes just before
+	// AddClosedTCPConnection, which has the total.
+	AddTCPData(accessKey string, data ProxyMetrics)
+	AddTCPProbe(clientInfo ClientInfo, status, drainResult string, port int, data ProxyMetrics)
+	// AddActiveTCPConnection and RemoveActiveTCPConnection track the
+	// authenticated connections of each access key and port.
+	AddActiveTCPConnection(accessKey string, port int)
+	RemoveActiveTCPConnection(accessKey string, port int)
+
+	// AddTimeToFirstByte reports the time from connecting to the target, or
+	// sending it the first packet, to its first byte, for `proto` tcp or udp.
+	AddTimeToFirstByte(proto string, ttfb time.Duration)
+
+	// Target metrics, that are opt-in.  GetTargetInfo returns the info of the
+	// target at `ip`, which may be nil if it's unknown, and `port`.
+	// AddTargetDial reports a TCP dial or the first packet of a UDP NAT entry,
+	// with the time of the dial, or zero for UDP.
+	GetTargetInfo(ip net.IP, port int) TargetInfo
+	AddTargetDial(proto string, targetInfo TargetInfo, status string, duration time.Duration)
 
 	// UDP metrics
-	AddUDPPacketFromClient(clientLocation, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration)
-	AddUDPPacketFromTarget(clientLocation, accessKey, status string, targetProxyBytes, proxyClientBytes int)
-	AddUDPNatEntry()
-	RemoveUDPNatEntry()
+	AddUDPPacketFromClient(clientInfo ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration)
+	AddUDPPacketFromTarget(clientInfo ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int)
+	// AddUDPNatEntry and RemoveUDPNatEntry track the NAT entries of each
+	// access key and port.  `duration` is the lifetime of the removed entry.
+	AddUDPNatEntry(accessKey string, port int)
+	RemoveUDPNatEntry(accessKey string, port int, duration time.Duration)
+
+	// Ban metrics
+	AddBan(scope string)
+	SetNumActiveBans(numBans int)
 }
 
 type shadowsocksMetrics struct {
 	ipCountryDB *geoip2.Reader
-
-	buildInfo      *prometheus.GaugeVec
-	accessKeys     prometheus.Gauge
-	ports          prometheus.Gauge
-	dataBytes      *prometheus.CounterVec
-	timeToCipherMs *prometheus.HistogramVec
-	// TODO: Add time to first byte.
+	ipASNDB     *geoip2.Reader
+	// Adds the asn label after the location label.  May be nil.
+	asnLabels *ASNLabeler
+	keyLabels *AccessKeyLabeler
+	// Whether the target metrics are enabled.
+	targetMetrics bool
+
+	buildInfo         *prometheus.GaugeVec
+	accessKeys        prometheus.Gauge
+	ports             prometheus.Gauge
+	dataBytes         *prometheus.CounterVec
+	timeToCipherMs    *prometheus.HistogramVec
+	timeToFirstByteMs *prometheus.HistogramVec
 
 	tcpProbes               *prometheus.HistogramVec
 	tcpOpenConnections      *prometheus.CounterVec
 	tcpClosedConnections    *prometheus.CounterVec
 	tcpConnectionDurationMs *prometheus.HistogramVec
+	tcpActiveConnections    *activeGauge
+	// Without the client and access key labels.
+	tcpAggregateClosedConnections *prometheus.CounterVec
+	aggregateDataBytes            *prometheus.CounterVec
+
+	udpAddedNatEntries    prometheus.Counter
+	udpRemovedNatEntries  prometheus.Counter
+	udpActiveNatEntries   *activeGauge
+	udpNatEntryDurationMs prometheus.Histogram
+
+	targetDials     *prometheus.CounterVec
+	tcpTargetDialMs *prometheus.HistogramVec
 
-	udpAddedNatEntries   prometheus.Counter
-	udpRemovedNatEntries prometheus.Counter
+	bans       *prometheus.CounterVec
+	activeBans prometheus.Gauge
 }
 
-func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
+// Config configures the Prometheus metrics.
+type Config struct {
+	// IPCountryDB converts the client IPs to countries.  May be nil.
+	IPCountryDB *geoip2.Reader
+	// IPASNDB converts the client IPs to ASNs.  May be nil.
+	IPASNDB *geoip2.Reader
+	// ASNLabels adds an asn label to the connection, probe and data metrics,
+	// with the ASNs of IPASNDB.  Nil leaves it out.
+	ASNLabels *ASNLabeler
+	// AccessKeyLabels converts the key IDs to the values of the access_key
+	// labels.  Nil labels with the key IDs.
+	AccessKeyLabels *AccessKeyLabeler
+	// TargetMetrics enables the metrics of the targets: their country, with
+	// IPCountryDB, their port class and the dial times.
+	TargetMetrics bool
+}
+
+func newShadowsocksMetrics(config Config) *shadowsocksMetrics {
+	// The labels of the client, that follow the location.
+	client := []string{"location"}
+	if config.ASNLabels != nil {
+		client = append(client, "asn")
+	}
+	labels := func(before []string, after ...string) []string {
+		return append(append(before, client...), after...)
+	}
 	return &shadowsocksMetrics{
-		ipCountryDB: ipCountryDB,
+		ipCountryDB:   config.IPCountryDB,
+		ipASNDB:       config.IPASNDB,
+		asnLabels:     config.ASNLabels,
+		keyLabels:     config.AccessKeyLabels,
+		targetMetrics: config.TargetMetrics,
 		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "shadowsocks",
 			Name:      "build_info",
@@ -89,13 +195,13 @@ func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
 			Subsystem: "tcp",
 			Name:      "connections_opened",
 			Help:      "Count of open TCP connections",
-		}, []string{"location"}),
+		}, labels(nil)),
 		tcpClosedConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "shadowsocks",
 			Subsystem: "tcp",
 			Name:      "connections_closed",
 			Help:      "Count of closed TCP connections",
-		}, []string{"location", "status", "access_key"}),
+		}, labels(nil, "status", "access_key")),
 		tcpConnectionDurationMs: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "shadowsocks",
@@ -116,13 +222,13 @@ func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
 				Namespace: "shadowsocks",
 				Name:      "data_bytes",
 				Help:      "Bytes transferred by the proxy",
-			}, []string{"dir", "proto", "location", "status", "access_key"}),
+			}, labels([]string{"dir", "proto"}, "status", "access_key")),
 		tcpProbes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Namespace: "shadowsocks",
 			Name:      "tcp_probes",
 			Buckets:   []float64{0, 49, 50, 51, 73, 91},
 			Help:      "Histogram of number of bytes from client to proxy, for detecting possible probes",
-		}, []string{"location", "port", "status", "error"}),
+		}, labels(nil, "port", "status", "error")),
 		timeToCipherMs: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "shadowsocks",
@@ -130,6 +236,31 @@ func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
 				Help:      "Time needed to find the cipher",
 				Buckets:   []float64{0.1, 1, 10, 100, 1000},
 			}, []string{"proto", "found_key"}),
+		timeToFirstByteMs: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "shadowsocks",
+				Name:      "time_to_first_byte_ms",
+				Help:      "Time from connecting to the target, or sending it the first UDP packet, to its first byte",
+				Buckets:   []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+			}, []string{"proto"}),
+		tcpActiveConnections: newActiveGauge(prometheus.GaugeOpts{
+			Namespace: "shadowsocks",
+			Subsystem: "tcp",
+			Name:      "active_connections",
+			Help:      "Count of authenticated TCP connections that are open",
+		}, config.AccessKeyLabels),
+		tcpAggregateClosedConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "shadowsocks",
+			Subsystem: "tcp",
+			Name:      "aggregate_connections_closed",
+			Help:      "Count of closed TCP connections, by status only",
+		}, []string{"status"}),
+		aggregateDataBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "shadowsocks",
+				Name:      "aggregate_data_bytes",
+				Help:      "Bytes transferred by the proxy, by direction and protocol only",
+			}, []string{"dir", "proto"}),
 		udpAddedNatEntries: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: "shadowsocks",
@@ -144,6 +275,52 @@ func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
 				Name:      "nat_entries_removed",
 				Help:      "Entries removed from the UDP NAT table",
 			}),
+		udpActiveNatEntries: newActiveGauge(prometheus.GaugeOpts{
+			Namespace: "shadowsocks",
+			Subsystem: "udp",
+			Name:      "active_nat_entries",
+			Help:      "Count of entries in the UDP NAT table",
+		}, config.AccessKeyLabels),
+		udpNatEntryDurationMs: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "shadowsocks",
+				Subsystem: "udp",
+				Name:      "nat_entry_duration_ms",
+				Help:      "UDP NAT entry (session) duration distributions.",
+				Buckets: []float64{
+					float64(time.Second.Milliseconds()),
+					float64(10 * time.Second.Milliseconds()),
+					float64(time.Minute.Milliseconds()),
+					float64(5 * time.Minute.Milliseconds()),
+					float64(time.Hour.Milliseconds()),
+					float64(24 * time.Hour.Milliseconds()), // Day
+				},
+			}),
+		targetDials: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "shadowsocks",
+				Name:      "target_dials",
+				Help:      "Count of TCP dials and UDP NAT entries, by target location and port class",
+			}, []string{"proto", "location", "port_class", "status"}),
+		tcpTargetDialMs: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "shadowsocks",
+				Subsystem: "tcp",
+				Name:      "target_dial_ms",
+				Help:      "Time to resolve and connect to the targets",
+				Buckets:   []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+			}, []string{"status"}),
+		bans: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "shadowsocks",
+				Name:      "bans",
+				Help:      "Count of bans of client IPs or subnets",
+			}, []string{"scope"}),
+		activeBans: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "shadowsocks",
+			Name:      "active_bans",
+			Help:      "Count of currently banned client IPs and subnets",
+		}),
 	}
 }
 
@@ -152,10 +329,19 @@ func newShadowsocksMetrics(ipCountryDB *geoip2.Reader) *shadowsocksMetrics {
 // metrics to Prometheus via `registerer`.  `ipCountryDB` may be nil, but
 // `registerer` must not be.
 func NewPrometheusShadowsocksMetrics(ipCountryDB *geoip2.Reader, registerer prometheus.Registerer) ShadowsocksMetrics {
-	m := newShadowsocksMetrics(ipCountryDB)
+	return NewPrometheusShadowsocksMetricsFromConfig(Config{IPCountryDB: ipCountryDB}, registerer)
+}
+
+// NewPrometheusShadowsocksMetricsFromConfig constructs a metrics object
+// configured by `config`, that reports all metrics to Prometheus via
+// `registerer`.
+func NewPrometheusShadowsocksMetricsFromConfig(config Config, registerer prometheus.Registerer) ShadowsocksMetrics {
+	m := newShadowsocksMetrics(config)
 	// TODO: Is it possible to pass where to register the collectors?
 	registerer.MustRegister(m.buildInfo, m.accessKeys, m.ports, m.tcpOpenConnections, m.tcpProbes, m.tcpClosedConnections, m.tcpConnectionDurationMs,
-		m.dataBytes, m.timeToCipherMs, m.udpAddedNatEntries, m.udpRemovedNatEntries)
+		m.dataBytes, m.timeToCipherMs, m.timeToFirstByteMs, m.tcpActiveConnections.vec, m.udpAddedNatEntries, m.udpRemovedNatEntries,
+		m.udpActiveNatEntries.vec, m.udpNatEntryDurationMs, m.bans, m.activeBans, m.tcpAggregateClosedConnections, m.aggregateDataBytes,
+		m.targetDials, m.tcpTargetDialMs)
 	return m
 }
 
@@ -170,17 +356,48 @@ func (m *shadowsocksMetrics) SetBuildInfo(version string) {
 	m.buildInfo.WithLabelValues(version).Set(1)
 }
 
-func (m *shadowsocksMetrics) GetLocation(addr net.Addr) (string, error) {
-	if m.ipCountryDB == nil {
-		return "", nil
+func (m *shadowsocksMetrics) GetClientInfo(addr net.Addr) (ClientInfo, error) {
+	var info ClientInfo
+	if m.ipCountryDB == nil && m.ipASNDB == nil {
+		return info, nil
 	}
 	hostname, _, err := net.SplitHostPort(addr.String())
 	if err != nil {
-		return errParseAddr, errors.New("Failed to split hostname and port")
+		info.CountryCode = m.failedLocation(errParseAddr)
+		return info, errors.New("Failed to split hostname and port")
 	}
 	ip := net.ParseIP(hostname)
 	if ip == nil {
-		return errParseAddr, errors.New("Failed to parse address as IP")
+		info.CountryCode = m.failedLocation(errParseAddr)
+		return info, errors.New("Failed to parse address as IP")
+	}
+	info.CountryCode, err = m.getLocation(ip)
+	if m.ipASNDB != nil && ip.IsGlobalUnicast() {
+		record, asnErr := m.ipASNDB.ASN(ip)
+		if asnErr != nil {
+			if err == nil {
+				err = errors.New("ASN lookup failed")
+			}
+		} else {
+			info.ASN = int(record.AutonomousSystemNumber)
+			info.ASOrganization = record.AutonomousSystemOrganization
+		}
+	}
+	return info, err
+}
+
+// Returns `code` if there is an IP-country database, so that the failed
+// lookups don't show up as locations without one.
+func (m *shadowsocksMetrics) failedLocation(code string) string {
+	if m.ipCountryDB == nil {
+		return ""
+	}
+	return code
+}
+
+func (m *shadowsocksMetrics) getLocation(ip net.IP) (string, error) {
+	if m.ipCountryDB == nil {
+		return "", nil
 	}
 	if ip.IsLoopback() {
 		return localLocation, nil
@@ -206,8 +423,17 @@ func (m *shadowsocksMetrics) SetNumAccessKeys(numKeys int, ports int) {
 	m.ports.Set(float64(ports))
 }
 
-func (m *shadowsocksMetrics) AddOpenTCPConnection(clientLocation string) {
-	m.tcpOpenConnections.WithLabelValues(clientLocation).Inc()
+func (m *shadowsocksMetrics) AddOpenTCPConnection(clientInfo ClientInfo) {
+	m.tcpOpenConnections.WithLabelValues(m.clientValues(clientInfo)...).Inc()
+}
+
+// Returns the values of the location label, and of the asn label if there is
+// one.
+func (m *shadowsocksMetrics) clientValues(clientInfo ClientInfo) []string {
+	if m.asnLabels == nil {
+		return []string{clientInfo.CountryCode}
+	}
+	return []string{clientInfo.CountryCode, m.asnLabels.Label(clientInfo.ASN)}
 }
 
 // Converts accessKey to "true" or "false"
@@ -215,44 +441,186 @@ func isFound(accessKey string) string {
 	return fmt.Sprintf("%t", accessKey != "")
 }
 
-// addIfNonZero helps avoid the creation of series that are always zero.
-func addIfNonZero(counter prometheus.Counter, value int64) {
+// addData adds `value` to the data of `dir` and `proto`, unless it's zero to
+// avoid the creation of series that are always zero.  `keyLabel` is the
+// label of the access key.
+func (m *shadowsocksMetrics) addData(dir, proto string, client []string, status, keyLabel string, value int64) {
 	if value > 0 {
-		counter.Add(float64(value))
+		values := append(append([]string{dir, proto}, client...), status, keyLabel)
+		m.dataBytes.WithLabelValues(values...).Add(float64(value))
+		m.aggregateDataBytes.WithLabelValues(dir, proto).Add(float64(value))
 	}
 }
 
-func (m *shadowsocksMetrics) AddClosedTCPConnection(clientLocation, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration) {
-	m.tcpClosedConnections.WithLabelValues(clientLocation, status, accessKey).Inc()
+func (m *shadowsocksMetrics) AddClosedTCPConnection(clientInfo ClientInfo, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration) {
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, data.ProxyTarget+data.ProxyClient)
+	keyLabel := m.keyLabels.Label(accessKey)
+	m.tcpClosedConnections.WithLabelValues(append(client, status, keyLabel)...).Inc()
+	m.tcpAggregateClosedConnections.WithLabelValues(status).Inc()
 	m.tcpConnectionDurationMs.WithLabelValues(status).Observe(duration.Seconds() * 1000)
 	m.timeToCipherMs.WithLabelValues("tcp", isFound(accessKey)).Observe(timeToCipher.Seconds() * 1000)
-	addIfNonZero(m.dataBytes.WithLabelValues("c>p", "tcp", clientLocation, status, accessKey), data.ClientProxy)
-	addIfNonZero(m.dataBytes.WithLabelValues("p>t", "tcp", clientLocation, status, accessKey), data.ProxyTarget)
-	addIfNonZero(m.dataBytes.WithLabelValues("p<t", "tcp", clientLocation, status, accessKey), data.TargetProxy)
-	addIfNonZero(m.dataBytes.WithLabelValues("c<p", "tcp", clientLocation, status, accessKey), data.ProxyClient)
+	m.addData("c>p", "tcp", client, status, keyLabel, data.ClientProxy)
+	m.addData("p>t", "tcp", client, status, keyLabel, data.ProxyTarget)
+	m.addData("p<t", "tcp", client, status, keyLabel, data.TargetProxy)
+	m.addData("c<p", "tcp", client, status, keyLabel, data.ProxyClient)
+}
+
+// AddTCPData does nothing, since the data of TCP connections is counted when
+// they close.
+func (m *shadowsocksMetrics) AddTCPData(accessKey string, data ProxyMetrics) {}
+
+func (m *shadowsocksMetrics) AddTCPProbe(clientInfo ClientInfo, status, drainResult string, port int, data ProxyMetrics) {
+	values := append(m.clientValues(clientInfo), strconv.Itoa(port), status, drainResult)
+	m.tcpProbes.WithLabelValues(values...).Observe(float64(data.ClientProxy))
+}
+
+func (m *shadowsocksMetrics) AddActiveTCPConnection(accessKey string, port int) {
+	m.tcpActiveConnections.add(accessKey, port, 1)
+}
+
+func (m *shadowsocksMetrics) RemoveActiveTCPConnection(accessKey string, port int) {
+	m.tcpActiveConnections.add(accessKey, port, -1)
+}
+
+func (m *shadowsocksMetrics) AddTimeToFirstByte(proto string, ttfb time.Duration) {
+	m.timeToFirstByteMs.WithLabelValues(proto).Observe(ttfb.Seconds() * 1000)
 }
 
-func (m *shadowsocksMetrics) AddTCPProbe(clientLocation, status, drainResult string, port int, data ProxyMetrics) {
-	m.tcpProbes.WithLabelValues(clientLocation, strconv.Itoa(port), status, drainResult).Observe(float64(data.ClientProxy))
+func (m *shadowsocksMetrics) GetTargetInfo(ip net.IP, port int) TargetInfo {
+	if !m.targetMetrics {
+		return TargetInfo{}
+	}
+	info := TargetInfo{PortClass: portClass(port)}
+	if ip != nil {
+		// The lookup errors only show up in the locations, so that the
+		// targets don't flood the logs.
+		info.CountryCode, _ = m.getLocation(ip)
+	}
+	return info
+}
+
+// Returns the class of the well-known `port`.
+func portClass(port int) string {
+	switch port {
+	case 80, 443, 8080, 8443:
+		return PortClassWeb
+	case 53, 853:
+		return PortClassDNS
+	case 25, 110, 143, 465, 587, 993, 995:
+		return PortClassMail
+	}
+	return PortClassOther
+}
+
+func (m *shadowsocksMetrics) AddTargetDial(proto string, targetInfo TargetInfo, status string, duration time.Duration) {
+	if !m.targetMetrics {
+		return
+	}
+	m.targetDials.WithLabelValues(proto, targetInfo.CountryCode, targetInfo.PortClass, status).Inc()
+	if proto == "tcp" {
+		m.tcpTargetDialMs.WithLabelValues(status).Observe(duration.Seconds() * 1000)
+	}
 }
 
-func (m *shadowsocksMetrics) AddUDPPacketFromClient(clientLocation, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+func (m *shadowsocksMetrics) AddUDPPacketFromClient(clientInfo ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, int64(proxyTargetBytes))
+	keyLabel := m.keyLabels.Label(accessKey)
 	m.timeToCipherMs.WithLabelValues("udp", isFound(accessKey)).Observe(timeToCipher.Seconds() * 1000)
-	addIfNonZero(m.dataBytes.WithLabelValues("c>p", "udp", clientLocation, status, accessKey), int64(clientProxyBytes))
-	addIfNonZero(m.dataBytes.WithLabelValues("p>t", "udp", clientLocation, status, accessKey), int64(proxyTargetBytes))
+	m.addData("c>p", "udp", client, status, keyLabel, int64(clientProxyBytes))
+	m.addData("p>t", "udp", client, status, keyLabel, int64(proxyTargetBytes))
 }
 
-func (m *shadowsocksMetrics) AddUDPPacketFromTarget(clientLocation, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
-	addIfNonZero(m.dataBytes.WithLabelValues("p<t", "udp", clientLocation, status, accessKey), int64(targetProxyBytes))
-	addIfNonZero(m.dataBytes.WithLabelValues("c<p", "udp", clientLocation, status, accessKey), int64(proxyClientBytes))
+func (m *shadowsocksMetrics) AddUDPPacketFromTarget(clientInfo ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+	client := m.clientValues(clientInfo)
+	m.keyLabels.Observe(accessKey, int64(proxyClientBytes))
+	keyLabel := m.keyLabels.Label(accessKey)
+	m.addData("p<t", "udp", client, status, keyLabel, int64(targetProxyBytes))
+	m.addData("c<p", "udp", client, status, keyLabel, int64(proxyClientBytes))
 }
 
-func (m *shadowsocksMetrics) AddUDPNatEntry() {
+func (m *shadowsocksMetrics) AddUDPNatEntry(accessKey string, port int) {
 	m.udpAddedNatEntries.Inc()
+	m.udpActiveNatEntries.add(accessKey, port, 1)
 }
 
-func (m *shadowsocksMetrics) RemoveUDPNatEntry() {
+func (m *shadowsocksMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
 	m.udpRemovedNatEntries.Inc()
+	m.udpActiveNatEntries.add(accessKey, port, -1)
+	m.udpNatEntryDurationMs.Observe(duration.Seconds() * 1000)
+}
+
+func (m *shadowsocksMetrics) AddBan(scope string) {
+	m.bans.WithLabelValues(scope).Inc()
+}
+
+func (m *shadowsocksMetrics) SetNumActiveBans(numBans int) {
+	m.activeBans.Set(float64(numBans))
+}
+
+// activeGauge is a gauge per access key and port, whose series are removed
+// when they reach zero so that removed keys don't leave series behind.
+type activeGauge struct {
+	vec       *prometheus.GaugeVec
+	keyLabels *AccessKeyLabeler
+	mu        sync.Mutex
+	counts    map[activeGaugeKey]int
+	// The access key label that each count is in, which may be out of date
+	// with AccessKeyLabelTop.
+	labels      map[activeGaugeKey]string
+	labelCounts map[activeGaugeKey]int
+}
+
+// A key of the counts, or of the label counts with the label of the key.
+type activeGaugeKey struct {
+	accessKey string
+	port      int
+}
+
+func newActiveGauge(opts prometheus.GaugeOpts, keyLabels *AccessKeyLabeler) *activeGauge {
+	return &activeGauge{
+		vec:         prometheus.NewGaugeVec(opts, []string{"access_key", "port"}),
+		keyLabels:   keyLabels,
+		counts:      make(map[activeGaugeKey]int),
+		labels:      make(map[activeGaugeKey]string),
+		labelCounts: make(map[activeGaugeKey]int),
+	}
+}
+
+func (g *activeGauge) add(accessKey string, port int, delta int) {
+	key := activeGaugeKey{accessKey, port}
+	label := g.keyLabels.Label(accessKey)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	count := g.counts[key]
+	if old, ok := g.labels[key]; ok && old != label {
+		// Move the count to the new label of the key.
+		g.addLabel(old, port, -count)
+		g.addLabel(label, port, count)
+	}
+	if count+delta <= 0 {
+		g.addLabel(label, port, -count)
+		delete(g.counts, key)
+		delete(g.labels, key)
+		return
+	}
+	g.addLabel(label, port, delta)
+	g.counts[key] = count + delta
+	g.labels[key] = label
+}
+
+// Adds `delta` to the series of `label` and `port`.  The caller holds mu.
+func (g *activeGauge) addLabel(label string, port int, delta int) {
+	key := activeGaugeKey{label, port}
+	count := g.labelCounts[key] + delta
+	if count <= 0 {
+		delete(g.labelCounts, key)
+		g.vec.DeleteLabelValues(label, strconv.Itoa(port))
+		return
+	}
+	g.labelCounts[key] = count
+	g.vec.WithLabelValues(label, strconv.Itoa(port)).Set(float64(count))
 }
 
 type ProxyMetrics struct {
@@ -277,30 +645,34 @@ type measuredConn struct {
 	writeCount *int64
 }
 
+// The counts are updated atomically, so that they can be read while the
+// connection is in use.
 func (c *measuredConn) Read(b []byte) (int, error) {
 	n, err := c.DuplexConn.Read(b)
-	*c.readCount += int64(n)
+	atomic.AddInt64(c.readCount, int64(n))
 	return n, err
 }
 
 func (c *measuredConn) WriteTo(w io.Writer) (int64, error) {
 	n, err := io.Copy(w, c.DuplexConn)
-	*c.readCount += n
+	atomic.AddInt64(c.readCount, n)
 	return n, err
 }
 
 func (c *measuredConn) Write(b []byte) (int, error) {
 	n, err := c.DuplexConn.Write(b)
-	*c.writeCount += int64(n)
+	atomic.AddInt64(c.writeCount, int64(n))
 	return n, err
 }
 
 func (c *measuredConn) ReadFrom(r io.Reader) (int64, error) {
 	n, err := io.Copy(c.DuplexConn, r)
-	*c.writeCount += n
+	atomic.AddInt64(c.writeCount, n)
 	return n, err
 }
 
+// MeasureConn counts the bytes sent and received on `conn`.  The counts are
+// updated atomically.
 func MeasureConn(conn onet.DuplexConn, bytesSent, bytesReceived *int64) onet.DuplexConn {
 	return &measuredConn{DuplexConn: conn, writeCount: bytesSent, readCount: bytesReceived}
 }
@@ -310,18 +682,145 @@ func MeasureConn(conn onet.DuplexConn, bytesSent, bytesReceived *int64) onet.Dup
 type NoOpMetrics struct{}
 
 func (m *NoOpMetrics) SetBuildInfo(version string) {}
-func (m *NoOpMetrics) AddTCPProbe(clientLocation, status, drainResult string, port int, data ProxyMetrics) {
+func (m *NoOpMetrics) AddTCPProbe(clientInfo ClientInfo, status, drainResult string, port int, data ProxyMetrics) {
 }
-func (m *NoOpMetrics) AddClosedTCPConnection(clientLocation, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration) {
+func (m *NoOpMetrics) AddClosedTCPConnection(clientInfo ClientInfo, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration) {
 }
-func (m *NoOpMetrics) GetLocation(net.Addr) (string, error) {
-	return "", nil
+func (m *NoOpMetrics) AddTCPData(accessKey string, data ProxyMetrics) {}
+func (m *NoOpMetrics) GetClientInfo(net.Addr) (ClientInfo, error) {
+	return ClientInfo{}, nil
 }
 func (m *NoOpMetrics) SetNumAccessKeys(numKeys int, numPorts int) {}
-func (m *NoOpMetrics) AddOpenTCPConnection(clientLocation string) {}
-func (m *NoOpMetrics) AddUDPPacketFromClient(clientLocation, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+func (m *NoOpMetrics) AddOpenTCPConnection(clientInfo ClientInfo) {}
+func (m *NoOpMetrics) AddUDPPacketFromClient(clientInfo ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+}
+func (m *NoOpMetrics) AddUDPPacketFromTarget(clientInfo ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+}
+func (m *NoOpMetrics) AddActiveTCPConnection(accessKey string, port int)    {}
+func (m *NoOpMetrics) RemoveActiveTCPConnection(accessKey string, port int) {}
+func (m *NoOpMetrics) AddTimeToFirstByte(proto string, ttfb time.Duration)  {}
+func (m *NoOpMetrics) GetTargetInfo(ip net.IP, port int) TargetInfo {
+	return TargetInfo{}
 }
-func (m *NoOpMetrics) AddUDPPacketFromTarget(clientLocation, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+func (m *NoOpMetrics) AddTargetDial(proto string, targetInfo TargetInfo, status string, duration time.Duration) {
+}
+func (m *NoOpMetrics) AddUDPNatEntry(accessKey string, port int) {}
+func (m *NoOpMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
+}
+func (m *NoOpMetrics) AddBan(scope string)          {}
+func (m *NoOpMetrics) SetNumActiveBans(numBans int) {}
+
+// Tee returns a ShadowsocksMetrics that reports to `primary` and `others`.
+// The client and target info comes from `primary`.
+func Tee(primary ShadowsocksMetrics, others ...ShadowsocksMetrics) ShadowsocksMetrics {
+	return &teeMetrics{primary: primary, all: append([]ShadowsocksMetrics{primary}, others...)}
+}
+
+type teeMetrics struct {
+	primary ShadowsocksMetrics
+	all     []ShadowsocksMetrics
+}
+
+func (t *teeMetrics) SetBuildInfo(version string) {
+	for _, m := range t.all {
+		m.SetBuildInfo(version)
+	}
+}
+
+func (t *teeMetrics) GetClientInfo(addr net.Addr) (ClientInfo, error) {
+	return t.primary.GetClientInfo(addr)
+}
+
+func (t *teeMetrics) SetNumAccessKeys(numKeys int, numPorts int) {
+	for _, m := range t.all {
+		m.SetNumAccessKeys(numKeys, numPorts)
+	}
+}
+
+func (t *teeMetrics) AddOpenTCPConnection(clientInfo ClientInfo) {
+	for _, m := range t.all {
+		m.AddOpenTCPConnection(clientInfo)
+	}
+}
+
+func (t *teeMetrics) AddClosedTCPConnection(clientInfo ClientInfo, accessKey, status string, data ProxyMetrics, timeToCipher, duration time.Duration) {
+	for _, m := range t.all {
+		m.AddClosedTCPConnection(clientInfo, accessKey, status, data, timeToCipher, duration)
+	}
+}
+
+func (t *teeMetrics) AddTCPData(accessKey string, data ProxyMetrics) {
+	for _, m := range t.all {
+		m.AddTCPData(accessKey, data)
+	}
+}
+
+func (t *teeMetrics) AddTCPProbe(clientInfo ClientInfo, status, drainResult string, port int, data ProxyMetrics) {
+	for _, m := range t.all {
+		m.AddTCPProbe(clientInfo, status, drainResult, port, data)
+	}
+}
+
+func (t *teeMetrics) AddActiveTCPConnection(accessKey string, port int) {
+	for _, m := range t.all {
+		m.AddActiveTCPConnection(accessKey, port)
+	}
+}
+
+func (t *teeMetrics) RemoveActiveTCPConnection(accessKey string, port int) {
+	for _, m := range t.all {
+		m.RemoveActiveTCPConnection(accessKey, port)
+	}
+}
+
+func (t *teeMetrics) AddTimeToFirstByte(proto string, ttfb time.Duration) {
+	for _, m := range t.all {
+		m.AddTimeToFirstByte(proto, ttfb)
+	}
+}
+
+func (t *teeMetrics) GetTargetInfo(ip net.IP, port int) TargetInfo {
+	return t.primary.GetTargetInfo(ip, port)
+}
+
+func (t *teeMetrics) AddTargetDial(proto string, targetInfo TargetInfo, status string, duration time.Duration) {
+	for _, m := range t.all {
+		m.AddTargetDial(proto, targetInfo, status, duration)
+	}
+}
+
+func (t *teeMetrics) AddUDPPacketFromClient(clientInfo ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+	for _, m := range t.all {
+		m.AddUDPPacketFromClient(clientInfo, accessKey, status, clientProxyBytes, proxyTargetBytes, timeToCipher)
+	}
+}
+
+func (t *teeMetrics) AddUDPPacketFromTarget(clientInfo ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+	for _, m := range t.all {
+		m.AddUDPPacketFromTarget(clientInfo, accessKey, status, targetProxyBytes, proxyClientBytes)
+	}
+}
+
+func (t *teeMetrics) AddUDPNatEntry(accessKey string, port int) {
+	for _, m := range t.all {
+		m.AddUDPNatEntry(accessKey, port)
+	}
+}
+
+func (t *teeMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
+	for _, m := range t.all {
+		m.RemoveUDPNatEntry(accessKey, port, duration)
+	}
+}
+
+func (t *teeMetrics) AddBan(scope string) {
+	for _, m := range t.all {
+		m.AddBan(scope)
+	}
+}
+
+func (t *teeMetrics) SetNumActiveBans(numBans int) {
+	for _, m := range t.all {
+		m.SetNumActiveBans(numBans)
+	}
 }
-func (m *NoOpMetrics) AddUDPNatEntry()    {}
-func (m *NoOpMetrics) RemoveUDPNatEntry() {}
@@ -2,11 +2,15 @@ package metrics
 
 import (
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	geoip2 "github.com/oschwald/geoip2-golang"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMethodsDontPanic(t *testing.T) {
@@ -18,18 +22,287 @@ func TestMethodsDontPanic(t *testing.T) {
 		ProxyClient: 4,
 	}
 	ssMetrics.SetNumAccessKeys(20, 2)
-	ssMetrics.AddOpenTCPConnection("US")
-	ssMetrics.AddClosedTCPConnection("US", "1", "OK", proxyMetrics, 10*time.Millisecond, 100*time.Millisecond)
-	ssMetrics.AddTCPProbe("US", "ERR_CIPHER", "eof", 443, proxyMetrics)
-	ssMetrics.AddUDPPacketFromClient("US", "2", "OK", 10, 20, 10*time.Millisecond)
-	ssMetrics.AddUDPPacketFromTarget("US", "3", "OK", 10, 20)
-	ssMetrics.AddUDPNatEntry()
-	ssMetrics.RemoveUDPNatEntry()
+	clientInfo := ClientInfo{CountryCode: "US", ASN: 64496}
+	ssMetrics.AddOpenTCPConnection(clientInfo)
+	ssMetrics.AddClosedTCPConnection(clientInfo, "1", "OK", proxyMetrics, 10*time.Millisecond, 100*time.Millisecond)
+	ssMetrics.AddTCPProbe(clientInfo, "ERR_CIPHER", "eof", 443, proxyMetrics)
+	ssMetrics.AddUDPPacketFromClient(clientInfo, "2", "OK", 10, 20, 10*time.Millisecond)
+	ssMetrics.AddUDPPacketFromTarget(clientInfo, "3", "OK", 10, 20)
+	ssMetrics.AddUDPNatEntry("2", 443)
+	ssMetrics.RemoveUDPNatEntry("2", 443, time.Minute)
+	ssMetrics.AddActiveTCPConnection("1", 443)
+	ssMetrics.RemoveActiveTCPConnection("1", 443)
+	ssMetrics.AddTimeToFirstByte("tcp", 50*time.Millisecond)
+	ssMetrics.AddBan("ip")
+	ssMetrics.SetNumActiveBans(1)
 }
 
-func BenchmarkGetLocation(b *testing.B) {
+func TestActiveConnections(t *testing.T) {
+	m := newShadowsocksMetrics(Config{})
+	m.AddActiveTCPConnection("key1", 443)
+	m.AddActiveTCPConnection("key1", 443)
+	m.AddActiveTCPConnection("key2", 8388)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key1", "443")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key2", "8388")))
+
+	m.RemoveActiveTCPConnection("key1", 443)
+	m.RemoveActiveTCPConnection("key2", 8388)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key1", "443")))
+	// The series of key2 is removed at zero.
+	require.Equal(t, 1, testutil.CollectAndCount(m.tcpActiveConnections.vec))
+	m.RemoveActiveTCPConnection("key1", 443)
+	require.Equal(t, 0, testutil.CollectAndCount(m.tcpActiveConnections.vec))
+}
+
+func TestUDPNatEntries(t *testing.T) {
+	m := newShadowsocksMetrics(Config{})
+	m.AddUDPNatEntry("key1", 443)
+	m.AddUDPNatEntry("key1", 443)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.udpActiveNatEntries.vec.WithLabelValues("key1", "443")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.udpAddedNatEntries))
+
+	m.RemoveUDPNatEntry("key1", 443, 30*time.Second)
+	m.RemoveUDPNatEntry("key1", 443, 2*time.Hour)
+	require.Equal(t, 0, testutil.CollectAndCount(m.udpActiveNatEntries.vec))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.udpRemovedNatEntries))
+	expected := `
+# HELP shadowsocks_udp_nat_entry_duration_ms UDP NAT entry (session) duration distributions.
+# TYPE shadowsocks_udp_nat_entry_duration_ms histogram
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="1000"} 0
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="10000"} 0
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="60000"} 1
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="300000"} 1
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="3.6e+06"} 1
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="8.64e+07"} 2
+shadowsocks_udp_nat_entry_duration_ms_bucket{le="+Inf"} 2
+shadowsocks_udp_nat_entry_duration_ms_sum 7.23e+06
+shadowsocks_udp_nat_entry_duration_ms_count 2
+`
+	require.NoError(t, testutil.CollectAndCompare(m.udpNatEntryDurationMs, strings.NewReader(expected)))
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	m := newShadowsocksMetrics(Config{})
+	m.AddTimeToFirstByte("tcp", 40*time.Millisecond)
+	m.AddTimeToFirstByte("tcp", 3*time.Second)
+	m.AddTimeToFirstByte("udp", 5*time.Millisecond)
+	expected := `
+# HELP shadowsocks_time_to_first_byte_ms Time from connecting to the target, or sending it the first UDP packet, to its first byte
+# TYPE shadowsocks_time_to_first_byte_ms histogram
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="1"} 0
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="10"} 0
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="50"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="100"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="250"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="500"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="1000"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="2500"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="5000"} 2
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="10000"} 2
+shadowsocks_time_to_first_byte_ms_bucket{proto="tcp",le="+Inf"} 2
+shadowsocks_time_to_first_byte_ms_sum{proto="tcp"} 3040
+shadowsocks_time_to_first_byte_ms_count{proto="tcp"} 2
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="1"} 0
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="10"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="50"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="100"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="250"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="500"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="1000"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="2500"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="5000"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="10000"} 1
+shadowsocks_time_to_first_byte_ms_bucket{proto="udp",le="+Inf"} 1
+shadowsocks_time_to_first_byte_ms_sum{proto="udp"} 5
+shadowsocks_time_to_first_byte_ms_count{proto="udp"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(m.timeToFirstByteMs, strings.NewReader(expected)))
+}
+
+func openTestDB(t *testing.T, name string) *geoip2.Reader {
+	db, err := geoip2.Open("../../third_party/maxmind/test-data/" + name)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestGetClientInfo(t *testing.T) {
+	m := newShadowsocksMetrics(Config{
+		IPCountryDB: openTestDB(t, "GeoIP2-Country-Test.mmdb"),
+		IPASNDB:     openTestDB(t, "GeoLite2-ASN-Test.mmdb"),
+	})
+	info, err := m.GetClientInfo(&net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 1234})
+	require.NoError(t, err)
+	require.Equal(t, ClientInfo{CountryCode: "US", ASN: 64496, ASOrganization: "Example Carrier"}, info)
+
+	info, err = m.GetClientInfo(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234})
+	require.NoError(t, err)
+	require.Equal(t, ClientInfo{CountryCode: "FR", ASN: 64499, ASOrganization: "Example IPv6 Carrier"}, info)
+
+	// The ASN is known but the country isn't.
+	info, err = m.GetClientInfo(&net.TCPAddr{IP: net.ParseIP("1.128.0.1"), Port: 1234})
+	require.Error(t, err)
+	require.Equal(t, ClientInfo{CountryCode: unknownLocation, ASN: 1221, ASOrganization: "Telstra Pty Ltd"}, info)
+
+	info, err = m.GetClientInfo(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234})
+	require.NoError(t, err)
+	require.Equal(t, ClientInfo{CountryCode: localLocation}, info)
+}
+
+func TestGetClientInfo_ASNOnly(t *testing.T) {
+	m := newShadowsocksMetrics(Config{IPASNDB: openTestDB(t, "GeoLite2-ASN-Test.mmdb")})
+	info, err := m.GetClientInfo(&net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 1234})
+	require.NoError(t, err)
+	require.Equal(t, ClientInfo{ASN: 64498, ASOrganization: "Example Mobile"}, info)
+
+	// Unknown ASNs are zero.
+	info, err = m.GetClientInfo(&net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 1234})
+	require.NoError(t, err)
+	require.Equal(t, ClientInfo{}, info)
+}
+
+func TestASNLabel(t *testing.T) {
+	m := newShadowsocksMetrics(Config{ASNLabels: NewASNLabeler(1)})
+	m.AddOpenTCPConnection(ClientInfo{CountryCode: "US", ASN: 64496})
+	m.AddOpenTCPConnection(ClientInfo{CountryCode: "DE", ASN: 64497})
+	m.AddOpenTCPConnection(ClientInfo{CountryCode: "JP"})
+	m.AddClosedTCPConnection(ClientInfo{CountryCode: "US", ASN: 64496}, "key1", "OK", ProxyMetrics{ClientProxy: 10}, 0, time.Second)
+	m.AddTCPProbe(ClientInfo{CountryCode: "DE", ASN: 64497}, "ERR_CIPHER", "eof", 443, ProxyMetrics{ClientProxy: 50})
+	m.AddUDPPacketFromTarget(ClientInfo{CountryCode: "DE", ASN: 64497}, "key2", "OK", 5, 6)
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpOpenConnections.WithLabelValues("US", "64496")))
+	// Over the limit of 1 ASN.
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpOpenConnections.WithLabelValues("DE", OtherLabel)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpOpenConnections.WithLabelValues("JP", "")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpClosedConnections.WithLabelValues("US", "64496", "OK", "key1")))
+	require.Equal(t, 10.0, testutil.ToFloat64(m.dataBytes.WithLabelValues("c>p", "tcp", "US", "64496", "OK", "key1")))
+	require.Equal(t, 6.0, testutil.ToFloat64(m.dataBytes.WithLabelValues("c<p", "udp", "DE", OtherLabel, "OK", "key2")))
+	require.Equal(t, 1, testutil.CollectAndCount(m.tcpProbes))
+}
+
+func TestASNLabeler(t *testing.T) {
+	l := NewASNLabeler(2)
+	require.Equal(t, "", l.Label(0))
+	require.Equal(t, "64496", l.Label(64496))
+	require.Equal(t, "1221", l.Label(1221))
+	require.Equal(t, OtherLabel, l.Label(64497))
+	// The first ASNs keep their label.
+	require.Equal(t, "64496", l.Label(64496))
+
+	unbounded := NewASNLabeler(0)
+	for asn := 1; asn <= 10; asn++ {
+		require.Equal(t, strconv.Itoa(asn), unbounded.Label(asn))
+	}
+}
+
+func TestAccessKeyLabeler(t *testing.T) {
+	_, err := NewAccessKeyLabeler("partial", 0)
+	require.Error(t, err)
+	_, err = NewAccessKeyLabeler(AccessKeyLabelTop, 0)
+	require.Error(t, err)
+
+	var full *AccessKeyLabeler
+	require.Equal(t, "key1", full.Label("key1"))
+	hash, err := NewAccessKeyLabeler(AccessKeyLabelHash, 0)
+	require.NoError(t, err)
+	require.Len(t, hash.Label("key1"), 2*accessKeyHashSize)
+	require.Equal(t, hash.Label("key1"), hash.Label("key1"))
+	require.NotEqual(t, hash.Label("key1"), hash.Label("key2"))
+	require.Equal(t, "", hash.Label(""))
+	none, err := NewAccessKeyLabeler(AccessKeyLabelNone, 0)
+	require.NoError(t, err)
+	require.Equal(t, "", none.Label("key1"))
+}
+
+func TestAccessKeyLabeler_Top(t *testing.T) {
+	l, err := NewAccessKeyLabeler(AccessKeyLabelTop, 2)
+	require.NoError(t, err)
+	// Keys without traffic aren't ranked.
+	require.Equal(t, OtherLabel, l.Label("key1"))
+	l.Observe("key1", 10)
+	l.Observe("key2", 20)
+	l.Observe("key3", 5)
+	require.Equal(t, "key1", l.Label("key1"))
+	require.Equal(t, "key2", l.Label("key2"))
+	require.Equal(t, OtherLabel, l.Label("key3"))
+	// key3 passes key1.
+	l.Observe("key3", 10)
+	require.Equal(t, OtherLabel, l.Label("key1"))
+	require.Equal(t, "key3", l.Label("key3"))
+	l.Observe("key1", 1)
+	require.Equal(t, OtherLabel, l.Label("key1"))
+	l.Observe("key1", 100)
+	require.Equal(t, "key1", l.Label("key1"))
+	require.Equal(t, "key2", l.Label("key2"))
+	require.Equal(t, OtherLabel, l.Label("key3"))
+}
+
+func TestAccessKeyLabels(t *testing.T) {
+	keyLabels, err := NewAccessKeyLabeler(AccessKeyLabelTop, 1)
+	require.NoError(t, err)
+	m := newShadowsocksMetrics(Config{AccessKeyLabels: keyLabels})
+	m.AddClosedTCPConnection(ClientInfo{}, "key1", "OK", ProxyMetrics{ProxyClient: 100}, 0, time.Second)
+	m.AddClosedTCPConnection(ClientInfo{}, "key2", "OK", ProxyMetrics{ProxyClient: 10}, 0, time.Second)
+	m.AddUDPPacketFromTarget(ClientInfo{}, "key3", "OK", 5, 5)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpClosedConnections.WithLabelValues("", "OK", "key1")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpClosedConnections.WithLabelValues("", "OK", OtherLabel)))
+	require.Equal(t, 2, testutil.CollectAndCount(m.tcpClosedConnections))
+	require.Equal(t, 5.0, testutil.ToFloat64(m.dataBytes.WithLabelValues("c<p", "udp", "", "OK", OtherLabel)))
+
+	// The aggregates have no client or key labels.
+	require.Equal(t, 2.0, testutil.ToFloat64(m.tcpAggregateClosedConnections.WithLabelValues("OK")))
+	require.Equal(t, 110.0, testutil.ToFloat64(m.aggregateDataBytes.WithLabelValues("c<p", "tcp")))
+	require.Equal(t, 5.0, testutil.ToFloat64(m.aggregateDataBytes.WithLabelValues("p<t", "udp")))
+}
+
+func TestActiveConnections_KeyLabelChange(t *testing.T) {
+	keyLabels, err := NewAccessKeyLabeler(AccessKeyLabelTop, 1)
+	require.NoError(t, err)
+	m := newShadowsocksMetrics(Config{AccessKeyLabels: keyLabels})
+	keyLabels.Observe("key1", 10)
+	m.AddActiveTCPConnection("key1", 443)
+	m.AddActiveTCPConnection("key2", 443)
+	m.AddActiveTCPConnection("key2", 443)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key1", "443")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues(OtherLabel, "443")))
+
+	// key2 takes the place of key1, while its connections are open.
+	keyLabels.Observe("key2", 20)
+	m.RemoveActiveTCPConnection("key2", 443)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key2", "443")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.tcpActiveConnections.vec.WithLabelValues("key1", "443")))
+	m.RemoveActiveTCPConnection("key1", 443)
+	m.RemoveActiveTCPConnection("key2", 443)
+	require.Equal(t, 0, testutil.CollectAndCount(m.tcpActiveConnections.vec))
+}
+
+func TestTargetMetrics(t *testing.T) {
+	m := newShadowsocksMetrics(Config{IPCountryDB: openTestDB(t, "GeoIP2-Country-Test.mmdb"), TargetMetrics: true})
+	require.Equal(t, TargetInfo{CountryCode: "DE", PortClass: PortClassWeb}, m.GetTargetInfo(net.ParseIP("198.51.100.1"), 443))
+	require.Equal(t, TargetInfo{CountryCode: "JP", PortClass: PortClassDNS}, m.GetTargetInfo(net.ParseIP("203.0.113.1"), 53))
+	require.Equal(t, TargetInfo{PortClass: PortClassMail}, m.GetTargetInfo(nil, 587))
+	require.Equal(t, TargetInfo{CountryCode: localLocation, PortClass: PortClassOther}, m.GetTargetInfo(net.ParseIP("127.0.0.1"), 5201))
+
+	m.AddTargetDial("tcp", TargetInfo{CountryCode: "DE", PortClass: PortClassWeb}, "OK", 30*time.Millisecond)
+	m.AddTargetDial("tcp", TargetInfo{PortClass: PortClassWeb}, "ERR_RESOLVE_NOT_FOUND", 5*time.Millisecond)
+	m.AddTargetDial("udp", TargetInfo{CountryCode: "JP", PortClass: PortClassDNS}, "OK", 0)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.targetDials.WithLabelValues("tcp", "DE", PortClassWeb, "OK")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.targetDials.WithLabelValues("tcp", "", PortClassWeb, "ERR_RESOLVE_NOT_FOUND")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.targetDials.WithLabelValues("udp", "JP", PortClassDNS, "OK")))
+	// Only the TCP dials are timed.
+	require.Equal(t, 2, testutil.CollectAndCount(m.tcpTargetDialMs))
+}
+
+func TestTargetMetrics_Disabled(t *testing.T) {
+	m := newShadowsocksMetrics(Config{IPCountryDB: openTestDB(t, "GeoIP2-Country-Test.mmdb")})
+	require.Equal(t, TargetInfo{}, m.GetTargetInfo(net.ParseIP("198.51.100.1"), 443))
+	m.AddTargetDial("tcp", TargetInfo{}, "OK", time.Millisecond)
+	require.Equal(t, 0, testutil.CollectAndCount(m.targetDials))
+	require.Equal(t, 0, testutil.CollectAndCount(m.tcpTargetDialMs))
+}
+
+func BenchmarkGetClientInfo(b *testing.B) {
 	var ipCountryDB *geoip2.Reader
-	// The test data is in a git submodule that must be initialized before running the test.
 	dbPath := "../../third_party/maxmind/test-data/GeoIP2-Country-Test.mmdb"
 	ipCountryDB, err := geoip2.Open(dbPath)
 	if err != nil {
@@ -45,7 +318,7 @@ func BenchmarkGetLocation(b *testing.B) {
 	// servers call this method for each new connection, but typically many connections
 	// come from a single user in succession.
 	for i := 0; i < b.N; i++ {
-		ssMetrics.GetLocation(testAddr)
+		ssMetrics.GetClientInfo(testAddr)
 	}
 }
 
@@ -53,13 +326,13 @@ func BenchmarkOpenTCP(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddOpenTCPConnection("ZZ")
+		ssMetrics.AddOpenTCPConnection(ClientInfo{CountryCode: "ZZ"})
 	}
 }
 
 func BenchmarkCloseTCP(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
-	clientLocation := "ZZ"
+	clientInfo := ClientInfo{CountryCode: "ZZ"}
 	accessKey := "key 1"
 	status := "OK"
 	data := ProxyMetrics{}
@@ -67,45 +340,45 @@ func BenchmarkCloseTCP(b *testing.B) {
 	duration := time.Minute
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddClosedTCPConnection(clientLocation, accessKey, status, data, timeToCipher, duration)
+		ssMetrics.AddClosedTCPConnection(clientInfo, accessKey, status, data, timeToCipher, duration)
 	}
 }
 
 func BenchmarkProbe(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
-	clientLocation := "ZZ"
+	clientInfo := ClientInfo{CountryCode: "ZZ"}
 	status := "ERR_REPLAY"
 	drainResult := "other"
 	port := 12345
 	data := ProxyMetrics{}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddTCPProbe(clientLocation, status, drainResult, port, data)
+		ssMetrics.AddTCPProbe(clientInfo, status, drainResult, port, data)
 	}
 }
 
 func BenchmarkClientUDP(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
-	clientLocation := "ZZ"
+	clientInfo := ClientInfo{CountryCode: "ZZ"}
 	accessKey := "key 1"
 	status := "OK"
 	size := 1000
 	timeToCipher := time.Microsecond
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddUDPPacketFromClient(clientLocation, accessKey, status, size, size, timeToCipher)
+		ssMetrics.AddUDPPacketFromClient(clientInfo, accessKey, status, size, size, timeToCipher)
 	}
 }
 
 func BenchmarkTargetUDP(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
-	clientLocation := "ZZ"
+	clientInfo := ClientInfo{CountryCode: "ZZ"}
 	accessKey := "key 1"
 	status := "OK"
 	size := 1000
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddUDPPacketFromTarget(clientLocation, accessKey, status, size, size)
+		ssMetrics.AddUDPPacketFromTarget(clientInfo, accessKey, status, size, size)
 	}
 }
 
@@ -113,7 +386,7 @@ func BenchmarkNAT(b *testing.B) {
 	ssMetrics := NewPrometheusShadowsocksMetrics(nil, prometheus.NewRegistry())
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ssMetrics.AddUDPNatEntry()
-		ssMetrics.RemoveUDPNatEntry()
+		ssMetrics.AddUDPNatEntry("key 1", 12345)
+		ssMetrics.RemoveUDPNatEntry("key 1", 12345, time.Minute)
 	}
 }
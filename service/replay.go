@@ -15,38 +15,164 @@
 package service
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 )
 
-// MaxCapacity is the largest allowed size of ReplayCache.
+// MaxCapacity is the largest allowed size of the map-based ReplayCache.
 //
 // Capacities in excess of 20,000 are not recommended, due to the false
-// positive rate of up to 2 * capacity / 2^32 = 1 / 100,000.  If larger
-// capacities are desired, the key type should be changed to uint64.
+// positive rate of up to 2 * capacity / 2^32 = 1 / 100,000.  Larger
+// capacities use a Bloom filter instead (see replay_bloom.go).
 const MaxCapacity = 20_000
 
+// Replay filter designs for ReplayConfig.Filter.
+const (
+	ReplayFilterMap   = "map"
+	ReplayFilterBloom = "bloom"
+)
+
+// DefaultReplayFalsePositiveRate is the default false positive target of the
+// Bloom filter design, which is 10 times lower than the map design at MaxCapacity.
+const DefaultReplayFalsePositiveRate = 1e-6
+
+// ReplayConfig selects the design and size of a ReplayCache.
+type ReplayConfig struct {
+	// Capacity is the number of recent handshakes to remember.  Zero disables the cache.
+	Capacity int
+	// Filter is ReplayFilterMap or ReplayFilterBloom.  If empty, the map design
+	// is used up to MaxCapacity, and the Bloom filter design above it.
+	Filter string
+	// FalsePositiveRate is the target probability that a fresh handshake is
+	// rejected as a replay, for the Bloom filter design.  Zero means
+	// DefaultReplayFalsePositiveRate.
+	FalsePositiveRate float64
+	// MaxMemory is the memory budget in bytes.  Zero means unlimited.
+	MaxMemory int
+}
+
 type empty struct{}
 
+// replayFilter is a design for remembering recent handshakes.  Implementations
+// are not thread-safe.
+type replayFilter interface {
+	// add a handshake with this key ID and salt to the filter.
+	// Returns false if it is already present.
+	add(id string, salt []byte) bool
+	// The snapshot kind, which must match when loading a snapshot.
+	kind() byte
+	// snapshot copies the contents, so that they can be encoded without
+	// holding the cache mutex.
+	snapshot() replaySnapshot
+	readSnapshot(payload []byte) error
+}
+
+// replaySnapshot is a copy of the contents of a replayFilter.
+type replaySnapshot interface {
+	// appendPayload appends the snapshot payload to `buf`.
+	appendPayload(buf []byte) []byte
+}
+
 // ReplayCache allows us to check whether a handshake salt was used within
-// the last `capacity` handshakes.  It requires approximately 20*capacity
-// bytes of memory (as measured by BenchmarkReplayCache_Creation).
+// the last `capacity` handshakes.  With the map design it requires approximately
+// 20*capacity bytes of memory (as measured by BenchmarkReplayCache_Creation).
 //
 // The nil and zero values represent a cache with capacity 0, i.e. no cache.
 type ReplayCache struct {
-	mutex    sync.Mutex
-	capacity int
-	active   map[uint32]empty
-	archive  map[uint32]empty
+	mutex  sync.Mutex
+	filter replayFilter
 }
 
-// NewReplayCache returns a fresh ReplayCache that promises to remember at least
-// the most recent `capacity` handshakes.
+// NewReplayCache returns a fresh map-based ReplayCache that promises to remember
+// at least the most recent `capacity` handshakes.
 func NewReplayCache(capacity int) ReplayCache {
 	if capacity > MaxCapacity {
 		panic("ReplayCache capacity would result in too many false positives")
 	}
-	return ReplayCache{
+	if capacity == 0 {
+		return ReplayCache{}
+	}
+	return ReplayCache{filter: newMapReplayFilter(capacity)}
+}
+
+// NewReplayCacheFromConfig returns a fresh ReplayCache with the design and size
+// selected by `config`, or an error if it can't meet the false positive target
+// within the memory budget.
+func NewReplayCacheFromConfig(config ReplayConfig) (*ReplayCache, error) {
+	if config.Capacity < 0 {
+		return nil, fmt.Errorf("invalid replay cache capacity %d", config.Capacity)
+	}
+	if config.Capacity == 0 {
+		return &ReplayCache{}, nil
+	}
+	filter := config.Filter
+	if filter == "" {
+		filter = ReplayFilterMap
+		if config.Capacity > MaxCapacity {
+			filter = ReplayFilterBloom
+		}
+	}
+	var memory int
+	switch filter {
+	case ReplayFilterMap:
+		if config.Capacity > MaxCapacity {
+			return nil, fmt.Errorf("map replay cache capacity %d exceeds %d, use the bloom filter", config.Capacity, MaxCapacity)
+		}
+		// Approximately 20 bytes per entry in each of the two generations.
+		memory = 40 * config.Capacity
+	case ReplayFilterBloom:
+		fpRate := config.FalsePositiveRate
+		if fpRate == 0 {
+			fpRate = DefaultReplayFalsePositiveRate
+		}
+		if fpRate <= 0 || fpRate >= 1 {
+			return nil, fmt.Errorf("invalid replay false positive rate %v", fpRate)
+		}
+		bloom := newBloomReplayFilter(config.Capacity, fpRate)
+		if config.MaxMemory > 0 && bloom.memory() > config.MaxMemory {
+			return nil, fmt.Errorf("bloom replay cache needs %d bytes for capacity %d at false positive rate %v, over the budget of %d bytes",
+				bloom.memory(), config.Capacity, fpRate, config.MaxMemory)
+		}
+		return &ReplayCache{filter: bloom}, nil
+	default:
+		return nil, fmt.Errorf("unknown replay filter %q", config.Filter)
+	}
+	if config.MaxMemory > 0 && memory > config.MaxMemory {
+		return nil, fmt.Errorf("map replay cache needs about %d bytes for capacity %d, over the budget of %d bytes",
+			memory, config.Capacity, config.MaxMemory)
+	}
+	return &ReplayCache{filter: newMapReplayFilter(config.Capacity)}, nil
+}
+
+// Add a handshake with this key ID and salt to the cache.
+// Returns false if it is already present.
+func (c *ReplayCache) Add(id string, salt []byte) bool {
+	if c == nil || c.filter == nil {
+		// Cache is disabled, so every salt is new.
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.filter.add(id, salt)
+}
+
+// The original design: two generations of hash sets.
+type mapReplayFilter struct {
+	capacity int
+	active   map[uint32]empty
+	archive  map[uint32]empty
+}
+
+func newMapReplayFilter(capacity int) *mapReplayFilter {
+	return &mapReplayFilter{
 		capacity: capacity,
 		active:   make(map[uint32]empty, capacity),
 		// `archive` is read-only and initially empty.
@@ -77,26 +203,207 @@ func preHash(id string, salt []byte) uint32 {
 	return binary.BigEndian.Uint32(buf[:])
 }
 
-// Add a handshake with this key ID and salt to the cache.
-// Returns false if it is already present.
-func (c *ReplayCache) Add(id string, salt []byte) bool {
-	if c == nil || c.capacity == 0 {
-		// Cache is disabled, so every salt is new.
-		return true
-	}
+func (f *mapReplayFilter) add(id string, salt []byte) bool {
 	hash := preHash(id, salt)
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	if _, ok := c.active[hash]; ok {
+	if _, ok := f.active[hash]; ok {
 		// Fast replay: `salt` is already in the active set.
 		return false
 	}
-	_, inArchive := c.archive[hash]
-	if len(c.active) == c.capacity {
+	_, inArchive := f.archive[hash]
+	if len(f.active) == f.capacity {
 		// Discard the archive and move active to archive.
-		c.archive = c.active
-		c.active = make(map[uint32]empty, c.capacity)
+		f.archive = f.active
+		f.active = make(map[uint32]empty, f.capacity)
 	}
-	c.active[hash] = empty{}
+	f.active[hash] = empty{}
 	return !inArchive
 }
+
+// Replay cache snapshots have the following format, with all integers big-endian:
+//
+//	magic "SSRC" | version (1 byte) | kind (1 byte) | payload |
+//	SHA-256 of all the preceding bytes
+//
+// The kind is 'm' for the map design, whose payload is:
+//
+//	capacity (uint32) |
+//	len(active) (uint32) | active hashes (uint32 each) |
+//	len(archive) (uint32) | archive hashes (uint32 each)
+//
+// The kind is 'b' for the Bloom filter design, whose payload is described in
+// replay_bloom.go.
+const (
+	replaySnapshotMagic   = "SSRC"
+	replaySnapshotVersion = 1
+
+	replayKindMap   = 'm'
+	replayKindBloom = 'b'
+)
+
+// ErrReplaySnapshotCorrupt is returned when a snapshot fails its integrity check.
+var ErrReplaySnapshotCorrupt = errors.New("replay cache snapshot is corrupt")
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendHashes(buf []byte, hashes []uint32) []byte {
+	buf = appendUint32(buf, uint32(len(hashes)))
+	for _, hash := range hashes {
+		buf = appendUint32(buf, hash)
+	}
+	return buf
+}
+
+func hashList(set map[uint32]empty) []uint32 {
+	hashes := make([]uint32, 0, len(set))
+	for hash := range set {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+func (f *mapReplayFilter) kind() byte {
+	return replayKindMap
+}
+
+type mapReplaySnapshot struct {
+	capacity int
+	active   []uint32
+	// The archive is never modified after it is rotated out of `active`, so
+	// it isn't copied.
+	archive map[uint32]empty
+}
+
+func (f *mapReplayFilter) snapshot() replaySnapshot {
+	return &mapReplaySnapshot{capacity: f.capacity, active: hashList(f.active), archive: f.archive}
+}
+
+func (s *mapReplaySnapshot) appendPayload(buf []byte) []byte {
+	buf = appendUint32(buf, uint32(s.capacity))
+	buf = appendHashes(buf, s.active)
+	return appendHashes(buf, hashList(s.archive))
+}
+
+// Reads a length-prefixed set of hashes from the front of `buf`, and returns the rest.
+func readHashes(buf []byte) (map[uint32]empty, []byte, error) {
+	if len(buf) < 4 {
+		return nil, nil, ErrReplaySnapshotCorrupt
+	}
+	n := int(binary.BigEndian.Uint32(buf))
+	buf = buf[4:]
+	if len(buf) < 4*n {
+		return nil, nil, ErrReplaySnapshotCorrupt
+	}
+	set := make(map[uint32]empty, n)
+	for i := 0; i < n; i++ {
+		set[binary.BigEndian.Uint32(buf[4*i:])] = empty{}
+	}
+	return set, buf[4*n:], nil
+}
+
+// The snapshot may have been taken with a different capacity.
+func (f *mapReplayFilter) readSnapshot(payload []byte) error {
+	if len(payload) < 4 {
+		return ErrReplaySnapshotCorrupt
+	}
+	active, rest, err := readHashes(payload[4:])
+	if err != nil {
+		return err
+	}
+	archive, rest, err := readHashes(rest)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrReplaySnapshotCorrupt
+	}
+	f.active, f.archive = active, archive
+	if len(f.active) >= f.capacity {
+		// The snapshot was taken with a larger capacity.  Keep the most
+		// recent generation as the archive, so it is forgotten first.
+		f.archive = f.active
+		f.active = make(map[uint32]empty, f.capacity)
+	}
+	return nil
+}
+
+// WriteSnapshot writes the contents of the cache to `w`.
+func (c *ReplayCache) WriteSnapshot(w io.Writer) error {
+	if c == nil || c.filter == nil {
+		return errors.New("replay cache is disabled")
+	}
+	c.mutex.Lock()
+	snapshot := c.filter.snapshot()
+	c.mutex.Unlock()
+	// Encode and write outside the lock, so that handshakes aren't blocked.
+	buf := append([]byte(replaySnapshotMagic), replaySnapshotVersion, c.filter.kind())
+	buf = snapshot.appendPayload(buf)
+	sum := sha256.Sum256(buf)
+	buf = append(buf, sum[:]...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadSnapshot replaces the contents of the cache with a snapshot read from `r`.
+// The snapshot must have been taken from a cache with the same design.  A
+// disabled cache ignores the snapshot.
+func (c *ReplayCache) ReadSnapshot(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// The header holds the magic, the version and the kind.
+	header := len(replaySnapshotMagic) + 2
+	if len(data) < header+sha256.Size || !bytes.HasPrefix(data, []byte(replaySnapshotMagic)) {
+		return ErrReplaySnapshotCorrupt
+	}
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if expected := sha256.Sum256(body); !bytes.Equal(sum, expected[:]) {
+		return ErrReplaySnapshotCorrupt
+	}
+	if version := body[len(replaySnapshotMagic)]; version != replaySnapshotVersion {
+		return fmt.Errorf("unsupported replay cache snapshot version %d", version)
+	}
+	kind, payload := body[header-1], body[header:]
+	if c == nil || c.filter == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if kind != c.filter.kind() {
+		return fmt.Errorf("replay cache snapshot has kind %q, expected %q", kind, c.filter.kind())
+	}
+	return c.filter.readSnapshot(payload)
+}
+
+// SaveFile atomically replaces the file at `path` with a snapshot of the cache.
+func (c *ReplayCache) SaveFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := c.WriteSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile loads a snapshot saved by SaveFile.
+func (c *ReplayCache) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.ReadSnapshot(f)
+}
@@ -0,0 +1,239 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errBloomParametersChanged = errors.New("replay cache snapshot was taken with a different capacity or false positive rate")
+
+// One generation of a rotating Bloom filter.
+type bloomGeneration struct {
+	bits  []uint64
+	count int
+}
+
+func (g *bloomGeneration) contains(h1, h2, numBits uint64, numHashes int) bool {
+	for i := 0; i < numHashes; i++ {
+		bit := (h1 + uint64(i)*h2) % numBits
+		if g.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *bloomGeneration) insert(h1, h2, numBits uint64, numHashes int) {
+	for i := 0; i < numHashes; i++ {
+		bit := (h1 + uint64(i)*h2) % numBits
+		g.bits[bit/64] |= 1 << (bit % 64)
+	}
+	g.count++
+}
+
+// bloomReplayFilter has the same two-generation structure as mapReplayFilter,
+// but each generation is a Bloom filter keyed by a 64-bit hash of the key ID and
+// salt.  Its memory use depends on the false positive target rather than the
+// size of a map entry, so it can remember millions of handshakes.
+type bloomReplayFilter struct {
+	capacity  int
+	numBits   uint64
+	numHashes int
+	active    *bloomGeneration
+	archive   *bloomGeneration
+}
+
+// Returns the size of a Bloom filter generation that holds `capacity` entries with
+// a false positive rate of `fpRate`.  Each lookup consults two generations, so
+// each one gets half of the budget.
+func bloomParameters(capacity int, fpRate float64) (numBits uint64, numHashes int) {
+	p := fpRate / 2
+	bits := math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2))
+	// Round up to whole words.
+	numBits = (uint64(bits) + 63) / 64 * 64
+	numHashes = int(math.Round(float64(numBits) / float64(capacity) * math.Ln2))
+	if numHashes < 1 {
+		numHashes = 1
+	}
+	return numBits, numHashes
+}
+
+func newBloomReplayFilter(capacity int, fpRate float64) *bloomReplayFilter {
+	numBits, numHashes := bloomParameters(capacity, fpRate)
+	f := &bloomReplayFilter{
+		capacity:  capacity,
+		numBits:   numBits,
+		numHashes: numHashes,
+	}
+	f.active = f.newGeneration()
+	f.archive = f.newGeneration()
+	return f
+}
+
+func (f *bloomReplayFilter) newGeneration() *bloomGeneration {
+	return &bloomGeneration{bits: make([]uint64, f.numBits/64)}
+}
+
+// memory returns the size of both generations in bytes.
+func (f *bloomReplayFilter) memory() int {
+	return 2 * int(f.numBits/8)
+}
+
+// Computes the two hashes for double hashing.  FNV-1a is not a secure hash,
+// but as with preHash, only authenticated handshakes are added, so a hostile
+// client could only cause false positives for itself.  Unlike the Go map hash,
+// the hash is stable across restarts, so the filter can be persisted.
+func bloomHashes(id string, salt []byte) (uint64, uint64) {
+	const offset64 = 14695981039346656037
+	const prime64 = 1099511628211
+	h := uint64(offset64)
+	for i := 0; i < len(id); i++ {
+		h ^= uint64(id[i])
+		h *= prime64
+	}
+	// Separate the key ID from the salt, so that their boundary is unambiguous.
+	h ^= 0xff
+	h *= prime64
+	for _, v := range salt {
+		h ^= uint64(v)
+		h *= prime64
+	}
+	// Derive the second hash with the splitmix64 finalizer, and make it odd
+	// so that the probe sequence doesn't get stuck on a short cycle.
+	h2 := h
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h, h2 | 1
+}
+
+func (f *bloomReplayFilter) add(id string, salt []byte) bool {
+	h1, h2 := bloomHashes(id, salt)
+	if f.active.contains(h1, h2, f.numBits, f.numHashes) {
+		return false
+	}
+	inArchive := f.archive.contains(h1, h2, f.numBits, f.numHashes)
+	if f.active.count == f.capacity {
+		// Discard the archive and move active to archive.  The old archive
+		// is recycled to avoid reallocating a large filter.
+		old := f.archive
+		f.archive = f.active
+		for i := range old.bits {
+			old.bits[i] = 0
+		}
+		old.count = 0
+		f.active = old
+	}
+	f.active.insert(h1, h2, f.numBits, f.numHashes)
+	return !inArchive
+}
+
+// The Bloom filter snapshot payload has the following format, with all
+// integers big-endian:
+//
+//	capacity (uint32) | numBits (uint64) | numHashes (uint32) |
+//	active count (uint32) | active words (uint64 each) |
+//	archive count (uint32) | archive words (uint64 each)
+func (f *bloomReplayFilter) kind() byte {
+	return replayKindBloom
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+func appendGeneration(buf []byte, g *bloomGeneration) []byte {
+	buf = appendUint32(buf, uint32(g.count))
+	for _, word := range g.bits {
+		buf = appendUint64(buf, word)
+	}
+	return buf
+}
+
+func (g *bloomGeneration) clone() *bloomGeneration {
+	return &bloomGeneration{bits: append([]uint64(nil), g.bits...), count: g.count}
+}
+
+// Both generations are copied, since the archive is recycled as the next
+// active generation.
+type bloomReplaySnapshot struct {
+	capacity  int
+	numBits   uint64
+	numHashes int
+	active    *bloomGeneration
+	archive   *bloomGeneration
+}
+
+func (f *bloomReplayFilter) snapshot() replaySnapshot {
+	return &bloomReplaySnapshot{
+		capacity:  f.capacity,
+		numBits:   f.numBits,
+		numHashes: f.numHashes,
+		active:    f.active.clone(),
+		archive:   f.archive.clone(),
+	}
+}
+
+func (s *bloomReplaySnapshot) appendPayload(buf []byte) []byte {
+	buf = appendUint32(buf, uint32(s.capacity))
+	buf = appendUint64(buf, s.numBits)
+	buf = appendUint32(buf, uint32(s.numHashes))
+	buf = appendGeneration(buf, s.active)
+	return appendGeneration(buf, s.archive)
+}
+
+func (f *bloomReplayFilter) readGeneration(buf []byte) (*bloomGeneration, []byte, error) {
+	words := int(f.numBits / 64)
+	if len(buf) < 4+8*words {
+		return nil, nil, ErrReplaySnapshotCorrupt
+	}
+	g := f.newGeneration()
+	g.count = int(binary.BigEndian.Uint32(buf))
+	buf = buf[4:]
+	for i := range g.bits {
+		g.bits[i] = binary.BigEndian.Uint64(buf[8*i:])
+	}
+	return g, buf[8*words:], nil
+}
+
+// The snapshot is only usable if it was taken with the same filter size, since
+// the bit positions depend on it.
+func (f *bloomReplayFilter) readSnapshot(payload []byte) error {
+	if len(payload) < 16 {
+		return ErrReplaySnapshotCorrupt
+	}
+	capacity := int(binary.BigEndian.Uint32(payload))
+	numBits := binary.BigEndian.Uint64(payload[4:])
+	numHashes := int(binary.BigEndian.Uint32(payload[12:]))
+	if capacity != f.capacity || numBits != f.numBits || numHashes != f.numHashes {
+		return errBloomParametersChanged
+	}
+	active, rest, err := f.readGeneration(payload[16:])
+	if err != nil {
+		return err
+	}
+	archive, rest, err := f.readGeneration(rest)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrReplaySnapshotCorrupt
+	}
+	f.active, f.archive = active, archive
+	return nil
+}
@@ -15,7 +15,9 @@
 package service
 
 import (
+	"bytes"
 	"encoding/binary"
+	"path/filepath"
 	"testing"
 )
 
@@ -91,6 +93,139 @@ func TestReplayCache_Archive(t *testing.T) {
 	}
 }
 
+func TestReplayCache_Snapshot(t *testing.T) {
+	salts0 := makeSalts(10)
+	salts1 := makeSalts(5)
+	cache := NewReplayCache(10)
+	// Fill the archive and half of the active set.
+	for _, s := range append(salts0, salts1...) {
+		cache.Add(keyID, s)
+	}
+
+	var buf bytes.Buffer
+	if err := cache.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewReplayCache(10)
+	if err := restored.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	// Salts found in the archive are re-added to the active set, so check the
+	// active set first and stop before the generations rotate.
+	for _, s := range append(salts1, salts0[:5]...) {
+		if restored.Add(keyID, s) {
+			t.Error("Restored cache should remember salts from both generations")
+		}
+	}
+	if !restored.Add(keyID, makeSalts(1)[0]) {
+		t.Error("Addition of a new vector should succeed")
+	}
+}
+
+func TestReplayCache_SnapshotSmallerCapacity(t *testing.T) {
+	salts := makeSalts(10)
+	cache := NewReplayCache(20)
+	for _, s := range salts {
+		cache.Add(keyID, s)
+	}
+	var buf bytes.Buffer
+	if err := cache.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewReplayCache(5)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Checking more than 5 salts would rotate the generations again.
+	for _, s := range salts[:5] {
+		if restored.Add(keyID, s) {
+			t.Error("Restored cache should remember the oversized generation")
+		}
+	}
+}
+
+func TestReplayCache_SnapshotCorrupt(t *testing.T) {
+	cache := NewReplayCache(10)
+	for _, s := range makeSalts(5) {
+		cache.Add(keyID, s)
+	}
+	var buf bytes.Buffer
+	if err := cache.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, i := range []int{0, 4, 10, len(data) - 1} {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0xff
+		restored := NewReplayCache(10)
+		if err := restored.ReadSnapshot(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("Corruption at byte %d should be detected", i)
+		}
+	}
+	restored := NewReplayCache(10)
+	if err := restored.ReadSnapshot(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Truncation should be detected")
+	}
+}
+
+// addingWriter adds a salt to the cache on every write.
+type addingWriter struct {
+	bytes.Buffer
+	cache *ReplayCache
+}
+
+func (w *addingWriter) Write(p []byte) (int, error) {
+	w.cache.Add(keyID, makeSalts(1)[0])
+	return w.Buffer.Write(p)
+}
+
+func TestReplayCache_SnapshotUnlocked(t *testing.T) {
+	cache := NewReplayCache(10)
+	salts := makeSalts(5)
+	for _, s := range salts {
+		cache.Add(keyID, s)
+	}
+	// The writer would deadlock if the snapshot were written under the lock.
+	w := &addingWriter{cache: &cache}
+	if err := cache.WriteSnapshot(w); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewReplayCache(10)
+	if err := restored.ReadSnapshot(&w.Buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range salts {
+		if restored.Add(keyID, s) {
+			t.Error("Restored cache should remember the salts")
+		}
+	}
+}
+
+func TestReplayCache_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay")
+	salts := makeSalts(3)
+	cache := NewReplayCache(10)
+	for _, s := range salts {
+		cache.Add(keyID, s)
+	}
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// Saving again replaces the file.
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewReplayCache(10)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range salts {
+		if restored.Add(keyID, s) {
+			t.Error("Duplicate add should fail after reload")
+		}
+	}
+}
+
 // Benchmark to determine the memory usage of ReplayCache.
 // Note that NewReplayCache only allocates the active set,
 // so the eventual memory usage will be roughly double.
@@ -135,3 +270,184 @@ func BenchmarkReplayCache_Parallel(b *testing.B) {
 		}
 	})
 }
+
+func makeBloomCache(t testing.TB, capacity int) *ReplayCache {
+	cache, err := NewReplayCacheFromConfig(ReplayConfig{Capacity: capacity, Filter: ReplayFilterBloom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestReplayCache_BloomArchive(t *testing.T) {
+	salts0 := makeSalts(10)
+	salts1 := makeSalts(10)
+	cache := makeBloomCache(t, 10)
+	for _, s := range salts0 {
+		if !cache.Add(keyID, s) {
+			t.Error("Addition of a new vector should succeed")
+		}
+	}
+	for _, s := range salts0 {
+		if cache.Add(keyID, s) {
+			t.Error("Duplicate add should fail")
+		}
+	}
+	for _, s := range salts1 {
+		if !cache.Add(keyID, s) {
+			t.Error("Addition of a new vector should succeed")
+		}
+	}
+	lastStraw := makeSalts(1)[0]
+	if !cache.Add(keyID, lastStraw) {
+		t.Error("Addition of a new vector should succeed")
+	}
+	for _, s := range salts0 {
+		if !cache.Add(keyID, s) {
+			t.Error("First 10 vectors should have been forgotten")
+		}
+	}
+}
+
+func TestReplayCache_BloomKeyID(t *testing.T) {
+	cache := makeBloomCache(t, 10)
+	salt := makeSalts(1)[0]
+	if !cache.Add("key 1", salt) {
+		t.Error("Addition of a new vector should succeed")
+	}
+	if !cache.Add("key 2", salt) {
+		t.Error("The same salt with another key should not be a replay")
+	}
+}
+
+func TestReplayCache_BloomFalsePositiveRate(t *testing.T) {
+	const capacity = 10_000
+	const fpRate = 1e-3
+	cache, err := NewReplayCacheFromConfig(ReplayConfig{Capacity: capacity, Filter: ReplayFilterBloom, FalsePositiveRate: fpRate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fill both generations, so that lookups see the worst case.
+	for _, s := range makeSalts(2*capacity - 1) {
+		cache.Add(keyID, s)
+	}
+	const trials = 100_000
+	falsePositives := 0
+	for _, s := range makeSalts(trials) {
+		if !cache.Add(keyID, s) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / trials; rate > 2*fpRate {
+		t.Errorf("False positive rate %v is far above the target %v", rate, fpRate)
+	}
+}
+
+func TestNewReplayCacheFromConfig(t *testing.T) {
+	cache, err := NewReplayCacheFromConfig(ReplayConfig{Capacity: MaxCapacity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.filter.(*mapReplayFilter); !ok {
+		t.Errorf("Expected the map design up to MaxCapacity, got %T", cache.filter)
+	}
+	cache, err = NewReplayCacheFromConfig(ReplayConfig{Capacity: MaxCapacity + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.filter.(*bloomReplayFilter); !ok {
+		t.Errorf("Expected the bloom design above MaxCapacity, got %T", cache.filter)
+	}
+	cache, err = NewReplayCacheFromConfig(ReplayConfig{})
+	if err != nil || cache.filter != nil {
+		t.Errorf("Zero capacity should disable the cache: %v", err)
+	}
+
+	badConfigs := []ReplayConfig{
+		{Capacity: MaxCapacity + 1, Filter: ReplayFilterMap},
+		{Capacity: 1_000_000, Filter: ReplayFilterBloom, MaxMemory: 1 << 20},
+		{Capacity: 100, Filter: ReplayFilterMap, MaxMemory: 100},
+		{Capacity: 100, Filter: ReplayFilterBloom, FalsePositiveRate: 2},
+		{Capacity: 100, Filter: "cuckoo"},
+		{Capacity: -1},
+	}
+	for _, config := range badConfigs {
+		if _, err := NewReplayCacheFromConfig(config); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
+
+func TestReplayCache_BloomSnapshot(t *testing.T) {
+	salts := makeSalts(15)
+	cache := makeBloomCache(t, 10)
+	for _, s := range salts {
+		cache.Add(keyID, s)
+	}
+	var buf bytes.Buffer
+	if err := cache.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := makeBloomCache(t, 10)
+	if err := restored.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range append(salts[10:], salts[:5]...) {
+		if restored.Add(keyID, s) {
+			t.Error("Restored cache should remember salts from both generations")
+		}
+	}
+
+	// The bit positions depend on the size, so a resized filter can't use the snapshot.
+	resized := makeBloomCache(t, 20)
+	if err := resized.ReadSnapshot(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Loading a snapshot with different parameters should fail")
+	}
+	// Neither can a different design.
+	mapCache := NewReplayCache(10)
+	if err := mapCache.ReadSnapshot(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Loading a bloom snapshot into a map cache should fail")
+	}
+}
+
+// Benchmark to determine the memory usage of the bloom ReplayCache, for
+// comparison with BenchmarkReplayCache_Creation.  Unlike the map design, both
+// generations are allocated up front.
+func BenchmarkReplayCache_BloomCreation(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		makeBloomCache(b, MaxCapacity)
+	}
+}
+
+func BenchmarkReplayCache_BloomMax(b *testing.B) {
+	salts := makeSalts(b.N)
+	cache := makeBloomCache(b, MaxCapacity)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Add(keyID, salts[i])
+	}
+}
+
+func BenchmarkReplayCache_BloomMillion(b *testing.B) {
+	salts := makeSalts(b.N)
+	cache := makeBloomCache(b, 1_000_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Add(keyID, salts[i])
+	}
+}
+
+func BenchmarkReplayCache_BloomParallel(b *testing.B) {
+	c := make(chan []byte, b.N)
+	for _, s := range makeSalts(b.N) {
+		c <- s
+	}
+	close(c)
+	cache := makeBloomCache(b, 100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cache.Add(keyID, <-c)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// Returns the status of the error of resolving or connecting to a target,
+// or `fallback` if it's not one of the known errors.
+func targetErrorStatus(err error, fallback string) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return "ERR_RESOLVE_NOT_FOUND"
+		case dnsErr.IsTimeout:
+			return "ERR_RESOLVE_TIMEOUT"
+		}
+		return "ERR_RESOLVE_ADDRESS"
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "ERR_CONNECT_REFUSED"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "ERR_CONNECT_UNREACHABLE"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "ERR_CONNECT_TIMEOUT"
+	}
+	return fallback
+}
+
+// Returns the IP of `addr`, or nil for a domain name, and its port.
+func splitSocksAddr(addr socks.Addr) (net.IP, int) {
+	host, portString, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0
+	}
+	port, _ := strconv.Atoi(portString)
+	return net.ParseIP(host), port
+}
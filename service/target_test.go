@@ -0,0 +1,67 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetErrorStatus(t *testing.T) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	for status, err := range map[string]error{
+		"ERR_RESOLVE_NOT_FOUND":   &net.DNSError{Name: "example.invalid", IsNotFound: true},
+		"ERR_RESOLVE_TIMEOUT":     &net.DNSError{Name: "example.com", IsTimeout: true},
+		"ERR_RESOLVE_ADDRESS":     &net.DNSError{Name: "example.com", IsTemporary: true},
+		"ERR_CONNECT_REFUSED":     opError(syscall.ECONNREFUSED),
+		"ERR_CONNECT_UNREACHABLE": opError(syscall.EHOSTUNREACH),
+		"ERR_CONNECT_TIMEOUT":     &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		"ERR_CONNECT":             errors.New("other"),
+	} {
+		require.Equal(t, status, targetErrorStatus(fmt.Errorf("wrapped: %w", err), "ERR_CONNECT"), err.Error())
+	}
+}
+
+func TestDialTarget_Refused(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	// Nothing listens on the port once it's closed.
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, ip, dialErr := dialTarget(socks.ParseAddr(addr), &metrics.ProxyMetrics{}, allowAll)
+	require.NotNil(t, dialErr)
+	require.Equal(t, "ERR_CONNECT_REFUSED", dialErr.Status)
+	require.Equal(t, "127.0.0.1", ip.String())
+}
+
+func TestSplitSocksAddr(t *testing.T) {
+	ip, port := splitSocksAddr(socks.ParseAddr("192.0.2.1:443"))
+	require.Equal(t, "192.0.2.1", ip.String())
+	require.Equal(t, 443, port)
+	ip, port = splitSocksAddr(socks.ParseAddr("example.com:53"))
+	require.Nil(t, ip)
+	require.Equal(t, 53, port)
+}
@@ -23,13 +23,14 @@ import (
 	"io/ioutil"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
-	logging "github.com/op/go-logging"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
 
@@ -48,12 +49,12 @@ func remoteIP(conn net.Conn) net.IP {
 	return nil
 }
 
-// Wrapper for logger.Debugf during TCP access key searches.
-func debugTCP(cipherID, template string, val interface{}) {
+// Wrapper for logger.Debug during TCP access key searches.
+func debugTCP(logger *log.Logger, cipherID, msg string, field log.Field) {
 	// This is an optimization to reduce unnecessary allocations due to an interaction
-	// between Go's inlining/escape analysis and varargs functions like logger.Debugf.
-	if logger.IsEnabledFor(logging.DEBUG) {
-		logger.Debugf("TCP(%s): "+template, cipherID, val)
+	// between Go's inlining/escape analysis and varargs functions like logger.Debug.
+	if logger.Enabled(log.LevelDebug) {
+		logger.Debug(msg, log.String("access_key", cipherID), field)
 	}
 }
 
@@ -63,7 +64,7 @@ func debugTCP(cipherID, template string, val interface{}) {
 // required = saltSize + 2 + cipher.TagSize, the number of bytes needed to authenticate the connection.
 const bytesForKeyFinding = 50
 
-func findAccessKey(clientReader io.Reader, clientIP net.IP, cipherList CipherList) (*CipherEntry, io.Reader, []byte, time.Duration, error) {
+func findAccessKey(clientReader io.Reader, clientIP net.IP, cipherList CipherList, logger *log.Logger) (*CipherEntry, io.Reader, []byte, time.Duration, error) {
 	// We snapshot the list because it may be modified while we use it.
 	ciphers := cipherList.SnapshotForClientIP(clientIP)
 	firstBytes := make([]byte, bytesForKeyFinding)
@@ -72,10 +73,9 @@ func findAccessKey(clientReader io.Reader, clientIP net.IP, cipherList CipherLis
 	}
 
 	findStartTime := time.Now()
-	entry, elt := findEntry(firstBytes, ciphers)
+	entry, elt := findEntry(firstBytes, ciphers, logger)
 	timeToCipher := time.Now().Sub(findStartTime)
 	if entry == nil {
-		// TODO: Ban and log client IPs with too many failures too quick to protect against DoS.
 		return nil, clientReader, nil, timeToCipher, fmt.Errorf("Could not find valid TCP cipher")
 	}
 
@@ -86,7 +86,7 @@ func findAccessKey(clientReader io.Reader, clientIP net.IP, cipherList CipherLis
 }
 
 // Implements a trial decryption search.  This assumes that all ciphers are AEAD.
-func findEntry(firstBytes []byte, ciphers []*list.Element) (*CipherEntry, *list.Element) {
+func findEntry(firstBytes []byte, ciphers []*list.Element, logger *log.Logger) (*CipherEntry, *list.Element) {
 	// To hold the decrypted chunk length.
 	chunkLenBuf := [2]byte{}
 	for ci, elt := range ciphers {
@@ -98,10 +98,10 @@ func findEntry(firstBytes []byte, ciphers []*list.Element) (*CipherEntry, *list.
 		cipherText := firstBytes[saltsize : saltsize+cipherTextLength]
 		_, err := ss.DecryptOnce(cipher, salt, chunkLenBuf[:0], cipherText)
 		if err != nil {
-			debugTCP(id, "Failed to decrypt length: %v", err)
+			debugTCP(logger, id, "Failed to decrypt length", log.Err(err))
 			continue
 		}
-		debugTCP(id, "Found cipher at index %d", ci)
+		debugTCP(logger, id, "Found cipher", log.Int("index", ci))
 		// Move the active cipher to the front, so that the search is quicker next time.
 		return entry, elt
 	}
@@ -119,17 +119,36 @@ type tcpService struct {
 	// `replayCache` is a pointer to SSServer.replayCache, to share the cache among all ports.
 	replayCache       *ReplayCache
 	targetIPValidator onet.TargetIPValidator
+	// `bans` is shared among all ports.  May be nil.
+	bans BanList
+	// Called for every authenticated connection.  May be nil.
+	authHook func(keyID string)
+	// May be nil.
+	accessLog AccessLog
+	privacy   *IPPrivacy
+	// May be nil.
+	tracer Tracer
+	// How often the data of open connections is reported.  Zero reports it
+	// only when they close.
+	dataInterval time.Duration
+	// The sessions of each access key, so that they can be closed when the key is revoked.
+	sessionsMu sync.Mutex
+	sessions   map[string]map[*tcpSession]struct{}
+	logger     *log.Logger
 }
 
 // NewTCPService creates a TCPService
 // `replayCache` is a pointer to SSServer.replayCache, to share the cache among all ports.
-func NewTCPService(ciphers CipherList, replayCache *ReplayCache, m metrics.ShadowsocksMetrics, timeout time.Duration) TCPService {
+// A nil `logger` discards the logs.
+func NewTCPService(ciphers CipherList, replayCache *ReplayCache, m metrics.ShadowsocksMetrics, timeout time.Duration, logger *log.Logger) TCPService {
 	return &tcpService{
+		logger:            logger,
 		ciphers:           ciphers,
 		m:                 m,
 		readTimeout:       timeout,
 		replayCache:       replayCache,
 		targetIPValidator: onet.RequirePublicIP,
+		sessions:          make(map[string]map[*tcpSession]struct{}),
 	}
 }
 
@@ -137,8 +156,33 @@ func NewTCPService(ciphers CipherList, replayCache *ReplayCache, m metrics.Shado
 type TCPService interface {
 	// SetTargetIPValidator sets the function to be used to validate the target IP addresses.
 	SetTargetIPValidator(targetIPValidator onet.TargetIPValidator)
+	// SetBanList sets the BanList that is consulted before the access key search,
+	// and that receives authentication failures.
+	SetBanList(bans BanList)
+	// SetAuthHook sets a function that is called with the access key ID of
+	// every authenticated connection.  It must be called before Serve, and the
+	// function must not block.
+	SetAuthHook(hook func(keyID string))
+	// SetAccessLog sets the AccessLog that receives every finished
+	// connection.  It must be called before Serve.
+	SetAccessLog(log AccessLog)
+	// SetIPPrivacy sets how the client IPs appear in the logs, and which part
+	// of them is kept for the access key search.  It must be called before
+	// Serve.
+	SetIPPrivacy(privacy *IPPrivacy)
+	// SetTracer sets the Tracer that records a span for every connection,
+	// with a child span for each stage.  It must be called before Serve.
+	SetTracer(tracer Tracer)
+	// SetDataReportInterval sets how often the bytes of open connections are
+	// reported with AddTCPData.  Zero, the default, reports them only when
+	// the connections close.  It must be called before Serve.
+	SetDataReportInterval(interval time.Duration)
 	// Serve adopts the listener, which will be closed before Serve returns.  Serve returns an error unless Stop() was called.
 	Serve(listener *net.TCPListener) error
+	// RevokeKey closes the established connections of the access key `keyID`,
+	// which end with status ERR_KEY_REVOKED.  The key should already be
+	// removed from the cipher list.  Returns the number of connections closed.
+	RevokeKey(keyID string) int
 	// Stop closes the listener but does not interfere with existing connections.
 	Stop() error
 	// GracefulStop calls Stop(), and then blocks until all resources have been cleaned up.
@@ -149,11 +193,120 @@ func (s *tcpService) SetTargetIPValidator(targetIPValidator onet.TargetIPValidat
 	s.targetIPValidator = targetIPValidator
 }
 
-func dialTarget(tgtAddr socks.Addr, proxyMetrics *metrics.ProxyMetrics, targetIPValidator onet.TargetIPValidator) (onet.DuplexConn, *onet.ConnectionError) {
+func (s *tcpService) SetBanList(bans BanList) {
+	s.bans = bans
+}
+
+func (s *tcpService) SetAuthHook(hook func(keyID string)) {
+	s.authHook = hook
+}
+
+func (s *tcpService) SetAccessLog(log AccessLog) {
+	s.accessLog = log
+}
+
+func (s *tcpService) SetIPPrivacy(privacy *IPPrivacy) {
+	s.privacy = privacy
+}
+
+func (s *tcpService) SetTracer(tracer Tracer) {
+	s.tracer = tracer
+}
+
+func (s *tcpService) SetDataReportInterval(interval time.Duration) {
+	s.dataInterval = interval
+}
+
+// Reports an authentication failure to the ban list, if there is one.
+func (s *tcpService) addFailure(clientIP net.IP, status string) {
+	if s.bans != nil {
+		s.bans.AddFailure(clientIP, status)
+	}
+}
+
+// tcpSession holds the connections of an authenticated client.
+type tcpSession struct {
+	mu      sync.Mutex
+	conns   []io.Closer
+	revoked bool
+}
+
+// Adds a connection to close on revocation.  Returns false if the session is
+// already revoked.
+func (s *tcpSession) add(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revoked {
+		return false
+	}
+	s.conns = append(s.conns, c)
+	return true
+}
+
+func (s *tcpSession) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = true
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *tcpSession) isRevoked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked
+}
+
+// Registers a session of `keyID` for `clientConn`.  The session is revoked
+// right away if the key was removed after the client was authenticated.
+func (s *tcpService) openSession(keyID string, clientConn io.Closer) *tcpSession {
+	session := &tcpSession{conns: []io.Closer{clientConn}}
+	s.sessionsMu.Lock()
+	keySessions, ok := s.sessions[keyID]
+	if !ok {
+		keySessions = make(map[*tcpSession]struct{})
+		s.sessions[keyID] = keySessions
+	}
+	keySessions[session] = struct{}{}
+	s.sessionsMu.Unlock()
+	if !s.ciphers.IsCipherExists(keyID) {
+		session.revoke()
+	}
+	return session
+}
+
+func (s *tcpService) closeSession(keyID string, session *tcpSession) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	delete(s.sessions[keyID], session)
+	if len(s.sessions[keyID]) == 0 {
+		delete(s.sessions, keyID)
+	}
+}
+
+func (s *tcpService) RevokeKey(keyID string) int {
+	s.sessionsMu.Lock()
+	var revoked []*tcpSession
+	for session := range s.sessions[keyID] {
+		revoked = append(revoked, session)
+	}
+	s.sessionsMu.Unlock()
+	for _, session := range revoked {
+		session.revoke()
+	}
+	return len(revoked)
+}
+
+// dialTarget also returns the last IP that it dialed, or nil if it didn't
+// resolve the target.
+func dialTarget(tgtAddr socks.Addr, proxyMetrics *metrics.ProxyMetrics, targetIPValidator onet.TargetIPValidator) (onet.DuplexConn, net.IP, *onet.ConnectionError) {
 	var ipError *onet.ConnectionError
+	var tgtIP net.IP
 	dialer := net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
 		ip, _, _ := net.SplitHostPort(address)
-		ipError = targetIPValidator(net.ParseIP(ip))
+		tgtIP = net.ParseIP(ip)
+		ipError = targetIPValidator(tgtIP)
 		if ipError != nil {
 			return errors.New(ipError.Message)
 		}
@@ -161,13 +314,14 @@ func dialTarget(tgtAddr socks.Addr, proxyMetrics *metrics.ProxyMetrics, targetIP
 	}}
 	tgtConn, err := dialer.Dial("tcp", tgtAddr.String())
 	if ipError != nil {
-		return nil, ipError
+		return nil, tgtIP, ipError
 	} else if err != nil {
-		return nil, onet.NewConnectionError("ERR_CONNECT", "Failed to connect to target", err)
+		status := targetErrorStatus(err, "ERR_CONNECT")
+		return nil, tgtIP, onet.NewConnectionError(status, "Failed to connect to target", err)
 	}
 	tgtTCPConn := tgtConn.(*net.TCPConn)
 	tgtTCPConn.SetKeepAlive(true)
-	return metrics.MeasureConn(tgtTCPConn, &proxyMetrics.ProxyTarget, &proxyMetrics.TargetProxy), nil
+	return metrics.MeasureConn(tgtTCPConn, &proxyMetrics.ProxyTarget, &proxyMetrics.TargetProxy), tgtIP, nil
 }
 
 func (s *tcpService) Serve(listener *net.TCPListener) error {
@@ -195,7 +349,7 @@ func (s *tcpService) Serve(listener *net.TCPListener) error {
 			if stopped {
 				return nil
 			}
-			logger.Errorf("Accept failed: %v", err)
+			s.logger.Error("Accept failed", log.Err(err))
 			continue
 		}
 
@@ -204,7 +358,7 @@ func (s *tcpService) Serve(listener *net.TCPListener) error {
 			defer s.running.Done()
 			defer func() {
 				if r := recover(); r != nil {
-					logger.Errorf("Panic in TCP handler: %v", r)
+					s.logger.Error("Panic in TCP handler", log.Any("panic", r))
 				}
 			}()
 			s.handleConnection(listener.Addr().(*net.TCPAddr).Port, clientTCPConn)
@@ -213,29 +367,68 @@ func (s *tcpService) Serve(listener *net.TCPListener) error {
 }
 
 func (s *tcpService) handleConnection(listenerPort int, clientTCPConn *net.TCPConn) {
-	clientLocation, err := s.m.GetLocation(clientTCPConn.RemoteAddr())
+	connSpan := startSpan(s.tracer, SpanTCPConnection, nil)
+	connSpan.SetAttribute("port", listenerPort)
+	acceptSpan := startSpan(s.tracer, SpanTCPAccept, connSpan)
+	connStart := time.Now()
+	clientIP := remoteIP(clientTCPConn)
+	if s.bans != nil && s.bans.IsBanned(clientIP) {
+		// Close immediately, without spending any time on the client info
+		// lookup or trial decryption.  Banned clients have no location.
+		s.logger.Debug("Rejected banned client", s.privacy.AddrField("client", clientTCPConn.RemoteAddr()))
+		clientTCPConn.Close()
+		acceptSpan.End("ERR_BANNED")
+		connSpan.End("ERR_BANNED")
+		connDuration := time.Now().Sub(connStart)
+		s.m.AddOpenTCPConnection(metrics.ClientInfo{})
+		s.m.AddClosedTCPConnection(metrics.ClientInfo{}, "", "ERR_BANNED", metrics.ProxyMetrics{}, 0, connDuration)
+		if s.accessLog != nil {
+			s.accessLog.Log(AccessLogEntry{
+				Proto:      "tcp",
+				Port:       listenerPort,
+				ClientAddr: clientTCPConn.RemoteAddr(),
+				Status:     "ERR_BANNED",
+				Start:      connStart,
+				Duration:   connDuration,
+			})
+		}
+		return
+	}
+	clientInfo, err := s.m.GetClientInfo(clientTCPConn.RemoteAddr())
 	if err != nil {
-		logger.Warningf("Failed location lookup: %v", err)
+		s.logger.Warning("Failed client info lookup", log.Err(err))
 	}
-	logger.Debugf("Got location \"%v\" for IP %v", clientLocation, clientTCPConn.RemoteAddr().String())
-	s.m.AddOpenTCPConnection(clientLocation)
-
-	connStart := time.Now()
+	s.logger.Debug("Got client info", log.String("location", clientInfo.CountryCode), log.Int("asn", clientInfo.ASN), s.privacy.AddrField("client", clientTCPConn.RemoteAddr()))
+	s.m.AddOpenTCPConnection(clientInfo)
+	setClientAttributes(connSpan, clientInfo)
+	acceptSpan.End("OK")
 	clientTCPConn.SetKeepAlive(true)
 	// Set a deadline to receive the address to the target.
 	clientTCPConn.SetReadDeadline(connStart.Add(s.readTimeout))
 	var proxyMetrics metrics.ProxyMetrics
 	clientConn := metrics.MeasureConn(clientTCPConn, &proxyMetrics.ProxyClient, &proxyMetrics.ClientProxy)
-	cipherEntry, clientReader, clientSalt, timeToCipher, keyErr := findAccessKey(clientConn, remoteIP(clientTCPConn), s.ciphers)
+	keySpan := startSpan(s.tracer, SpanTCPKeySearch, connSpan)
+	cipherEntry, clientReader, clientSalt, timeToCipher, keyErr := findAccessKey(clientConn, s.privacy.Retain(clientIP), s.ciphers, s.logger)
+	if keyErr != nil {
+		keySpan.End("ERR_CIPHER")
+	} else {
+		keySpan.SetAttribute("access_key", cipherEntry.ID)
+		keySpan.End("OK")
+	}
+	var session *tcpSession
+	var reporter *tcpDataReporter
+	var target string
 
 	connError := func() *onet.ConnectionError {
 		if keyErr != nil {
-			logger.Debugf("Failed to find a valid cipher after reading %v bytes: %v", proxyMetrics.ClientProxy, keyErr)
+			s.logger.Debug("Failed to find a valid cipher", log.Int64("bytes", proxyMetrics.ClientProxy), log.Err(keyErr))
 			const status = "ERR_CIPHER"
-			s.absorbProbe(listenerPort, clientConn, clientLocation, status, &proxyMetrics)
+			s.addFailure(clientIP, status)
+			s.absorbProbe(listenerPort, clientConn, clientInfo, status, &proxyMetrics)
 			return onet.NewConnectionError(status, "Failed to find a valid cipher", keyErr)
 		}
 
+		replaySpan := startSpan(s.tracer, SpanTCPReplayCheck, connSpan)
 		isServerSalt := cipherEntry.SaltGenerator.IsServerSalt(clientSalt)
 		// Only check the cache if findAccessKey succeeded and the salt is unrecognized.
 		if isServerSalt || !s.replayCache.Add(cipherEntry.ID, clientSalt) {
@@ -245,11 +438,25 @@ func (s *tcpService) handleConnection(listenerPort int, clientTCPConn *net.TCPCo
 			} else {
 				status = "ERR_REPLAY_CLIENT"
 			}
-			s.absorbProbe(listenerPort, clientConn, clientLocation, status, &proxyMetrics)
-			logger.Debugf(status+": %v in %s sent %d bytes", clientTCPConn.RemoteAddr(), clientLocation, proxyMetrics.ClientProxy)
+			replaySpan.End(status)
+			s.addFailure(clientIP, status)
+			s.absorbProbe(listenerPort, clientConn, clientInfo, status, &proxyMetrics)
+			s.logger.Debug("Replay detected", log.String("status", status), s.privacy.AddrField("client", clientTCPConn.RemoteAddr()),
+				log.String("location", clientInfo.CountryCode), log.Int64("bytes", proxyMetrics.ClientProxy))
 			return onet.NewConnectionError(status, "Replay detected", nil)
 		}
 
+		replaySpan.End("OK")
+
+		session = s.openSession(cipherEntry.ID, clientTCPConn)
+		defer s.closeSession(cipherEntry.ID, session)
+		reporter = newTCPDataReporter(s.m, cipherEntry.ID, &proxyMetrics, s.dataInterval)
+		s.m.AddActiveTCPConnection(cipherEntry.ID, listenerPort)
+		defer s.m.RemoveActiveTCPConnection(cipherEntry.ID, listenerPort)
+		if s.authHook != nil {
+			s.authHook(cipherEntry.ID)
+		}
+
 		ssr := ss.NewShadowsocksReader(clientReader, cipherEntry.Cipher)
 		tgtAddr, err := socks.ReadAddr(ssr)
 		// Clear the deadline for the target address
@@ -259,15 +466,29 @@ func (s *tcpService) handleConnection(listenerPort int, clientTCPConn *net.TCPCo
 			io.Copy(ioutil.Discard, clientConn)
 			return onet.NewConnectionError("ERR_READ_ADDRESS", "Failed to get target address", err)
 		}
-
-		tgtConn, dialErr := dialTarget(tgtAddr, &proxyMetrics, s.targetIPValidator)
+		target = tgtAddr.String()
+
+		dialSpan := startSpan(s.tracer, SpanTCPDial, connSpan)
+		dialSpan.SetAttribute("target", target)
+		dialStart := time.Now()
+		tgtConn, tgtIP, dialErr := dialTarget(tgtAddr, &proxyMetrics, s.targetIPValidator)
+		dialSpan.End(spanStatus(dialErr))
+		literalIP, tgtPort := splitSocksAddr(tgtAddr)
+		if tgtIP == nil {
+			tgtIP = literalIP
+		}
+		s.m.AddTargetDial("tcp", s.m.GetTargetInfo(tgtIP, tgtPort), spanStatus(dialErr), time.Since(dialStart))
 		if dialErr != nil {
 			// We don't drain so dial errors and invalid addresses are communicated quickly.
 			return dialErr
 		}
 		defer tgtConn.Close()
+		if !session.add(tgtConn) {
+			return onet.NewConnectionError("ERR_KEY_REVOKED", "Access key was revoked", nil)
+		}
 
-		logger.Debugf("proxy %s <-> %s", clientTCPConn.RemoteAddr().String(), tgtConn.RemoteAddr().String())
+		s.logger.Debug("Proxying", s.privacy.AddrField("client", clientTCPConn.RemoteAddr()), log.Any("target", tgtConn.RemoteAddr()))
+		relaySpan := startSpan(s.tracer, SpanTCPRelay, connSpan)
 		ssw := ss.NewShadowsocksWriter(clientConn, cipherEntry.Cipher)
 		ssw.SetSaltGenerator(cipherEntry.SaltGenerator)
 
@@ -285,43 +506,159 @@ func (s *tcpService) handleConnection(listenerPort int, clientTCPConn *net.TCPCo
 			tgtConn.CloseWrite()
 			fromClientErrCh <- fromClientErr
 		}()
-		_, fromTargetErr := ssw.ReadFrom(tgtConn)
+		fromTarget := &firstByteReader{Reader: tgtConn, start: time.Now()}
+		_, fromTargetErr := ssw.ReadFrom(fromTarget)
+		if fromTarget.ttfb > 0 {
+			s.m.AddTimeToFirstByte("tcp", fromTarget.ttfb)
+		}
 		// Send FIN to client.
 		clientConn.CloseWrite()
 		tgtConn.CloseRead()
 
 		fromClientErr := <-fromClientErrCh
+		var relayErr *onet.ConnectionError
 		if fromClientErr != nil {
-			return onet.NewConnectionError("ERR_RELAY_CLIENT", "Failed to relay traffic from client", fromClientErr)
-		}
-		if fromTargetErr != nil {
-			return onet.NewConnectionError("ERR_RELAY_TARGET", "Failed to relay traffic from target", fromTargetErr)
+			relayErr = onet.NewConnectionError("ERR_RELAY_CLIENT", "Failed to relay traffic from client", fromClientErr)
+		} else if fromTargetErr != nil {
+			relayErr = onet.NewConnectionError("ERR_RELAY_TARGET", "Failed to relay traffic from target", fromTargetErr)
 		}
-		return nil
+		relaySpan.End(spanStatus(relayErr))
+		return relayErr
 	}()
 
 	connDuration := time.Now().Sub(connStart)
 	status := "OK"
 	if connError != nil {
-		logger.Debugf("TCP Error: %v: %v", connError.Message, connError.Cause)
+		s.logger.Debug(connError.Message, log.String("status", connError.Status), log.Err(connError.Cause))
 		status = connError.Status
 	}
+	if session != nil && session.isRevoked() {
+		// Whatever error the relay ended with was caused by the revocation.
+		status = "ERR_KEY_REVOKED"
+	}
 	var id string
 	if cipherEntry != nil {
 		id = cipherEntry.ID
 	}
-	s.m.AddClosedTCPConnection(clientLocation, id, status, proxyMetrics, timeToCipher, connDuration)
+	if reporter != nil {
+		reporter.stop()
+	} else if id != "" {
+		s.m.AddTCPData(id, proxyMetrics)
+	}
+	s.m.AddClosedTCPConnection(clientInfo, id, status, proxyMetrics, timeToCipher, connDuration)
+	if id != "" {
+		connSpan.SetAttribute("access_key", id)
+	}
+	connSpan.SetAttribute("client_proxy_bytes", proxyMetrics.ClientProxy)
+	connSpan.SetAttribute("proxy_client_bytes", proxyMetrics.ProxyClient)
+	connSpan.End(status)
+	if s.accessLog != nil {
+		s.accessLog.Log(AccessLogEntry{
+			Proto:                "tcp",
+			AccessKey:            id,
+			Port:                 listenerPort,
+			ClientAddr:           clientTCPConn.RemoteAddr(),
+			ClientLocation:       clientInfo.CountryCode,
+			ClientASN:            clientInfo.ASN,
+			ClientASOrganization: clientInfo.ASOrganization,
+			Target:               target,
+			Status:               status,
+			Data:                 proxyMetrics,
+			Start:                connStart,
+			Duration:             connDuration,
+			TimeToCipher:         timeToCipher,
+		})
+	}
 	clientConn.Close() // Closing after the metrics are added aids integration testing.
-	logger.Debugf("Done with status %v, duration %v", status, connDuration)
+	s.logger.Debug("Done", log.String("status", status), log.Duration("duration", connDuration))
+}
+
+// tcpDataReporter reports the bytes of an open connection with AddTCPData.
+type tcpDataReporter struct {
+	m     metrics.ShadowsocksMetrics
+	keyID string
+	// Updated atomically by the measured connections.
+	data     *metrics.ProxyMetrics
+	reported metrics.ProxyMetrics
+	done     chan struct{}
+	running  sync.WaitGroup
+}
+
+// newTCPDataReporter reports `data` every `interval`, unless it's zero, until
+// stop is called.
+func newTCPDataReporter(m metrics.ShadowsocksMetrics, keyID string, data *metrics.ProxyMetrics, interval time.Duration) *tcpDataReporter {
+	r := &tcpDataReporter{m: m, keyID: keyID, data: data, done: make(chan struct{})}
+	if interval > 0 {
+		r.running.Add(1)
+		go r.run(interval)
+	}
+	return r
+}
+
+func (r *tcpDataReporter) run(interval time.Duration) {
+	defer r.running.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.report()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// report reports the bytes since the last report, if any.
+func (r *tcpDataReporter) report() {
+	current := metrics.ProxyMetrics{
+		ClientProxy: atomic.LoadInt64(&r.data.ClientProxy),
+		ProxyTarget: atomic.LoadInt64(&r.data.ProxyTarget),
+		TargetProxy: atomic.LoadInt64(&r.data.TargetProxy),
+		ProxyClient: atomic.LoadInt64(&r.data.ProxyClient),
+	}
+	delta := metrics.ProxyMetrics{
+		ClientProxy: current.ClientProxy - r.reported.ClientProxy,
+		ProxyTarget: current.ProxyTarget - r.reported.ProxyTarget,
+		TargetProxy: current.TargetProxy - r.reported.TargetProxy,
+		ProxyClient: current.ProxyClient - r.reported.ProxyClient,
+	}
+	r.reported = current
+	if delta != (metrics.ProxyMetrics{}) {
+		r.m.AddTCPData(r.keyID, delta)
+	}
+}
+
+// stop stops the periodic reports, and reports the rest of the bytes.
+func (r *tcpDataReporter) stop() {
+	close(r.done)
+	r.running.Wait()
+	r.report()
+}
+
+// firstByteReader measures the time from `start` to the first byte read.
+type firstByteReader struct {
+	io.Reader
+	start time.Time
+	// Zero until the first byte.
+	ttfb time.Duration
+}
+
+func (r *firstByteReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 && r.ttfb == 0 {
+		r.ttfb = time.Since(r.start)
+	}
+	return n, err
 }
 
 // Keep the connection open until we hit the authentication deadline to protect against probing attacks
 // `proxyMetrics` is a pointer because its value is being mutated by `clientConn`.
-func (s *tcpService) absorbProbe(listenerPort int, clientConn io.ReadCloser, clientLocation, status string, proxyMetrics *metrics.ProxyMetrics) {
+func (s *tcpService) absorbProbe(listenerPort int, clientConn io.ReadCloser, clientInfo metrics.ClientInfo, status string, proxyMetrics *metrics.ProxyMetrics) {
 	_, drainErr := io.Copy(ioutil.Discard, clientConn) // drain socket
 	drainResult := drainErrToString(drainErr)
-	logger.Debugf("Drain error: %v, drain result: %v", drainErr, drainResult)
-	s.m.AddTCPProbe(clientLocation, status, drainResult, listenerPort, *proxyMetrics)
+	s.logger.Debug("Drained probe", log.Err(drainErr), log.String("result", drainResult))
+	s.m.AddTCPProbe(clientInfo, status, drainResult, listenerPort, *proxyMetrics)
 }
 
 func drainErrToString(drainErr error) string {
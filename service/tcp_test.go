@@ -29,15 +29,10 @@ import (
 	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
-	logging "github.com/op/go-logging"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 	"github.com/stretchr/testify/require"
 )
 
-func init() {
-	logging.SetLevel(logging.INFO, "")
-}
-
 func allowAll(ip net.IP) *onet.ConnectionError {
 	// Allow access to localhost so that we can run integration tests with
 	// an actual destination server.
@@ -103,7 +98,7 @@ func BenchmarkTCPFindCipherFail(b *testing.B) {
 		}
 		clientIP := clientConn.RemoteAddr().(*net.TCPAddr).IP
 		b.StartTimer()
-		findAccessKey(clientConn, clientIP, cipherList)
+		findAccessKey(clientConn, clientIP, cipherList, nil)
 		b.StopTimer()
 	}
 }
@@ -206,7 +201,7 @@ func BenchmarkTCPFindCipherRepeat(b *testing.B) {
 		cipher := cipherEntries[cipherNumber].Cipher
 		go ss.NewShadowsocksWriter(writer, cipher).Write(ss.MakeTestPayload(50))
 		b.StartTimer()
-		_, _, _, _, err := findAccessKey(&c, clientIP, cipherList)
+		_, _, _, _, err := findAccessKey(&c, clientIP, cipherList, nil)
 		b.StopTimer()
 		if err != nil {
 			b.Error(err)
@@ -222,33 +217,58 @@ type probeTestMetrics struct {
 	probeData   []metrics.ProxyMetrics
 	probeStatus []string
 	closeStatus []string
+	tcpData     metrics.ProxyMetrics
+	tcpReports  int
+	lookups     int
 }
 
-func (m *probeTestMetrics) AddTCPProbe(clientLocation, status, drainResult string, port int, data metrics.ProxyMetrics) {
+func (m *probeTestMetrics) AddTCPProbe(clientInfo metrics.ClientInfo, status, drainResult string, port int, data metrics.ProxyMetrics) {
 	m.mu.Lock()
 	m.probeData = append(m.probeData, data)
 	m.probeStatus = append(m.probeStatus, status)
 	m.mu.Unlock()
 }
-func (m *probeTestMetrics) AddClosedTCPConnection(clientLocation, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
+func (m *probeTestMetrics) AddClosedTCPConnection(clientInfo metrics.ClientInfo, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
 	m.mu.Lock()
 	m.closeStatus = append(m.closeStatus, status)
 	m.mu.Unlock()
 }
 
-func (m *probeTestMetrics) GetLocation(net.Addr) (string, error) {
-	return "", nil
+func (m *probeTestMetrics) AddTCPData(accessKey string, data metrics.ProxyMetrics) {
+	m.mu.Lock()
+	m.tcpData.ClientProxy += data.ClientProxy
+	m.tcpData.ProxyTarget += data.ProxyTarget
+	m.tcpData.TargetProxy += data.TargetProxy
+	m.tcpData.ProxyClient += data.ProxyClient
+	m.tcpReports++
+	m.mu.Unlock()
+}
+
+func (m *probeTestMetrics) GetClientInfo(net.Addr) (metrics.ClientInfo, error) {
+	m.mu.Lock()
+	m.lookups++
+	m.mu.Unlock()
+	return metrics.ClientInfo{}, nil
 }
 func (m *probeTestMetrics) SetNumAccessKeys(numKeys int, numPorts int) {
 }
-func (m *probeTestMetrics) AddOpenTCPConnection(clientLocation string) {
+func (m *probeTestMetrics) AddOpenTCPConnection(clientInfo metrics.ClientInfo) {
+}
+func (m *probeTestMetrics) AddUDPPacketFromClient(clientInfo metrics.ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+}
+func (m *probeTestMetrics) AddUDPPacketFromTarget(clientInfo metrics.ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+}
+func (m *probeTestMetrics) AddActiveTCPConnection(accessKey string, port int)    {}
+func (m *probeTestMetrics) RemoveActiveTCPConnection(accessKey string, port int) {}
+func (m *probeTestMetrics) AddTimeToFirstByte(proto string, ttfb time.Duration)  {}
+func (m *probeTestMetrics) GetTargetInfo(ip net.IP, port int) metrics.TargetInfo {
+	return metrics.TargetInfo{}
 }
-func (m *probeTestMetrics) AddUDPPacketFromClient(clientLocation, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+func (m *probeTestMetrics) AddTargetDial(proto string, targetInfo metrics.TargetInfo, status string, duration time.Duration) {
 }
-func (m *probeTestMetrics) AddUDPPacketFromTarget(clientLocation, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+func (m *probeTestMetrics) AddUDPNatEntry(accessKey string, port int) {}
+func (m *probeTestMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {
 }
-func (m *probeTestMetrics) AddUDPNatEntry()    {}
-func (m *probeTestMetrics) RemoveUDPNatEntry() {}
 
 func (m *probeTestMetrics) countStatuses() map[string]int {
 	counts := make(map[string]int)
@@ -282,7 +302,7 @@ func TestProbeRandom(t *testing.T) {
 	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond)
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
 	go s.Serve(listener)
 
 	// 221 is the largest random probe reported by https://gfw.report/blog/gfw_shadowsocks/
@@ -349,7 +369,7 @@ func TestProbeClientBytesBasicTruncated(t *testing.T) {
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	cipher := firstCipher(cipherList)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond)
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
 	s.SetTargetIPValidator(allowAll)
 	go s.Serve(listener)
 
@@ -379,7 +399,7 @@ func TestProbeClientBytesBasicModified(t *testing.T) {
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	cipher := firstCipher(cipherList)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond)
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
 	s.SetTargetIPValidator(allowAll)
 	go s.Serve(listener)
 
@@ -410,7 +430,7 @@ func TestProbeClientBytesCoalescedModified(t *testing.T) {
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	cipher := firstCipher(cipherList)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond)
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
 	s.SetTargetIPValidator(allowAll)
 	go s.Serve(listener)
 
@@ -448,7 +468,7 @@ func TestProbeServerBytesModified(t *testing.T) {
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	cipher := firstCipher(cipherList)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond)
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
 	go s.Serve(listener)
 
 	initialBytes := makeServerBytes(t, cipher)
@@ -473,7 +493,7 @@ func TestReplayDefense(t *testing.T) {
 	replayCache := NewReplayCache(5)
 	testMetrics := &probeTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout)
+	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout, nil)
 	snapshot := cipherList.SnapshotForClientIP(nil)
 	cipherEntry := snapshot[0].Value.(*CipherEntry)
 	cipher := cipherEntry.Cipher
@@ -546,7 +566,7 @@ func TestReverseReplayDefense(t *testing.T) {
 	replayCache := NewReplayCache(5)
 	testMetrics := &probeTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout)
+	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout, nil)
 	snapshot := cipherList.SnapshotForClientIP(nil)
 	cipherEntry := snapshot[0].Value.(*CipherEntry)
 	cipher := cipherEntry.Cipher
@@ -611,7 +631,7 @@ func probeExpectTimeout(t *testing.T, payloadSize int) {
 	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(5))
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	testMetrics := &probeTestMetrics{}
-	s := NewTCPService(cipherList, nil, testMetrics, testTimeout)
+	s := NewTCPService(cipherList, nil, testMetrics, testTimeout, nil)
 
 	testPayload := ss.MakeTestPayload(payloadSize)
 	done := make(chan bool)
@@ -668,13 +688,136 @@ func probeExpectTimeout(t *testing.T, payloadSize int) {
 	}
 }
 
+func TestBannedClient(t *testing.T) {
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
+	testMetrics := &probeTestMetrics{}
+	const testTimeout = 200 * time.Millisecond
+	s := NewTCPService(cipherList, nil, testMetrics, testTimeout, nil)
+	bans := NewBanList(BanConfig{Window: time.Minute, MaxFailures: 1, BanDuration: time.Minute}, &metrics.NoOpMetrics{}, nil)
+	defer bans.Close()
+	s.SetBanList(bans)
+	go s.Serve(listener)
+
+	// The first probe is absorbed and gets the client banned.
+	require.Nil(t, probe(listener.Addr().(*net.TCPAddr), make([]byte, 50)))
+	// The second connection is closed without reading anything.
+	start := time.Now()
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	conn.Write(make([]byte, 50))
+	nRead, _ := conn.Read(make([]byte, 1))
+	require.Equal(t, 0, nRead)
+	require.Less(t, time.Since(start), testTimeout, "Banned client should be closed immediately")
+	conn.Close()
+
+	s.GracefulStop()
+	require.Equal(t, []string{"ERR_CIPHER", "ERR_BANNED"}, testMetrics.closeStatus)
+	require.Equal(t, 1, len(testMetrics.probeData))
+	require.Equal(t, 1, testMetrics.lookups, "Banned client should not be looked up")
+}
+
+func TestTCPDataReports(t *testing.T) {
+	targetListener, targetRunning := startDiscardServer(t)
+	defer targetRunning.Wait()
+	defer targetListener.Close()
+
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
+	testMetrics := &probeTestMetrics{}
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
+	s.SetTargetIPValidator(allowAll)
+	s.SetDataReportInterval(10 * time.Millisecond)
+	go s.Serve(listener)
+
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	clientBytes := makeClientBytesBasic(t, entry.Cipher, targetListener.Addr().String())
+	_, err = conn.Write(clientBytes)
+	require.Nil(t, err)
+
+	// The data is reported while the connection is open.
+	reported := func() metrics.ProxyMetrics {
+		testMetrics.mu.Lock()
+		defer testMetrics.mu.Unlock()
+		return testMetrics.tcpData
+	}
+	deadline := time.Now().Add(time.Second)
+	for reported().ProxyTarget == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Greater(t, reported().ProxyTarget, int64(0))
+	testMetrics.mu.Lock()
+	require.Empty(t, testMetrics.closeStatus)
+	testMetrics.mu.Unlock()
+
+	// The rest is reported when it closes.
+	conn.Close()
+	s.GracefulStop()
+	require.Equal(t, []string{"OK"}, testMetrics.closeStatus)
+	require.Equal(t, int64(len(clientBytes)), reported().ClientProxy)
+}
+
+func TestTCPRevokeKey(t *testing.T) {
+	// A target that reports its connections.
+	targetListener := makeLocalhostListener(t)
+	defer targetListener.Close()
+	accepted := make(chan struct{})
+	go func() {
+		for {
+			conn, err := targetListener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(2))
+	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
+	testMetrics := &probeTestMetrics{}
+	s := NewTCPService(cipherList, nil, testMetrics, 200*time.Millisecond, nil)
+	s.SetTargetIPValidator(allowAll)
+	go s.Serve(listener)
+
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(makeClientBytesBasic(t, entry.Cipher, targetListener.Addr().String()))
+	require.Nil(t, err)
+	<-accepted
+
+	require.Equal(t, 0, s.RevokeKey("unknown"))
+	cipherList.RemoveCipher(entry.ID)
+	require.Equal(t, 1, s.RevokeKey(entry.ID))
+	// The proxy closes the connection.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.NotNil(t, err)
+	netErr, ok := err.(net.Error)
+	require.False(t, ok && netErr.Timeout(), "Connection should be closed by the proxy")
+
+	s.GracefulStop()
+	require.Equal(t, []string{"ERR_KEY_REVOKED"}, testMetrics.closeStatus)
+	require.Equal(t, 0, s.RevokeKey(entry.ID), "Closed sessions should be forgotten")
+}
+
 func TestTCPDoubleServe(t *testing.T) {
 	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
 	require.Nil(t, err, "MakeTestCiphers failed: %v", err)
 	replayCache := NewReplayCache(5)
 	testMetrics := &probeTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout)
+	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout, nil)
 
 	c := make(chan error)
 	for i := 0; i < 2; i++ {
@@ -704,7 +847,7 @@ func TestTCPEarlyStop(t *testing.T) {
 	replayCache := NewReplayCache(5)
 	testMetrics := &probeTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout)
+	s := NewTCPService(cipherList, &replayCache, testMetrics, testTimeout, nil)
 
 	if err := s.Stop(); err != nil {
 		t.Error(err)
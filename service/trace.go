@@ -0,0 +1,78 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+)
+
+// Span names.  A TCP connection is a SpanTCPConnection with a child span for
+// each stage, and a UDP NAT entry is a single SpanUDPSession.
+const (
+	SpanTCPConnection  = "tcp.connection"
+	SpanTCPAccept      = "tcp.accept"
+	SpanTCPKeySearch   = "tcp.key_search"
+	SpanTCPReplayCheck = "tcp.replay_check"
+	SpanTCPDial        = "tcp.dial"
+	SpanTCPRelay       = "tcp.relay"
+	SpanUDPSession     = "udp.session"
+)
+
+// Span is a stage of a connection, recorded by a Tracer.
+type Span interface {
+	// SetAttribute adds an attribute to the span.  `value` is a string, an
+	// int, an int64 or a bool.
+	SetAttribute(key string, value interface{})
+	// End ends the span with `status`, "OK" or the error status of the stage.
+	End(status string)
+}
+
+// Tracer starts the spans of the TCP connections and UDP NAT entries.  It
+// must be safe for concurrent use.
+type Tracer interface {
+	// StartSpan starts a span named `name`, that is a child of `parent`
+	// unless it's nil.
+	StartSpan(name string, parent Span) Span
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End(status string)                          {}
+
+// Starts a span with `tracer`, or a span that does nothing if it's nil.
+func startSpan(tracer Tracer, name string, parent Span) Span {
+	if tracer == nil {
+		return noopSpan{}
+	}
+	return tracer.StartSpan(name, parent)
+}
+
+// Sets the location of the client on `span`, and its ASN if known.
+func setClientAttributes(span Span, clientInfo metrics.ClientInfo) {
+	span.SetAttribute("location", clientInfo.CountryCode)
+	if clientInfo.ASN != 0 {
+		span.SetAttribute("asn", clientInfo.ASN)
+	}
+}
+
+// Returns the status of a stage that failed with `err`, or "OK".
+func spanStatus(err *onet.ConnectionError) string {
+	if err == nil {
+		return "OK"
+	}
+	return err.Status
+}
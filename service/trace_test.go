@@ -0,0 +1,201 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
+	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSpan struct {
+	tracer     *fakeTracer
+	name       string
+	parent     *fakeSpan
+	attributes map[string]interface{}
+	status     string
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *fakeSpan) End(status string) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.status = status
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+// A Tracer that keeps the ended spans.
+type fakeTracer struct {
+	mu    sync.Mutex
+	ended []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(name string, parent Span) Span {
+	s := &fakeSpan{tracer: t, name: name, attributes: make(map[string]interface{})}
+	if parent != nil {
+		s.parent = parent.(*fakeSpan)
+	}
+	return s
+}
+
+func (t *fakeTracer) spans() map[string]*fakeSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byName := make(map[string]*fakeSpan)
+	for _, s := range t.ended {
+		byName[s.name] = s
+	}
+	return byName
+}
+
+func TestTCPSpans(t *testing.T) {
+	tracer := &fakeTracer{}
+	targetListener, targetRunning := startDiscardServer(t)
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.NoError(t, err)
+	replayCache := NewReplayCache(5)
+	s := NewTCPService(cipherList, &replayCache, &probeTestMetrics{}, 200*time.Millisecond, nil)
+	s.SetTargetIPValidator(allowAll)
+	s.SetTracer(tracer)
+	go s.Serve(listener)
+
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	_, err = conn.Write(makeClientBytesBasic(t, entry.Cipher, targetListener.Addr().String()))
+	require.NoError(t, err)
+	conn.CloseWrite()
+	io.Copy(ioutil.Discard, conn)
+	conn.Close()
+	s.GracefulStop()
+	targetListener.Close()
+	targetRunning.Wait()
+
+	spans := tracer.spans()
+	root := spans[SpanTCPConnection]
+	require.NotNil(t, root)
+	require.Nil(t, root.parent)
+	require.Equal(t, "OK", root.status)
+	require.Equal(t, entry.ID, root.attributes["access_key"])
+	require.Equal(t, listener.Addr().(*net.TCPAddr).Port, root.attributes["port"])
+	for _, name := range []string{SpanTCPAccept, SpanTCPKeySearch, SpanTCPReplayCheck, SpanTCPDial, SpanTCPRelay} {
+		require.Contains(t, spans, name)
+		require.Equal(t, root, spans[name].parent, name)
+		require.Equal(t, "OK", spans[name].status, name)
+	}
+	require.Equal(t, targetListener.Addr().String(), spans[SpanTCPDial].attributes["target"])
+}
+
+func TestTCPSpans_Replay(t *testing.T) {
+	tracer := &fakeTracer{}
+	listener := makeLocalhostListener(t)
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.NoError(t, err)
+	replayCache := NewReplayCache(5)
+	s := NewTCPService(cipherList, &replayCache, &probeTestMetrics{}, 200*time.Millisecond, nil)
+	s.SetTargetIPValidator(allowAll)
+	s.SetTracer(tracer)
+	go s.Serve(listener)
+
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	// The target isn't reachable, but only the replay matters.
+	request := makeClientBytesBasic(t, entry.Cipher, "127.0.0.1:9")
+	send := func() {
+		conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+		require.NoError(t, err)
+		conn.Write(request)
+		conn.CloseWrite()
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}
+	send()
+	send()
+	s.GracefulStop()
+
+	var replayed *fakeSpan
+	for _, span := range tracer.ended {
+		if span.name == SpanTCPReplayCheck && span.status == "ERR_REPLAY_CLIENT" {
+			replayed = span.parent
+		}
+	}
+	require.NotNil(t, replayed)
+	require.Equal(t, "ERR_REPLAY_CLIENT", replayed.status)
+	for _, span := range tracer.ended {
+		// The replayed connection isn't dialed.
+		require.False(t, span.parent == replayed && span.name == SpanTCPDial)
+	}
+}
+
+func TestUDPSpans(t *testing.T) {
+	tracer := &fakeTracer{}
+	targetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer targetConn.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := targetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			targetConn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	cipherList, err := MakeTestCiphers(ss.MakeTestSecrets(1))
+	require.NoError(t, err)
+	entry := cipherList.SnapshotForClientIP(nil)[0].Value.(*CipherEntry)
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewUDPService(time.Minute, cipherList, &metrics.NoOpMetrics{}, nil)
+	s.SetTargetIPValidator(allowAll)
+	s.SetTracer(tracer)
+	go s.Serve(serverConn)
+
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer clientConn.Close()
+	plaintext := append(socks.ParseAddr(targetConn.LocalAddr().String()), make([]byte, 10)...)
+	packet, err := ss.Pack(make([]byte, serverUDPBufferSize), plaintext, entry.Cipher)
+	require.NoError(t, err)
+	_, err = clientConn.WriteTo(packet, serverConn.LocalAddr())
+	require.NoError(t, err)
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = clientConn.ReadFrom(make([]byte, serverUDPBufferSize))
+	require.NoError(t, err)
+	s.GracefulStop()
+
+	session := tracer.spans()[SpanUDPSession]
+	require.NotNil(t, session)
+	require.Equal(t, "OK", session.status)
+	require.Equal(t, entry.ID, session.attributes["access_key"])
+	require.Equal(t, serverConn.LocalAddr().(*net.UDPAddr).Port, session.attributes["port"])
+	require.Equal(t, int64(1), session.attributes["packets_from_client"])
+	require.Equal(t, int64(1), session.attributes["packets_from_target"])
+}
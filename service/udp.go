@@ -20,37 +20,38 @@ import (
 	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
-	logging "github.com/op/go-logging"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
 
 // Max UDP buffer size for the server code.
 const serverUDPBufferSize = 64 * 1024
 
-// Wrapper for logger.Debugf during UDP proxying.
-func debugUDP(tag string, template string, val interface{}) {
+// Wrapper for logger.Debug during UDP proxying.
+func debugUDP(logger *log.Logger, tag log.Field, msg string, field log.Field) {
 	// This is an optimization to reduce unnecessary allocations due to an interaction
-	// between Go's inlining/escape analysis and varargs functions like logger.Debugf.
-	if logger.IsEnabledFor(logging.DEBUG) {
-		logger.Debugf("UDP(%s): "+template, tag, val)
+	// between Go's inlining/escape analysis and varargs functions like logger.Debug.
+	if logger.Enabled(log.LevelDebug) {
+		logger.Debug(msg, tag, field)
 	}
 }
 
-func debugUDPAddr(addr net.Addr, template string, val interface{}) {
-	if logger.IsEnabledFor(logging.DEBUG) {
-		// Avoid calling addr.String() unless debugging is enabled.
-		debugUDP(addr.String(), template, val)
+func debugUDPAddr(logger *log.Logger, privacy *IPPrivacy, addr net.Addr, msg string, field log.Field) {
+	if logger.Enabled(log.LevelDebug) {
+		// Avoid formatting addr unless debugging is enabled.
+		debugUDP(logger, log.String("client", privacy.Addr(addr)), msg, field)
 	}
 }
 
 // Decrypts src into dst. It tries each cipher until it finds one that authenticates
 // correctly. dst and src must not overlap.
-func findAccessKeyUDP(clientIP net.IP, dst, src []byte, cipherList CipherList) ([]byte, string, *ss.Cipher, error) {
+func findAccessKeyUDP(clientIP net.IP, dst, src []byte, cipherList CipherList, logger *log.Logger) ([]byte, string, *ss.Cipher, error) {
 	// Try each cipher until we find one that authenticates successfully. This assumes that all ciphers are AEAD.
 	// We snapshot the list because it may be modified while we use it.
 	snapshot := cipherList.SnapshotForClientIP(clientIP)
@@ -58,10 +59,10 @@ func findAccessKeyUDP(clientIP net.IP, dst, src []byte, cipherList CipherList) (
 		id, cipher := entry.Value.(*CipherEntry).ID, entry.Value.(*CipherEntry).Cipher
 		buf, err := ss.Unpack(dst, src, cipher)
 		if err != nil {
-			debugUDP(id, "Failed to unpack: %v", err)
+			debugUDP(logger, log.String("access_key", id), "Failed to unpack", log.Err(err))
 			continue
 		}
-		debugUDP(id, "Found cipher at index %d", ci)
+		debugUDP(logger, log.String("access_key", id), "Found cipher", log.Int("index", ci))
 		// Move the active cipher to the front, so that the search is quicker next time.
 		cipherList.MarkUsedByClientIP(entry, clientIP)
 		return buf, id, cipher, nil
@@ -70,27 +71,66 @@ func findAccessKeyUDP(clientIP net.IP, dst, src []byte, cipherList CipherList) (
 }
 
 type udpService struct {
-	mu                sync.RWMutex // Protects .clientConn and .stopped
+	mu                sync.RWMutex // Protects .clientConn, .stopped and .nm
 	clientConn        net.PacketConn
 	stopped           bool
+	nm                *natmap
 	natTimeout        time.Duration
 	ciphers           CipherList
 	m                 metrics.ShadowsocksMetrics
 	running           sync.WaitGroup
 	targetIPValidator onet.TargetIPValidator
+	// `bans` is shared among all ports.  May be nil.
+	bans BanList
+	// Called for every new NAT entry.  May be nil.
+	authHook func(keyID string)
+	// May be nil.
+	accessLog AccessLog
+	privacy   *IPPrivacy
+	// May be nil.
+	tracer Tracer
+	// Number of packets to remember per access key for replay detection.
+	// Zero disables the check.
+	replayHistory int
+	logger        *log.Logger
 }
 
-// NewUDPService creates a UDPService
-func NewUDPService(natTimeout time.Duration, cipherList CipherList, m metrics.ShadowsocksMetrics) UDPService {
-	return &udpService{natTimeout: natTimeout, ciphers: cipherList, m: m, targetIPValidator: onet.RequirePublicIP}
+// NewUDPService creates a UDPService.  A nil `logger` discards the logs.
+func NewUDPService(natTimeout time.Duration, cipherList CipherList, m metrics.ShadowsocksMetrics, logger *log.Logger) UDPService {
+	return &udpService{natTimeout: natTimeout, ciphers: cipherList, m: m, targetIPValidator: onet.RequirePublicIP, logger: logger}
 }
 
 // UDPService is a running UDP shadowsocks proxy that can be stopped.
 type UDPService interface {
 	// SetTargetIPValidator sets the function to be used to validate the target IP addresses.
 	SetTargetIPValidator(targetIPValidator onet.TargetIPValidator)
+	// SetBanList sets the BanList that is consulted before unpacking the first
+	// packet of a new NAT entry.
+	SetBanList(bans BanList)
+	// SetReplayHistory enables replay detection for the most recent `capacity`
+	// packets of each access key.  It must be called before Serve.  Returns an
+	// error if `capacity` exceeds MaxCapacity.
+	SetReplayHistory(capacity int) error
+	// SetAuthHook sets a function that is called with the access key ID of
+	// every new NAT entry.  It must be called before Serve, and the function
+	// must not block.
+	SetAuthHook(hook func(keyID string))
+	// SetAccessLog sets the AccessLog that receives every NAT entry when it
+	// expires.  It must be called before Serve.
+	SetAccessLog(log AccessLog)
+	// SetIPPrivacy sets how the client IPs appear in the logs, and which part
+	// of them is kept for the access key search.  It must be called before
+	// Serve.
+	SetIPPrivacy(privacy *IPPrivacy)
+	// SetTracer sets the Tracer that records a span for every NAT entry.  It
+	// must be called before Serve.
+	SetTracer(tracer Tracer)
 	// Serve adopts the clientConn, and will not return until it is closed by Stop().
 	Serve(clientConn net.PacketConn) error
+	// RevokeKey closes the NAT entries of the access key `keyID`.  Packets
+	// that arrive for them before they are removed are dropped with status
+	// ERR_KEY_REVOKED.  Returns the number of entries closed.
+	RevokeKey(keyID string) int
 	// Stop closes the clientConn and prevents further forwarding of packets.
 	Stop() error
 	// GracefulStop calls Stop(), and then blocks until all resources have been cleaned up.
@@ -101,6 +141,63 @@ func (s *udpService) SetTargetIPValidator(targetIPValidator onet.TargetIPValidat
 	s.targetIPValidator = targetIPValidator
 }
 
+func (s *udpService) SetBanList(bans BanList) {
+	s.bans = bans
+}
+
+func (s *udpService) SetAuthHook(hook func(keyID string)) {
+	s.authHook = hook
+}
+
+func (s *udpService) SetAccessLog(log AccessLog) {
+	s.accessLog = log
+}
+
+func (s *udpService) SetIPPrivacy(privacy *IPPrivacy) {
+	s.privacy = privacy
+}
+
+func (s *udpService) SetTracer(tracer Tracer) {
+	s.tracer = tracer
+}
+
+func (s *udpService) SetReplayHistory(capacity int) error {
+	if capacity > MaxCapacity {
+		return fmt.Errorf("UDP replay history %d exceeds the maximum of %d", capacity, MaxCapacity)
+	}
+	s.replayHistory = capacity
+	return nil
+}
+
+// udpReplayFilters holds a replay filter per access key, so that a busy key
+// can't push the salts of other keys out of the history.  The filters belong
+// to the Serve goroutine, so they need no lock.  The history of a key is kept
+// after the key is removed, so that packets captured before the removal can't
+// be replayed if the key comes back with the same secret.
+type udpReplayFilters struct {
+	capacity int
+	filters  map[string]*mapReplayFilter
+}
+
+func newUDPReplayFilters(capacity int) *udpReplayFilters {
+	return &udpReplayFilters{capacity: capacity, filters: make(map[string]*mapReplayFilter)}
+}
+
+// Returns false if the packet's salt was already seen for this key.
+// Filters are allocated on the first packet of each key, and grow with the
+// number of packets, so idle keys cost little memory.
+func (r *udpReplayFilters) add(keyID string, salt []byte) bool {
+	if r == nil {
+		return true
+	}
+	f, ok := r.filters[keyID]
+	if !ok {
+		f = &mapReplayFilter{capacity: r.capacity, active: make(map[uint32]empty)}
+		r.filters[keyID] = f
+	}
+	return f.add(keyID, salt)
+}
+
 // Listen on addr for encrypted packets and basically do UDP NAT.
 // We take the ciphers as a pointer because it gets replaced on config updates.
 func (s *udpService) Serve(clientConn net.PacketConn) error {
@@ -119,17 +216,30 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 	s.mu.Unlock()
 	defer s.running.Done()
 
-	nm := newNATmap(s.natTimeout, s.m, &s.running)
+	nm := newNATmap(s.natTimeout, s.m, &s.running, s.logger)
 	defer nm.Close()
+	nm.privacy = s.privacy
+	nm.accessLog = s.accessLog
+	nm.tracer = s.tracer
+	if addr, ok := clientConn.LocalAddr().(*net.UDPAddr); ok {
+		nm.port = addr.Port
+	}
+	s.mu.Lock()
+	s.nm = nm
+	s.mu.Unlock()
 	cipherBuf := make([]byte, serverUDPBufferSize)
 	textBuf := make([]byte, serverUDPBufferSize)
+	var replays *udpReplayFilters
+	if s.replayHistory > 0 {
+		replays = newUDPReplayFilters(s.replayHistory)
+	}
 
 	stopped := false
 	for !stopped {
 		func() (connError *onet.ConnectionError) {
 			defer func() {
 				if r := recover(); r != nil {
-					logger.Errorf("Panic in UDP loop: %v", r)
+					s.logger.Error("Panic in UDP loop", log.Any("panic", r))
 					debug.PrintStack()
 				}
 			}()
@@ -147,25 +257,25 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 
 			// An upstream packet should have been read.  Set up the metrics reporting
 			// for this forwarding event.
-			clientLocation := ""
+			var clientInfo metrics.ClientInfo
 			keyID := ""
 			var proxyTargetBytes int
 			var timeToCipher time.Duration
 			defer func() {
 				status := "OK"
 				if connError != nil {
-					logger.Debugf("UDP Error: %v: %v", connError.Message, connError.Cause)
+					s.logger.Debug(connError.Message, log.String("status", connError.Status), log.Err(connError.Cause))
 					status = connError.Status
 				}
-				s.m.AddUDPPacketFromClient(clientLocation, keyID, status, clientProxyBytes, proxyTargetBytes, timeToCipher)
+				s.m.AddUDPPacketFromClient(clientInfo, keyID, status, clientProxyBytes, proxyTargetBytes, timeToCipher)
 			}()
 
 			if err != nil {
 				return onet.NewConnectionError("ERR_READ", "Failed to read from client", err)
 			}
-			if logger.IsEnabledFor(logging.DEBUG) {
-				defer logger.Debugf("UDP(%v): done", clientAddr)
-				logger.Debugf("UDP(%v): Outbound packet has %d bytes", clientAddr, clientProxyBytes)
+			if s.logger.Enabled(log.LevelDebug) {
+				defer s.logger.Debug("Done", s.privacy.AddrField("client", clientAddr))
+				s.logger.Debug("Outbound packet", s.privacy.AddrField("client", clientAddr), log.Int("bytes", clientProxyBytes))
 			}
 
 			cipherData := cipherBuf[:clientProxyBytes]
@@ -173,26 +283,34 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 			var tgtUDPAddr *net.UDPAddr
 			targetConn := nm.Get(clientAddr.String())
 			if targetConn == nil {
-				var locErr error
-				clientLocation, locErr = s.m.GetLocation(clientAddr)
-				if locErr != nil {
-					logger.Warningf("Failed location lookup: %v", locErr)
-				}
-				debugUDPAddr(clientAddr, "Got location \"%s\"", clientLocation)
-
+				// Banned clients are dropped before the client info lookup.
 				ip := clientAddr.(*net.UDPAddr).IP
+				if s.bans != nil && s.bans.IsBanned(ip) {
+					return onet.NewConnectionError("ERR_BANNED", "Client is banned", nil)
+				}
+				var infoErr error
+				clientInfo, infoErr = s.m.GetClientInfo(clientAddr)
+				if infoErr != nil {
+					s.logger.Warning("Failed client info lookup", log.Err(infoErr))
+				}
+				debugUDPAddr(s.logger, s.privacy, clientAddr, "Got client info", log.String("location", clientInfo.CountryCode))
 				var textData []byte
 				var cipher *ss.Cipher
 				unpackStart := time.Now()
-				textData, keyID, cipher, err = findAccessKeyUDP(ip, textBuf, cipherData, s.ciphers)
+				textData, keyID, cipher, err = findAccessKeyUDP(s.privacy.Retain(ip), textBuf, cipherData, s.ciphers, s.logger)
 				timeToCipher = time.Now().Sub(unpackStart)
 
 				if err != nil {
 					return onet.NewConnectionError("ERR_CIPHER", "Failed to unpack initial packet", err)
 				}
+				if !replays.add(keyID, cipherData[:cipher.SaltSize()]) {
+					return onet.NewConnectionError("ERR_REPLAY", "Replay detected", nil)
+				}
 
 				var onetErr *onet.ConnectionError
-				if payload, tgtUDPAddr, onetErr = s.validatePacket(textData); onetErr != nil {
+				payload, tgtUDPAddr, onetErr = s.validatePacket(textData)
+				s.addTargetDial(textData, tgtUDPAddr, onetErr)
+				if onetErr != nil {
 					return onetErr
 				}
 
@@ -200,9 +318,19 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 				if err != nil {
 					return onet.NewConnectionError("ERR_CREATE_SOCKET", "Failed to create UDP socket", err)
 				}
-				targetConn = nm.Add(clientAddr, clientConn, cipher, udpConn, clientLocation, keyID)
+				targetConn = nm.Add(clientAddr, clientConn, cipher, udpConn, clientInfo, keyID)
+				if !s.ciphers.IsCipherExists(keyID) {
+					// The key was removed after the search, and might have missed the revocation.
+					targetConn.revoke()
+				} else if s.authHook != nil {
+					s.authHook(keyID)
+				}
 			} else {
-				clientLocation = targetConn.clientLocation
+				clientInfo = targetConn.clientInfo
+				if targetConn.isRevoked() {
+					keyID = targetConn.keyID
+					return onet.NewConnectionError("ERR_KEY_REVOKED", "Access key was revoked", nil)
+				}
 
 				unpackStart := time.Now()
 				textData, err := ss.Unpack(nil, cipherData, targetConn.cipher)
@@ -213,6 +341,9 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 
 				// The key ID is known with confidence once decryption succeeds.
 				keyID = targetConn.keyID
+				if !replays.add(keyID, cipherData[:targetConn.cipher.SaltSize()]) {
+					return onet.NewConnectionError("ERR_REPLAY", "Replay detected", nil)
+				}
 
 				var onetErr *onet.ConnectionError
 				if payload, tgtUDPAddr, onetErr = s.validatePacket(textData); onetErr != nil {
@@ -220,11 +351,15 @@ func (s *udpService) Serve(clientConn net.PacketConn) error {
 				}
 			}
 
-			debugUDPAddr(clientAddr, "Proxy exit %v", targetConn.LocalAddr())
+			if targetConn.isRevoked() {
+				return onet.NewConnectionError("ERR_KEY_REVOKED", "Access key was revoked", nil)
+			}
+			debugUDPAddr(s.logger, s.privacy, clientAddr, "Proxy exit", log.Any("exit", targetConn.LocalAddr()))
 			proxyTargetBytes, err = targetConn.WriteTo(payload, tgtUDPAddr) // accept only UDPAddr despite the signature
 			if err != nil {
 				return onet.NewConnectionError("ERR_WRITE", "Failed to write to target", err)
 			}
+			targetConn.stats.addFromClient(tgtUDPAddr, clientProxyBytes, proxyTargetBytes)
 			return nil
 		}()
 	}
@@ -242,7 +377,8 @@ func (s *udpService) validatePacket(textData []byte) ([]byte, *net.UDPAddr, *one
 
 	tgtUDPAddr, err := net.ResolveUDPAddr("udp", tgtAddr.String())
 	if err != nil {
-		return nil, nil, onet.NewConnectionError("ERR_RESOLVE_ADDRESS", fmt.Sprintf("Failed to resolve target address %v", tgtAddr), err)
+		status := targetErrorStatus(err, "ERR_RESOLVE_ADDRESS")
+		return nil, nil, onet.NewConnectionError(status, fmt.Sprintf("Failed to resolve target address %v", tgtAddr), err)
 	}
 	if err := s.targetIPValidator(tgtUDPAddr.IP); err != nil {
 		return nil, nil, err
@@ -252,6 +388,32 @@ func (s *udpService) validatePacket(textData []byte) ([]byte, *net.UDPAddr, *one
 	return payload, tgtUDPAddr, nil
 }
 
+// Reports the target of a new NAT entry to the metrics: `tgtUDPAddr`, or the
+// address in `textData` if validatePacket failed with `err`.
+func (s *udpService) addTargetDial(textData []byte, tgtUDPAddr *net.UDPAddr, err *onet.ConnectionError) {
+	var ip net.IP
+	var port int
+	if tgtUDPAddr != nil {
+		ip, port = tgtUDPAddr.IP, tgtUDPAddr.Port
+	} else if tgtAddr := socks.SplitAddr(textData); tgtAddr != nil {
+		ip, port = splitSocksAddr(tgtAddr)
+	} else {
+		// There is no target.
+		return
+	}
+	s.m.AddTargetDial("udp", s.m.GetTargetInfo(ip, port), spanStatus(err), 0)
+}
+
+func (s *udpService) RevokeKey(keyID string) int {
+	s.mu.RLock()
+	nm := s.nm
+	s.mu.RUnlock()
+	if nm == nil {
+		return 0
+	}
+	return nm.revoke(keyID)
+}
+
 func (s *udpService) Stop() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -277,9 +439,9 @@ type natconn struct {
 	net.PacketConn
 	cipher *ss.Cipher
 	keyID  string
-	// We store the client location in the NAT map to avoid recomputing it
+	// We store the client info in the NAT map to avoid recomputing it
 	// for every downstream packet in a UDP-based connection.
-	clientLocation string
+	clientInfo metrics.ClientInfo
 	// NAT timeout to apply for non-DNS packets.
 	defaultTimeout time.Duration
 	// Current read deadline of PacketConn.  Used to avoid decreasing the
@@ -288,6 +450,20 @@ type natconn struct {
 	// If the connection has only sent one DNS query, it will close
 	// if it receives a DNS response.
 	fastClose sync.Once
+	// Set to 1 when the access key is revoked.
+	revoked int32
+	start   time.Time
+	stats   natStats
+}
+
+// Closes the connection to the target, which ends timedCopy.
+func (c *natconn) revoke() {
+	atomic.StoreInt32(&c.revoked, 1)
+	c.Close()
+}
+
+func (c *natconn) isRevoked() bool {
+	return atomic.LoadInt32(&c.revoked) == 1
 }
 
 func (c *natconn) onWrite(addr net.Addr) {
@@ -342,10 +518,18 @@ type natmap struct {
 	timeout time.Duration
 	metrics metrics.ShadowsocksMetrics
 	running *sync.WaitGroup
+	// Receives the entries when they expire.  May be nil.
+	accessLog AccessLog
+	// The port of the service, for the metrics and the access log.
+	port    int
+	privacy *IPPrivacy
+	// Records a span for every entry.  May be nil.
+	tracer Tracer
+	logger *log.Logger
 }
 
-func newNATmap(timeout time.Duration, sm metrics.ShadowsocksMetrics, running *sync.WaitGroup) *natmap {
-	m := &natmap{metrics: sm, running: running}
+func newNATmap(timeout time.Duration, sm metrics.ShadowsocksMetrics, running *sync.WaitGroup, logger *log.Logger) *natmap {
+	m := &natmap{metrics: sm, running: running, logger: logger}
 	m.keyConn = make(map[string]*natconn)
 	m.timeout = timeout
 	return m
@@ -357,13 +541,14 @@ func (m *natmap) Get(key string) *natconn {
 	return m.keyConn[key]
 }
 
-func (m *natmap) set(key string, pc net.PacketConn, cipher *ss.Cipher, keyID, clientLocation string) *natconn {
+func (m *natmap) set(key string, pc net.PacketConn, cipher *ss.Cipher, keyID string, clientInfo metrics.ClientInfo) *natconn {
 	entry := &natconn{
 		PacketConn:     pc,
 		cipher:         cipher,
 		keyID:          keyID,
-		clientLocation: clientLocation,
+		clientInfo:     clientInfo,
 		defaultTimeout: m.timeout,
+		start:          time.Now(),
 	}
 
 	m.Lock()
@@ -385,14 +570,19 @@ func (m *natmap) del(key string) net.PacketConn {
 	return nil
 }
 
-func (m *natmap) Add(clientAddr net.Addr, clientConn net.PacketConn, cipher *ss.Cipher, targetConn net.PacketConn, clientLocation, keyID string) *natconn {
-	entry := m.set(clientAddr.String(), targetConn, cipher, keyID, clientLocation)
+func (m *natmap) Add(clientAddr net.Addr, clientConn net.PacketConn, cipher *ss.Cipher, targetConn net.PacketConn, clientInfo metrics.ClientInfo, keyID string) *natconn {
+	entry := m.set(clientAddr.String(), targetConn, cipher, keyID, clientInfo)
+	span := startSpan(m.tracer, SpanUDPSession, nil)
 
-	m.metrics.AddUDPNatEntry()
+	m.metrics.AddUDPNatEntry(keyID, m.port)
 	m.running.Add(1)
 	go func() {
-		timedCopy(clientAddr, clientConn, entry, keyID, m.metrics)
-		m.metrics.RemoveUDPNatEntry()
+		timedCopy(clientAddr, clientConn, entry, keyID, m.metrics, m.privacy, m.logger)
+		m.metrics.RemoveUDPNatEntry(keyID, m.port, time.Since(entry.start))
+		if m.accessLog != nil {
+			m.logEntry(clientAddr, entry)
+		}
+		m.endSpan(span, entry)
 		if pc := m.del(clientAddr.String()); pc != nil {
 			pc.Close()
 		}
@@ -401,6 +591,59 @@ func (m *natmap) Add(clientAddr net.Addr, clientConn net.PacketConn, cipher *ss.
 	return entry
 }
 
+func (m *natmap) logEntry(clientAddr net.Addr, entry *natconn) {
+	logEntry := AccessLogEntry{
+		Proto:                "udp",
+		AccessKey:            entry.keyID,
+		Port:                 m.port,
+		ClientAddr:           clientAddr,
+		ClientLocation:       entry.clientInfo.CountryCode,
+		ClientASN:            entry.clientInfo.ASN,
+		ClientASOrganization: entry.clientInfo.ASOrganization,
+		Status:               "OK",
+		Start:                entry.start,
+		Duration:             time.Since(entry.start),
+	}
+	if entry.isRevoked() {
+		logEntry.Status = "ERR_KEY_REVOKED"
+	}
+	entry.stats.fill(&logEntry)
+	m.accessLog.Log(logEntry)
+}
+
+// Ends the span of `entry` with its access key, location and data.
+func (m *natmap) endSpan(span Span, entry *natconn) {
+	var stats AccessLogEntry
+	entry.stats.fill(&stats)
+	span.SetAttribute("access_key", entry.keyID)
+	span.SetAttribute("port", m.port)
+	setClientAttributes(span, entry.clientInfo)
+	span.SetAttribute("client_proxy_bytes", stats.Data.ClientProxy)
+	span.SetAttribute("proxy_client_bytes", stats.Data.ProxyClient)
+	span.SetAttribute("packets_from_client", stats.PacketsFromClient)
+	span.SetAttribute("packets_from_target", stats.PacketsFromTarget)
+	if entry.isRevoked() {
+		span.End("ERR_KEY_REVOKED")
+	} else {
+		span.End("OK")
+	}
+}
+
+// Revokes the entries of `keyID`.  They are removed from the map when their
+// timedCopy ends.
+func (m *natmap) revoke(keyID string) int {
+	m.RLock()
+	defer m.RUnlock()
+	count := 0
+	for _, entry := range m.keyConn {
+		if entry.keyID == keyID && !entry.isRevoked() {
+			entry.revoke()
+			count++
+		}
+	}
+	return count
+}
+
 func (m *natmap) Close() error {
 	m.Lock()
 	defer m.Unlock()
@@ -421,7 +664,7 @@ var maxAddrLen int = len(socks.ParseAddr("[2001:db8::1]:12345"))
 
 // copy from target to client until read timeout
 func timedCopy(clientAddr net.Addr, clientConn net.PacketConn, targetConn *natconn,
-	keyID string, sm metrics.ShadowsocksMetrics) {
+	keyID string, sm metrics.ShadowsocksMetrics, privacy *IPPrivacy, logger *log.Logger) {
 	// pkt is used for in-place encryption of downstream UDP packets, with the layout
 	// [padding?][salt][address][body][tag][extra]
 	// Padding is only used if the address is IPv4.
@@ -432,6 +675,7 @@ func timedCopy(clientAddr net.Addr, clientConn net.PacketConn, targetConn *natco
 	bodyStart := saltSize + maxAddrLen
 
 	expired := false
+	gotResponse := false
 	for {
 		var bodyLen, proxyClientBytes int
 		connError := func() (connError *onet.ConnectionError) {
@@ -454,7 +698,12 @@ func timedCopy(clientAddr net.Addr, clientConn net.PacketConn, targetConn *natco
 				return onet.NewConnectionError("ERR_READ", "Failed to read from target", err)
 			}
 
-			debugUDPAddr(clientAddr, "Got response from %v", raddr)
+			if !gotResponse {
+				// The entry is created right before the first packet is sent.
+				gotResponse = true
+				sm.AddTimeToFirstByte("udp", time.Since(targetConn.start))
+			}
+			debugUDPAddr(logger, privacy, clientAddr, "Got response", log.Any("target", raddr))
 			srcAddr := socks.ParseAddr(raddr.String())
 			addrStart := bodyStart - len(srcAddr)
 			// `plainTextBuf` concatenates the SOCKS address and body:
@@ -483,12 +732,15 @@ func timedCopy(clientAddr net.Addr, clientConn net.PacketConn, targetConn *natco
 		}()
 		status := "OK"
 		if connError != nil {
-			logger.Debugf("UDP Error: %v: %v", connError.Message, connError.Cause)
+			logger.Debug(connError.Message, log.String("status", connError.Status), log.Err(connError.Cause))
 			status = connError.Status
 		}
-		if expired {
+		if expired || (connError != nil && targetConn.isRevoked()) {
 			break
 		}
-		sm.AddUDPPacketFromTarget(targetConn.clientLocation, keyID, status, bodyLen, proxyClientBytes)
+		sm.AddUDPPacketFromTarget(targetConn.clientInfo, keyID, status, bodyLen, proxyClientBytes)
+		if connError == nil {
+			targetConn.stats.addFromTarget(bodyLen, proxyClientBytes)
+		}
 	}
 }
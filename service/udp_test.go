@@ -25,7 +25,6 @@ import (
 	onet "github.com/evgeniy-krivenko/outline-ss-server/net"
 	"github.com/evgeniy-krivenko/outline-ss-server/service/metrics"
 	ss "github.com/evgeniy-krivenko/outline-ss-server/shadowsocks"
-	logging "github.com/op/go-logging"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,7 +37,6 @@ var dnsAddr = net.UDPAddr{IP: []byte{192, 0, 2, 3}, Port: 53}
 var natCipher *ss.Cipher
 
 func init() {
-	logging.SetLevel(logging.INFO, "")
 	natCipher, _ = ss.NewCipher(ss.TestCipher, "test password")
 }
 
@@ -67,6 +65,10 @@ func (conn *fakePacketConn) SetReadDeadline(deadline time.Time) error {
 	return nil
 }
 
+func (conn *fakePacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8388}
+}
+
 func (conn *fakePacketConn) WriteTo(payload []byte, addr net.Addr) (int, error) {
 	conn.send <- packet{addr, payload, nil}
 	return len(payload), nil
@@ -102,26 +104,34 @@ type natTestMetrics struct {
 	upstreamPackets []udpReport
 }
 
-func (m *natTestMetrics) AddTCPProbe(clientLocation, status, drainResult string, port int, data metrics.ProxyMetrics) {
+func (m *natTestMetrics) AddTCPProbe(clientInfo metrics.ClientInfo, status, drainResult string, port int, data metrics.ProxyMetrics) {
+}
+func (m *natTestMetrics) AddClosedTCPConnection(clientInfo metrics.ClientInfo, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
 }
-func (m *natTestMetrics) AddClosedTCPConnection(clientLocation, accessKey, status string, data metrics.ProxyMetrics, timeToCipher, duration time.Duration) {
+func (m *natTestMetrics) AddTCPData(accessKey string, data metrics.ProxyMetrics) {
 }
-func (m *natTestMetrics) GetLocation(net.Addr) (string, error) {
-	return "", nil
+func (m *natTestMetrics) GetClientInfo(net.Addr) (metrics.ClientInfo, error) {
+	return metrics.ClientInfo{}, nil
 }
 func (m *natTestMetrics) SetNumAccessKeys(numKeys int, numPorts int) {
 }
-func (m *natTestMetrics) AddOpenTCPConnection(clientLocation string) {
+func (m *natTestMetrics) AddOpenTCPConnection(clientInfo metrics.ClientInfo) {
+}
+func (m *natTestMetrics) AddUDPPacketFromClient(clientInfo metrics.ClientInfo, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
+	m.upstreamPackets = append(m.upstreamPackets, udpReport{clientInfo.CountryCode, accessKey, status, clientProxyBytes, proxyTargetBytes})
+}
+func (m *natTestMetrics) AddUDPPacketFromTarget(clientInfo metrics.ClientInfo, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
 }
-func (m *natTestMetrics) AddUDPPacketFromClient(clientLocation, accessKey, status string, clientProxyBytes, proxyTargetBytes int, timeToCipher time.Duration) {
-	m.upstreamPackets = append(m.upstreamPackets, udpReport{clientLocation, accessKey, status, clientProxyBytes, proxyTargetBytes})
+func (m *natTestMetrics) AddTimeToFirstByte(proto string, ttfb time.Duration) {}
+func (m *natTestMetrics) GetTargetInfo(ip net.IP, port int) metrics.TargetInfo {
+	return metrics.TargetInfo{}
 }
-func (m *natTestMetrics) AddUDPPacketFromTarget(clientLocation, accessKey, status string, targetProxyBytes, proxyClientBytes int) {
+func (m *natTestMetrics) AddTargetDial(proto string, targetInfo metrics.TargetInfo, status string, duration time.Duration) {
 }
-func (m *natTestMetrics) AddUDPNatEntry() {
+func (m *natTestMetrics) AddUDPNatEntry(accessKey string, port int) {
 	m.natEntriesAdded++
 }
-func (m *natTestMetrics) RemoveUDPNatEntry() {}
+func (m *natTestMetrics) RemoveUDPNatEntry(accessKey string, port int, duration time.Duration) {}
 
 // Takes a validation policy, and returns the metrics it
 // generates when localhost access is attempted
@@ -130,7 +140,7 @@ func sendToDiscard(payloads [][]byte, validator onet.TargetIPValidator) *natTest
 	cipher := ciphers.SnapshotForClientIP(nil)[0].Value.(*CipherEntry).Cipher
 	clientConn := makePacketConn()
 	metrics := &natTestMetrics{}
-	service := NewUDPService(timeout, ciphers, metrics)
+	service := NewUDPService(timeout, ciphers, metrics, nil)
 	service.SetTargetIPValidator(validator)
 	go service.Serve(clientConn)
 
@@ -193,6 +203,97 @@ func TestUpstreamMetrics(t *testing.T) {
 	}
 }
 
+func TestUDPReplay(t *testing.T) {
+	ciphers, _ := MakeTestCiphers([]string{"asdf", "qwer"})
+	snapshot := ciphers.SnapshotForClientIP(nil)
+	clientConn := makePacketConn()
+	metrics := &natTestMetrics{}
+	service := NewUDPService(timeout, ciphers, metrics, nil)
+	service.SetTargetIPValidator(allowAll)
+	if err := service.SetReplayHistory(10); err != nil {
+		t.Fatal(err)
+	}
+	go service.Serve(clientConn)
+
+	targetAddr := socks.ParseAddr("127.0.0.1:9")
+	plaintext := append(targetAddr, []byte("payload")...)
+	pack := func(cipher *ss.Cipher) []byte {
+		ciphertext := make([]byte, cipher.SaltSize()+len(plaintext)+cipher.TagSize())
+		ss.Pack(ciphertext, plaintext, cipher)
+		return ciphertext
+	}
+	send := func(payload []byte, port int) {
+		clientConn.recv <- packet{
+			addr:    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: port},
+			payload: payload,
+		}
+	}
+	cipher0 := snapshot[0].Value.(*CipherEntry).Cipher
+	cipher1 := snapshot[1].Value.(*CipherEntry).Cipher
+	first := pack(cipher0)
+	send(first, 54321)
+	// Replay on the same NAT entry.
+	send(first, 54321)
+	// Replay from a different source port, which would create a new NAT entry.
+	send(first, 54322)
+	send(pack(cipher0), 54321)
+	send(pack(cipher1), 54323)
+	// The history outlives the revocation of the key.
+	service.RevokeKey("id-0")
+	send(first, 54324)
+
+	service.GracefulStop()
+	var statuses []string
+	for _, report := range metrics.upstreamPackets {
+		statuses = append(statuses, report.status)
+	}
+	assert.Equal(t, []string{"OK", "ERR_REPLAY", "ERR_REPLAY", "OK", "OK", "ERR_REPLAY"}, statuses)
+	assert.Equal(t, 2, metrics.natEntriesAdded, "Replayed packets should not create NAT entries")
+}
+
+func TestUDPReplayFilters_PerKey(t *testing.T) {
+	replays := newUDPReplayFilters(2)
+	salt := []byte("0123456789abcdef")
+	assert.True(t, replays.add("key1", salt))
+	assert.False(t, replays.add("key1", salt))
+	assert.True(t, replays.add("key2", salt), "Keys should not share a history")
+	assert.Equal(t, 2, len(replays.filters))
+	// A nil filter set accepts everything.
+	var disabled *udpReplayFilters
+	assert.True(t, disabled.add("key1", salt))
+}
+
+func TestUDPReplayHistoryTooLarge(t *testing.T) {
+	service := NewUDPService(timeout, NewCipherList(), &natTestMetrics{}, nil)
+	assert.Error(t, service.SetReplayHistory(MaxCapacity+1))
+}
+
+func TestNATRevoke(t *testing.T) {
+	var running sync.WaitGroup
+	nat := newNATmap(timeout, &natTestMetrics{}, &running, nil)
+	clientConn := makePacketConn()
+	listen := func() net.PacketConn {
+		targetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return targetConn
+	}
+	otherAddr := net.UDPAddr{IP: []byte{192, 0, 2, 2}, Port: 12345}
+	revoked := nat.Add(&clientAddr, clientConn, natCipher, listen(), metrics.ClientInfo{CountryCode: "ZZ"}, "revoked")
+	other := nat.Add(&otherAddr, clientConn, natCipher, listen(), metrics.ClientInfo{CountryCode: "ZZ"}, "other")
+
+	assert.Equal(t, 0, nat.revoke("unknown"))
+	assert.Equal(t, 1, nat.revoke("revoked"))
+	assert.True(t, revoked.isRevoked())
+	assert.False(t, other.isRevoked())
+
+	// The revoked entry is removed from the map once its timedCopy ends.
+	other.SetReadDeadline(time.Now())
+	running.Wait()
+	assert.Nil(t, nat.Get(clientAddr.String()))
+}
+
 func assertAlmostEqual(t *testing.T, a, b time.Time) {
 	delta := a.Sub(b)
 	limit := 100 * time.Millisecond
@@ -202,17 +303,17 @@ func assertAlmostEqual(t *testing.T, a, b time.Time) {
 }
 
 func TestNATEmpty(t *testing.T) {
-	nat := newNATmap(timeout, &natTestMetrics{}, &sync.WaitGroup{})
+	nat := newNATmap(timeout, &natTestMetrics{}, &sync.WaitGroup{}, nil)
 	if nat.Get("foo") != nil {
 		t.Error("Expected nil value from empty NAT map")
 	}
 }
 
 func setupNAT() (*fakePacketConn, *fakePacketConn, *natconn) {
-	nat := newNATmap(timeout, &natTestMetrics{}, &sync.WaitGroup{})
+	nat := newNATmap(timeout, &natTestMetrics{}, &sync.WaitGroup{}, nil)
 	clientConn := makePacketConn()
 	targetConn := makePacketConn()
-	nat.Add(&clientAddr, clientConn, natCipher, targetConn, "ZZ", "key id")
+	nat.Add(&clientAddr, clientConn, natCipher, targetConn, metrics.ClientInfo{CountryCode: "ZZ"}, "key id")
 	entry := nat.Get(clientAddr.String())
 	return clientConn, targetConn, entry
 }
@@ -402,7 +503,7 @@ func BenchmarkUDPUnpackFail(b *testing.B) {
 	testIP := net.ParseIP("192.0.2.1")
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		findAccessKeyUDP(testIP, textBuf, testPayload, cipherList)
+		findAccessKeyUDP(testIP, textBuf, testPayload, cipherList, nil)
 	}
 }
 
@@ -432,7 +533,7 @@ func BenchmarkUDPUnpackRepeat(b *testing.B) {
 		cipherNumber := n % numCiphers
 		ip := ips[cipherNumber]
 		packet := packets[cipherNumber]
-		_, _, _, err := findAccessKeyUDP(ip, testBuf, packet, cipherList)
+		_, _, _, err := findAccessKeyUDP(ip, testBuf, packet, cipherList, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -460,7 +561,7 @@ func BenchmarkUDPUnpackSharedKey(b *testing.B) {
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		ip := ips[n%numIPs]
-		_, _, _, err := findAccessKeyUDP(ip, testBuf, packet, cipherList)
+		_, _, _, err := findAccessKeyUDP(ip, testBuf, packet, cipherList, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -474,7 +575,7 @@ func TestUDPDoubleServe(t *testing.T) {
 	}
 	testMetrics := &natTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewUDPService(testTimeout, cipherList, testMetrics)
+	s := NewUDPService(testTimeout, cipherList, testMetrics, nil)
 
 	c := make(chan error)
 	for i := 0; i < 2; i++ {
@@ -508,7 +609,7 @@ func TestUDPEarlyStop(t *testing.T) {
 	}
 	testMetrics := &natTestMetrics{}
 	const testTimeout = 200 * time.Millisecond
-	s := NewUDPService(testTimeout, cipherList, testMetrics)
+	s := NewUDPService(testTimeout, cipherList, testMetrics, nil)
 
 	if err := s.Stop(); err != nil {
 		t.Error(err)
@@ -94,6 +94,11 @@ type Cipher struct {
 	secret []byte
 }
 
+// Name is the name of the AEAD spec of this Cipher, e.g. "chacha20-ietf-poly1305".
+func (c *Cipher) Name() string {
+	return c.aead.name
+}
+
 // SaltSize is the size of the salt for this Cipher
 func (c *Cipher) SaltSize() int {
 	return c.aead.saltSize
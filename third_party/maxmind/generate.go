@@ -0,0 +1,248 @@
+// Copyright 2022 Jigsaw Operations LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+
+// Generates the test databases in test-data, with the layout of the
+// MaxMind-DB repository:
+//
+//	go run generate.go
+//
+// They are in the MaxMind DB format (https://maxmind.github.io/MaxMind-DB/),
+// and contain a few of the records of the MaxMind test databases, and the
+// documentation networks and ASNs.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"sort"
+)
+
+// Fixed so that the output doesn't change between runs.
+const buildEpoch = 1666224000
+
+type network struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+func asn(number uint32, organization string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       number,
+		"autonomous_system_organization": organization,
+	}
+}
+
+func country(geonameID uint32, isoCode, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"geoname_id": geonameID,
+			"iso_code":   isoCode,
+			"names":      map[string]interface{}{"en": name},
+		},
+	}
+}
+
+func main() {
+	if err := os.MkdirAll("test-data", 0755); err != nil {
+		log.Fatal(err)
+	}
+	write("test-data/GeoLite2-ASN-Test.mmdb", "GeoLite2-ASN", "Test ASN database", []network{
+		{"1.128.0.0/11", asn(1221, "Telstra Pty Ltd")},
+		{"192.0.2.0/24", asn(64496, "Example Carrier")},
+		{"198.51.100.0/24", asn(64497, "Example Hosting")},
+		{"203.0.113.0/24", asn(64498, "Example Mobile")},
+		{"2001:db8::/32", asn(64499, "Example IPv6 Carrier")},
+	})
+	write("test-data/GeoIP2-Country-Test.mmdb", "GeoIP2-Country", "Test country database", []network{
+		{"81.2.69.160/27", country(2635167, "GB", "United Kingdom")},
+		{"192.0.2.0/24", country(6252001, "US", "United States")},
+		{"198.51.100.0/24", country(2921044, "DE", "Germany")},
+		{"203.0.113.0/24", country(1861060, "JP", "Japan")},
+		{"2001:db8::/32", country(3017382, "FR", "France")},
+		{"217.65.48.0/29", country(2411586, "GI", "Gibraltar")},
+	})
+}
+
+// A node of the search tree.  Each side is a child node, a data record or
+// nothing.
+type node struct {
+	children [2]*node
+	data     [2][]byte
+	index    int
+}
+
+func write(path, databaseType, description string, networks []network) {
+	root := &node{}
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		ip := ipNet.IP.To16()
+		if bits == 32 {
+			// IPv4 networks are at ::/96, where the readers look them up.
+			ip = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+		insert(root, ip, ones, encode(n.record))
+	}
+
+	// Number the nodes in preorder, and lay out the data section.
+	var nodes []*node
+	var number func(n *node)
+	number = func(n *node) {
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil {
+				number(child)
+			}
+		}
+	}
+	number(root)
+	var data bytes.Buffer
+	var tree bytes.Buffer
+	for _, n := range nodes {
+		for side := 0; side < 2; side++ {
+			var record int
+			switch {
+			case n.children[side] != nil:
+				record = n.children[side].index
+			case n.data[side] != nil:
+				record = len(nodes) + 16 + data.Len()
+				data.Write(n.data[side])
+			default:
+				record = len(nodes)
+			}
+			tree.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	out.Write(encode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(buildEpoch),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": description},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	}))
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func insert(root *node, ip net.IP, prefixLen int, data []byte) {
+	n := root
+	for i := 0; i < prefixLen; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if i == prefixLen-1 {
+			n.data[bit] = data
+			return
+		}
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+}
+
+// Data types.
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+func control(dataType, size int) []byte {
+	var first byte
+	if dataType <= 7 {
+		first = byte(dataType << 5)
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		first |= byte(size)
+	case size < 29+256:
+		first |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 285+65536:
+		first |= 30
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		first |= 31
+		extra = []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+	}
+	b := []byte{first}
+	if dataType > 7 {
+		b = append(b, byte(dataType-7))
+	}
+	return append(b, extra...)
+}
+
+func encodeUint(dataType int, value uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], value)
+	trimmed := bytes.TrimLeft(buf[:], "\x00")
+	return append(control(dataType, len(trimmed)), trimmed...)
+}
+
+func encode(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint16:
+		return encodeUint(typeUint16, uint64(v))
+	case uint32:
+		return encodeUint(typeUint32, uint64(v))
+	case uint64:
+		return encodeUint(typeUint64, v)
+	case []interface{}:
+		b := control(typeArray, len(v))
+		for _, item := range v {
+			b = append(b, encode(item)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := control(typeMap, len(v))
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(v[k])...)
+		}
+		return b
+	}
+	log.Fatalf("Unsupported type %T", value)
+	return nil
+}
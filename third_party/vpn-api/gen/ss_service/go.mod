@@ -0,0 +1,8 @@
+module github.com/evgeniy-krivenko/vpn-api/gen/ss_service
+
+go 1.18
+
+require (
+	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.28.1
+)
@@ -0,0 +1,365 @@
+// Package webhook delivers the lifecycle events of the server to HTTP
+// endpoints, signed with HMAC-SHA256, with retries from a bounded queue that
+// is kept on disk across restarts.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/pkg/log"
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+)
+
+// The headers of the requests.
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	defaultMaxQueue    = 1000
+	defaultMaxAttempts = 10
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = 5 * time.Minute
+	defaultTimeout     = 10 * time.Second
+)
+
+// Config configures the Dispatcher.
+type Config struct {
+	// URLs receive every event.
+	URLs []string
+	// Secret is the HMAC key of the signatures.  Empty sends no signatures.
+	Secret []byte
+	// Events are the event types to send.  Empty sends all of them.
+	Events []string
+	// QueuePath is the file where the undelivered events are kept across
+	// restarts.  Empty keeps them in memory only.
+	QueuePath string
+	// MaxQueue bounds the undelivered requests.  The oldest are dropped when
+	// it's full.  Zero selects 1000.
+	MaxQueue int
+	// MaxAttempts is how many times a request is sent before it's dropped.
+	// Zero selects 10.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay between attempts, which
+	// doubles after every failed attempt.  Zero selects 1 second and 5 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout of each request.  Zero selects 10 seconds.
+	Timeout time.Duration
+	Logger  *log.Logger
+}
+
+// A request to one URL.
+type delivery struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// Dispatcher is a server.EventSink that POSTs the events as JSON.
+type Dispatcher struct {
+	config Config
+	client *http.Client
+	events map[string]bool
+	mu     sync.Mutex // Protects queue and dirty.
+	queue  []*delivery
+	// Whether the queue changed since it was saved.
+	dirty bool
+	wake  chan struct{}
+	// Canceled by Close, to interrupt the request in progress.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewDispatcher loads the queue of a previous run and starts delivering.
+func NewDispatcher(config Config) (*Dispatcher, error) {
+	if len(config.URLs) == 0 {
+		return nil, errors.New("no webhook URLs")
+	}
+	if config.MaxQueue <= 0 {
+		config.MaxQueue = defaultMaxQueue
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = defaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default().Named("webhook")
+	}
+	d := &Dispatcher{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	if len(config.Events) > 0 {
+		d.events = make(map[string]bool)
+		for _, e := range config.Events {
+			d.events[e] = true
+		}
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	go d.run()
+	return d, nil
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Emit queues `e` for every URL.  It doesn't block.
+func (d *Dispatcher) Emit(e server.Event) {
+	if d.events != nil && !d.events[e.Type] {
+		return
+	}
+	id := newID()
+	body, err := json.Marshal(struct {
+		ID string `json:"id"`
+		server.Event
+	}{id, e})
+	if err != nil {
+		d.config.Logger.Error("Failed to encode event", log.String("type", string(e.Type)), log.Err(err))
+		return
+	}
+	d.mu.Lock()
+	for _, url := range d.config.URLs {
+		d.queue = append(d.queue, &delivery{ID: id, Type: e.Type, URL: url, Body: body})
+	}
+	if dropped := len(d.queue) - d.config.MaxQueue; dropped > 0 {
+		d.config.Logger.Warning("Webhook queue is full, dropping the oldest requests", log.Int("dropped", dropped))
+		d.queue = append([]*delivery(nil), d.queue[dropped:]...)
+	}
+	d.dirty = true
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sign returns the signature of a request: the hex HMAC-SHA256 of the
+// timestamp header, a dot and the body, with a "sha256=" prefix.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the first request that is due, or when the next one is due.
+func (d *Dispatcher) next(now time.Time) (*delivery, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var wakeAt time.Time
+	for _, del := range d.queue {
+		if !del.NextAttempt.After(now) {
+			return del, time.Time{}
+		}
+		if wakeAt.IsZero() || del.NextAttempt.Before(wakeAt) {
+			wakeAt = del.NextAttempt
+		}
+	}
+	return nil, wakeAt
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.stopped)
+	for d.ctx.Err() == nil {
+		d.save()
+		del, wakeAt := d.next(time.Now())
+		if del != nil {
+			d.deliver(del)
+			continue
+		}
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if !wakeAt.IsZero() {
+			timer = time.NewTimer(time.Until(wakeAt))
+			timerC = timer.C
+		}
+		select {
+		case <-d.wake:
+		case <-timerC:
+		case <-d.ctx.Done():
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Sends one request, and removes it from the queue unless it should be retried.
+func (d *Dispatcher) deliver(del *delivery) {
+	err := d.send(del)
+	if d.ctx.Err() != nil {
+		// Interrupted by Close.  The request stays in the queue.
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dirty = true
+	del.Attempts++
+	if err == nil || del.Attempts >= d.config.MaxAttempts {
+		if err != nil {
+			d.config.Logger.Error("Dropping event", log.String("type", string(del.Type)), log.String("id", del.ID), log.String("url", del.URL), log.Int("attempts", del.Attempts), log.Err(err))
+		}
+		for i, queued := range d.queue {
+			if queued == del {
+				d.queue = append(d.queue[:i], d.queue[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	backoff := d.config.MinBackoff
+	for i := 1; i < del.Attempts && backoff < d.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.config.MaxBackoff {
+		backoff = d.config.MaxBackoff
+	}
+	// Up to 25% of jitter, so that retries to a recovering receiver spread out.
+	backoff += time.Duration(mrand.Int63n(int64(backoff)/4 + 1))
+	del.NextAttempt = time.Now().Add(backoff)
+	d.config.Logger.Warning("Failed to send event", log.String("type", string(del.Type)), log.String("id", del.ID), log.String("url", del.URL), log.Duration("retry_in", backoff), log.Err(err))
+}
+
+func (d *Dispatcher) send(del *delivery) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, del.ID)
+	req.Header.Set(HeaderEvent, del.Type)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(d.config.Secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(d.config.Secret, timestamp, del.Body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %v", resp.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) load() error {
+	if d.config.QueuePath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.config.QueuePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read webhook queue: %w", err)
+	}
+	if err := json.Unmarshal(data, &d.queue); err != nil {
+		return fmt.Errorf("failed to parse webhook queue %v: %w", d.config.QueuePath, err)
+	}
+	// The backoff of the previous run doesn't apply, since the receivers may
+	// have recovered in the meantime.
+	for _, del := range d.queue {
+		del.NextAttempt = time.Time{}
+	}
+	if len(d.queue) > 0 {
+		d.config.Logger.Info("Loaded undelivered webhook requests", log.Int("requests", len(d.queue)))
+	}
+	return nil
+}
+
+// Writes the queue atomically if it changed, so that a crash can't leave a
+// partial file.
+func (d *Dispatcher) save() {
+	if d.config.QueuePath == "" {
+		return
+	}
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(d.queue)
+	d.dirty = false
+	d.mu.Unlock()
+	if err == nil {
+		err = writeFile(d.config.QueuePath, data)
+	}
+	if err != nil {
+		d.mu.Lock()
+		d.dirty = true
+		d.mu.Unlock()
+		d.config.Logger.Error("Failed to save webhook queue", log.Err(err))
+	}
+}
+
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Close stops the deliveries and saves the undelivered requests.
+func (d *Dispatcher) Close() error {
+	d.cancel()
+	<-d.stopped
+	d.save()
+	return nil
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evgeniy-krivenko/outline-ss-server/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A receiver that fails the first `failures` requests.
+type receiver struct {
+	t        *testing.T
+	secret   []byte
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   []map[string]interface{}
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, secret []byte, failures int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, secret: secret, failures: failures, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if len(r.secret) > 0 {
+		assert.Equal(r.t, Sign(r.secret, req.Header.Get(HeaderTimestamp), body), req.Header.Get(HeaderSignature))
+	} else {
+		assert.Empty(r.t, req.Header.Get(HeaderSignature))
+	}
+	var decoded map[string]interface{}
+	assert.NoError(r.t, json.Unmarshal(body, &decoded))
+	assert.Equal(r.t, decoded["id"], req.Header.Get(HeaderID))
+	assert.Equal(r.t, decoded["type"], req.Header.Get(HeaderEvent))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.attempts <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	r.bodies = append(r.bodies, decoded)
+	r.received <- struct{}{}
+}
+
+func (r *receiver) wait(t *testing.T, n int) []map[string]interface{} {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d events", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bodies
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	secret := []byte("secret")
+	r, receiverServer := newReceiver(t, secret, 2)
+	d, err := NewDispatcher(Config{
+		URLs:       []string{receiverServer.URL},
+		Secret:     secret,
+		Events:     []string{server.EventKeyFirstUsed},
+		MinBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	d.Emit(server.Event{Type: server.EventUsageBatch})
+	d.Emit(server.Event{Type: server.EventKeyFirstUsed, AccessKey: "key1", Port: 1234})
+	bodies := r.wait(t, 1)
+	require.Len(t, bodies, 1)
+	require.Equal(t, "key1", bodies[0]["access_key"])
+	r.mu.Lock()
+	require.Equal(t, 3, r.attempts)
+	r.mu.Unlock()
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	r, receiverServer := newReceiver(t, nil, 3)
+	d, err := NewDispatcher(Config{
+		URLs:        []string{receiverServer.URL},
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	d.Emit(server.Event{Type: server.EventKeyExpired, AccessKey: "dropped"})
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.queue) == 0
+	}, 5*time.Second, time.Millisecond)
+	d.Emit(server.Event{Type: server.EventKeyExpired, AccessKey: "delivered"})
+	bodies := r.wait(t, 1)
+	require.Equal(t, "delivered", bodies[0]["access_key"])
+}
+
+func TestDispatcher_Queue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	r, receiverServer := newReceiver(t, nil, 3)
+	config := Config{
+		URLs:       []string{receiverServer.URL},
+		QueuePath:  path,
+		MaxQueue:   2,
+		MinBackoff: time.Hour,
+	}
+	d, err := NewDispatcher(config)
+	require.NoError(t, err)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		d.Emit(server.Event{Type: server.EventKeyFirstUsed, AccessKey: key})
+	}
+	// Wait for the requests to fail.
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.attempts >= 2
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+
+	// The next run sends the saved requests without waiting for their backoff.
+	r.mu.Lock()
+	r.failures = 0
+	r.mu.Unlock()
+	d, err = NewDispatcher(config)
+	require.NoError(t, err)
+	defer d.Close()
+	bodies := r.wait(t, 2)
+	// key1 was dropped from the full queue.
+	require.Equal(t, "key2", bodies[0]["access_key"])
+	require.Equal(t, "key3", bodies[1]["access_key"])
+}